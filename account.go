@@ -0,0 +1,327 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// defaultAccountQuotas are the quota entries GetAccountSummary reports
+// unless the fixture overrides them.
+var defaultAccountQuotas = map[string]int64{
+	"AccessKeysPerUserQuota":          2,
+	"AssumeRolePolicySizeQuota":       2048,
+	"AttachedPoliciesPerGroupQuota":   10,
+	"AttachedPoliciesPerRoleQuota":    10,
+	"AttachedPoliciesPerUserQuota":    10,
+	"GlobalEndpointTokenVersion":      1,
+	"GroupPolicySizeQuota":            5120,
+	"GroupsPerUserQuota":              10,
+	"GroupsQuota":                     300,
+	"InstanceProfilesQuota":           1000,
+	"PoliciesQuota":                   1500,
+	"PolicySizeQuota":                 6144,
+	"PolicyVersionsInUseQuota":        10000,
+	"RolePolicySizeQuota":             10240,
+	"RolesQuota":                      1000,
+	"ServerCertificatesQuota":         20,
+	"SigningCertificatesPerUserQuota": 2,
+	"UserPolicySizeQuota":             2048,
+	"UsersQuota":                      5000,
+	"VersionsPerPolicyQuota":          5,
+}
+
+var accountIdRegexp = regexp.MustCompile(`^[0-9]{12}$`)
+
+var accountAliasRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,61}[a-z0-9]$`)
+
+type IAMAccount struct {
+	Id     string           `yaml:"id,omitempty"`
+	Alias  string           `yaml:"alias,omitempty"`
+	Quotas map[string]int64 `yaml:"quotas,omitempty"`
+}
+
+func accountAliasKey(alias string) string {
+	return alias
+}
+
+func validateAccountAlias(alias string) error {
+	if !accountAliasRegexp.MatchString(alias) || strings.Contains(alias, "--") {
+		return &SenderFault{
+			Code_:    "ValidationError",
+			Message_: fmt.Sprintf("1 validation error detected: Value '%s' at 'accountAlias' failed to satisfy constraint: Member must satisfy regular expression pattern: ^[a-z0-9]([a-z0-9]|-(?!-)){1,61}[a-z0-9]$", alias),
+		}
+	}
+	return nil
+}
+
+func registerAccountHandlers(iamAPISet *APISet, reg IAMRegistry) {
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "CreateAccountAlias",
+			Proto: iam.CreateAccountAliasInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.CreateAccountAliasInput)
+				err := validateAccountAlias(*params.AccountAlias)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.CreateAccountAlias(*params.AccountAlias)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.CreateAccountAliasOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "DeleteAccountAlias",
+			Proto: iam.DeleteAccountAliasInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.DeleteAccountAliasInput)
+				err := reg.DeleteAccountAlias(*params.AccountAlias)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.DeleteAccountAliasOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListAccountAliases",
+			Proto: iam.ListAccountAliasesInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.ListAccountAliasesInput)
+				aliases, err := reg.GetAccountAliases()
+				if err != nil {
+					return nil, err
+				}
+				aliases, marker, err := paginate(aliases, accountAliasKey, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.ListAccountAliasesOutput{
+							AccountAliases: aliases,
+							IsTruncated:    aws.Bool(marker != nil),
+							Marker:         marker,
+						},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "GetAccountSummary",
+			Proto: iam.GetAccountSummaryInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				summary, err := buildAccountSummary(reg)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.GetAccountSummaryOutput{
+							SummaryMap: summary,
+						},
+					},
+				}, nil
+			},
+		},
+	)
+}
+
+// buildAccountSummary computes the SummaryMap of GetAccountSummary from what
+// the registry currently holds.  Entities the emulator does not model are
+// reported as absent.
+func buildAccountSummary(reg IAMRegistry) (map[string]int64, error) {
+	users, err := reg.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := reg.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+	roles, err := reg.GetRoles()
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := reg.GetInstanceProfiles()
+	if err != nil {
+		return nil, err
+	}
+	policies, err := reg.GetPolicies()
+	if err != nil {
+		return nil, err
+	}
+	var localPolicies int64
+	for _, p := range policies {
+		if !p.AWSManaged {
+			localPolicies++
+		}
+	}
+	// every attachment of a managed policy puts a version of it in use
+	attachments, err := countPolicyAttachments(reg)
+	if err != nil {
+		return nil, err
+	}
+	var policyVersionsInUse int64
+	for _, n := range attachments {
+		policyVersionsInUse += n
+	}
+	oidcProviders, err := reg.GetOpenIDConnectProviders()
+	if err != nil {
+		return nil, err
+	}
+	samlProviders, err := reg.GetSAMLProviders()
+	if err != nil {
+		return nil, err
+	}
+	serverCerts, err := reg.GetServerCertificates()
+	if err != nil {
+		return nil, err
+	}
+	devices, err := reg.GetVirtualMFADevices()
+	if err != nil {
+		return nil, err
+	}
+	// users marked mfa_active without a virtual device have one the
+	// emulator does not model
+	hasDevice := make(map[string]bool)
+	mfaDevices := int64(len(devices))
+	var mfaDevicesInUse int64
+	for _, d := range devices {
+		if d.User != "" {
+			hasDevice[d.User] = true
+			mfaDevicesInUse++
+		}
+	}
+	for _, u := range users {
+		if u.MFAActive && !hasDevice[u.Name] {
+			mfaDevices++
+			mfaDevicesInUse++
+		}
+	}
+	quotas, err := reg.GetAccountQuotas()
+	if err != nil {
+		return nil, err
+	}
+
+	summary := make(map[string]int64, len(quotas)+16)
+	for k, v := range quotas {
+		summary[k] = v
+	}
+	summary["Users"] = int64(len(users))
+	summary["Groups"] = int64(len(groups))
+	summary["Roles"] = int64(len(roles))
+	summary["InstanceProfiles"] = int64(len(profiles))
+	summary["Policies"] = localPolicies
+	summary["PolicyVersionsInUse"] = policyVersionsInUse
+	summary["Providers"] = int64(len(oidcProviders) + len(samlProviders))
+	summary["ServerCertificates"] = int64(len(serverCerts))
+	summary["MFADevices"] = mfaDevices
+	summary["MFADevicesInUse"] = mfaDevicesInUse
+	summary["AccountMFAEnabled"] = 0
+	summary["AccountAccessKeysPresent"] = 0
+	summary["AccountSigningCertificatesPresent"] = 0
+	return summary, nil
+}
+
+// GetAccountId returns the ID of the emulated account, which goes into every
+// ARN.
+func (reg *BasicIAMRegistry) GetAccountId() (string, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.accountId(), nil
+}
+
+// accountId is GetAccountId for callers that hold the lock already.
+func (reg *BasicIAMRegistry) accountId() string {
+	if reg.account.Id == "" {
+		return defaultAccountId
+	}
+	return reg.account.Id
+}
+
+func (reg *BasicIAMRegistry) GetAccountAliases() ([]string, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	if reg.account.Alias == "" {
+		return []string{}, nil
+	}
+	return []string{reg.account.Alias}, nil
+}
+
+func (reg *BasicIAMRegistry) CreateAccountAlias(alias string) error {
+	return reg.update(func(tx *registryTx) error {
+		// an account can only have a single alias, and creating another one
+		// replaces it.
+		account := reg.account
+		account.Alias = alias
+		tx.putAccount(account)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) DeleteAccountAlias(alias string) error {
+	return reg.update(func(tx *registryTx) error {
+		if reg.account.Alias != alias {
+			return &SenderFault{
+				Code_:    "NoSuchEntity",
+				Message_: fmt.Sprintf("The account alias %s cannot be found.", alias),
+			}
+		}
+		account := reg.account
+		account.Alias = ""
+		tx.putAccount(account)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) GetAccountQuotas() (map[string]int64, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	quotas := make(map[string]int64, len(defaultAccountQuotas))
+	for k, v := range defaultAccountQuotas {
+		quotas[k] = v
+	}
+	for k, v := range reg.account.Quotas {
+		quotas[k] = v
+	}
+	return quotas, nil
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAccountAliases(t *testing.T) {
+	svc, reg := newTestService(t, `account: {alias: before}`)
+	for _, c := range []struct {
+		params url.Values
+		status int
+		want   string
+	}{
+		{url.Values{"Action": {"ListAccountAliases"}}, http.StatusOK, "<member>before</member>"},
+		{url.Values{"Action": {"CreateAccountAlias"}, "AccountAlias": {"Bad"}}, http.StatusBadRequest, "<Code>ValidationError</Code>"},
+		{url.Values{"Action": {"CreateAccountAlias"}, "AccountAlias": {"a--b"}}, http.StatusBadRequest, "<Code>ValidationError</Code>"},
+		{url.Values{"Action": {"CreateAccountAlias"}, "AccountAlias": {"after"}}, http.StatusOK, ""},
+		{url.Values{"Action": {"ListAccountAliases"}}, http.StatusOK, "<member>after</member>"},
+		{url.Values{"Action": {"DeleteAccountAlias"}, "AccountAlias": {"before"}}, http.StatusNotFound, "The account alias before cannot be found."},
+		{url.Values{"Action": {"DeleteAccountAlias"}, "AccountAlias": {"after"}}, http.StatusOK, ""},
+	} {
+		w := query(t, svc, c.params)
+		if w.Code != c.status || !strings.Contains(w.Body.String(), c.want) {
+			t.Errorf("%v: got %d, want %d and %q:\n%s", c.params, w.Code, c.status, c.want, w.Body)
+		}
+	}
+	if aliases, _ := reg.GetAccountAliases(); len(aliases) != 0 {
+		t.Errorf("got aliases %v after deleting the alias", aliases)
+	}
+}
+
+func TestAccountSummary(t *testing.T) {
+	reg := newTestRegistry(t, `
+account:
+  quotas:
+    UsersQuota: 10
+users:
+  - name: alice
+    mfa_active: true
+    managed_policies: [p, arn:aws:iam::aws:policy/ReadOnlyAccess]
+  - name: bob
+  - name: carol
+    mfa_active: true
+groups:
+  - name: g
+    managed_policies: [p]
+roles:
+  - name: r
+    instance_profile: {}
+policies:
+  - name: p
+    versions: [{document: '{}'}]
+  - name: ReadOnlyAccess
+    aws_managed: true
+    versions: [{document: '{}'}]
+open_id_connect_providers:
+  - url: https://token.example.com
+    thumbprints: [0123456789abcdef0123456789abcdef01234567]
+saml_providers:
+  - name: idp
+    metadata_document: '<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata"/>'
+virtual_mfa_devices:
+  - name: alice
+    user: alice
+  - name: spare
+`)
+	summary, err := buildAccountSummary(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]int64{
+		"Users":               3,
+		"Groups":              1,
+		"Roles":               1,
+		"InstanceProfiles":    1,
+		"Policies":            1,
+		"PolicyVersionsInUse": 3,
+		"Providers":           2,
+		"ServerCertificates":  0,
+		"MFADevices":          3,
+		"MFADevicesInUse":     2,
+		"UsersQuota":          10,
+		"GroupsQuota":         defaultAccountQuotas["GroupsQuota"],
+	} {
+		if summary[k] != v {
+			t.Errorf("%s: got %d, want %d", k, summary[k], v)
+		}
+	}
+}
+
+func TestAccountSettingsInFixture(t *testing.T) {
+	for _, y := range []string{
+		`account: {id: "123"}`,
+		`account: {alias: "-bad"}`,
+		`account: {quotas: {NoSuchQuota: 1}}`,
+	} {
+		f, err := parseFixture([]byte(y))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := buildRegistry(f); err == nil {
+			t.Errorf("%s was accepted", y)
+		}
+	}
+	reg := newTestRegistry(t, `account: {id: "123456789012"}`)
+	if id, _ := reg.GetAccountId(); id != "123456789012" {
+		t.Errorf("got account ID %s", id)
+	}
+}
@@ -0,0 +1,337 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// adminPathPrefix is where the endpoints that manage the emulator itself
+// live, out of the way of the AWS APIs.
+const adminPathPrefix = "/_emulator/"
+
+// maxAdminFixtureSize caps the fixtures POSTed to the admin API.
+const maxAdminFixtureSize = 64 << 20
+
+var snapshotNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// AdminServer serves the control plane under adminPathPrefix, through which
+// test harnesses inspect the emulator and rewind it between test cases
+// without restarting it.
+type AdminServer struct {
+	Registry *BasicIAMRegistry
+	// FixturePaths are what the emulator was started with, which reset
+	// reads again.
+	FixturePaths []string
+	Requests     *RequestLog
+
+	mu        sync.Mutex
+	initial   []byte
+	snapshots map[string]adminSnapshot
+}
+
+// adminSnapshot is a state saved by name.  States are kept marshalled, as
+// the registry built out of a fixture shares memory with it.
+type adminSnapshot struct {
+	createdAt time.Time
+	fixture   []byte
+}
+
+type adminSnapshotInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewAdminServer returns an admin server for reg, which it takes the state
+// to reset to from unless there are fixtures to read it from.
+func NewAdminServer(reg *BasicIAMRegistry, fixturePaths []string, requests *RequestLog) (*AdminServer, error) {
+	initial, err := marshalFixture(reg.Export())
+	if err != nil {
+		return nil, err
+	}
+	return &AdminServer{
+		Registry:     reg,
+		FixturePaths: fixturePaths,
+		Requests:     requests,
+		initial:      initial,
+		snapshots:    map[string]adminSnapshot{},
+	}, nil
+}
+
+// newServiceHandler serves the AWS APIs, along with the admin endpoints.
+func newServiceHandler(admin *AdminServer) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminPathPrefix, http.NotFound)
+	mux.HandleFunc(adminPathPrefix+"export", admin.handleExport)
+	mux.HandleFunc(adminPathPrefix+"reset", admin.handleReset)
+	mux.HandleFunc(adminPathPrefix+"fixture", admin.handleFixture)
+	mux.HandleFunc(adminPathPrefix+"snapshots", admin.handleSnapshots)
+	mux.HandleFunc(adminPathPrefix+"snapshots/", admin.handleSnapshot)
+	mux.HandleFunc(adminPathPrefix+"requests", admin.handleRequests)
+	mux.HandleFunc("/", iamService.Handle)
+	return mux
+}
+
+func allowMethods(w http.ResponseWriter, req *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if req.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeAdminBody(w http.ResponseWriter, contentType string, b []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
+
+func writeAdminJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminBody(w, "application/json", append(b, '\n'))
+}
+
+// writeFixture answers a fixture in YAML, or in JSON if the client asks for
+// it with ?format=json or the Accept header.
+func writeFixture(w http.ResponseWriter, req *http.Request, f *fixture) {
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = "yaml"
+		if strings.Contains(req.Header.Get("Accept"), "application/json") {
+			format = "json"
+		}
+	}
+	var b []byte
+	var err error
+	switch format {
+	case "yaml":
+		b, err = marshalFixture(f)
+	case "json":
+		b, err = marshalFixtureJSON(f)
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %s (must be either yaml or json)", format), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Error("failed to export the registry", slog.String("error", err.Error()))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeAdminBody(w, "application/"+format, b)
+}
+
+// replace swaps the state of the registry for that of y, logging msg with
+// what changed as the reloads of the fixture do.
+func (a *AdminServer) replace(y *fixture, msg string, attrs ...any) error {
+	next, err := buildRegistry(y)
+	if err != nil {
+		return err
+	}
+	prev, err := a.Registry.replace(next)
+	if err != nil {
+		return err
+	}
+	logger.Info(msg, append(attrs, diffFixtures(prev, next.Export())...)...)
+	return nil
+}
+
+func (a *AdminServer) handleExport(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	writeFixture(w, req, a.Registry.Export())
+}
+
+// handleReset puts the emulator back into the state it started in.  The
+// fixture files are read again, so that changes made to them since take
+// effect; entities without explicit IDs get the ones they had at the start.
+func (a *AdminServer) handleReset(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodPost) {
+		return
+	}
+	initial, err := parseFixture(a.initial)
+	if err == nil && len(a.FixturePaths) > 0 {
+		var y *fixture
+		y, _, err = loadFixtures(a.FixturePaths)
+		if err == nil {
+			inheritIds(y, initial)
+			initial = y
+		}
+	}
+	if err == nil {
+		err = a.replace(initial, "reset to the fixture")
+	}
+	if err != nil {
+		logger.Error("failed to reset", slog.String("error", err.Error()))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleFixture replaces the state with the fixture in the request body, in
+// YAML or JSON, as though the fixture files had been changed to it.
+func (a *AdminServer) handleFixture(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodPost) {
+		return
+	}
+	b, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxAdminFixtureSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	y, err := loadFixtureBytes("request", b)
+	if err == nil {
+		inheritIds(y, a.Registry.Export())
+		err = a.replace(y, "loaded a fixture")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminServer) handleSnapshots(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet) {
+		return
+	}
+	a.mu.Lock()
+	infos := make([]adminSnapshotInfo, 0, len(a.snapshots))
+	for name, s := range a.snapshots {
+		infos = append(infos, adminSnapshotInfo{Name: name, CreatedAt: s.createdAt})
+	}
+	a.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	writeAdminJSON(w, infos)
+}
+
+// handleSnapshot serves snapshots/NAME, which PUT takes, GET dumps and
+// DELETE deletes, and snapshots/NAME/restore, to which a POST restores the
+// snapshot.
+func (a *AdminServer) handleSnapshot(w http.ResponseWriter, req *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, adminPathPrefix+"snapshots/"), "/")
+	if !snapshotNameRegexp.MatchString(name) || (action != "" && action != "restore") {
+		http.NotFound(w, req)
+		return
+	}
+	if action == "restore" {
+		if !allowMethods(w, req, http.MethodPost) {
+			return
+		}
+	} else if !allowMethods(w, req, http.MethodGet, http.MethodPut, http.MethodDelete) {
+		return
+	}
+
+	if req.Method == http.MethodPut {
+		b, err := marshalFixture(a.Registry.Export())
+		if err != nil {
+			logger.Error("failed to export the registry", slog.String("error", err.Error()))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		a.mu.Lock()
+		a.snapshots[name] = adminSnapshot{createdAt: time.Now().UTC(), fixture: b}
+		a.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	a.mu.Lock()
+	s, ok := a.snapshots[name]
+	if ok && req.Method == http.MethodDelete {
+		delete(a.snapshots, name)
+	}
+	a.mu.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("no snapshot named %s", name), http.StatusNotFound)
+		return
+	}
+	if req.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	y, err := parseFixture(s.fixture)
+	if err == nil && req.Method == http.MethodPost {
+		err = a.replace(y, "restored a snapshot", slog.String("snapshot", name))
+	}
+	if err != nil {
+		logger.Error("failed to restore a snapshot", slog.String("snapshot", name), slog.String("error", err.Error()))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.Method == http.MethodPost {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeFixture(w, req, y)
+}
+
+// handleRequests lists the latest requests to the AWS APIs, oldest first,
+// optionally only those after the one numbered ?since and of ?operation;
+// DELETE forgets them.
+func (a *AdminServer) handleRequests(w http.ResponseWriter, req *http.Request) {
+	if !allowMethods(w, req, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	if req.Method == http.MethodDelete {
+		a.Requests.clear()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	q := req.URL.Query()
+	var since uint64
+	if s := q.Get("since"); s != "" {
+		var err error
+		since, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since %s", s), http.StatusBadRequest)
+			return
+		}
+	}
+	requests := a.Requests.since(since)
+	if op := q.Get("operation"); op != "" {
+		filtered := requests[:0]
+		for _, r := range requests {
+			if r.Operation == op {
+				filtered = append(filtered, r)
+			}
+		}
+		requests = filtered
+	}
+	writeAdminJSON(w, requests)
+}
@@ -0,0 +1,342 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const adminTestFixture = `
+users:
+  - name: alice
+  - name: bob
+`
+
+func newTestAdmin(t *testing.T, fixtureYAML string, fixturePaths ...string) (http.Handler, *Service, *BasicIAMRegistry) {
+	t.Helper()
+	svc, reg := newTestService(t, fixtureYAML)
+	svc.Requests = NewRequestLog(100)
+	admin, err := NewAdminServer(reg, fixturePaths, svc.Requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newServiceHandler(admin), svc, reg
+}
+
+func adminRequest(t *testing.T, h http.Handler, method, path string, body io.Reader) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, body))
+	return w
+}
+
+func TestAdminExport(t *testing.T) {
+	h, _, _ := newTestAdmin(t, adminTestFixture)
+	for _, c := range []struct {
+		path, accept string
+		status       int
+		contentType  string
+	}{
+		{"/_emulator/export", "", http.StatusOK, "application/yaml"},
+		{"/_emulator/export?format=yaml", "application/json", http.StatusOK, "application/yaml"},
+		{"/_emulator/export?format=json", "", http.StatusOK, "application/json"},
+		{"/_emulator/export", "application/json", http.StatusOK, "application/json"},
+		{"/_emulator/export?format=xml", "", http.StatusBadRequest, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		if c.accept != "" {
+			req.Header.Set("Accept", c.accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != c.status {
+			t.Errorf("%s %s: got %d, want %d:\n%s", c.path, c.accept, w.Code, c.status, w.Body)
+			continue
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != c.contentType {
+			t.Errorf("%s %s: got Content-Type %s, want %s", c.path, c.accept, ct, c.contentType)
+		}
+		// either format reads back as a fixture
+		y, err := loadFixtureBytes("export", w.Body.Bytes())
+		if err != nil {
+			t.Errorf("%s %s: %v:\n%s", c.path, c.accept, err, w.Body)
+			continue
+		}
+		reg, err := buildRegistry(y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := userNames(userIds(t, reg)); got != "alice,bob" {
+			t.Errorf("%s %s: got users %s", c.path, c.accept, got)
+		}
+	}
+}
+
+func TestAdminFixture(t *testing.T) {
+	h, _, reg := newTestAdmin(t, adminTestFixture)
+	before := userIds(t, reg)
+
+	for _, body := range []string{
+		"users: 42\n",
+		"include: [other.yaml]\n",
+		"users:\n  - name: alice\n  - name: alice\n",
+	} {
+		if w := adminRequest(t, h, http.MethodPost, "/_emulator/fixture", strings.NewReader(body)); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: got %d, want %d:\n%s", body, w.Code, http.StatusBadRequest, w.Body)
+		}
+	}
+	if got := userIds(t, reg); !reflect.DeepEqual(got, before) {
+		t.Errorf("a rejected fixture changed the state: got %v, want %v", got, before)
+	}
+
+	// JSON is YAML, too
+	w := adminRequest(t, h, http.MethodPost, "/_emulator/fixture", strings.NewReader(`{"users": [{"name": "alice"}, {"name": "carol"}]}`))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got %d:\n%s", w.Code, w.Body)
+	}
+	after := userIds(t, reg)
+	if got := userNames(after); got != "alice,carol" {
+		t.Errorf("got users %s, want alice,carol", got)
+	}
+	if after["alice"] != before["alice"] {
+		t.Errorf("alice got a new ID: %s, was %s", after["alice"], before["alice"])
+	}
+}
+
+func TestAdminReset(t *testing.T) {
+	h, _, reg := newTestAdmin(t, adminTestFixture)
+	initial := userIds(t, reg)
+	if w := adminRequest(t, h, http.MethodPost, "/_emulator/fixture", strings.NewReader("users:\n  - name: carol\n")); w.Code != http.StatusNoContent {
+		t.Fatalf("got %d:\n%s", w.Code, w.Body)
+	}
+	if w := adminRequest(t, h, http.MethodPost, "/_emulator/reset", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("got %d:\n%s", w.Code, w.Body)
+	}
+	if got := userIds(t, reg); !reflect.DeepEqual(got, initial) {
+		t.Errorf("got %v, want %v", got, initial)
+	}
+}
+
+// Reset reads the fixture files again, keeping the IDs entities had at the
+// start.
+func TestAdminResetFixturePaths(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixture.yaml")
+	writeTestFile(t, path, adminTestFixture)
+	y, _, err := loadFixtures([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := buildRegistry(y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := NewAdminServer(reg, []string{path}, NewRequestLog(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newServiceHandler(admin)
+	initial := userIds(t, reg)
+
+	writeTestFile(t, path, "users:\n  - name: alice\n  - name: dave\n")
+	if w := adminRequest(t, h, http.MethodPost, "/_emulator/reset", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("got %d:\n%s", w.Code, w.Body)
+	}
+	got := userIds(t, reg)
+	if names := userNames(got); names != "alice,dave" {
+		t.Errorf("got users %s, want alice,dave", names)
+	}
+	if got["alice"] != initial["alice"] {
+		t.Errorf("alice got a new ID: %s, was %s", got["alice"], initial["alice"])
+	}
+
+	// a broken fixture fails the reset and leaves the state alone
+	writeTestFile(t, path, "users: 42\n")
+	if w := adminRequest(t, h, http.MethodPost, "/_emulator/reset", nil); w.Code != http.StatusInternalServerError {
+		t.Errorf("got %d:\n%s", w.Code, w.Body)
+	}
+	if names := userNames(userIds(t, reg)); names != "alice,dave" {
+		t.Errorf("got users %s after a failed reset", names)
+	}
+}
+
+func TestAdminSnapshots(t *testing.T) {
+	h, _, reg := newTestAdmin(t, adminTestFixture)
+	saved := userIds(t, reg)
+
+	if w := adminRequest(t, h, http.MethodPut, "/_emulator/snapshots/before-test.1", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("got %d:\n%s", w.Code, w.Body)
+	}
+	w := adminRequest(t, h, http.MethodGet, "/_emulator/snapshots", nil)
+	var infos []adminSnapshotInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil {
+		t.Fatalf("%v:\n%s", err, w.Body)
+	}
+	if len(infos) != 1 || infos[0].Name != "before-test.1" || infos[0].CreatedAt.IsZero() {
+		t.Errorf("got %+v", infos)
+	}
+
+	if w := adminRequest(t, h, http.MethodPost, "/_emulator/fixture", strings.NewReader("users:\n  - name: carol\n")); w.Code != http.StatusNoContent {
+		t.Fatalf("got %d:\n%s", w.Code, w.Body)
+	}
+
+	// the dump is of the snapshot, not of the current state
+	w = adminRequest(t, h, http.MethodGet, "/_emulator/snapshots/before-test.1?format=json", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("got %d %s:\n%s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	y, err := loadFixtureBytes("snapshot", w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(y.Users) != 2 || y.Users[0].Name != "alice" || y.Users[1].Name != "bob" {
+		t.Errorf("got users %+v", y.Users)
+	}
+
+	if w := adminRequest(t, h, http.MethodPost, "/_emulator/snapshots/before-test.1/restore", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("got %d:\n%s", w.Code, w.Body)
+	}
+	if got := userIds(t, reg); !reflect.DeepEqual(got, saved) {
+		t.Errorf("got %v, want %v", got, saved)
+	}
+
+	if w := adminRequest(t, h, http.MethodDelete, "/_emulator/snapshots/before-test.1", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("got %d:\n%s", w.Code, w.Body)
+	}
+	for _, c := range []struct {
+		method, path string
+	}{
+		{http.MethodGet, "/_emulator/snapshots/before-test.1"},
+		{http.MethodPost, "/_emulator/snapshots/before-test.1/restore"},
+		{http.MethodDelete, "/_emulator/snapshots/before-test.1"},
+		{http.MethodGet, "/_emulator/snapshots/bad%20name"},
+		{http.MethodPut, "/_emulator/snapshots/"},
+		{http.MethodPost, "/_emulator/snapshots/x/rewind"},
+		{http.MethodGet, "/_emulator/nothing"},
+	} {
+		if w := adminRequest(t, h, c.method, c.path, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: got %d, want %d", c.method, c.path, w.Code, http.StatusNotFound)
+		}
+	}
+	w = adminRequest(t, h, http.MethodGet, "/_emulator/snapshots", nil)
+	if strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("got %s", w.Body)
+	}
+}
+
+func TestAdminRequests(t *testing.T) {
+	h, svc, _ := newTestAdmin(t, adminTestFixture)
+
+	// requests to the AWS APIs still go to the service
+	for _, params := range []url.Values{
+		{"Action": {"GetUser"}, "UserName": {"alice"}},
+		{"Action": {"ListUsers"}},
+		{"Action": {"GetUser"}, "UserName": {"carol"}},
+	} {
+		params.Set("Version", "2010-05-08")
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(params.Encode()))
+		req.Header.Set("Content-Type", formContentType)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	// the admin API does not record requests to itself
+	adminRequest(t, h, http.MethodGet, "/_emulator/export", nil)
+
+	list := func(path string) []RecordedRequest {
+		t.Helper()
+		w := adminRequest(t, h, http.MethodGet, path, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d:\n%s", path, w.Code, w.Body)
+		}
+		var requests []RecordedRequest
+		if err := json.Unmarshal(w.Body.Bytes(), &requests); err != nil {
+			t.Fatalf("%s: %v:\n%s", path, err, w.Body)
+		}
+		return requests
+	}
+	summary := func(requests []RecordedRequest) string {
+		var s []string
+		for _, r := range requests {
+			s = append(s, r.Operation+" "+r.ErrorCode)
+		}
+		return strings.Join(s, ",")
+	}
+	for _, c := range []struct {
+		path, want string
+	}{
+		{"/_emulator/requests", "GetUser ,ListUsers ,GetUser NoSuchEntity"},
+		{"/_emulator/requests?since=1", "ListUsers ,GetUser NoSuchEntity"},
+		{"/_emulator/requests?since=3", ""},
+		{"/_emulator/requests?operation=GetUser", "GetUser ,GetUser NoSuchEntity"},
+		{"/_emulator/requests?since=1&operation=GetUser", "GetUser NoSuchEntity"},
+	} {
+		if got := summary(list(c.path)); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.path, got, c.want)
+		}
+	}
+	// filtering leaves the log alone
+	if got := summary(svc.Requests.since(0)); got != "GetUser ,ListUsers ,GetUser NoSuchEntity" {
+		t.Errorf("got %q", got)
+	}
+
+	if w := adminRequest(t, h, http.MethodGet, "/_emulator/requests?since=-1", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("got %d:\n%s", w.Code, w.Body)
+	}
+	if w := adminRequest(t, h, http.MethodDelete, "/_emulator/requests", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("got %d:\n%s", w.Code, w.Body)
+	}
+	if got := list("/_emulator/requests"); len(got) != 0 {
+		t.Errorf("got %d requests after clearing", len(got))
+	}
+	// numbering goes on after clearing
+	query(t, svc, url.Values{"Action": {"ListUsers"}})
+	if got := list("/_emulator/requests"); len(got) != 1 || got[0].Seq != 4 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestAdminMethods(t *testing.T) {
+	h, _, _ := newTestAdmin(t, adminTestFixture)
+	for _, c := range []struct {
+		method, path, allow string
+	}{
+		{http.MethodPost, "/_emulator/export", "GET"},
+		{http.MethodGet, "/_emulator/reset", "POST"},
+		{http.MethodPut, "/_emulator/fixture", "POST"},
+		{http.MethodPost, "/_emulator/snapshots", "GET"},
+		{http.MethodPost, "/_emulator/snapshots/x", "GET, PUT, DELETE"},
+		{http.MethodGet, "/_emulator/snapshots/x/restore", "POST"},
+		{http.MethodPost, "/_emulator/requests", "GET, DELETE"},
+	} {
+		w := adminRequest(t, h, c.method, c.path, nil)
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != c.allow {
+			t.Errorf("%s %s: got %d, Allow %q, want %d and %q", c.method, c.path, w.Code, w.Header().Get("Allow"), http.StatusMethodNotAllowed, c.allow)
+		}
+	}
+}
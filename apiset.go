@@ -20,6 +20,7 @@
 package main
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 	"reflect"
@@ -30,7 +31,11 @@ import (
 type APISet struct {
 	Version   string
 	Namespace string
-	handlers  map[string]Handler
+	// ServiceShape is the name of the Smithy service shape, which prefixes
+	// X-Amz-Target of awsJson requests and names the service in the path
+	// of RPCv2 CBOR ones.
+	ServiceShape string
+	handlers     map[string]Handler
 }
 
 type HandlerFunc func(*aws.Request) (*aws.Response, error)
@@ -40,6 +45,7 @@ type UnmarshalParamsFunc func(interface{}, *http.Request) error
 type Handler interface {
 	Name() string
 	UnmarshalParams(req *http.Request) (interface{}, error)
+	UnmarshalDocument(doc map[string]interface{}) (interface{}, error)
 	Handle(*aws.Request) (*aws.Response, error)
 }
 
@@ -77,6 +83,13 @@ func (h *QueryOperationHandler) UnmarshalParams(req *http.Request) (interface{},
 	err := UnmarshalParams(v.Interface(), req.Form, h.IsEC2)
 	if err != nil {
 		logger.Info("error occurred", slog.String("error", err.Error()))
+		var paramErr *invalidParameterError
+		if errors.As(err, &paramErr) {
+			return nil, &SenderFault{
+				Code_:    "InvalidParameterValue",
+				Message_: paramErr.Error(),
+			}
+		}
 		return nil, &SenderFault{
 			Code_:    "InvalidParameterValue",
 			Message_: "invalid parameter",
@@ -85,6 +98,16 @@ func (h *QueryOperationHandler) UnmarshalParams(req *http.Request) (interface{},
 	return v.Interface(), nil
 }
 
+func (h *QueryOperationHandler) UnmarshalDocument(doc map[string]interface{}) (interface{}, error) {
+	v := reflect.New(reflect.TypeOf(h.Proto))
+	err := unmarshalDocument(v.Interface(), doc)
+	if err != nil {
+		logger.Info("error occurred", slog.String("error", err.Error()))
+		return nil, serializationFault(err)
+	}
+	return v.Interface(), nil
+}
+
 func (h *QueryOperationHandler) Handle(req *aws.Request) (*aws.Response, error) {
 	return h.Handler(req)
 }
@@ -0,0 +1,266 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// authorizationDetailsEntry is a single entity in the sequence that
+// GetAccountAuthorizationDetails pages through.  The key orders users before
+// groups before roles before managed policies, and each kind by name.  As
+// the registry enumerates entities by name, collecting them kind by kind
+// yields them in key order.
+type authorizationDetailsEntry struct {
+	key    string
+	user   *IAMUser
+	group  *IAMGroup
+	role   *IAMRole
+	policy *IAMPolicy
+}
+
+func authorizationDetailsEntryKey(e authorizationDetailsEntry) string {
+	return e.key
+}
+
+func parseEntityTypeFilter(filter []iam.EntityType) (map[iam.EntityType]bool, error) {
+	wanted := make(map[iam.EntityType]bool)
+	for _, f := range filter {
+		switch f {
+		case iam.EntityTypeUser, iam.EntityTypeRole, iam.EntityTypeGroup, iam.EntityTypeLocalManagedPolicy, iam.EntityTypeAwsmanagedPolicy:
+			wanted[f] = true
+		default:
+			return nil, &SenderFault{
+				Code_:    "ValidationError",
+				Message_: fmt.Sprintf("1 validation error detected: Value '[%s]' at 'filter' failed to satisfy constraint: Member must satisfy constraint: [Member must satisfy enum value set: [LocalManagedPolicy, Group, Role, User, AWSManagedPolicy]]", f),
+			}
+		}
+	}
+	if len(wanted) == 0 {
+		for _, f := range []iam.EntityType{iam.EntityTypeUser, iam.EntityTypeRole, iam.EntityTypeGroup, iam.EntityTypeLocalManagedPolicy, iam.EntityTypeAwsmanagedPolicy} {
+			wanted[f] = true
+		}
+	}
+	return wanted, nil
+}
+
+func collectAuthorizationDetailsEntries(reg IAMRegistry, wanted map[iam.EntityType]bool) ([]authorizationDetailsEntry, error) {
+	var entries []authorizationDetailsEntry
+	if wanted[iam.EntityTypeUser] {
+		users, err := reg.GetUsers()
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			entries = append(entries, authorizationDetailsEntry{key: "1/" + u.Name, user: u})
+		}
+	}
+	if wanted[iam.EntityTypeGroup] {
+		groups, err := reg.GetGroups()
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			entries = append(entries, authorizationDetailsEntry{key: "2/" + g.Name, group: g})
+		}
+	}
+	if wanted[iam.EntityTypeRole] {
+		roles, err := reg.GetRoles()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range roles {
+			entries = append(entries, authorizationDetailsEntry{key: "3/" + r.Name, role: r})
+		}
+	}
+	if wanted[iam.EntityTypeLocalManagedPolicy] || wanted[iam.EntityTypeAwsmanagedPolicy] {
+		policies, err := reg.GetPolicies()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range policies {
+			if p.AWSManaged && wanted[iam.EntityTypeAwsmanagedPolicy] || !p.AWSManaged && wanted[iam.EntityTypeLocalManagedPolicy] {
+				entries = append(entries, authorizationDetailsEntry{key: "4/" + policyKey(p), policy: p})
+			}
+		}
+	}
+	return entries, nil
+}
+
+func registerAuthorizationDetailsHandlers(iamAPISet *APISet, reg IAMRegistry) {
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "GetAccountAuthorizationDetails",
+			Proto: iam.GetAccountAuthorizationDetailsInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*iam.GetAccountAuthorizationDetailsInput)
+				wanted, err := parseEntityTypeFilter(params.Filter)
+				if err != nil {
+					return nil, err
+				}
+				entries, err := collectAuthorizationDetailsEntries(reg, wanted)
+				if err != nil {
+					return nil, err
+				}
+				page, marker, err := paginate(entries, authorizationDetailsEntryKey, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+
+				policies, err := reg.GetPolicies()
+				if err != nil {
+					return nil, err
+				}
+				policiesByKey := make(map[string]*IAMPolicy, len(policies))
+				for _, p := range policies {
+					policiesByKey[policyKey(p)] = p
+				}
+
+				var groupsOfUser map[string][]string
+				var profilesOfRole map[string][]*IAMInstanceProfile
+				var attachments map[string]int64
+				out := &iam.GetAccountAuthorizationDetailsOutput{
+					GroupDetailList: []iam.GroupDetail{},
+					IsTruncated:     aws.Bool(marker != nil),
+					Marker:          marker,
+					Policies:        []iam.ManagedPolicyDetail{},
+					RoleDetailList:  []iam.RoleDetail{},
+					UserDetailList:  []iam.UserDetail{},
+				}
+				for _, e := range page {
+					switch {
+					case e.user != nil:
+						if groupsOfUser == nil {
+							groupsOfUser, err = buildGroupsOfUser(reg)
+							if err != nil {
+								return nil, err
+							}
+						}
+						u := e.user
+						groupList := groupsOfUser[u.Name]
+						if groupList == nil {
+							groupList = []string{}
+						}
+						out.UserDetailList = append(out.UserDetailList, iam.UserDetail{
+							Arn:                     aws.String(u.BuildArn(accountId)),
+							AttachedManagedPolicies: buildAttachedPolicies(u.ManagedPolicies, policiesByKey, accountId),
+							CreateDate:              aws.Time(u.CreatedAt),
+							GroupList:               groupList,
+							Path:                    aws.String(u.Path),
+							Tags:                    buildTags(u.Tags),
+							UserId:                  aws.String(u.Id),
+							UserName:                aws.String(u.Name),
+							UserPolicyList:          buildInlinePolicies(u.InlinePolicies),
+						})
+					case e.group != nil:
+						g := e.group
+						out.GroupDetailList = append(out.GroupDetailList, iam.GroupDetail{
+							Arn:                     aws.String(g.BuildArn(accountId)),
+							AttachedManagedPolicies: buildAttachedPolicies(g.ManagedPolicies, policiesByKey, accountId),
+							CreateDate:              aws.Time(g.CreatedAt),
+							GroupId:                 aws.String(g.Id),
+							GroupName:               aws.String(g.Name),
+							GroupPolicyList:         buildInlinePolicies(g.InlinePolicies),
+							Path:                    aws.String(g.Path),
+						})
+					case e.role != nil:
+						if profilesOfRole == nil {
+							profilesOfRole, err = buildInstanceProfilesOfRole(reg)
+							if err != nil {
+								return nil, err
+							}
+						}
+						r := e.role
+						role := r.BuildRole(accountId)
+						profiles := make([]iam.InstanceProfile, len(profilesOfRole[r.Name]))
+						for i, p := range profilesOfRole[r.Name] {
+							profiles[i] = p.BuildInstanceProfile(accountId)
+						}
+						out.RoleDetailList = append(out.RoleDetailList, iam.RoleDetail{
+							Arn:                      role.Arn,
+							AssumeRolePolicyDocument: role.AssumeRolePolicyDocument,
+							AttachedManagedPolicies:  buildAttachedPolicies(r.ManagedPolicies, policiesByKey, accountId),
+							CreateDate:               role.CreateDate,
+							InstanceProfileList:      profiles,
+							Path:                     role.Path,
+							RoleId:                   role.RoleId,
+							RoleLastUsed:             &iam.RoleLastUsed{},
+							RoleName:                 role.RoleName,
+							RolePolicyList:           buildInlinePolicies(r.InlinePolicies),
+							Tags:                     buildTags(r.Tags),
+						})
+					case e.policy != nil:
+						if attachments == nil {
+							attachments, err = countPolicyAttachments(reg)
+							if err != nil {
+								return nil, err
+							}
+						}
+						out.Policies = append(out.Policies, e.policy.BuildManagedPolicyDetail(accountId, attachments[policyKey(e.policy)]))
+					}
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+}
+
+func buildGroupsOfUser(reg IAMRegistry) (map[string][]string, error) {
+	groups, err := reg.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+	groupsOfUser := make(map[string][]string)
+	for _, g := range groups {
+		for _, u := range g.Members {
+			groupsOfUser[u.Name] = append(groupsOfUser[u.Name], g.Name)
+		}
+	}
+	for _, names := range groupsOfUser {
+		sort.Strings(names)
+	}
+	return groupsOfUser, nil
+}
+
+func buildInstanceProfilesOfRole(reg IAMRegistry) (map[string][]*IAMInstanceProfile, error) {
+	profiles, err := reg.GetInstanceProfiles()
+	if err != nil {
+		return nil, err
+	}
+	profilesOfRole := make(map[string][]*IAMInstanceProfile)
+	for _, p := range profiles {
+		for _, r := range p.Roles {
+			profilesOfRole[r.Name] = append(profilesOfRole[r.Name], p)
+		}
+	}
+	return profilesOfRole, nil
+}
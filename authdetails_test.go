@@ -0,0 +1,119 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const authorizationDetailsFixture = `
+users:
+  - name: bob
+  - name: alice
+    inline_policies:
+      inline: '{"Version": "2012-10-17"}'
+    managed_policies: [local]
+groups:
+  - name: devs
+    members: [alice]
+roles:
+  - name: web
+    instance_profile: {}
+policies:
+  - name: local
+    versions: [{document: '{}'}]
+  - name: ReadOnlyAccess
+    aws_managed: true
+    versions: [{document: '{}'}]
+`
+
+func TestGetAccountAuthorizationDetails(t *testing.T) {
+	svc, _ := newTestService(t, authorizationDetailsFixture)
+	w := query(t, svc, url.Values{"Action": {"GetAccountAuthorizationDetails"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d:\n%s", w.Code, w.Body)
+	}
+	body := w.Body.String()
+	for _, s := range []string{
+		"<PolicyDocument>%7B%22Version%22%3A%20%222012-10-17%22%7D</PolicyDocument>",
+		"<GroupList>\n          <member>devs</member>",
+		"<InstanceProfileName>web</InstanceProfileName>",
+		"<Arn>arn:aws:iam::aws:policy/ReadOnlyAccess</Arn>",
+		"<AttachmentCount>1</AttachmentCount>",
+		"<IsTruncated>false</IsTruncated>",
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("%q is missing from\n%s", s, body)
+		}
+	}
+}
+
+func TestGetAccountAuthorizationDetailsPages(t *testing.T) {
+	svc, _ := newTestService(t, authorizationDetailsFixture)
+	// users, groups, roles and managed policies, each kind by name
+	want := []string{
+		"UserName:alice UserName:bob",
+		"GroupName:devs RoleName:web",
+		"PolicyName:ReadOnlyAccess PolicyName:local",
+	}
+	var got []string
+	params := url.Values{"Action": {"GetAccountAuthorizationDetails"}, "MaxItems": {"2"}}
+	for len(got) <= len(want) {
+		w := query(t, svc, params)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d:\n%s", w.Code, w.Body)
+		}
+		body := w.Body.String()
+		// the names of the entities themselves are the only ones at this
+		// depth
+		var names []string
+		for _, m := range regexp.MustCompile(`(?m)^        <(UserName|GroupName|RoleName|PolicyName)>([^<]*)<`).FindAllStringSubmatch(body, -1) {
+			names = append(names, m[1]+":"+m[2])
+		}
+		sort.Strings(names)
+		got = append(got, strings.Join(names, " "))
+		marker := regexp.MustCompile(`<Marker>([^<]*)</Marker>`).FindStringSubmatch(body)
+		if marker == nil {
+			break
+		}
+		params.Set("Marker", marker[1])
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got pages %q, want %q", got, want)
+	}
+}
+
+func TestGetAccountAuthorizationDetailsFilter(t *testing.T) {
+	svc, _ := newTestService(t, authorizationDetailsFixture)
+	w := query(t, svc, url.Values{"Action": {"GetAccountAuthorizationDetails"}, "Filter.member.1": {"AWSManagedPolicy"}})
+	body := w.Body.String()
+	if !strings.Contains(body, "<PolicyName>ReadOnlyAccess</PolicyName>") || strings.Contains(body, "<PolicyName>local</PolicyName>") || strings.Contains(body, "<UserName>") {
+		t.Errorf("the filter was not applied:\n%s", body)
+	}
+	w = query(t, svc, url.Values{"Action": {"GetAccountAuthorizationDetails"}, "Filter.member.1": {"Bogus"}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "<Code>ValidationError</Code>") {
+		t.Errorf("got %d:\n%s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const containerCredentialsRolePathPrefix = "/role/"
+
+// ContainerCredentialsServer emulates the ECS / EKS Pod Identity container
+// credentials endpoint, the one SDKs reach through
+// AWS_CONTAINER_CREDENTIALS_FULL_URI (or _RELATIVE_URI) together with
+// AWS_CONTAINER_AUTHORIZATION_TOKEN.  The role is taken from Mappings keyed
+// by the request path, or from the path itself when it is of the form
+// /role/ROLE_NAME.
+type ContainerCredentialsServer struct {
+	Registry IAMRegistry
+	Issuer   *CredentialsIssuer
+	// AuthorizationToken is compared against the Authorization header when
+	// non-empty.
+	AuthorizationToken string
+	Mappings           map[string]string
+}
+
+type containerCredentialsPayload struct {
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+	RoleArn         string `json:"RoleArn"`
+}
+
+type containerCredentialsErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeContainerCredentialsError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, status, containerCredentialsErrorPayload{Code: code, Message: message})
+}
+
+func (s *ContainerCredentialsServer) roleName(path string) (string, bool) {
+	if name, ok := s.Mappings[path]; ok {
+		return name, true
+	}
+	if strings.HasPrefix(path, containerCredentialsRolePathPrefix) {
+		name := strings.TrimPrefix(path, containerCredentialsRolePathPrefix)
+		if name != "" && !strings.Contains(name, "/") {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (s *ContainerCredentialsServer) Handle(w http.ResponseWriter, req *http.Request) {
+	logger.Debug("container credentials request", slog.String("method", req.Method), slog.String("path", req.URL.Path))
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeContainerCredentialsError(w, http.StatusMethodNotAllowed, "InvalidRequest", "Method not allowed")
+		return
+	}
+	if s.AuthorizationToken != "" {
+		auth := req.Header.Get("Authorization")
+		if auth == "" {
+			writeContainerCredentialsError(w, http.StatusUnauthorized, "AccessDenied", "Authorization header is missing")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(s.AuthorizationToken)) != 1 {
+			writeContainerCredentialsError(w, http.StatusForbidden, "AccessDenied", "Invalid authorization token")
+			return
+		}
+	}
+
+	name, ok := s.roleName(req.URL.Path)
+	if !ok {
+		writeContainerCredentialsError(w, http.StatusNotFound, "NotFound", fmt.Sprintf("No role is associated with %s", req.URL.Path))
+		return
+	}
+	role, ok, err := s.Registry.GetRoleByName(name)
+	if err != nil {
+		logger.Error("failed to look up the role", slog.String("error", err.Error()))
+		writeContainerCredentialsError(w, http.StatusInternalServerError, "InternalError", "Internal error")
+		return
+	}
+	if !ok {
+		writeContainerCredentialsError(w, http.StatusNotFound, "NoSuchEntity", fmt.Sprintf("The role with name %s cannot be found.", name))
+		return
+	}
+	accountId, err := s.Registry.GetAccountId()
+	if err != nil {
+		logger.Error("failed to look up the account", slog.String("error", err.Error()))
+		writeContainerCredentialsError(w, http.StatusInternalServerError, "InternalError", "Internal error")
+		return
+	}
+	creds, err := s.Issuer.CredentialsForRole(role, accountId)
+	if err != nil {
+		logger.Error("failed to issue credentials", slog.String("error", err.Error()))
+		writeContainerCredentialsError(w, http.StatusInternalServerError, "InternalError", "Internal error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, http.StatusOK, containerCredentialsPayload{
+		AccessKeyId:     creds.AccessKeyId,
+		SecretAccessKey: creds.SecretAccessKey,
+		Token:           creds.SessionToken,
+		Expiration:      creds.Expiration.Format(time.RFC3339),
+		RoleArn:         creds.RoleArn,
+	})
+}
+
+// roleMappingFlag collects repeated PATH=ROLE command line arguments.
+type roleMappingFlag map[string]string
+
+func (f roleMappingFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f roleMappingFlag) Set(value string) error {
+	path, role, ok := strings.Cut(value, "=")
+	if !ok || path == "" || role == "" {
+		return fmt.Errorf("expected PATH=ROLE, got %q", value)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	f[path] = role
+	return nil
+}
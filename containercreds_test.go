@@ -0,0 +1,106 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestContainerCredentials(t *testing.T) {
+	reg := newTestRegistry(t, `
+account:
+  id: "123456789012"
+roles:
+  - name: task
+  - name: other
+`)
+	s := &ContainerCredentialsServer{
+		Registry:           reg,
+		Issuer:             NewCredentialsIssuer(time.Hour),
+		AuthorizationToken: "secret",
+		Mappings:           map[string]string{"/v2/credentials/abc": "other"},
+	}
+	for _, c := range []struct {
+		name    string
+		method  string
+		path    string
+		auth    string
+		status  int
+		roleArn string
+		code    string
+	}{
+		{"by role path", http.MethodGet, "/role/task", "secret", http.StatusOK, "arn:aws:iam::123456789012:role/task", ""},
+		{"by mapping", http.MethodGet, "/v2/credentials/abc", "secret", http.StatusOK, "arn:aws:iam::123456789012:role/other", ""},
+		{"unknown role", http.MethodGet, "/role/nope", "secret", http.StatusNotFound, "", "NoSuchEntity"},
+		{"unmapped path", http.MethodGet, "/v2/credentials/xyz", "secret", http.StatusNotFound, "", "NotFound"},
+		{"nested role path", http.MethodGet, "/role/a/b", "secret", http.StatusNotFound, "", "NotFound"},
+		{"no token", http.MethodGet, "/role/task", "", http.StatusUnauthorized, "", "AccessDenied"},
+		{"wrong token", http.MethodGet, "/role/task", "guess", http.StatusForbidden, "", "AccessDenied"},
+		{"POST", http.MethodPost, "/role/task", "secret", http.StatusMethodNotAllowed, "", "InvalidRequest"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, c.path, nil)
+			if c.auth != "" {
+				req.Header.Set("Authorization", c.auth)
+			}
+			w := httptest.NewRecorder()
+			s.Handle(w, req)
+			if w.Code != c.status {
+				t.Fatalf("got %d, want %d: %s", w.Code, c.status, w.Body)
+			}
+			if w.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("got Content-Type %s", w.Header().Get("Content-Type"))
+			}
+			if c.status != http.StatusOK {
+				var e containerCredentialsErrorPayload
+				if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || e.Code != c.code {
+					t.Errorf("got %s, want code %s", w.Body, c.code)
+				}
+				return
+			}
+			var creds containerCredentialsPayload
+			if err := json.Unmarshal(w.Body.Bytes(), &creds); err != nil {
+				t.Fatal(err)
+			}
+			if creds.RoleArn != c.roleArn || creds.AccessKeyId == "" || creds.SecretAccessKey == "" || creds.Token == "" {
+				t.Errorf("got %+v", creds)
+			}
+		})
+	}
+}
+
+func TestRoleMappingFlag(t *testing.T) {
+	f := roleMappingFlag{}
+	if err := f.Set("creds=task"); err != nil {
+		t.Fatal(err)
+	}
+	if f["/creds"] != "task" {
+		t.Errorf("got %v", f)
+	}
+	for _, v := range []string{"creds", "=task", "/creds="} {
+		if err := f.Set(v); err == nil {
+			t.Errorf("%q was accepted", v)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// credentialReportLifetime is how long a generated credential report can be
+// retrieved before GetCredentialReport starts answering ReportExpired.
+const credentialReportLifetime = 4 * time.Hour
+
+const credentialReportTimeFormat = "2006-01-02T15:04:05+00:00"
+
+const maxAccessKeysPerUser = 2
+const maxSigningCertificatesPerUser = 2
+
+var credentialReportColumns = []string{
+	"user",
+	"arn",
+	"user_creation_time",
+	"password_enabled",
+	"password_last_used",
+	"password_last_changed",
+	"password_next_rotation",
+	"mfa_active",
+	"access_key_1_active",
+	"access_key_1_last_rotated",
+	"access_key_1_last_used_date",
+	"access_key_1_last_used_region",
+	"access_key_1_last_used_service",
+	"access_key_2_active",
+	"access_key_2_last_rotated",
+	"access_key_2_last_used_date",
+	"access_key_2_last_used_region",
+	"access_key_2_last_used_service",
+	"cert_1_active",
+	"cert_1_last_rotated",
+	"cert_2_active",
+	"cert_2_last_rotated",
+}
+
+// IAMUserPassword describes the console password (login profile) of a user
+// as far as the credential report is concerned.
+type IAMUserPassword struct {
+	LastChanged time.Time `yaml:"last_changed,omitempty"`
+	LastUsed    time.Time `yaml:"last_used,omitempty"`
+}
+
+type IAMAccessKeyLastUsed struct {
+	Date    time.Time `yaml:"date"`
+	Region  string    `yaml:"region,omitempty"`
+	Service string    `yaml:"service,omitempty"`
+}
+
+type IAMAccessKey struct {
+	Id        string                `yaml:"id,omitempty"`
+	Status    string                `yaml:"status,omitempty"`
+	CreatedAt time.Time             `yaml:"created_at,omitempty"`
+	LastUsed  *IAMAccessKeyLastUsed `yaml:"last_used,omitempty"`
+}
+
+type IAMSigningCertificate struct {
+	Status     string    `yaml:"status,omitempty"`
+	UploadedAt time.Time `yaml:"uploaded_at,omitempty"`
+}
+
+type IAMCredentialReport struct {
+	Content     []byte
+	GeneratedAt time.Time
+}
+
+// credentialReportRecord is the credential report in a fixture, which keeps
+// the CSV as text.
+type credentialReportRecord struct {
+	Content     string    `yaml:"content"`
+	GeneratedAt time.Time `yaml:"generated_at"`
+}
+
+func validateCredentialStatus(status string) error {
+	switch status {
+	case "", "Active", "Inactive":
+		return nil
+	}
+	return fmt.Errorf("invalid status %s (must be either Active or Inactive)", status)
+}
+
+func formatReportTime(t time.Time) string {
+	if t.IsZero() {
+		return "N/A"
+	}
+	return t.UTC().Format(credentialReportTimeFormat)
+}
+
+func isActive(status string) string {
+	return strconv.FormatBool(status != "Inactive")
+}
+
+// buildCredentialReportRow renders the row of u; mfaActive tells whether u
+// has an MFA device, be it by mfa_active or an assigned virtual device.
+func buildCredentialReportRow(u *IAMUser, accountId string, mfaActive bool) []string {
+	row := make([]string, 0, len(credentialReportColumns))
+	row = append(row, u.Name, u.BuildArn(accountId), formatReportTime(u.CreatedAt))
+	if u.Password != nil {
+		lastUsed := "no_information"
+		if !u.Password.LastUsed.IsZero() {
+			lastUsed = formatReportTime(u.Password.LastUsed)
+		}
+		lastChanged := u.Password.LastChanged
+		if lastChanged.IsZero() {
+			lastChanged = u.CreatedAt
+		}
+		row = append(row, "true", lastUsed, formatReportTime(lastChanged), "N/A")
+	} else {
+		row = append(row, "false", "N/A", "N/A", "N/A")
+	}
+	row = append(row, strconv.FormatBool(mfaActive))
+	for i := 0; i < maxAccessKeysPerUser; i++ {
+		if i >= len(u.AccessKeys) {
+			row = append(row, "false", "N/A", "N/A", "N/A", "N/A")
+			continue
+		}
+		k := &u.AccessKeys[i]
+		if k.LastUsed == nil || k.LastUsed.Date.IsZero() {
+			row = append(row, isActive(k.Status), formatReportTime(k.CreatedAt), "N/A", "N/A", "N/A")
+			continue
+		}
+		region, service := k.LastUsed.Region, k.LastUsed.Service
+		if region == "" {
+			region = "N/A"
+		}
+		if service == "" {
+			service = "N/A"
+		}
+		row = append(row, isActive(k.Status), formatReportTime(k.CreatedAt), formatReportTime(k.LastUsed.Date), region, service)
+	}
+	for i := 0; i < maxSigningCertificatesPerUser; i++ {
+		if i >= len(u.SigningCertificates) {
+			row = append(row, "false", "N/A")
+			continue
+		}
+		c := &u.SigningCertificates[i]
+		row = append(row, isActive(c.Status), formatReportTime(c.UploadedAt))
+	}
+	return row
+}
+
+// buildCredentialReport renders the CSV credential report.  The root account
+// comes first, followed by the users in name order.
+func buildCredentialReport(reg IAMRegistry, accountId string) ([]byte, error) {
+	users, err := reg.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	devices, err := reg.GetVirtualMFADevices()
+	if err != nil {
+		return nil, err
+	}
+	hasDevice := make(map[string]bool, len(devices))
+	for _, d := range devices {
+		if d.User != "" {
+			hasDevice[d.User] = true
+		}
+	}
+
+	b := &bytes.Buffer{}
+	w := csv.NewWriter(b)
+	err = w.Write(credentialReportColumns)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Write([]string{
+		"<root_account>",
+		fmt.Sprintf("arn:aws:iam::%s:root", accountId),
+		formatReportTime(epoch),
+		"not_supported", "no_information", "not_supported", "not_supported",
+		"false",
+		"false", "N/A", "N/A", "N/A", "N/A",
+		"false", "N/A", "N/A", "N/A", "N/A",
+		"false", "N/A",
+		"false", "N/A",
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		err = w.Write(buildCredentialReportRow(u, accountId, u.MFAActive || hasDevice[u.Name]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+func registerCredentialReportHandlers(iamAPISet *APISet, reg IAMRegistry) {
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "GenerateCredentialReport",
+			Proto: iam.GenerateCredentialReportInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				out := &iam.GenerateCredentialReportOutput{
+					State: iam.ReportStateTypeComplete,
+				}
+				report, ok, err := reg.GetCredentialReport()
+				if err != nil {
+					return nil, err
+				}
+				// reports are built synchronously, but a fresh generation is
+				// still reported as STARTED as the service does; the report
+				// is COMPLETE from the next call on.
+				if !ok || time.Since(report.GeneratedAt) >= credentialReportLifetime {
+					content, err := buildCredentialReport(reg, accountId)
+					if err != nil {
+						return nil, err
+					}
+					err = reg.PutCredentialReport(&IAMCredentialReport{
+						Content:     content,
+						GeneratedAt: time.Now().UTC().Truncate(time.Second),
+					})
+					if err != nil {
+						return nil, err
+					}
+					out.State = iam.ReportStateTypeStarted
+					out.Description = aws.String("No report exists. Starting a new report generation task")
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "GetCredentialReport",
+			Proto: iam.GetCredentialReportInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				report, ok, err := reg.GetCredentialReport()
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, &SenderFault{
+						Code_:    "ReportNotPresent",
+						Message_: "Credential report not present. To generate a credential report, use GenerateCredentialReport.",
+					}
+				}
+				if time.Since(report.GeneratedAt) >= credentialReportLifetime {
+					return nil, &SenderFault{
+						Code_:    "ReportExpired",
+						Message_: "Credential report has expired. To generate a new credential report, use GenerateCredentialReport.",
+					}
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.GetCredentialReportOutput{
+							Content:       report.Content,
+							GeneratedTime: aws.Time(report.GeneratedAt),
+							ReportFormat:  iam.ReportFormatTypeTextCsv,
+						},
+					},
+				}, nil
+			},
+		},
+	)
+}
+
+func (reg *BasicIAMRegistry) GetCredentialReport() (*IAMCredentialReport, bool, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.credentialReport, reg.credentialReport != nil, nil
+}
+
+func (reg *BasicIAMRegistry) PutCredentialReport(report *IAMCredentialReport) error {
+	return reg.update(func(tx *registryTx) error {
+		tx.putCredentialReport(report)
+		return nil
+	})
+}
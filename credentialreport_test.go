@@ -0,0 +1,118 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const credentialReportFixture = `
+account:
+  id: "123456789012"
+users:
+  - name: alice
+    created_at: 2020-01-02T03:04:05Z
+    password:
+      last_changed: 2020-02-01T00:00:00Z
+      last_used: 2020-03-01T00:00:00Z
+    mfa_active: true
+    access_keys:
+      - created_at: 2020-01-03T00:00:00Z
+        last_used:
+          date: 2020-01-04T00:00:00Z
+          region: us-east-1
+          service: s3
+      - status: Inactive
+        created_at: 2020-01-05T00:00:00Z
+    signing_certificates:
+      - uploaded_at: 2020-01-06T00:00:00Z
+  - name: bob
+    created_at: 2020-01-02T03:04:05Z
+    password: {}
+`
+
+func TestBuildCredentialReport(t *testing.T) {
+	reg := newTestRegistry(t, credentialReportFixture)
+	b, err := buildCredentialReport(reg, "123456789012")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(string(b))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want the header, the root account and 2 users", len(rows))
+	}
+	want := [][]string{
+		credentialReportColumns,
+		{"<root_account>", "arn:aws:iam::123456789012:root", "1970-01-01T00:00:00+00:00", "not_supported", "no_information", "not_supported", "not_supported", "false", "false", "N/A", "N/A", "N/A", "N/A", "false", "N/A", "N/A", "N/A", "N/A", "false", "N/A", "false", "N/A"},
+		{"alice", "arn:aws:iam::123456789012:user/alice", "2020-01-02T03:04:05+00:00", "true", "2020-03-01T00:00:00+00:00", "2020-02-01T00:00:00+00:00", "N/A", "true", "true", "2020-01-03T00:00:00+00:00", "2020-01-04T00:00:00+00:00", "us-east-1", "s3", "false", "2020-01-05T00:00:00+00:00", "N/A", "N/A", "N/A", "true", "2020-01-06T00:00:00+00:00", "false", "N/A"},
+		// a password that was never changed dates from the creation of the
+		// user, and one never used has no information
+		{"bob", "arn:aws:iam::123456789012:user/bob", "2020-01-02T03:04:05+00:00", "true", "no_information", "2020-01-02T03:04:05+00:00", "N/A", "false", "false", "N/A", "N/A", "N/A", "N/A", "false", "N/A", "N/A", "N/A", "N/A", "false", "N/A", "false", "N/A"},
+	}
+	for i := range want {
+		if strings.Join(rows[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("row %d:\ngot  %v\nwant %v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestCredentialReportOperations(t *testing.T) {
+	svc, reg := newTestService(t, credentialReportFixture)
+	w := query(t, svc, url.Values{"Action": {"GetCredentialReport"}})
+	if w.Code != http.StatusGone || !strings.Contains(w.Body.String(), "<Code>ReportNotPresent</Code>") {
+		t.Errorf("before generation: got %d:\n%s", w.Code, w.Body)
+	}
+	w = query(t, svc, url.Values{"Action": {"GenerateCredentialReport"}})
+	if !strings.Contains(w.Body.String(), "<State>STARTED</State>") {
+		t.Errorf("first generation:\n%s", w.Body)
+	}
+	w = query(t, svc, url.Values{"Action": {"GenerateCredentialReport"}})
+	if !strings.Contains(w.Body.String(), "<State>COMPLETE</State>") {
+		t.Errorf("second generation:\n%s", w.Body)
+	}
+	w = query(t, svc, url.Values{"Action": {"GetCredentialReport"}})
+	m := regexp.MustCompile(`<Content>([^<]*)</Content>`).FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatalf("no content:\n%s", w.Body)
+	}
+	content, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil || !strings.HasPrefix(string(content), "user,arn,user_creation_time,") {
+		t.Errorf("got content %q (%v)", content, err)
+	}
+	if !strings.Contains(w.Body.String(), "<ReportFormat>text/csv</ReportFormat>") {
+		t.Errorf("no report format:\n%s", w.Body)
+	}
+
+	reg.PutCredentialReport(&IAMCredentialReport{GeneratedAt: time.Now().Add(-credentialReportLifetime)})
+	w = query(t, svc, url.Values{"Action": {"GetCredentialReport"}})
+	if !strings.Contains(w.Body.String(), "<Code>ReportExpired</Code>") {
+		t.Errorf("after expiry: got %d:\n%s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const temporaryAccessKeyIdPrefix = "ASIA"
+
+// defaultCredentialsLifetime is the lifetime of the temporary credentials
+// vended for a role unless configured otherwise.
+const defaultCredentialsLifetime = time.Hour
+
+type TemporaryCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	IssuedAt        time.Time
+	Expiration      time.Time
+	RoleArn         string
+	RoleId          string
+}
+
+// CredentialsIssuer mints temporary credentials on behalf of roles.
+// Credentials are reused for the same role until they come close to expiry,
+// after which a new set is minted, much like the rotation EC2 and ECS
+// perform.  The emulator does not authenticate requests, so nothing checks
+// the credentials once they are handed out.
+type CredentialsIssuer struct {
+	mu       sync.Mutex
+	lifetime time.Duration
+	now      func() time.Time
+	byRole   map[string]*TemporaryCredentials
+}
+
+func NewCredentialsIssuer(lifetime time.Duration) *CredentialsIssuer {
+	if lifetime <= 0 {
+		lifetime = defaultCredentialsLifetime
+	}
+	return &CredentialsIssuer{
+		lifetime: lifetime,
+		now:      time.Now,
+		byRole:   make(map[string]*TemporaryCredentials),
+	}
+}
+
+func secureRandomString(l int, chars []byte) (string, error) {
+	b := make([]byte, l)
+	max := big.NewInt(int64(len(chars)))
+	for i := 0; i < l; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = chars[n.Int64()]
+	}
+	return string(b), nil
+}
+
+var secretKeyChars = []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/")
+
+func (ci *CredentialsIssuer) mint(roleArn, roleId string) (*TemporaryCredentials, error) {
+	keyId, err := secureRandomString(16, alnum)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := secureRandomString(40, secretKeyChars)
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, 192)
+	_, err = rand.Read(token)
+	if err != nil {
+		return nil, err
+	}
+	now := ci.now().UTC().Truncate(time.Second)
+	return &TemporaryCredentials{
+		AccessKeyId:     temporaryAccessKeyIdPrefix + keyId,
+		SecretAccessKey: secret,
+		SessionToken:    base64.StdEncoding.EncodeToString(token),
+		IssuedAt:        now,
+		Expiration:      now.Add(ci.lifetime),
+		RoleArn:         roleArn,
+		RoleId:          roleId,
+	}, nil
+}
+
+// CredentialsForRole returns the current credentials for the role, minting a
+// new set once less than a quarter of the lifetime of the previous one
+// remains.
+func (ci *CredentialsIssuer) CredentialsForRole(role *IAMRole, accountId string) (*TemporaryCredentials, error) {
+	roleArn := role.BuildArn(accountId)
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	creds, ok := ci.byRole[roleArn]
+	if ok && creds.RoleId == role.Id && ci.now().Add(ci.lifetime/4).Before(creds.Expiration) {
+		return creds, nil
+	}
+	creds, err := ci.mint(roleArn, role.Id)
+	if err != nil {
+		return nil, err
+	}
+	ci.expunge()
+	ci.byRole[roleArn] = creds
+	return creds, nil
+}
+
+// expunge forgets the credentials that have expired, e.g. those of roles
+// that no longer ask for any.
+func (ci *CredentialsIssuer) expunge() {
+	now := ci.now()
+	for roleArn, creds := range ci.byRole {
+		if !now.Before(creds.Expiration) {
+			delete(ci.byRole, roleArn)
+		}
+	}
+}
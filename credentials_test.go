@@ -0,0 +1,74 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCredentialsForRole(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ci := NewCredentialsIssuer(time.Hour)
+	ci.now = func() time.Time { return now }
+	web := &IAMRole{Id: "AROAWEB", Name: "web", Path: "/"}
+	db := &IAMRole{Id: "AROADB", Name: "db", Path: "/"}
+
+	first, err := ci.CredentialsForRole(web, defaultAccountId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(first.AccessKeyId, temporaryAccessKeyIdPrefix) || len(first.AccessKeyId) != 20 {
+		t.Errorf("got access key ID %s", first.AccessKeyId)
+	}
+	if !first.Expiration.Equal(now.Add(time.Hour)) || first.RoleArn != "arn:aws:iam::000000000000:role/web" {
+		t.Errorf("got %+v", first)
+	}
+
+	now = now.Add(40 * time.Minute)
+	if creds, _ := ci.CredentialsForRole(web, defaultAccountId); creds != first {
+		t.Error("the credentials were rotated before three quarters of their lifetime")
+	}
+	now = now.Add(6 * time.Minute)
+	second, _ := ci.CredentialsForRole(web, defaultAccountId)
+	if second == first || second.AccessKeyId == first.AccessKeyId {
+		t.Error("the credentials were not rotated near their expiry")
+	}
+
+	// a role of the same name that was created again gets credentials of
+	// its own
+	recreated := &IAMRole{Id: "AROAWEB2", Name: "web", Path: "/"}
+	if creds, _ := ci.CredentialsForRole(recreated, defaultAccountId); creds == second {
+		t.Error("the credentials of the previous role were handed out")
+	}
+
+	// the credentials of roles that stop asking are forgotten once expired
+	if _, err := ci.CredentialsForRole(db, defaultAccountId); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := ci.CredentialsForRole(web, defaultAccountId); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ci.byRole[db.BuildArn(defaultAccountId)]; ok || len(ci.byRole) != 1 {
+		t.Errorf("expired credentials are kept: %v", ci.byRole)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// unmarshalDocument fills in the shape recv points to from a document
+// decoded off the body of a JSON or CBOR request, i.e. a tree of
+// map[string]interface{}, []interface{} and scalars.  Members are looked up
+// by their names in the shape, which are those of the fields.
+func unmarshalDocument(recv interface{}, doc interface{}) error {
+	return buildFromDocument(reflect.ValueOf(recv), doc, "")
+}
+
+func buildFromDocument(value reflect.Value, doc interface{}, name string) error {
+	if doc == nil {
+		return nil
+	}
+	value = elemOf(value)
+
+	switch value.Kind() {
+	case reflect.Struct:
+		if value.Type() == timeType {
+			t, err := documentTime(doc)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			value.Set(reflect.ValueOf(t))
+			return nil
+		}
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected a structure, got %s", name, documentKind(doc))
+		}
+		t := value.Type()
+		for i := 0; i < value.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue // ignore unexported fields
+			}
+			if field.Tag.Get("ignore") != "" || field.Tag.Get("location") != "" {
+				continue
+			}
+			v, ok := m[field.Name]
+			if !ok {
+				continue
+			}
+			err := buildFromDocument(value.Field(i).Addr(), v, joinMemberName(name, field.Name))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			var b []byte
+			switch v := doc.(type) {
+			case []byte:
+				b = v
+			case string:
+				var err error
+				b, err = base64.StdEncoding.DecodeString(v)
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			default:
+				return fmt.Errorf("%s: expected a blob, got %s", name, documentKind(doc))
+			}
+			value.SetBytes(b)
+			return nil
+		}
+		l, ok := doc.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected a list, got %s", name, documentKind(doc))
+		}
+		slice := reflect.MakeSlice(value.Type(), len(l), len(l))
+		for i, v := range l {
+			err := buildFromDocument(slice.Index(i).Addr(), v, name+"."+strconv.Itoa(i))
+			if err != nil {
+				return err
+			}
+		}
+		value.Set(slice)
+		return nil
+	case reflect.Map:
+		m, ok := doc.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected a map, got %s", name, documentKind(doc))
+		}
+		mv := reflect.MakeMapWithSize(value.Type(), len(m))
+		for k, v := range m {
+			elem := reflect.New(value.Type().Elem())
+			err := buildFromDocument(elem, v, joinMemberName(name, k))
+			if err != nil {
+				return err
+			}
+			mv.SetMapIndex(reflect.ValueOf(k).Convert(value.Type().Key()), elem.Elem())
+		}
+		value.Set(mv)
+		return nil
+	case reflect.String:
+		s, ok := doc.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string, got %s", name, documentKind(doc))
+		}
+		value.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, ok := doc.(bool)
+		if !ok {
+			return fmt.Errorf("%s: expected a boolean, got %s", name, documentKind(doc))
+		}
+		value.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f, err := documentNumber(doc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if f != math.Trunc(f) || value.OverflowInt(int64(f)) {
+			return fmt.Errorf("%s: %v is not a valid integer", name, f)
+		}
+		value.SetInt(int64(f))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := documentNumber(doc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		value.SetFloat(f)
+		return nil
+	}
+	return fmt.Errorf("%s: unsupported type %s", name, value.Type())
+}
+
+func joinMemberName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// documentKind names the kind of a document value for error messages.
+func documentKind(doc interface{}) string {
+	switch doc.(type) {
+	case map[string]interface{}:
+		return "a structure"
+	case []interface{}:
+		return "a list"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case []byte:
+		return "a blob"
+	case time.Time:
+		return "a timestamp"
+	case json.Number, float64, float32, int64, uint64:
+		return "a number"
+	}
+	return fmt.Sprintf("%T", doc)
+}
+
+func documentNumber(doc interface{}) (float64, error) {
+	switch v := doc.(type) {
+	case json.Number:
+		return v.Float64()
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	}
+	return 0, fmt.Errorf("expected a number, got %s", documentKind(doc))
+}
+
+// documentTime accepts a timestamp either as seconds since the epoch, which
+// is how both protocols send them, or as an ISO 8601 string.
+func documentTime(doc interface{}) (time.Time, error) {
+	switch v := doc.(type) {
+	case time.Time:
+		return v.UTC(), nil
+	case string:
+		return parseTimestamp(v, "iso8601")
+	}
+	f, err := documentNumber(doc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseTimestamp(strconv.FormatFloat(f, 'f', -1, 64), "unixTimestamp")
+}
+
+// buildDocument turns a shape into a document for the JSON and CBOR
+// protocols, leaving the representation of timestamps to the protocol.
+func buildDocument(v reflect.Value, timestamp func(time.Time) interface{}) interface{} {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return timestamp(t)
+		}
+		m := make(map[string]interface{})
+		for _, member := range shapeMembers(v) {
+			m[member.field] = buildDocument(member.value, timestamp)
+		}
+		return m
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes()
+		}
+		l := make([]interface{}, v.Len())
+		for i := range l {
+			l[i] = buildDocument(v.Index(i), timestamp)
+		}
+		return l
+	case reflect.Map:
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = buildDocument(iter.Value(), timestamp)
+		}
+		return m
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return v.Interface()
+}
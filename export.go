@@ -0,0 +1,152 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Export snapshots the registry as a fixture, which buildRegistry
+// reads back into the same state, IDs and timestamps included.
+func (reg *BasicIAMRegistry) Export() *fixture {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.export()
+}
+
+func (reg *BasicIAMRegistry) export() *fixture {
+	f := &fixture{Account: reg.account}
+	for _, u := range reg.users.values() {
+		f.Users = append(f.Users, *u)
+	}
+	for _, g := range reg.groups.values() {
+		rec := groupRecord{IAMGroup: *g, Members: make([]string, len(g.Members))}
+		for i, u := range g.Members {
+			rec.Members[i] = u.Name
+		}
+		f.Groups = append(f.Groups, rec)
+	}
+	for _, r := range reg.roles.values() {
+		f.Roles = append(f.Roles, roleRecord{IAMRole: *r})
+	}
+	for _, p := range reg.policies.values() {
+		f.Policies = append(f.Policies, *p)
+	}
+	for _, p := range reg.instanceProfiles.values() {
+		rec := instanceProfileRecord{IAMInstanceProfile: *p, Roles: make([]string, len(p.Roles))}
+		for i, r := range p.Roles {
+			rec.Roles[i] = r.Name
+		}
+		f.InstanceProfiles = append(f.InstanceProfiles, rec)
+	}
+	for _, p := range reg.oidcProviders.values() {
+		f.OpenIDConnectProviders = append(f.OpenIDConnectProviders, *p)
+	}
+	for _, p := range reg.samlProviders.values() {
+		f.SAMLProviders = append(f.SAMLProviders, *p)
+	}
+	for _, d := range reg.mfaDevices.values() {
+		f.VirtualMFADevices = append(f.VirtualMFADevices, *d)
+	}
+	for _, c := range reg.serverCerts.values() {
+		f.ServerCertificates = append(f.ServerCertificates, *c)
+	}
+	if reg.credentialReport != nil {
+		f.CredentialReport = &credentialReportRecord{
+			Content:     string(reg.credentialReport.Content),
+			GeneratedAt: reg.credentialReport.GeneratedAt,
+		}
+	}
+	return f
+}
+
+// marshalFixture renders a fixture as YAML, writing multi-line strings such
+// as policy documents as literal blocks.
+func marshalFixture(f *fixture) ([]byte, error) {
+	return yaml.MarshalWithOptions(f, yaml.UseLiteralStyleIfMultiline(true))
+}
+
+// marshalFixtureJSON renders a fixture as JSON, with the same keys in the
+// same order as marshalFixture.
+func marshalFixtureJSON(f *fixture) ([]byte, error) {
+	return yaml.MarshalWithOptions(f, yaml.JSON())
+}
+
+// exportMain is the export subcommand, which writes out the state the
+// emulator would start with given the same store and fixture.
+func exportMain(args []string) int {
+	// the fixture goes to the standard output, so the log must not
+	logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	fs := flag.NewFlagSet(progname+" export", flag.ExitOnError)
+	var storeSpec, output string
+	fs.StringVar(&storeSpec, "store", "memory", "read the entities from `STORE`: memory, or bolt:PATH")
+	fs.StringVar(&idSeed, "seed", "", "derive the IDs of entities that have none in the fixture from `SEED`")
+	fs.StringVar(&output, "o", "", "write the fixture to `FILE` instead of the standard output")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s export [-store STORE] [-seed SEED] [-o FILE] [FIXTURE...]\n", progname)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	store, err := openStore(storeSpec)
+	if err != nil {
+		cmdlineErr(err.Error())
+		return 1
+	}
+	defer store.Close()
+	var y *fixture
+	if fs.NArg() >= 1 {
+		y, _, err = loadFixtures(fs.Args())
+		if err != nil {
+			cmdlineErr(err.Error())
+			return 1
+		}
+	} else if _, ok := store.(*MemoryStore); ok {
+		fs.Usage()
+		cmdlineErr("specify a path to the YAML file(s)")
+		return 255
+	}
+	reg, err := openRegistry(store, y)
+	if err != nil {
+		cmdlineErr(err.Error())
+		return 1
+	}
+	out, err := marshalFixture(reg.Export())
+	if err != nil {
+		cmdlineErr(err.Error())
+		return 1
+	}
+	if output == "" {
+		_, err = os.Stdout.Write(out)
+	} else {
+		err = ioutil.WriteFile(output, out, 0o644)
+	}
+	if err != nil {
+		cmdlineErr(err.Error())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	body, _ := newTestCertificate(t)
+	reg := newTestRegistry(t, `
+users:
+  - name: alice
+open_id_connect_providers:
+  - url: https://token.example.com
+    client_ids: [sts.amazonaws.com]
+    thumbprints: [0123456789abcdef0123456789abcdef01234567]
+    tags: {env: test}
+saml_providers:
+  - name: idp
+    metadata_document: '<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata"/>'
+virtual_mfa_devices:
+  - name: alice
+    user: alice
+server_certificates:
+  - name: web
+    body: |
+      `+strings.ReplaceAll(strings.TrimSpace(body), "\n", "\n      ")+`
+`)
+	b, err := marshalFixture(reg.Export())
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := parseFixture(b)
+	if err != nil {
+		t.Fatalf("parseFixture: %v\n%s", err, b)
+	}
+	reg2, err := buildRegistry(again)
+	if err != nil {
+		t.Fatalf("buildRegistry: %v\n%s", err, b)
+	}
+	b2, err := marshalFixture(reg2.Export())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(b2) {
+		t.Errorf("the export changed across a round trip:\n%s\n---\n%s", b, b2)
+	}
+	for _, n := range []int{
+		len(again.OpenIDConnectProviders),
+		len(again.SAMLProviders),
+		len(again.VirtualMFADevices),
+		len(again.ServerCertificates),
+	} {
+		if n != 1 {
+			t.Errorf("an entity was lost in the export:\n%s", b)
+			break
+		}
+	}
+}
+
+func TestExportOmitsUnsetTimestamps(t *testing.T) {
+	reg := newTestRegistry(t, `
+users:
+  - name: alice
+    password: {}
+    access_keys:
+      - status: Active
+    signing_certificates:
+      - status: Inactive
+`)
+	for _, marshal := range []func(*fixture) ([]byte, error){marshalFixture, marshalFixtureJSON} {
+		b, err := marshal(reg.Export())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), "0001-01-01") {
+			t.Errorf("a zero timestamp was exported:\n%s", b)
+		}
+		for _, key := range []string{"last_changed", "last_used", "uploaded_at"} {
+			if strings.Contains(string(b), key) {
+				t.Errorf("%s was exported though it was never set:\n%s", key, b)
+			}
+		}
+		if _, err := parseFixture(b); err != nil {
+			t.Errorf("parseFixture: %v\n%s", err, b)
+		}
+	}
+}
+
+func TestExportCredentialReport(t *testing.T) {
+	svc, reg := newTestService(t, credentialReportFixture)
+	if w := query(t, svc, url.Values{"Action": {"GenerateCredentialReport"}}); w.Code != http.StatusOK {
+		t.Fatalf("got %d:\n%s", w.Code, w.Body)
+	}
+	report, _, _ := reg.GetCredentialReport()
+
+	b, err := marshalFixture(reg.Export())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "export.yml")
+	if err := ioutil.WriteFile(file, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l := newFixtureLinter()
+	l.lintFile(file)
+	if len(l.diagnostics) != 0 {
+		t.Errorf("the export does not pass validate: %v\n%s", l.diagnostics, b)
+	}
+	y, err := parseFixture(b)
+	if err != nil {
+		t.Fatalf("parseFixture: %v\n%s", err, b)
+	}
+	reg2, err := buildRegistry(y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok, _ := reg2.GetCredentialReport()
+	if !ok || string(got.Content) != string(report.Content) || !got.GeneratedAt.Equal(report.GeneratedAt) {
+		t.Errorf("got %+v, want %+v", got, report)
+	}
+}
@@ -20,9 +20,11 @@
 package main
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
 	"net/http"
+	"strconv"
 )
 
 const awsFaultNamespaceUrl = "http://webservices.amazon.com/AWSFault/2005-15-09"
@@ -33,32 +35,124 @@ type Fault interface {
 	Message() string
 }
 
-type ErrorResponsePayload struct {
-	Type    string `xml:"Type"`
-	Code    string `xml:"Code"`
-	Message string `xml:"Message"`
-}
-
-func renderFaultResponse(w http.ResponseWriter, requestId string, err Fault) error {
-	w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
-	w.WriteHeader(http.StatusBadRequest)
-	enc := xml.NewEncoder(w)
-	return enc.EncodeElement(
-		struct {
-			ErrorResponsePayload ErrorResponsePayload `xml:"Error"`
-			RequestId            string               `xml:"RequestId"`
-		}{
-			ErrorResponsePayload: ErrorResponsePayload{
-				Type:    err.Type(),
-				Code:    err.Code(),
-				Message: err.Message(),
-			},
-			RequestId: requestId,
-		},
-		xml.StartElement{
-			Name: xml.Name{Space: awsFaultNamespaceUrl, Local: "ErrorResponse"},
-		},
-	)
+type faultSpec struct {
+	Type       string
+	StatusCode int
+}
+
+// faultCatalog maps the error codes of IAM and STS, along with the common
+// ones of the query protocol, to the fault type and HTTP status code the
+// services answer them with.  Codes that are not listed are rendered with
+// 400 for sender faults and 500 for receiver faults.
+var faultCatalog = map[string]faultSpec{
+	// IAM
+	"ConcurrentModification":        {"Sender", http.StatusConflict},
+	"DeleteConflict":                {"Sender", http.StatusConflict},
+	"DuplicateCertificate":          {"Sender", http.StatusConflict},
+	"DuplicateSSHPublicKey":         {"Sender", http.StatusBadRequest},
+	"EntityAlreadyExists":           {"Sender", http.StatusConflict},
+	"EntityTemporarilyUnmodifiable": {"Sender", http.StatusConflict},
+	"InvalidAuthenticationCode":     {"Sender", http.StatusForbidden},
+	"InvalidCertificate":            {"Sender", http.StatusBadRequest},
+	"InvalidInput":                  {"Sender", http.StatusBadRequest},
+	"InvalidPublicKey":              {"Sender", http.StatusBadRequest},
+	"InvalidUserType":               {"Sender", http.StatusBadRequest},
+	"KeyPairMismatch":               {"Sender", http.StatusBadRequest},
+	"LimitExceeded":                 {"Sender", http.StatusConflict},
+	"MalformedCertificate":          {"Sender", http.StatusBadRequest},
+	"MalformedPolicyDocument":       {"Sender", http.StatusBadRequest},
+	"NoSuchEntity":                  {"Sender", http.StatusNotFound},
+	"OpenIdIdpCommunicationError":   {"Sender", http.StatusBadRequest},
+	"PasswordPolicyViolation":       {"Sender", http.StatusBadRequest},
+	"PolicyEvaluation":              {"Receiver", http.StatusInternalServerError},
+	"PolicyNotAttachable":           {"Sender", http.StatusBadRequest},
+	"ReportExpired":                 {"Sender", http.StatusGone},
+	"ReportInProgress":              {"Sender", http.StatusNotFound},
+	"ReportNotPresent":              {"Sender", http.StatusGone},
+	"ServiceFailure":                {"Receiver", http.StatusInternalServerError},
+	"ServiceNotSupported":           {"Sender", http.StatusNotFound},
+	"UnmodifiableEntity":            {"Sender", http.StatusBadRequest},
+	"UnrecognizedPublicKeyEncoding": {"Sender", http.StatusBadRequest},
+	"ValidationError":               {"Sender", http.StatusBadRequest},
+	// STS
+	"ExpiredToken":                         {"Sender", http.StatusBadRequest},
+	"IDPCommunicationError":                {"Sender", http.StatusBadRequest},
+	"IDPRejectedClaim":                     {"Sender", http.StatusForbidden},
+	"InvalidAuthorizationMessageException": {"Sender", http.StatusBadRequest},
+	"InvalidIdentityToken":                 {"Sender", http.StatusBadRequest},
+	"PackedPolicyTooLarge":                 {"Sender", http.StatusBadRequest},
+	"RegionDisabledException":              {"Sender", http.StatusForbidden},
+	// common to the query protocol
+	"AccessDenied":                {"Sender", http.StatusForbidden},
+	"IncompleteSignature":         {"Sender", http.StatusBadRequest},
+	"InternalFailure":             {"Receiver", http.StatusInternalServerError},
+	"InvalidAction":               {"Sender", http.StatusBadRequest},
+	"InvalidClientTokenId":        {"Sender", http.StatusForbidden},
+	"InvalidParameterCombination": {"Sender", http.StatusBadRequest},
+	"InvalidParameterValue":       {"Sender", http.StatusBadRequest},
+	"InvalidQueryParameter":       {"Sender", http.StatusBadRequest},
+	"MalformedQueryString":        {"Sender", http.StatusNotFound},
+	"MethodNotAllowed":            {"Sender", http.StatusMethodNotAllowed},
+	"MissingAction":               {"Sender", http.StatusBadRequest},
+	"MissingAuthenticationToken":  {"Sender", http.StatusForbidden},
+	"MissingParameter":            {"Sender", http.StatusBadRequest},
+	"OptInRequired":               {"Sender", http.StatusForbidden},
+	"RequestEntityTooLarge":       {"Sender", http.StatusRequestEntityTooLarge},
+	"RequestExpired":              {"Sender", http.StatusBadRequest},
+	"ServiceUnavailable":          {"Receiver", http.StatusServiceUnavailable},
+	"SignatureDoesNotMatch":       {"Sender", http.StatusForbidden},
+	"Throttling":                  {"Sender", http.StatusBadRequest},
+	"ThrottlingException":         {"Sender", http.StatusTooManyRequests},
+	"UnsupportedMediaType":        {"Sender", http.StatusUnsupportedMediaType},
+}
+
+// faultType returns the type a fault is rendered with, which is that of the
+// catalog for the codes it lists whichever kind of fault carries them.
+func faultType(err Fault) string {
+	if spec, ok := faultCatalog[err.Code()]; ok {
+		return spec.Type
+	}
+	return err.Type()
+}
+
+// faultStatusCode returns the HTTP status code a fault is rendered with.
+func faultStatusCode(err Fault) int {
+	if spec, ok := faultCatalog[err.Code()]; ok {
+		return spec.StatusCode
+	}
+	if err.Type() == "Receiver" {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// renderFaultResponse writes the error envelope of the query protocol, e.g.
+//
+//	<ErrorResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/">
+//	  <Error>
+//	    <Type>Sender</Type>
+//	    <Code>NoSuchEntity</Code>
+//	    <Message>...</Message>
+//	  </Error>
+//	  <RequestId>...</RequestId>
+//	</ErrorResponse>
+func renderFaultResponse(w http.ResponseWriter, requestId, ns string, err Fault) error {
+	b := &bytes.Buffer{}
+	x := newXMLWriter(b)
+	x.start("ErrorResponse", xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: ns})
+	x.start("Error")
+	x.element("Type", faultType(err))
+	x.element("Code", err.Code())
+	x.element("Message", err.Message())
+	x.end("Error")
+	x.element("RequestId", requestId)
+	x.end("ErrorResponse")
+	b.WriteByte('\n')
+	w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(faultStatusCode(err))
+	_, _err := w.Write(b.Bytes())
+	return _err
 }
 
 type SenderFault struct {
@@ -81,3 +175,24 @@ func (fault *SenderFault) Message() string {
 func (fault *SenderFault) Error() string {
 	return fmt.Sprintf("SenderFault: Code=%s, Message=%s", fault.Code_, fault.Message_)
 }
+
+type ReceiverFault struct {
+	Code_    string
+	Message_ string
+}
+
+func (fault *ReceiverFault) Type() string {
+	return "Receiver"
+}
+
+func (fault *ReceiverFault) Code() string {
+	return fault.Code_
+}
+
+func (fault *ReceiverFault) Message() string {
+	return fault.Message_
+}
+
+func (fault *ReceiverFault) Error() string {
+	return fmt.Sprintf("ReceiverFault: Code=%s, Message=%s", fault.Code_, fault.Message_)
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRenderFaultResponse(t *testing.T) {
+	for _, c := range []struct {
+		fault  Fault
+		status int
+		typ    string
+	}{
+		{&SenderFault{Code_: "NoSuchEntity"}, http.StatusNotFound, "Sender"},
+		// the catalog tells the type of the codes it lists
+		{&SenderFault{Code_: "ServiceFailure"}, http.StatusInternalServerError, "Receiver"},
+		{&ReceiverFault{Code_: "LimitExceeded"}, http.StatusConflict, "Sender"},
+		{&SenderFault{Code_: "Unlisted"}, http.StatusBadRequest, "Sender"},
+		{&ReceiverFault{Code_: "Unlisted"}, http.StatusInternalServerError, "Receiver"},
+	} {
+		w := httptest.NewRecorder()
+		if err := renderFaultResponse(w, "id", "ns", c.fault); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != c.status || !strings.Contains(w.Body.String(), "<Type>"+c.typ+"</Type>") {
+			t.Errorf("%s: got %d, want %d and type %s:\n%s", c.fault.Code(), w.Code, c.status, c.typ, w.Body)
+		}
+		w = httptest.NewRecorder()
+		setQueryErrorHeader(w, c.fault)
+		if got, want := w.Header().Get("x-amzn-query-error"), c.fault.Code()+";"+c.typ; got != want {
+			t.Errorf("%s: got x-amzn-query-error %q, want %q", c.fault.Code(), got, want)
+		}
+	}
+}
@@ -0,0 +1,565 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/goccy/go-yaml/token"
+)
+
+// position is where an entity is defined among the fixture files.
+type position struct {
+	file string
+	line int
+}
+
+func (p position) String() string {
+	if p.line == 0 {
+		return p.file
+	}
+	return fmt.Sprintf("%s:%d", p.file, p.line)
+}
+
+// origin returns where the entity of kind named name is defined, if known.
+func (y *fixture) origin(kind, name string) (position, bool) {
+	p, ok := y.origins[kind+" "+name]
+	return p, ok
+}
+
+// errorf formats an error about the entity of kind named name, prefixed with
+// where it is defined.
+func (y *fixture) errorf(kind, name, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	if p, ok := y.origin(kind, name); ok {
+		return fmt.Errorf("%s: %w", p, err)
+	}
+	return err
+}
+
+// fixtureLoader composes a fixture out of several files.  Every file is
+// read at most once, however many times it is named or included.
+type fixtureLoader struct {
+	fixture     fixture
+	credentials []importedCredentials
+	files       []string
+	loaded      map[string]bool
+	stack       []string
+}
+
+// loadFixtures reads and merges the fixture files at paths, which may also
+// be directories, whose *.yml and *.yaml files are read in lexical order.
+// It returns the merged fixture along with the files it read.
+//
+// Besides YAML fixtures, *.json files are read as the output of
+// GetAccountAuthorizationDetails, *.tfstate files as Terraform state, and
+// CloudFormation templates, in JSON or YAML, are told apart by their
+// top-level keys.  *.csv files are read as credential reports, whose
+// information is applied to the users they name wherever those are defined.
+func loadFixtures(paths []string) (*fixture, []string, error) {
+	l := &fixtureLoader{loaded: map[string]bool{}}
+	l.fixture.origins = map[string]position{}
+	for _, p := range paths {
+		if err := l.loadPath(p); err != nil {
+			return nil, l.files, err
+		}
+	}
+	for _, c := range l.credentials {
+		if err := l.fixture.applyCredentials(c); err != nil {
+			return nil, l.files, err
+		}
+	}
+	// imported entities date from the import where their sources, credential
+	// reports among them, do not tell, rather than from the epoch as those of
+	// YAML fixtures do
+	now := time.Now().UTC().Truncate(time.Second)
+	l.fixture.creationDates(func(key string, t *time.Time) {
+		if !l.fixture.importDated[key] {
+			return
+		}
+		if t.IsZero() {
+			*t = now
+		} else {
+			delete(l.fixture.importDated, key)
+		}
+	})
+	return &l.fixture, l.files, nil
+}
+
+// loadFixtureBytes reads a fixture that does not come from a file, and
+// hence cannot include any, with the same checks as loadFixtures.  name
+// stands for the file in errors.
+func loadFixtureBytes(name string, b []byte) (*fixture, error) {
+	y, err := parseFixture(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if len(y.Include) > 0 {
+		return nil, fmt.Errorf("%s: include is only supported in files", name)
+	}
+	origins, err := fixtureOrigins(name, b, y)
+	if err != nil {
+		return nil, err
+	}
+	l := &fixtureLoader{loaded: map[string]bool{}}
+	l.fixture.origins = map[string]position{}
+	if err := l.merge(name, y, origins); err != nil {
+		return nil, err
+	}
+	return &l.fixture, nil
+}
+
+// fixtureFiles lists the fixture files in dir.
+func fixtureFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if !e.IsDir() && (ext == ".yml" || ext == ".yaml") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (l *fixtureLoader) loadPath(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return l.loadFile(path)
+	}
+	files, err := fixtureFiles(path)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := l.loadFile(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *fixtureLoader) loadFile(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	for _, p := range l.stack {
+		if p == abs {
+			return fmt.Errorf("%s: include cycle: %s", path, strings.Join(append(l.stack, abs), " -> "))
+		}
+	}
+	if l.loaded[abs] {
+		return nil
+	}
+	l.loaded[abs] = true
+	l.files = append(l.files, path)
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var load func([]byte) (*fixture, error)
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case ext == ".csv":
+		c, err := loadCredentialReport(path, b)
+		if err != nil {
+			return err
+		}
+		l.credentials = append(l.credentials, c...)
+		return nil
+	case ext == ".tfstate":
+		load = loadTerraformState
+	case ext == ".template" || isCloudFormationTemplate(b):
+		load = func(b []byte) (*fixture, error) {
+			return loadCloudFormationTemplate(path, b)
+		}
+	case ext == ".json":
+		load = loadAuthorizationDetails
+	}
+	if load != nil {
+		y, err := load(b)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		y.importDated = map[string]bool{}
+		y.creationDates(func(key string, t *time.Time) {
+			if t.IsZero() {
+				y.importDated[key] = true
+			}
+		})
+		return l.merge(path, y, nil)
+	}
+	af, err := parseYAML(b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := expandEnv(path, af); err != nil {
+		return err
+	}
+	y, err := decodeFixture(af)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	origins, err := fixtureOrigins(path, b, y)
+	if err != nil {
+		return err
+	}
+
+	// included files are merged first, as though they were written in place
+	// of the directive
+	l.stack = append(l.stack, abs)
+	for _, inc := range y.Include {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(path), inc)
+		}
+		if err := l.loadPath(inc); err != nil {
+			l.stack = l.stack[:len(l.stack)-1]
+			return err
+		}
+	}
+	l.stack = l.stack[:len(l.stack)-1]
+	return l.merge(path, y, origins)
+}
+
+// merge adds the entities of y, read from file, to the fixture composed so
+// far.  Each entity may only be defined once; account settings may be given
+// by several files as long as they agree.
+func (l *fixtureLoader) merge(file string, y *fixture, origins map[string][]position) error {
+	f := &l.fixture
+	// seen counts the definitions of each entity in y met so far, to pick
+	// the position of the one at hand
+	seen := map[string]int{}
+	define := func(kind, name string) error {
+		key := kind + " " + name
+		p := position{file: file}
+		if n := seen[key]; n < len(origins[key]) {
+			p = origins[key][n]
+		}
+		seen[key]++
+		if prev, ok := f.origins[key]; ok {
+			return fmt.Errorf("%s: %s %s is already defined at %s", p, kind, name, prev)
+		}
+		f.origins[key] = p
+		return nil
+	}
+	for _, u := range y.Users {
+		if err := define("user", u.Name); err != nil {
+			return err
+		}
+	}
+	for _, g := range y.Groups {
+		if err := define("group", g.Name); err != nil {
+			return err
+		}
+	}
+	for _, r := range y.Roles {
+		if err := define("role", r.Name); err != nil {
+			return err
+		}
+	}
+	for _, p := range y.InstanceProfiles {
+		if err := define("instance profile", p.Name); err != nil {
+			return err
+		}
+	}
+	for i := range y.Policies {
+		if err := define("policy", policyKey(&y.Policies[i])); err != nil {
+			return err
+		}
+	}
+	for i := range y.OpenIDConnectProviders {
+		if err := define("OpenID Connect provider", oidcProviderKey(&y.OpenIDConnectProviders[i])); err != nil {
+			return err
+		}
+	}
+	for _, p := range y.SAMLProviders {
+		if err := define("SAML provider", p.Name); err != nil {
+			return err
+		}
+	}
+	for _, d := range y.VirtualMFADevices {
+		if err := define("virtual MFA device", d.Name); err != nil {
+			return err
+		}
+	}
+	for _, c := range y.ServerCertificates {
+		if err := define("server certificate", c.Name); err != nil {
+			return err
+		}
+	}
+	for key := range y.importDated {
+		if f.importDated == nil {
+			f.importDated = map[string]bool{}
+		}
+		f.importDated[key] = true
+	}
+	// the instance profiles roles declare are checked for duplicates when
+	// the registry is built, but are located here
+	for _, r := range y.Roles {
+		if r.InstanceProfile != nil {
+			name := r.InstanceProfile.Name
+			if name == "" {
+				name = r.Name
+			}
+			if _, ok := f.origins["instance profile "+name]; !ok {
+				f.origins["instance profile "+name] = f.origins["role "+r.Name]
+			}
+		}
+	}
+	f.Users = append(f.Users, y.Users...)
+	f.Groups = append(f.Groups, y.Groups...)
+	f.Roles = append(f.Roles, y.Roles...)
+	f.InstanceProfiles = append(f.InstanceProfiles, y.InstanceProfiles...)
+	f.Policies = append(f.Policies, y.Policies...)
+	f.OpenIDConnectProviders = append(f.OpenIDConnectProviders, y.OpenIDConnectProviders...)
+	f.SAMLProviders = append(f.SAMLProviders, y.SAMLProviders...)
+	f.VirtualMFADevices = append(f.VirtualMFADevices, y.VirtualMFADevices...)
+	f.ServerCertificates = append(f.ServerCertificates, y.ServerCertificates...)
+
+	if y.Account.Id != "" {
+		if f.Account.Id != "" && f.Account.Id != y.Account.Id {
+			return fmt.Errorf("%s: account ID %s conflicts with %s given elsewhere", file, y.Account.Id, f.Account.Id)
+		}
+		f.Account.Id = y.Account.Id
+	}
+
+	if y.Account.Alias != "" {
+		if f.Account.Alias != "" && f.Account.Alias != y.Account.Alias {
+			return fmt.Errorf("%s: account alias %s conflicts with %s given elsewhere", file, y.Account.Alias, f.Account.Alias)
+		}
+		f.Account.Alias = y.Account.Alias
+	}
+	for k, v := range y.Account.Quotas {
+		if pv, ok := f.Account.Quotas[k]; ok && pv != v {
+			return fmt.Errorf("%s: quota %s of %d conflicts with %d given elsewhere", file, k, v, pv)
+		}
+		if f.Account.Quotas == nil {
+			f.Account.Quotas = map[string]int64{}
+		}
+		f.Account.Quotas[k] = v
+	}
+
+	if y.CredentialReport != nil {
+		if f.CredentialReport != nil {
+			return fmt.Errorf("%s: a credential report is given elsewhere as well", file)
+		}
+		f.CredentialReport = y.CredentialReport
+	}
+	return nil
+}
+
+// parseYAML parses b into an AST.  The parser panics on some malformed
+// flow collections, which is reported as an error instead.
+func parseYAML(b []byte) (f *ast.File, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed YAML: %v", r)
+		}
+	}()
+	return parser.ParseBytes(b, 0)
+}
+
+// fixtureOrigins locates the entities of y, which was parsed out of b.  An
+// entity defined more than once has the position of every definition, in
+// the order they are written.
+func fixtureOrigins(file string, b []byte, y *fixture) (map[string][]position, error) {
+	af, err := parseYAML(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	origins := map[string][]position{}
+	locate := func(key, kind string, names []string) {
+		p, err := yaml.PathString("$." + key)
+		if err != nil {
+			return
+		}
+		n, err := p.FilterFile(af)
+		if err != nil {
+			return
+		}
+		seq, ok := n.(*ast.SequenceNode)
+		if !ok {
+			return
+		}
+		for i, v := range seq.Values {
+			if i < len(names) {
+				key := kind + " " + names[i]
+				origins[key] = append(origins[key], position{file: file, line: v.GetToken().Position.Line})
+			}
+		}
+	}
+	var names []string
+	for _, u := range y.Users {
+		names = append(names, u.Name)
+	}
+	locate("users", "user", names)
+	names = nil
+	for _, g := range y.Groups {
+		names = append(names, g.Name)
+	}
+	locate("groups", "group", names)
+	names = nil
+	for _, r := range y.Roles {
+		names = append(names, r.Name)
+	}
+	locate("roles", "role", names)
+	names = nil
+	for _, p := range y.InstanceProfiles {
+		names = append(names, p.Name)
+	}
+	locate("instance_profiles", "instance profile", names)
+	names = nil
+	for i := range y.Policies {
+		names = append(names, policyKey(&y.Policies[i]))
+	}
+	locate("policies", "policy", names)
+	names = nil
+	for i := range y.OpenIDConnectProviders {
+		names = append(names, oidcProviderKey(&y.OpenIDConnectProviders[i]))
+	}
+	locate("open_id_connect_providers", "OpenID Connect provider", names)
+	names = nil
+	for _, p := range y.SAMLProviders {
+		names = append(names, p.Name)
+	}
+	locate("saml_providers", "SAML provider", names)
+	names = nil
+	for _, d := range y.VirtualMFADevices {
+		names = append(names, d.Name)
+	}
+	locate("virtual_mfa_devices", "virtual MFA device", names)
+	names = nil
+	for _, c := range y.ServerCertificates {
+		names = append(names, c.Name)
+	}
+	locate("server_certificates", "server certificate", names)
+	return origins, nil
+}
+
+var envRefRegexp = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)((:?-)([^}\n]*))?\}`)
+
+// expandEnv substitutes ${NAME} in the scalar values of a parsed fixture
+// file with the value of the environment variable NAME.  ${NAME-DEFAULT}
+// stands for DEFAULT when NAME is unset, and ${NAME:-DEFAULT} when NAME is
+// unset or empty.  $$ stands for a literal $.  Mapping keys and comments are
+// left as they are, and so are references of other forms, such as the
+// ${aws:username} of policy documents.
+//
+// The values never become YAML syntax, but a plain scalar is resolved again
+// once substituted, so that e.g. a quota can be given by a variable.
+func expandEnv(file string, f *ast.File) error {
+	var err error
+	expand := func(tk *token.Token, s string) string {
+		return envRefRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+			if ref == "$$" {
+				return "$"
+			}
+			m := envRefRegexp.FindStringSubmatch(ref)
+			v, ok := os.LookupEnv(m[1])
+			switch {
+			case m[3] == ":-" && v == "":
+				return m[4]
+			case ok:
+				return v
+			case m[3] == "-":
+				return m[4]
+			}
+			if err == nil {
+				err = fmt.Errorf("%s:%d: environment variable %s is not set", file, tk.Position.Line, m[1])
+			}
+			return ref
+		})
+	}
+	var walk func(n ast.Node, resolve bool) ast.Node
+	walk = func(n ast.Node, resolve bool) ast.Node {
+		switch n := n.(type) {
+		case *ast.MappingNode:
+			for _, v := range n.Values {
+				walk(v, true)
+			}
+		case *ast.MappingValueNode:
+			n.Value = walk(n.Value, true)
+		case *ast.SequenceNode:
+			for i, v := range n.Values {
+				n.Values[i] = walk(v, true)
+			}
+		case *ast.AnchorNode:
+			n.Value = walk(n.Value, resolve)
+		case *ast.TagNode:
+			// the tag tells the type
+			n.Value = walk(n.Value, false)
+		case *ast.LiteralNode:
+			n.Value.Value = expand(n.Start, n.Value.Value)
+		case *ast.StringNode:
+			v := expand(n.Token, n.Value)
+			if v == n.Value {
+				break
+			}
+			if resolve && n.Token.Type == token.StringType {
+				return plainScalar(v, n.Token.Position)
+			}
+			n.Value = v
+		}
+		return n
+	}
+	for _, doc := range f.Docs {
+		doc.Body = walk(doc.Body, true)
+	}
+	return err
+}
+
+// plainScalar makes the node a plain scalar of the value s would be parsed
+// into, which is a string unless s reads as e.g. a number or a boolean.
+func plainScalar(s string, pos *token.Position) ast.Node {
+	tk := token.New(s, s, pos)
+	switch tk.Type {
+	case token.NullType:
+		return ast.Null(tk)
+	case token.BoolType:
+		return ast.Bool(tk)
+	case token.IntegerType, token.BinaryIntegerType, token.OctetIntegerType, token.HexIntegerType:
+		return ast.Integer(tk)
+	case token.FloatType:
+		return ast.Float(tk)
+	case token.InfinityType:
+		return ast.Infinity(tk)
+	case token.NanType:
+		return ast.Nan(tk)
+	}
+	return ast.String(token.String(s, s, pos))
+}
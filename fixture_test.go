@@ -0,0 +1,156 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("EMU_ALIAS", "team-a")
+	t.Setenv("EMU_QUOTA", "7")
+	t.Setenv("EMU_TRUE", "true")
+	t.Setenv("EMU_EMPTY", "")
+	t.Setenv("EMU_YAML", "a: [b, # c")
+	file := filepath.Join(t.TempDir(), "fixture.yml")
+	writeTestFile(t, file, `# ${EMU_UNSET} in a comment is left alone
+account:
+  alias: ${EMU_ALIAS}
+  quotas:
+    UsersQuota: ${EMU_QUOTA}
+users:
+  - name: alice
+    mfa_active: ${EMU_TRUE}
+    tags:
+      quoted: "${EMU_QUOTA}"
+      empty: x${EMU_EMPTY}y
+      default: ${EMU_EMPTY:-fallback}
+      unset-default: ${EMU_UNSET-fallback}
+      set-empty: "${EMU_EMPTY-fallback}"
+    inline_policies:
+      home: |
+        {"Statement": [{"Effect": "Allow", "Action": "s3:*", "Resource": "arn:aws:s3:::${EMU_ALIAS}/${aws:username}/*"}]}
+roles:
+  - name: yaml
+    description: ${EMU_YAML}
+  - name: dollar
+    description: $${EMU_ALIAS}
+`)
+	y, _, err := loadFixtures([]string{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if y.Account.Alias != "team-a" || y.Account.Quotas["UsersQuota"] != 7 {
+		t.Errorf("got account %+v", y.Account)
+	}
+	u := y.Users[0]
+	if !u.MFAActive {
+		t.Error("a plain scalar substituted was not resolved as a boolean")
+	}
+	for k, want := range map[string]string{
+		"quoted":        "7",
+		"empty":         "xy",
+		"default":       "fallback",
+		"unset-default": "fallback",
+		"set-empty":     "",
+	} {
+		if got, ok := u.Tags[k]; !ok || got != want {
+			t.Errorf("tag %s: got %q, want %q", k, got, want)
+		}
+	}
+	// a value that looks like YAML stays a string
+	if got, want := y.Roles[0].Description, "a: [b, # c"; got != want {
+		t.Errorf("got description %q, want %q", got, want)
+	}
+	if got, want := y.Roles[1].Description, "${EMU_ALIAS}"; got != want {
+		t.Errorf("got description %q, want %q", got, want)
+	}
+	if want := "arn:aws:s3:::team-a/${aws:username}/*"; !strings.Contains(u.InlinePolicies["home"], want) {
+		t.Errorf("got policy %s, want it to contain %s", u.InlinePolicies["home"], want)
+	}
+
+	// validate reads the file the same way
+	l := newFixtureLinter()
+	l.lintFile(file)
+	if len(l.diagnostics) != 0 {
+		t.Errorf("validate: %v", l.diagnostics)
+	}
+}
+
+func TestExpandEnvErrors(t *testing.T) {
+	t.Setenv("EMU_EMPTY", "")
+	for _, c := range []struct {
+		fixture string
+		err     string
+	}{
+		{"users:\n  - name: alice\n    path: ${EMU_UNSET}\n", "fixture.yml:3: environment variable EMU_UNSET is not set"},
+		{"users:\n  - name: alice\n    tags:\n      team: |\n        ${EMU_UNSET}\n", "fixture.yml:4: environment variable EMU_UNSET is not set"},
+		// a variable that is set counts even if empty
+		{"users:\n  - name: alice${EMU_EMPTY}\n", ""},
+		// keys are not expanded
+		{"users:\n  - name: alice\n    tags:\n      ${EMU_UNSET}: x\n", ""},
+	} {
+		file := filepath.Join(t.TempDir(), "fixture.yml")
+		writeTestFile(t, file, c.fixture)
+		_, _, err := loadFixtures([]string{file})
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("%q: %v", c.fixture, err)
+			}
+		} else if err == nil || !strings.HasSuffix(err.Error(), c.err) {
+			t.Errorf("%q: got error %v, want %s", c.fixture, err, c.err)
+		}
+	}
+}
+
+func TestLoadFixturesDuplicates(t *testing.T) {
+	for _, c := range []struct {
+		files []string
+		err   string
+	}{
+		{
+			[]string{"users:\n  - name: alice\n  - name: bob\n  - name: alice\n"},
+			"d0.yml:4: user alice is already defined at d0.yml:2",
+		},
+		{
+			[]string{"roles:\n  - name: app\n  - name: app\n  - name: app\n"},
+			"d0.yml:3: role app is already defined at d0.yml:2",
+		},
+		{
+			[]string{"users:\n  - name: alice\n", "users:\n  - name: bob\n  - name: alice\n"},
+			"d1.yml:3: user alice is already defined at d0.yml:2",
+		},
+	} {
+		dir := t.TempDir()
+		var paths []string
+		for i, f := range c.files {
+			path := filepath.Join(dir, fmt.Sprintf("d%d.yml", i))
+			writeTestFile(t, path, f)
+			paths = append(paths, path)
+		}
+		_, _, err := loadFixtures(paths)
+		if err == nil || strings.ReplaceAll(err.Error(), dir+string(filepath.Separator), "") != c.err {
+			t.Errorf("%q: got error %v, want %s", c.files, err, c.err)
+		}
+	}
+}
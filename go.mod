@@ -4,16 +4,18 @@ go 1.23
 
 require (
 	github.com/aws/aws-sdk-go-v2 v0.24.0
+	github.com/fxamacker/cbor/v2 v2.7.0
 	github.com/goccy/go-yaml v1.12.0
 	github.com/google/uuid v1.6.0
 	github.com/mattn/go-isatty v0.0.20
-	github.com/rs/zerolog v1.33.0
+	go.etcd.io/bbolt v1.3.11
 )
 
 require (
 	github.com/fatih/color v1.10.0 // indirect
 	github.com/jmespath/go-jmespath v0.0.0-20180206201540-c2b33e8439af // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	golang.org/x/sys v0.12.0 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 )
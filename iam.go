@@ -22,46 +22,29 @@ package main
 import (
 	"fmt"
 	"log/slog"
-	"math/rand"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
 )
 
-const groupIdPrefix = "AGPA"
-const userIdPrefix = "AIDA"
-
-var alnum = []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
-
 var iamService = &Service{
 	Name: "iam",
 }
 
-func randomAlnum(l int) string {
-	b := make([]byte, l)
-	for i := 0; i < l; i++ {
-		b[i] = alnum[rand.Intn(len(alnum))]
-	}
-	return string(b)
-}
-
-func generateGroupId() string {
-	return groupIdPrefix + randomAlnum(17)
-}
-
-func generateUserId() string {
-	return userIdPrefix + randomAlnum(17)
-}
-
 type IAMGroup struct {
-	Id        string     `yaml:"id"`
-	Name      string     `yaml:"name"`
-	CreatedAt time.Time  `yaml:"created_at"`
-	Path      string     `yaml:"path"`
-	Members   []*IAMUser `yaml:"-"`
+	Id              string            `yaml:"id"`
+	Name            string            `yaml:"name"`
+	CreatedAt       time.Time         `yaml:"created_at"`
+	Path            string            `yaml:"path"`
+	Tags            map[string]string `yaml:"tags,omitempty"`
+	InlinePolicies  map[string]string `yaml:"inline_policies,omitempty"`
+	ManagedPolicies []string          `yaml:"managed_policies,omitempty"`
+	Members         []*IAMUser        `yaml:"-"`
 }
 
 func (g *IAMGroup) BuildArn(accountId string) string {
@@ -73,11 +56,26 @@ func (g *IAMGroup) BuildArn(accountId string) string {
 	return fmt.Sprintf("arn:aws:iam::%s:group/%s%s%s", accountId, path, slash, g.Name)
 }
 
+func groupName(g *IAMGroup) string {
+	return g.Name
+}
+
+func groupPath(g *IAMGroup) string {
+	return g.Path
+}
+
 type IAMUser struct {
-	Id        string    `yaml:"id"`
-	Name      string    `yaml:"name"`
-	CreatedAt time.Time `yaml:"created_at"`
-	Path      string    `yaml:"path"`
+	Id                  string                  `yaml:"id"`
+	Name                string                  `yaml:"name"`
+	CreatedAt           time.Time               `yaml:"created_at"`
+	Path                string                  `yaml:"path"`
+	Tags                map[string]string       `yaml:"tags,omitempty"`
+	Password            *IAMUserPassword        `yaml:"password,omitempty"`
+	MFAActive           bool                    `yaml:"mfa_active,omitempty"`
+	AccessKeys          []IAMAccessKey          `yaml:"access_keys,omitempty"`
+	SigningCertificates []IAMSigningCertificate `yaml:"signing_certificates,omitempty"`
+	InlinePolicies      map[string]string       `yaml:"inline_policies,omitempty"`
+	ManagedPolicies     []string                `yaml:"managed_policies,omitempty"`
 }
 
 func (u *IAMUser) BuildArn(accountId string) string {
@@ -89,28 +87,94 @@ func (u *IAMUser) BuildArn(accountId string) string {
 	return fmt.Sprintf("arn:aws:iam::%s:user/%s%s%s", accountId, path, slash, u.Name)
 }
 
+func userName(u *IAMUser) string {
+	return u.Name
+}
+
+func userPath(u *IAMUser) string {
+	return u.Path
+}
+
+// IAMRegistry is the store of the emulated IAM entities.  The methods that
+// enumerate entities return them in ascending order of their names, which is
+// also the order pagination markers follow.
 type IAMRegistry interface {
 	GetGroupByName(string) (*IAMGroup, bool, error)
 	GetUserByName(string) (*IAMUser, bool, error)
 	GetUsers() ([]*IAMUser, error)
 	GetGroups() ([]*IAMGroup, error)
+	GetRoleByName(string) (*IAMRole, bool, error)
+	GetRoles() ([]*IAMRole, error)
+	GetInstanceProfileByName(string) (*IAMInstanceProfile, bool, error)
+	GetInstanceProfiles() ([]*IAMInstanceProfile, error)
+	GetInstanceProfilesForRole(string) ([]*IAMInstanceProfile, error)
+	GetPolicies() ([]*IAMPolicy, error)
+	GetPolicyByArn(string) (*IAMPolicy, bool, error)
+	GetOpenIDConnectProviders() ([]*IAMOpenIDConnectProvider, error)
+	GetOpenIDConnectProvider(arn string) (*IAMOpenIDConnectProvider, bool, error)
+	CreateOpenIDConnectProvider(*IAMOpenIDConnectProvider) error
+	DeleteOpenIDConnectProvider(arn string) error
+	GetSAMLProviders() ([]*IAMSAMLProvider, error)
+	GetSAMLProvider(arn string) (*IAMSAMLProvider, bool, error)
+	CreateSAMLProvider(*IAMSAMLProvider) error
+	DeleteSAMLProvider(arn string) error
+	GetVirtualMFADevices() ([]*IAMVirtualMFADevice, error)
+	GetVirtualMFADevice(serial string) (*IAMVirtualMFADevice, bool, error)
+	CreateVirtualMFADevice(*IAMVirtualMFADevice) error
+	DeleteVirtualMFADevice(serial string) error
+	GetServerCertificates() ([]*IAMServerCertificate, error)
+	GetServerCertificateByName(string) (*IAMServerCertificate, bool, error)
+	UploadServerCertificate(*IAMServerCertificate) error
+	DeleteServerCertificate(string) error
+	CreateInstanceProfile(*IAMInstanceProfile) error
+	DeleteInstanceProfile(string) error
+	AddRoleToInstanceProfile(profileName, roleName string) error
+	RemoveRoleFromInstanceProfile(profileName, roleName string) error
+	GetAccountId() (string, error)
+	GetAccountAliases() ([]string, error)
+	CreateAccountAlias(string) error
+	DeleteAccountAlias(string) error
+	GetAccountQuotas() (map[string]int64, error)
+	GetCredentialReport() (*IAMCredentialReport, bool, error)
+	PutCredentialReport(*IAMCredentialReport) error
+	TagUser(string, []iam.Tag) error
+	UntagUser(string, []string) error
+	TagRole(string, []iam.Tag) error
+	UntagRole(string, []string) error
+	TagInstanceProfile(string, []iam.Tag) error
+	UntagInstanceProfile(string, []string) error
+	TagPolicy(arn string, tags []iam.Tag) error
+	UntagPolicy(arn string, keys []string) error
+	TagOpenIDConnectProvider(arn string, tags []iam.Tag) error
+	UntagOpenIDConnectProvider(arn string, keys []string) error
+	TagSAMLProvider(arn string, tags []iam.Tag) error
+	UntagSAMLProvider(arn string, keys []string) error
+	TagMFADevice(serial string, tags []iam.Tag) error
+	UntagMFADevice(serial string, keys []string) error
+	TagServerCertificate(string, []iam.Tag) error
+	UntagServerCertificate(string, []string) error
+}
+
+func noSuchUser(name string) error {
+	return &SenderFault{
+		Code_:    "NoSuchEntity",
+		Message_: fmt.Sprintf("The user with name %s cannot be found.", name),
+	}
 }
 
 func registerAPISet(reg IAMRegistry) {
 	iamAPISet := NewAPISet("2010-05-08", "https://iam.amazonaws.com/doc/2010-05-08/")
+	iamAPISet.ServiceShape = "AWSIdentityManagementV20100508"
 	iamAPISet.RegisterHandler(
 		&QueryOperationHandler{
 			Name_: "GetGroup",
 			Proto: iam.GetGroupInput{},
 			Handler: func(req *aws.Request) (*aws.Response, error) {
-				accountId := getAccountId(req)
-				params := req.Params.(*iam.GetGroupInput)
-				if params.GroupName == nil {
-					return nil, &SenderFault{
-						Code_:    "MissingParameter",
-						Message_: "GroupName",
-					}
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
 				}
+				params := req.Params.(*iam.GetGroupInput)
 				g, ok, err := reg.GetGroupByName(*params.GroupName)
 				if err != nil {
 					return nil, err
@@ -122,6 +186,13 @@ func registerAPISet(reg IAMRegistry) {
 					}
 				}
 
+				members := append([]*IAMUser(nil), g.Members...)
+				sortByKey(members, userName)
+				members, marker, err := paginate(members, userName, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+
 				out := &iam.GetGroupOutput{
 					Group: &iam.Group{
 						Arn:        aws.String(g.BuildArn(accountId)),
@@ -130,10 +201,11 @@ func registerAPISet(reg IAMRegistry) {
 						GroupName:  aws.String(g.Name),
 						Path:       aws.String(g.Path),
 					},
-					IsTruncated: aws.Bool(false),
+					IsTruncated: aws.Bool(marker != nil),
+					Marker:      marker,
 				}
-				out.Users = make([]iam.User, len(g.Members))
-				for i, u := range g.Members {
+				out.Users = make([]iam.User, len(members))
+				for i, u := range members {
 					out.Users[i] = iam.User{
 						Arn:        aws.String(u.BuildArn(accountId)),
 						CreateDate: aws.Time(u.CreatedAt),
@@ -155,7 +227,10 @@ func registerAPISet(reg IAMRegistry) {
 			Name_: "GetUser",
 			Proto: iam.GetUserInput{},
 			Handler: func(req *aws.Request) (*aws.Response, error) {
-				accountId := getAccountId(req)
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
 				params := req.Params.(*iam.GetUserInput)
 				if params.UserName == nil {
 					return nil, &SenderFault{
@@ -168,10 +243,7 @@ func registerAPISet(reg IAMRegistry) {
 					return nil, err
 				}
 				if !ok {
-					return nil, &SenderFault{
-						Code_:    "NoSuchEntity",
-						Message_: fmt.Sprintf("The user with name %s cannot be found.", *params.UserName),
-					}
+					return nil, noSuchUser(*params.UserName)
 				}
 
 				out := &iam.GetUserOutput{
@@ -183,6 +255,9 @@ func registerAPISet(reg IAMRegistry) {
 						Path:       aws.String(u.Path),
 					},
 				}
+				if len(u.Tags) > 0 {
+					out.User.Tags = buildTags(u.Tags)
+				}
 				return &aws.Response{
 					Request: &aws.Request{
 						Data: out,
@@ -196,15 +271,26 @@ func registerAPISet(reg IAMRegistry) {
 			Name_: "ListUsers",
 			Proto: iam.ListUsersInput{},
 			Handler: func(req *aws.Request) (*aws.Response, error) {
-				accountId := getAccountId(req)
-
-				out := &iam.ListUsersOutput{
-					IsTruncated: aws.Bool(false),
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
 				}
+				params := req.Params.(*iam.ListUsersInput)
+
 				users, err := reg.GetUsers()
 				if err != nil {
 					return nil, err
 				}
+				users = filterByPathPrefix(users, userPath, params.PathPrefix)
+				users, marker, err := paginate(users, userName, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+
+				out := &iam.ListUsersOutput{
+					IsTruncated: aws.Bool(marker != nil),
+					Marker:      marker,
+				}
 
 				out.Users = make([]iam.User, len(users))
 				for i, u := range users {
@@ -229,15 +315,26 @@ func registerAPISet(reg IAMRegistry) {
 			Name_: "ListGroups",
 			Proto: iam.ListGroupsInput{},
 			Handler: func(req *aws.Request) (*aws.Response, error) {
-				accountId := getAccountId(req)
-
-				out := &iam.ListGroupsOutput{
-					IsTruncated: aws.Bool(false),
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
 				}
+				params := req.Params.(*iam.ListGroupsInput)
+
 				groups, err := reg.GetGroups()
 				if err != nil {
 					return nil, err
 				}
+				groups = filterByPathPrefix(groups, groupPath, params.PathPrefix)
+				groups, marker, err := paginate(groups, groupName, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+
+				out := &iam.ListGroupsOutput{
+					IsTruncated: aws.Bool(marker != nil),
+					Marker:      marker,
+				}
 
 				out.Groups = make([]iam.Group, len(groups))
 				for i, u := range groups {
@@ -258,74 +355,262 @@ func registerAPISet(reg IAMRegistry) {
 		},
 	)
 
+	registerRoleHandlers(iamAPISet, reg)
+	registerInstanceProfileHandlers(iamAPISet, reg)
+	registerPolicyHandlers(iamAPISet, reg)
+	registerProviderHandlers(iamAPISet, reg)
+	registerMFADeviceHandlers(iamAPISet, reg)
+	registerServerCertificateHandlers(iamAPISet, reg)
+	registerSimulationHandlers(iamAPISet, reg)
+	registerAccountHandlers(iamAPISet, reg)
+	registerAuthorizationDetailsHandlers(iamAPISet, reg)
+	registerCredentialReportHandlers(iamAPISet, reg)
+	registerTagHandlers(iamAPISet, reg)
+
 	iamService.AddAPISet(iamAPISet)
 }
 
 type BasicIAMRegistry struct {
-	groups map[string]*IAMGroup
-	users  map[string]*IAMUser
+	mu               sync.RWMutex
+	groups           *orderedIndex[*IAMGroup]
+	users            *orderedIndex[*IAMUser]
+	roles            *orderedIndex[*IAMRole]
+	instanceProfiles *orderedIndex[*IAMInstanceProfile]
+	policies         *orderedIndex[*IAMPolicy]
+	oidcProviders    *orderedIndex[*IAMOpenIDConnectProvider]
+	samlProviders    *orderedIndex[*IAMSAMLProvider]
+	mfaDevices       *orderedIndex[*IAMVirtualMFADevice]
+	serverCerts      *orderedIndex[*IAMServerCertificate]
+	account          IAMAccount
+	ids              *IDAllocator
+	credentialReport *IAMCredentialReport
+	store            Store
 }
 
 func (reg *BasicIAMRegistry) GetGroupByName(name string) (*IAMGroup, bool, error) {
-	g, ok := reg.groups[name]
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	g, ok := reg.groups.get(name)
 	return g, ok, nil
 }
 
 func (reg *BasicIAMRegistry) GetUserByName(name string) (*IAMUser, bool, error) {
-	u, ok := reg.users[name]
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	u, ok := reg.users.get(name)
 	return u, ok, nil
 }
 
 func (reg *BasicIAMRegistry) GetUsers() ([]*IAMUser, error) {
-	users := make([]*IAMUser, 0, len(reg.users))
-	for _, u := range reg.users {
-		users = append(users, u)
-	}
-	return users, nil
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.users.values(), nil
 }
 
 func (reg *BasicIAMRegistry) GetGroups() ([]*IAMGroup, error) {
-	groups := make([]*IAMGroup, 0, len(reg.groups))
-	for _, u := range reg.groups {
-		groups = append(groups, u)
-	}
-	return groups, nil
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.groups.values(), nil
 }
 
 var epoch = time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
 
-func buildRegistryFromYAML(yamlBytes []byte) (*BasicIAMRegistry, error) {
-	var y struct {
-		Groups []struct {
-			IAMGroup `yaml:",inline"`
-			Members  []string `yaml:"members"`
-		} `yaml:"groups"`
-		Users []IAMUser `yaml:"users"`
+// fixture is the schema of fixture files.
+type fixture struct {
+	Users            []IAMUser               `yaml:"users,omitempty"`
+	Groups           []groupRecord           `yaml:"groups,omitempty"`
+	Roles            []roleRecord            `yaml:"roles,omitempty"`
+	InstanceProfiles []instanceProfileRecord `yaml:"instance_profiles,omitempty"`
+	Policies         []IAMPolicy             `yaml:"policies,omitempty"`
+	// OpenIDConnectProviders, SAMLProviders, VirtualMFADevices and
+	// ServerCertificates refer to nothing else, and users are referred to
+	// by name from VirtualMFADevices.
+	OpenIDConnectProviders []IAMOpenIDConnectProvider `yaml:"open_id_connect_providers,omitempty"`
+	SAMLProviders          []IAMSAMLProvider          `yaml:"saml_providers,omitempty"`
+	VirtualMFADevices      []IAMVirtualMFADevice      `yaml:"virtual_mfa_devices,omitempty"`
+	ServerCertificates     []IAMServerCertificate     `yaml:"server_certificates,omitempty"`
+	Account                IAMAccount                 `yaml:"account,omitempty"`
+	// CredentialReport is the report GenerateCredentialReport made last.
+	CredentialReport *credentialReportRecord `yaml:"credential_report,omitempty"`
+	// Include names further fixture files or directories to merge, relative
+	// to the file that includes them.
+	Include []string `yaml:"include,omitempty"`
+
+	origins map[string]position
+	// importDated are the entities, keyed as origins are, which were
+	// imported from sources that give no creation date for them.
+	importDated map[string]bool
+}
+
+// roleRecord is a role in a fixture, which may declare an instance profile
+// of its own along with it.
+type roleRecord struct {
+	IAMRole         `yaml:",inline"`
+	InstanceProfile *IAMInstanceProfile `yaml:"instance_profile,omitempty"`
+}
+
+func parseFixture(yamlBytes []byte) (*fixture, error) {
+	// the parser is run on its own, as it panics on some malformed input
+	// instead of failing
+	f, err := parseYAML(yamlBytes)
+	if err != nil {
+		return nil, err
+	}
+	return decodeFixture(f)
+}
+
+// decodeFixture decodes the first document of a parsed fixture file.
+func decodeFixture(f *ast.File) (*fixture, error) {
+	var y fixture
+	if len(f.Docs) == 0 || f.Docs[0].Body == nil {
+		return &y, nil
 	}
-	err := yaml.Unmarshal(yamlBytes, &y)
+	err := yaml.NodeToValue(f.Docs[0].Body, &y)
 	if err != nil {
 		return nil, err
 	}
+	return &y, nil
+}
+
+// buildRegistry builds a registry out of a fixture.  The registry is not
+// written to its store; openRegistry does that.
+func buildRegistry(y *fixture) (*BasicIAMRegistry, error) {
+	r := newBasicIAMRegistry(NewMemoryStore())
+	r.account = y.Account
+
+	if r.account.Id != "" && !accountIdRegexp.MatchString(r.account.Id) {
+		return nil, fmt.Errorf("invalid account ID %s", r.account.Id)
+	}
+	if r.account.Alias != "" {
+		err := validateAccountAlias(r.account.Alias)
+		if err != nil {
+			return nil, fmt.Errorf("invalid account alias %s", r.account.Alias)
+		}
+	}
+	for k := range r.account.Quotas {
+		if _, ok := defaultAccountQuotas[k]; !ok {
+			return nil, fmt.Errorf("unknown quota %s", k)
+		}
+	}
+	if c := y.CredentialReport; c != nil {
+		r.credentialReport = &IAMCredentialReport{
+			Content:     []byte(c.Content),
+			GeneratedAt: c.GeneratedAt,
+		}
+	}
+
+	// explicitly given IDs are reserved up front so that no generated ID
+	// can take them
+	reserveId := func(kind, name, id string) error {
+		if id == "" {
+			return nil
+		}
+		if err := r.ids.Reserve(id); err != nil {
+			return y.errorf(kind, name, "%s %s: %w", kind, name, err)
+		}
+		return nil
+	}
+	for _, u := range y.Users {
+		if err := reserveId("user", u.Name, u.Id); err != nil {
+			return nil, err
+		}
+	}
+	for _, g := range y.Groups {
+		if err := reserveId("group", g.Name, g.Id); err != nil {
+			return nil, err
+		}
+	}
+	for _, ro := range y.Roles {
+		if err := reserveId("role", ro.Name, ro.Id); err != nil {
+			return nil, err
+		}
+		if ro.InstanceProfile != nil {
+			if err := reserveId("instance profile", ro.InstanceProfile.Name, ro.InstanceProfile.Id); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, p := range y.InstanceProfiles {
+		if err := reserveId("instance profile", p.Name, p.Id); err != nil {
+			return nil, err
+		}
+	}
+	for i := range y.Policies {
+		if err := reserveId("policy", policyKey(&y.Policies[i]), y.Policies[i].Id); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range y.ServerCertificates {
+		if err := reserveId("server certificate", c.Name, c.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Info("populating policy DB", slog.Int("nPolicies", len(y.Policies)))
 
-	r := &BasicIAMRegistry{
-		groups: make(map[string]*IAMGroup),
-		users:  make(map[string]*IAMUser),
+	// managed policies go first, as users, groups and roles refer to them
+	for i := range y.Policies {
+		p := &y.Policies[i]
+		key := policyKey(p)
+		if _, ok := r.policies.get(key); ok {
+			return nil, y.errorf("policy", key, "duplicate policy %s", key)
+		}
+		if err := normalizePolicy(p); err != nil {
+			return nil, y.errorf("policy", key, "%w", err)
+		}
+		if p.Id == "" {
+			p.Id = r.ids.Allocate(policyIdPrefix, key)
+		}
+		if err := validateTagMap(p.Tags); err != nil {
+			return nil, y.errorf("policy", key, "policy %s: %w", key, err)
+		}
+		r.policies.put(key, p)
+	}
+	checkPolicies := func(inline map[string]string, managed []string) error {
+		for n, doc := range inline {
+			if doc == "" {
+				return fmt.Errorf("inline policy %s has no document", n)
+			}
+		}
+		return checkPolicyAttachments(r, managed)
 	}
 
 	logger.Info("populating user/group DB", slog.Int("nGroups", len(y.Groups)), slog.Int("nUsers", len(y.Users)))
 
 	for i, _ := range y.Users {
 		u := &y.Users[i]
-		r.users[u.Name] = u
+		r.users.put(u.Name, u)
 		if u.CreatedAt.IsZero() {
 			u.CreatedAt = epoch
 		}
 		if u.Id == "" {
-			u.Id = generateGroupId()
+			u.Id = r.ids.Allocate(userIdPrefix, u.Name)
 		}
 		if u.Path == "" {
 			u.Path = "/"
 		}
+		if err := validateTagMap(u.Tags); err != nil {
+			return nil, y.errorf("user", u.Name, "user %s: %w", u.Name, err)
+		}
+		if err := checkPolicies(u.InlinePolicies, u.ManagedPolicies); err != nil {
+			return nil, y.errorf("user", u.Name, "user %s: %w", u.Name, err)
+		}
+		if len(u.AccessKeys) > maxAccessKeysPerUser {
+			return nil, y.errorf("user", u.Name, "user %s cannot have more than %d access keys", u.Name, maxAccessKeysPerUser)
+		}
+		for _, k := range u.AccessKeys {
+			if err := validateCredentialStatus(k.Status); err != nil {
+				return nil, y.errorf("user", u.Name, "access key of user %s: %w", u.Name, err)
+			}
+		}
+		if len(u.SigningCertificates) > maxSigningCertificatesPerUser {
+			return nil, y.errorf("user", u.Name, "user %s cannot have more than %d signing certificates", u.Name, maxSigningCertificatesPerUser)
+		}
+		for _, c := range u.SigningCertificates {
+			if err := validateCredentialStatus(c.Status); err != nil {
+				return nil, y.errorf("user", u.Name, "signing certificate of user %s: %w", u.Name, err)
+			}
+		}
 	}
 
 	for i, _ := range y.Groups {
@@ -333,9 +618,9 @@ func buildRegistryFromYAML(yamlBytes []byte) (*BasicIAMRegistry, error) {
 		var members []*IAMUser
 		logger.Info("populating group entry", slog.String("group", g.Name), slog.Int("nMembers", len(g.Members)))
 		for _, m := range g.Members {
-			u, ok := r.users[m]
+			u, ok := r.users.get(m)
 			if !ok {
-				return nil, fmt.Errorf("unknown user %s among the members of %s", m, g.Name)
+				return nil, y.errorf("group", g.Name, "unknown user %s among the members of %s", m, g.Name)
 			}
 			members = append(members, u)
 		}
@@ -346,10 +631,176 @@ func buildRegistryFromYAML(yamlBytes []byte) (*BasicIAMRegistry, error) {
 			g.Path = "/"
 		}
 		if g.Id == "" {
-			g.Id = generateGroupId()
+			g.Id = r.ids.Allocate(groupIdPrefix, g.Name)
+		}
+		if err := validateTagMap(g.Tags); err != nil {
+			return nil, y.errorf("group", g.Name, "group %s: %w", g.Name, err)
+		}
+		if err := checkPolicies(g.InlinePolicies, g.ManagedPolicies); err != nil {
+			return nil, y.errorf("group", g.Name, "group %s: %w", g.Name, err)
 		}
 		g.IAMGroup.Members = members
-		r.groups[g.Name] = &g.IAMGroup
+		r.groups.put(g.Name, &g.IAMGroup)
+	}
+
+	logger.Info("populating role/instance profile DB", slog.Int("nRoles", len(y.Roles)), slog.Int("nInstanceProfiles", len(y.InstanceProfiles)))
+
+	addInstanceProfile := func(p *IAMInstanceProfile, roles []*IAMRole) error {
+		if _, ok := r.instanceProfiles.get(p.Name); ok {
+			return y.errorf("instance profile", p.Name, "duplicate instance profile %s", p.Name)
+		}
+		if len(roles) > maxRolesPerInstanceProfile {
+			return y.errorf("instance profile", p.Name, "instance profile %s cannot have more than %d role(s)", p.Name, maxRolesPerInstanceProfile)
+		}
+		if p.CreatedAt.IsZero() {
+			p.CreatedAt = epoch
+		}
+		if p.Path == "" {
+			p.Path = "/"
+		}
+		if p.Id == "" {
+			p.Id = r.ids.Allocate(instanceProfileIdPrefix, p.Name)
+		}
+		if err := validateTagMap(p.Tags); err != nil {
+			return y.errorf("instance profile", p.Name, "instance profile %s: %w", p.Name, err)
+		}
+		p.Roles = roles
+		r.instanceProfiles.put(p.Name, p)
+		return nil
+	}
+
+	for i, _ := range y.Roles {
+		ro := &y.Roles[i]
+		if ro.CreatedAt.IsZero() {
+			ro.CreatedAt = epoch
+		}
+		if ro.Path == "" {
+			ro.Path = "/"
+		}
+		if ro.Id == "" {
+			ro.Id = r.ids.Allocate(roleIdPrefix, ro.Name)
+		}
+		if err := validateTagMap(ro.Tags); err != nil {
+			return nil, y.errorf("role", ro.Name, "role %s: %w", ro.Name, err)
+		}
+		if err := checkPolicies(ro.InlinePolicies, ro.ManagedPolicies); err != nil {
+			return nil, y.errorf("role", ro.Name, "role %s: %w", ro.Name, err)
+		}
+		r.roles.put(ro.Name, &ro.IAMRole)
+		if ro.InstanceProfile != nil {
+			if ro.InstanceProfile.Name == "" {
+				ro.InstanceProfile.Name = ro.Name
+			}
+			err := addInstanceProfile(ro.InstanceProfile, []*IAMRole{&ro.IAMRole})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i, _ := range y.InstanceProfiles {
+		p := &y.InstanceProfiles[i]
+		var roles []*IAMRole
+		for _, n := range p.Roles {
+			ro, ok := r.roles.get(n)
+			if !ok {
+				return nil, y.errorf("instance profile", p.Name, "unknown role %s among the roles of %s", n, p.Name)
+			}
+			roles = append(roles, ro)
+		}
+		err := addInstanceProfile(&p.IAMInstanceProfile, roles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Info("populating identity provider DB", slog.Int("nOpenIDConnectProviders", len(y.OpenIDConnectProviders)), slog.Int("nSAMLProviders", len(y.SAMLProviders)))
+
+	for i := range y.OpenIDConnectProviders {
+		p := &y.OpenIDConnectProviders[i]
+		key := oidcProviderKey(p)
+		if _, ok := r.oidcProviders.get(key); ok {
+			return nil, y.errorf("OpenID Connect provider", key, "duplicate OpenID Connect provider %s", key)
+		}
+		if p.CreatedAt.IsZero() {
+			p.CreatedAt = epoch
+		}
+		if err := validateOIDCProvider(p); err != nil {
+			return nil, y.errorf("OpenID Connect provider", key, "OpenID Connect provider %s: %s", key, err.(Fault).Message())
+		}
+		if err := validateTagMap(p.Tags); err != nil {
+			return nil, y.errorf("OpenID Connect provider", key, "OpenID Connect provider %s: %w", key, err)
+		}
+		r.oidcProviders.put(key, p)
+	}
+
+	for i := range y.SAMLProviders {
+		p := &y.SAMLProviders[i]
+		if _, ok := r.samlProviders.get(p.Name); ok {
+			return nil, y.errorf("SAML provider", p.Name, "duplicate SAML provider %s", p.Name)
+		}
+		if p.CreatedAt.IsZero() {
+			p.CreatedAt = epoch
+		}
+		validUntil, err := parseSAMLMetadata(p.MetadataDocument)
+		if err != nil {
+			return nil, y.errorf("SAML provider", p.Name, "SAML provider %s: %s", p.Name, err.(Fault).Message())
+		}
+		if p.ValidUntil == nil {
+			p.ValidUntil = validUntil
+		}
+		if err := validateTagMap(p.Tags); err != nil {
+			return nil, y.errorf("SAML provider", p.Name, "SAML provider %s: %w", p.Name, err)
+		}
+		r.samlProviders.put(p.Name, p)
+	}
+
+	logger.Info("populating MFA device/server certificate DB", slog.Int("nVirtualMFADevices", len(y.VirtualMFADevices)), slog.Int("nServerCertificates", len(y.ServerCertificates)))
+
+	for i := range y.VirtualMFADevices {
+		d := &y.VirtualMFADevices[i]
+		if _, ok := r.mfaDevices.get(d.Name); ok {
+			return nil, y.errorf("virtual MFA device", d.Name, "duplicate virtual MFA device %s", d.Name)
+		}
+		if d.Path == "" {
+			d.Path = "/"
+		}
+		if d.User != "" {
+			if _, ok := r.users.get(d.User); !ok {
+				return nil, y.errorf("virtual MFA device", d.Name, "unknown user %s assigned virtual MFA device %s", d.User, d.Name)
+			}
+			if d.EnabledAt == nil {
+				enabledAt := epoch
+				d.EnabledAt = &enabledAt
+			}
+		}
+		if err := validateTagMap(d.Tags); err != nil {
+			return nil, y.errorf("virtual MFA device", d.Name, "virtual MFA device %s: %w", d.Name, err)
+		}
+		r.mfaDevices.put(d.Name, d)
+	}
+
+	for i := range y.ServerCertificates {
+		c := &y.ServerCertificates[i]
+		if _, ok := r.serverCerts.get(c.Name); ok {
+			return nil, y.errorf("server certificate", c.Name, "duplicate server certificate %s", c.Name)
+		}
+		if c.UploadedAt.IsZero() {
+			c.UploadedAt = epoch
+		}
+		if c.Path == "" {
+			c.Path = "/"
+		}
+		if c.Id == "" {
+			c.Id = r.ids.Allocate(serverCertificateIdPrefix, c.Name)
+		}
+		if err := validateServerCertificate(c, ""); err != nil {
+			return nil, y.errorf("server certificate", c.Name, "server certificate %s: %s", c.Name, err.(Fault).Message())
+		}
+		if err := validateTagMap(c.Tags); err != nil {
+			return nil, y.errorf("server certificate", c.Name, "server certificate %s: %w", c.Name, err)
+		}
+		r.serverCerts.put(c.Name, c)
 	}
 
 	return r, nil
@@ -0,0 +1,131 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+// Unique ID prefixes IAM uses for each kind of entity.
+const (
+	userIdPrefix              = "AIDA"
+	groupIdPrefix             = "AGPA"
+	roleIdPrefix              = "AROA"
+	policyIdPrefix            = "ANPA"
+	instanceProfileIdPrefix   = "AIPA"
+	serverCertificateIdPrefix = "ASCA"
+	accessKeyIdPrefix         = "AKIA"
+)
+
+// uniqueIdSuffixLength is the number of characters that follow the prefix
+// in an ID, which makes IDs 21 characters long like the real ones.
+const uniqueIdSuffixLength = 17
+
+var alnum = []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+
+// idSeed makes the IDs of entities that have none in the fixture
+// deterministic when non-empty.
+var idSeed string
+
+func randomAlnum(l int) string {
+	b := make([]byte, l)
+	for i := 0; i < l; i++ {
+		b[i] = alnum[rand.Intn(len(alnum))]
+	}
+	return string(b)
+}
+
+// IDAllocator hands out unique IDs.  Without a seed the IDs are random.
+// With a seed, an ID is derived from the seed, the prefix (i.e. the entity
+// type) and the entity name, so that the same fixture yields the same IDs on
+// every start; should a derived ID be taken already, e.g. by an entity that
+// was deleted and created again, derivation is repeated with a counter.
+type IDAllocator struct {
+	mu   sync.Mutex
+	seed string
+	used map[string]bool
+}
+
+func NewIDAllocator(seed string) *IDAllocator {
+	return &IDAllocator{
+		seed: seed,
+		used: make(map[string]bool),
+	}
+}
+
+func (a *IDAllocator) derive(prefix, name string, counter uint64) string {
+	h := sha256.New()
+	for _, s := range []string{a.seed, prefix, name} {
+		var l [8]byte
+		binary.BigEndian.PutUint64(l[:], uint64(len(s)))
+		h.Write(l[:])
+		h.Write([]byte(s))
+	}
+	var c [8]byte
+	binary.BigEndian.PutUint64(c[:], counter)
+	h.Write(c[:])
+	sum := h.Sum(nil)
+	b := make([]byte, uniqueIdSuffixLength)
+	for i := range b {
+		b[i] = alnum[int(sum[i])%len(alnum)]
+	}
+	return prefix + string(b)
+}
+
+// Allocate returns a fresh ID for the entity of the given name.
+func (a *IDAllocator) Allocate(prefix, name string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for counter := uint64(0); ; counter++ {
+		var id string
+		if a.seed != "" {
+			id = a.derive(prefix, name, counter)
+		} else {
+			id = prefix + randomAlnum(uniqueIdSuffixLength)
+		}
+		if !a.used[id] {
+			a.used[id] = true
+			return id
+		}
+	}
+}
+
+// Reserve marks an ID given explicitly (e.g. in a fixture) as taken.
+func (a *IDAllocator) Reserve(id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.used[id] {
+		return fmt.Errorf("duplicate id %s", id)
+	}
+	a.used[id] = true
+	return nil
+}
+
+// Release gives back an ID that was allocated or reserved, e.g. for an
+// entity whose creation failed.
+func (a *IDAllocator) Release(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.used, id)
+}
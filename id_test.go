@@ -0,0 +1,95 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+var idRegexp = regexp.MustCompile(`^[A-Z]{4}[A-Z0-9]{17}$`)
+
+func TestIDAllocator(t *testing.T) {
+	a := NewIDAllocator("seed")
+	b := NewIDAllocator("seed")
+	other := NewIDAllocator("other")
+	for _, prefix := range []string{userIdPrefix, groupIdPrefix, roleIdPrefix, policyIdPrefix, instanceProfileIdPrefix, serverCertificateIdPrefix} {
+		id := a.Allocate(prefix, "alice")
+		if !idRegexp.MatchString(id) || id[:4] != prefix {
+			t.Errorf("got malformed ID %s for %s", id, prefix)
+		}
+		if b.Allocate(prefix, "alice") != id {
+			t.Errorf("the same seed gave a different ID for %s", prefix)
+		}
+		if other.Allocate(prefix, "alice") == id {
+			t.Errorf("another seed gave the same ID for %s", prefix)
+		}
+	}
+	// an ID that is taken is derived again
+	first := NewIDAllocator("seed").Allocate(userIdPrefix, "bob")
+	c := NewIDAllocator("seed")
+	if err := c.Reserve(first); err != nil {
+		t.Fatal(err)
+	}
+	if id := c.Allocate(userIdPrefix, "bob"); id == first || !idRegexp.MatchString(id) {
+		t.Errorf("got %s, which is taken or malformed", id)
+	}
+	if err := c.Reserve(first); err == nil {
+		t.Error("an ID was reserved twice")
+	}
+	c.Release(first)
+	if err := c.Reserve(first); err != nil {
+		t.Errorf("a released ID could not be reserved: %v", err)
+	}
+}
+
+func TestRandomIDsAreUnique(t *testing.T) {
+	a := NewIDAllocator("")
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		id := a.Allocate(userIdPrefix, "alice")
+		if seen[id] || !idRegexp.MatchString(id) {
+			t.Fatalf("got %s, which is taken or malformed", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestSeededFixtureIDs(t *testing.T) {
+	saved := idSeed
+	idSeed = "seed"
+	t.Cleanup(func() { idSeed = saved })
+	fixture := `
+users: [{name: alice}]
+roles: [{name: web, instance_profile: {}}]
+`
+	r1 := newTestRegistry(t, fixture)
+	r2 := newTestRegistry(t, fixture)
+	u1, _, _ := r1.GetUserByName("alice")
+	u2, _, _ := r2.GetUserByName("alice")
+	if u1.Id != u2.Id || u1.Id[:4] != userIdPrefix {
+		t.Errorf("got user IDs %s and %s", u1.Id, u2.Id)
+	}
+	p1, _, _ := r1.GetInstanceProfileByName("web")
+	p2, _, _ := r2.GetInstanceProfileByName("web")
+	if p1.Id != p2.Id || p1.Id[:4] != instanceProfileIdPrefix {
+		t.Errorf("got instance profile IDs %s and %s", p1.Id, p2.Id)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const imdsTokenHeader = "X-aws-ec2-metadata-token"
+const imdsTokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+const imdsMaxTokenTTL = 21600
+
+const imdsCredentialsPath = "/latest/meta-data/iam/security-credentials/"
+
+// IMDSServer emulates the part of the EC2 instance metadata service that the
+// SDK default credential chains consult, vending credentials for the role
+// that belongs to the configured instance profile.  Both IMDSv1 and the
+// session-oriented IMDSv2 are supported.
+type IMDSServer struct {
+	Registry            IAMRegistry
+	Issuer              *CredentialsIssuer
+	InstanceProfileName string
+	// RequireToken rejects IMDSv1 (tokenless) requests when set.
+	RequireToken bool
+
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+type imdsCredentialsPayload struct {
+	Code            string `json:"Code"`
+	LastUpdated     string `json:"LastUpdated"`
+	Type            string `json:"Type"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+type imdsInfoPayload struct {
+	Code               string `json:"Code"`
+	LastUpdated        string `json:"LastUpdated"`
+	InstanceProfileArn string `json:"InstanceProfileArn"`
+	InstanceProfileId  string `json:"InstanceProfileId"`
+}
+
+func (s *IMDSServer) issueToken(ttl int) (string, error) {
+	b := make([]byte, 42)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := base64.URLEncoding.EncodeToString(b)
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens == nil {
+		s.tokens = make(map[string]time.Time)
+	}
+	for t, exp := range s.tokens {
+		if !now.Before(exp) {
+			delete(s.tokens, t)
+		}
+	}
+	s.tokens[token] = now.Add(time.Duration(ttl) * time.Second)
+	return token, nil
+}
+
+func (s *IMDSServer) validToken(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.tokens[token]
+	return ok && time.Now().Before(exp)
+}
+
+func (s *IMDSServer) handleToken(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut {
+		w.Header().Set("Allow", http.MethodPut)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ttl, err := strconv.Atoi(req.Header.Get(imdsTokenTTLHeader))
+	if err != nil || ttl < 1 || ttl > imdsMaxTokenTTL {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	token, err := s.issueToken(ttl)
+	if err != nil {
+		logger.Error("failed to issue a token", slog.String("error", err.Error()))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(imdsTokenTTLHeader, strconv.Itoa(ttl))
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(token))
+}
+
+func (s *IMDSServer) instanceProfile() (*IAMInstanceProfile, *IAMRole, error) {
+	p, ok, err := s.Registry.GetInstanceProfileByName(s.InstanceProfileName)
+	if err != nil || !ok {
+		return nil, nil, err
+	}
+	if len(p.Roles) == 0 {
+		return p, nil, nil
+	}
+	return p, p.Roles[0], nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/plain")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func writeText(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Length", strconv.Itoa(len(text)))
+	w.Write([]byte(text))
+}
+
+func (s *IMDSServer) Handle(w http.ResponseWriter, req *http.Request) {
+	logger.Debug("IMDS request", slog.String("method", req.Method), slog.String("path", req.URL.Path))
+	if req.URL.Path == "/latest/api/token" {
+		s.handleToken(w, req)
+		return
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := req.Header.Get(imdsTokenHeader); token != "" {
+		if !s.validToken(token) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	} else if s.RequireToken {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	p, role, err := s.instanceProfile()
+	if err != nil {
+		logger.Error("failed to look up the instance profile", slog.String("error", err.Error()))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if p == nil {
+		http.NotFound(w, req)
+		return
+	}
+	accountId, err := s.Registry.GetAccountId()
+	if err != nil {
+		logger.Error("failed to look up the account", slog.String("error", err.Error()))
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	switch path := req.URL.Path; {
+	case path == "/latest/meta-data/":
+		writeText(w, "iam/")
+	case path == "/latest/meta-data/iam/":
+		writeText(w, "info\nsecurity-credentials/")
+	case path == "/latest/meta-data/iam/info":
+		writeJSON(w, http.StatusOK, imdsInfoPayload{
+			Code:               "Success",
+			LastUpdated:        time.Now().UTC().Format(time.RFC3339),
+			InstanceProfileArn: p.BuildArn(accountId),
+			InstanceProfileId:  p.Id,
+		})
+	case path == imdsCredentialsPath:
+		if role == nil {
+			http.NotFound(w, req)
+			return
+		}
+		writeText(w, role.Name)
+	case strings.HasPrefix(path, imdsCredentialsPath):
+		if role == nil || strings.TrimPrefix(path, imdsCredentialsPath) != role.Name {
+			http.NotFound(w, req)
+			return
+		}
+		creds, err := s.Issuer.CredentialsForRole(role, accountId)
+		if err != nil {
+			logger.Error("failed to issue credentials", slog.String("error", err.Error()))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, imdsCredentialsPayload{
+			Code:            "Success",
+			LastUpdated:     creds.IssuedAt.Format(time.RFC3339),
+			Type:            "AWS-HMAC",
+			AccessKeyId:     creds.AccessKeyId,
+			SecretAccessKey: creds.SecretAccessKey,
+			Token:           creds.SessionToken,
+			Expiration:      creds.Expiration.Format(time.RFC3339),
+		})
+	default:
+		http.NotFound(w, req)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const imdsFixture = `
+account:
+  id: "123456789012"
+roles:
+  - name: web
+    instance_profile: {}
+instance_profiles:
+  - name: empty
+`
+
+func imdsRequest(s *IMDSServer, method, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v[0])
+	}
+	w := httptest.NewRecorder()
+	s.Handle(w, req)
+	return w
+}
+
+func TestIMDS(t *testing.T) {
+	reg := newTestRegistry(t, imdsFixture)
+	s := &IMDSServer{
+		Registry:            reg,
+		Issuer:              NewCredentialsIssuer(time.Hour),
+		InstanceProfileName: "web",
+	}
+
+	w := imdsRequest(s, http.MethodGet, imdsCredentialsPath, nil)
+	if w.Code != http.StatusOK || w.Body.String() != "web" {
+		t.Fatalf("IMDSv1: got %d %q", w.Code, w.Body)
+	}
+
+	w = imdsRequest(s, http.MethodPut, "/latest/api/token", http.Header{imdsTokenTTLHeader: {"60"}})
+	if w.Code != http.StatusOK || w.Header().Get(imdsTokenTTLHeader) != "60" {
+		t.Fatalf("token: got %d %q", w.Code, w.Body)
+	}
+	token := w.Body.String()
+
+	w = imdsRequest(s, http.MethodGet, imdsCredentialsPath+"web", http.Header{imdsTokenHeader: {token}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("credentials: got %d %q", w.Code, w.Body)
+	}
+	var creds imdsCredentialsPayload
+	if err := json.Unmarshal(w.Body.Bytes(), &creds); err != nil {
+		t.Fatal(err)
+	}
+	if creds.Code != "Success" || creds.Type != "AWS-HMAC" || creds.AccessKeyId == "" || creds.Token == "" {
+		t.Errorf("got %+v", creds)
+	}
+	if _, err := time.Parse(time.RFC3339, creds.Expiration); err != nil {
+		t.Errorf("expiration: %v", err)
+	}
+
+	w = imdsRequest(s, http.MethodGet, "/latest/meta-data/iam/info", nil)
+	var info imdsInfoPayload
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.InstanceProfileArn != "arn:aws:iam::123456789012:instance-profile/web" {
+		t.Errorf("got %+v", info)
+	}
+
+	for _, c := range []struct {
+		name   string
+		method string
+		path   string
+		header http.Header
+		status int
+	}{
+		{"another role", http.MethodGet, imdsCredentialsPath + "db", nil, http.StatusNotFound},
+		{"unknown path", http.MethodGet, "/latest/meta-data/hostname", nil, http.StatusNotFound},
+		{"invalid token", http.MethodGet, imdsCredentialsPath, http.Header{imdsTokenHeader: {"bogus"}}, http.StatusUnauthorized},
+		{"token without TTL", http.MethodPut, "/latest/api/token", nil, http.StatusBadRequest},
+		{"token TTL too long", http.MethodPut, "/latest/api/token", http.Header{imdsTokenTTLHeader: {"21601"}}, http.StatusBadRequest},
+		{"token by GET", http.MethodGet, "/latest/api/token", nil, http.StatusMethodNotAllowed},
+		{"POST", http.MethodPost, imdsCredentialsPath, nil, http.StatusMethodNotAllowed},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if w := imdsRequest(s, c.method, c.path, c.header); w.Code != c.status {
+				t.Errorf("got %d, want %d", w.Code, c.status)
+			}
+		})
+	}
+}
+
+func TestIMDSRequireToken(t *testing.T) {
+	reg := newTestRegistry(t, imdsFixture)
+	s := &IMDSServer{
+		Registry:            reg,
+		Issuer:              NewCredentialsIssuer(time.Hour),
+		InstanceProfileName: "web",
+		RequireToken:        true,
+	}
+	if w := imdsRequest(s, http.MethodGet, imdsCredentialsPath, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("IMDSv1: got %d, want 401", w.Code)
+	}
+	token := imdsRequest(s, http.MethodPut, "/latest/api/token", http.Header{imdsTokenTTLHeader: {"60"}}).Body.String()
+	if w := imdsRequest(s, http.MethodGet, imdsCredentialsPath, http.Header{imdsTokenHeader: {token}}); w.Code != http.StatusOK {
+		t.Errorf("IMDSv2: got %d, want 200", w.Code)
+	}
+}
+
+func TestIMDSWithoutRole(t *testing.T) {
+	reg := newTestRegistry(t, imdsFixture)
+	for _, name := range []string{"empty", "nope"} {
+		s := &IMDSServer{
+			Registry:            reg,
+			Issuer:              NewCredentialsIssuer(time.Hour),
+			InstanceProfileName: name,
+		}
+		if w := imdsRequest(s, http.MethodGet, imdsCredentialsPath, nil); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got %d, want 404", name, w.Code)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// fixtureBuilder assembles a fixture out of resources that define entities
+// and the relationships between them separately, the way infrastructure as
+// code does.  Relationships may be given before the entities they relate,
+// and are resolved by build.
+type fixtureBuilder struct {
+	fixture          fixture
+	users            map[string]*IAMUser
+	groups           map[string]*groupRecord
+	roles            map[string]*IAMRole
+	instanceProfiles map[string]*instanceProfileRecord
+	policies         map[string]*IAMPolicy
+	order            []func()
+	relations        []func() error
+}
+
+func newFixtureBuilder() *fixtureBuilder {
+	return &fixtureBuilder{
+		users:            map[string]*IAMUser{},
+		groups:           map[string]*groupRecord{},
+		roles:            map[string]*IAMRole{},
+		instanceProfiles: map[string]*instanceProfileRecord{},
+		policies:         map[string]*IAMPolicy{},
+	}
+}
+
+// accountFromArn records the account an ARN belongs to as that of the
+// fixture.
+func (b *fixtureBuilder) accountFromArn(arn string) error {
+	// arn:aws:iam::ACCOUNT:...
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 || parts[4] == "" || parts[4] == "aws" {
+		return nil
+	}
+	if b.fixture.Account.Id != "" && b.fixture.Account.Id != parts[4] {
+		return fmt.Errorf("%s belongs to account %s rather than %s", arn, parts[4], b.fixture.Account.Id)
+	}
+	b.fixture.Account.Id = parts[4]
+	return nil
+}
+
+func (b *fixtureBuilder) addUser(u *IAMUser) error {
+	if _, ok := b.users[u.Name]; ok {
+		return fmt.Errorf("duplicate user %s", u.Name)
+	}
+	b.users[u.Name] = u
+	b.order = append(b.order, func() { b.fixture.Users = append(b.fixture.Users, *u) })
+	return nil
+}
+
+func (b *fixtureBuilder) addGroup(g *IAMGroup) error {
+	if _, ok := b.groups[g.Name]; ok {
+		return fmt.Errorf("duplicate group %s", g.Name)
+	}
+	rec := &groupRecord{IAMGroup: *g}
+	b.groups[g.Name] = rec
+	b.order = append(b.order, func() { b.fixture.Groups = append(b.fixture.Groups, *rec) })
+	return nil
+}
+
+func (b *fixtureBuilder) addRole(r *IAMRole) error {
+	if _, ok := b.roles[r.Name]; ok {
+		return fmt.Errorf("duplicate role %s", r.Name)
+	}
+	b.roles[r.Name] = r
+	b.order = append(b.order, func() { b.fixture.Roles = append(b.fixture.Roles, roleRecord{IAMRole: *r}) })
+	return nil
+}
+
+func (b *fixtureBuilder) addInstanceProfile(p *IAMInstanceProfile, roles []string) error {
+	if _, ok := b.instanceProfiles[p.Name]; ok {
+		return fmt.Errorf("duplicate instance profile %s", p.Name)
+	}
+	rec := &instanceProfileRecord{IAMInstanceProfile: *p, Roles: roles}
+	b.instanceProfiles[p.Name] = rec
+	b.order = append(b.order, func() { b.fixture.InstanceProfiles = append(b.fixture.InstanceProfiles, *rec) })
+	return nil
+}
+
+func (b *fixtureBuilder) addPolicy(p *IAMPolicy) error {
+	key := policyKey(p)
+	if _, ok := b.policies[key]; ok {
+		return fmt.Errorf("duplicate policy %s", key)
+	}
+	b.policies[key] = p
+	b.order = append(b.order, func() { b.fixture.Policies = append(b.fixture.Policies, *p) })
+	return nil
+}
+
+// policyHolder returns the inline and managed policies of the user, group or
+// role named name.
+func (b *fixtureBuilder) policyHolder(kind, name string) (*map[string]string, *[]string, error) {
+	switch kind {
+	case "user":
+		if u, ok := b.users[name]; ok {
+			return &u.InlinePolicies, &u.ManagedPolicies, nil
+		}
+	case "group":
+		if g, ok := b.groups[name]; ok {
+			return &g.InlinePolicies, &g.ManagedPolicies, nil
+		}
+	case "role":
+		if r, ok := b.roles[name]; ok {
+			return &r.InlinePolicies, &r.ManagedPolicies, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("unknown %s %s", kind, name)
+}
+
+// putInlinePolicy gives the user, group or role named name an inline policy.
+func (b *fixtureBuilder) putInlinePolicy(kind, name, policyName, document string) {
+	b.relations = append(b.relations, func() error {
+		inline, _, err := b.policyHolder(kind, name)
+		if err != nil {
+			return fmt.Errorf("inline policy %s: %w", policyName, err)
+		}
+		if *inline == nil {
+			*inline = map[string]string{}
+		}
+		(*inline)[policyName] = document
+		return nil
+	})
+}
+
+// attachPolicy attaches the managed policy of arn to the user, group or role
+// named name.  Attaching the same policy twice is harmless, as it is in IAM.
+func (b *fixtureBuilder) attachPolicy(kind, name, arn string) {
+	b.relations = append(b.relations, func() error {
+		_, managed, err := b.policyHolder(kind, name)
+		if err != nil {
+			return fmt.Errorf("attachment of %s: %w", arn, err)
+		}
+		key := policyKeyFromArn(arn)
+		for _, k := range *managed {
+			if k == key {
+				return nil
+			}
+		}
+		*managed = append(*managed, key)
+		return nil
+	})
+}
+
+// addGroupMember adds the user named user to the group named group.
+func (b *fixtureBuilder) addGroupMember(group, user string) {
+	b.relations = append(b.relations, func() error {
+		g, ok := b.groups[group]
+		if !ok {
+			return fmt.Errorf("membership of %s: unknown group %s", user, group)
+		}
+		if _, ok := b.users[user]; !ok {
+			return fmt.Errorf("membership of %s: unknown user %s", group, user)
+		}
+		for _, m := range g.Members {
+			if m == user {
+				return nil
+			}
+		}
+		g.Members = append(g.Members, user)
+		return nil
+	})
+}
+
+// updateUser makes a change to the user named name.
+func (b *fixtureBuilder) updateUser(name string, fn func(u *IAMUser)) {
+	b.relations = append(b.relations, func() error {
+		u, ok := b.users[name]
+		if !ok {
+			return fmt.Errorf("unknown user %s", name)
+		}
+		fn(u)
+		return nil
+	})
+}
+
+// build resolves the relationships and returns the fixture, its entities in
+// the order they were added.
+func (b *fixtureBuilder) build() (*fixture, error) {
+	for _, fn := range b.relations {
+		if err := fn(); err != nil {
+			return nil, err
+		}
+	}
+	for _, fn := range b.order {
+		fn()
+	}
+	return &b.fixture, nil
+}
+
+// creationDates calls fn with the key, as origins are keyed, and the creation
+// date of each user, group, role, instance profile and policy of y.
+func (y *fixture) creationDates(fn func(key string, t *time.Time)) {
+	for i := range y.Users {
+		fn("user "+y.Users[i].Name, &y.Users[i].CreatedAt)
+	}
+	for i := range y.Groups {
+		fn("group "+y.Groups[i].Name, &y.Groups[i].CreatedAt)
+	}
+	for i := range y.Roles {
+		fn("role "+y.Roles[i].Name, &y.Roles[i].CreatedAt)
+	}
+	for i := range y.InstanceProfiles {
+		fn("instance profile "+y.InstanceProfiles[i].Name, &y.InstanceProfiles[i].CreatedAt)
+	}
+	for i := range y.Policies {
+		fn("policy "+policyKey(&y.Policies[i]), &y.Policies[i].CreatedAt)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const importTerraformState = `{
+  "version": 4,
+  "resources": [
+    {"mode": "managed", "type": "aws_iam_user", "name": "alice", "instances": [{"attributes": {
+      "name": "alice", "path": "/", "arn": "arn:aws:iam::123456789012:user/alice", "create_date": "2020-01-02T03:04:05Z"}}]},
+    {"mode": "managed", "type": "aws_iam_user", "name": "bob", "instances": [{"attributes": {
+      "name": "bob", "path": "/", "arn": "arn:aws:iam::123456789012:user/bob"}}]},
+    {"mode": "managed", "type": "aws_iam_group", "name": "devs", "instances": [{"attributes": {
+      "name": "devs", "path": "/", "arn": "arn:aws:iam::123456789012:group/devs"}}]},
+    {"mode": "managed", "type": "aws_iam_policy", "name": "local", "instances": [{"attributes": {
+      "name": "local", "path": "/", "arn": "arn:aws:iam::123456789012:policy/local", "policy": "{}"}}]}
+  ]
+}`
+
+const importTemplate = `{
+  "Resources": {
+    "Web": {"Type": "AWS::IAM::Role", "Properties": {"AssumeRolePolicyDocument": {"Version": "2012-10-17", "Statement": []}}}
+  }
+}`
+
+func TestImportDates(t *testing.T) {
+	dir := t.TempDir()
+	report := strings.Join(credentialReportColumns, ",") + "\n" +
+		"bob,arn:aws:iam::123456789012:user/bob,2019-05-06T07:08:09+00:00,false,N/A,N/A,N/A,false,false,N/A,N/A,N/A,N/A,false,N/A,N/A,N/A,N/A,false,N/A,false,N/A\n"
+	paths := []string{
+		filepath.Join(dir, "terraform.tfstate"),
+		filepath.Join(dir, "stack.json"),
+		filepath.Join(dir, "report.csv"),
+		filepath.Join(dir, "fixture.yml"),
+	}
+	writeTestFile(t, paths[0], importTerraformState)
+	writeTestFile(t, paths[1], importTemplate)
+	writeTestFile(t, paths[2], report)
+	writeTestFile(t, paths[3], "users:\n  - name: carol\n")
+
+	before := time.Now().UTC().Truncate(time.Second)
+	y, _, err := loadFixtures(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now().UTC()
+	reg, err := buildRegistry(y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := reg.Export()
+	dates := map[string]time.Time{}
+	exported.creationDates(func(key string, t *time.Time) {
+		dates[key] = *t
+	})
+	imported := func(d time.Time) bool {
+		return !d.Before(before) && !d.After(after)
+	}
+	for key, ok := range map[string]func(time.Time) bool{
+		// the dates sources give are kept
+		"user alice": func(d time.Time) bool { return d.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) },
+		"user bob":   func(d time.Time) bool { return d.Equal(time.Date(2019, 5, 6, 7, 8, 9, 0, time.UTC)) },
+		// the other imported entities date from the import
+		"group devs":   imported,
+		"policy local": imported,
+		"role Web":     imported,
+		// and those of YAML fixtures from the epoch
+		"user carol": func(d time.Time) bool { return d.Equal(epoch) },
+	} {
+		if d, found := dates[key]; !found || !ok(d) {
+			t.Errorf("%s: got %v (imported between %v and %v)", key, d, before, after)
+		}
+	}
+	for _, p := range exported.Policies {
+		if !p.UpdatedAt.Equal(dates["policy local"]) || !p.Versions[0].CreatedAt.Equal(dates["policy local"]) {
+			t.Errorf("got policy %+v", p)
+		}
+	}
+}
+
+func TestImportDatesAcrossReloads(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "terraform.tfstate")
+	writeTestFile(t, file, importTerraformState)
+	y, _, err := loadFixtures([]string{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as though the state was imported long ago
+	for i := range y.Groups {
+		y.Groups[i].CreatedAt = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	reg, err := buildRegistry(y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newFixtureWatcher(reg, []string{file}, time.Hour)
+	writeTestFile(t, file, strings.Replace(importTerraformState, `"create_date": "2020-01-02T03:04:05Z"`, `"create_date": "2020-02-03T04:05:06Z"`, 1))
+	w.reload()
+	dates := map[string]time.Time{}
+	reg.Export().creationDates(func(key string, t *time.Time) {
+		dates[key] = *t
+	})
+	if d := dates["group devs"]; !d.Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("the group was dated anew: %v", d)
+	}
+	// dates the source gives follow the source
+	if d := dates["user alice"]; !d.Equal(time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)) {
+		t.Errorf("got %v for the user", d)
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// policyDocumentMembers are the members of GetAccountAuthorizationDetails
+// that carry policy documents.
+var policyDocumentMembers = map[string]bool{
+	"AssumeRolePolicyDocument": true,
+	"PolicyDocument":           true,
+	"Document":                 true,
+}
+
+// loadAuthorizationDetails reads the output of
+// `aws iam get-account-authorization-details`, i.e. the JSON of
+// GetAccountAuthorizationDetails with every page merged, as a fixture.  IDs,
+// dates and the account ID, which makes the ARNs, are kept as they are.
+func loadAuthorizationDetails(b []byte) (*fixture, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	doc, err := decodeAuthorizationDetails(dec)
+	if err != nil {
+		return nil, err
+	}
+	var out iam.GetAccountAuthorizationDetailsOutput
+	if err := unmarshalDocument(&out, doc); err != nil {
+		return nil, err
+	}
+	profileTags := instanceProfileTags(doc)
+
+	y := &fixture{}
+	accountOf := func(arn *string) error {
+		// arn:aws:iam::ACCOUNT:...
+		parts := strings.SplitN(aws.StringValue(arn), ":", 6)
+		if len(parts) < 6 || parts[4] == "" || parts[4] == "aws" {
+			return nil
+		}
+		if y.Account.Id != "" && y.Account.Id != parts[4] {
+			return fmt.Errorf("%s belongs to account %s rather than %s", *arn, parts[4], y.Account.Id)
+		}
+		y.Account.Id = parts[4]
+		return nil
+	}
+
+	members := map[string][]string{}
+	groups := map[string]bool{}
+	for _, g := range out.GroupDetailList {
+		groups[aws.StringValue(g.GroupName)] = true
+	}
+	for _, u := range out.UserDetailList {
+		if err := accountOf(u.Arn); err != nil {
+			return nil, err
+		}
+		y.Users = append(y.Users, IAMUser{
+			Id:              aws.StringValue(u.UserId),
+			Name:            aws.StringValue(u.UserName),
+			CreatedAt:       aws.TimeValue(u.CreateDate),
+			Path:            aws.StringValue(u.Path),
+			Tags:            importTags(u.Tags),
+			InlinePolicies:  importInlinePolicies(u.UserPolicyList),
+			ManagedPolicies: importAttachedPolicies(u.AttachedManagedPolicies),
+		})
+		for _, g := range u.GroupList {
+			if !groups[g] {
+				return nil, fmt.Errorf("user %s is a member of group %s, which is not among the groups", aws.StringValue(u.UserName), g)
+			}
+			members[g] = append(members[g], aws.StringValue(u.UserName))
+		}
+	}
+	for _, g := range out.GroupDetailList {
+		if err := accountOf(g.Arn); err != nil {
+			return nil, err
+		}
+		name := aws.StringValue(g.GroupName)
+		y.Groups = append(y.Groups, groupRecord{
+			IAMGroup: IAMGroup{
+				Id:              aws.StringValue(g.GroupId),
+				Name:            name,
+				CreatedAt:       aws.TimeValue(g.CreateDate),
+				Path:            aws.StringValue(g.Path),
+				InlinePolicies:  importInlinePolicies(g.GroupPolicyList),
+				ManagedPolicies: importAttachedPolicies(g.AttachedManagedPolicies),
+			},
+			Members: members[name],
+		})
+	}
+	profiles := map[string]bool{}
+	for _, r := range out.RoleDetailList {
+		if err := accountOf(r.Arn); err != nil {
+			return nil, err
+		}
+		y.Roles = append(y.Roles, roleRecord{
+			IAMRole: IAMRole{
+				Id:                       aws.StringValue(r.RoleId),
+				Name:                     aws.StringValue(r.RoleName),
+				CreatedAt:                aws.TimeValue(r.CreateDate),
+				Path:                     aws.StringValue(r.Path),
+				AssumeRolePolicyDocument: aws.StringValue(r.AssumeRolePolicyDocument),
+				Tags:                     importTags(r.Tags),
+				InlinePolicies:           importInlinePolicies(r.RolePolicyList),
+				ManagedPolicies:          importAttachedPolicies(r.AttachedManagedPolicies),
+			},
+		})
+		// an instance profile shows up under each of its roles
+		for _, p := range r.InstanceProfileList {
+			name := aws.StringValue(p.InstanceProfileName)
+			if profiles[name] {
+				continue
+			}
+			profiles[name] = true
+			rec := instanceProfileRecord{
+				IAMInstanceProfile: IAMInstanceProfile{
+					Id:        aws.StringValue(p.InstanceProfileId),
+					Name:      name,
+					CreatedAt: aws.TimeValue(p.CreateDate),
+					Path:      aws.StringValue(p.Path),
+					Tags:      profileTags[name],
+				},
+			}
+			for _, pr := range p.Roles {
+				rec.Roles = append(rec.Roles, aws.StringValue(pr.RoleName))
+			}
+			y.InstanceProfiles = append(y.InstanceProfiles, rec)
+		}
+	}
+	for _, p := range out.Policies {
+		if err := accountOf(p.Arn); err != nil {
+			return nil, err
+		}
+		policy := IAMPolicy{
+			Id:             aws.StringValue(p.PolicyId),
+			Name:           aws.StringValue(p.PolicyName),
+			CreatedAt:      aws.TimeValue(p.CreateDate),
+			UpdatedAt:      aws.TimeValue(p.UpdateDate),
+			Path:           aws.StringValue(p.Path),
+			Description:    aws.StringValue(p.Description),
+			AWSManaged:     isAWSManagedPolicyArn(aws.StringValue(p.Arn)),
+			DefaultVersion: aws.StringValue(p.DefaultVersionId),
+		}
+		for _, v := range p.PolicyVersionList {
+			policy.Versions = append(policy.Versions, IAMPolicyVersion{
+				Id:        aws.StringValue(v.VersionId),
+				Document:  aws.StringValue(v.Document),
+				CreatedAt: aws.TimeValue(v.CreateDate),
+			})
+		}
+		y.Policies = append(y.Policies, policy)
+	}
+	return y, nil
+}
+
+// instanceProfileTags picks the tags of the instance profiles out of the
+// document of GetAccountAuthorizationDetails, as the shapes of the bundled
+// SDK have no member for them.
+func instanceProfileTags(doc interface{}) map[string]map[string]string {
+	tags := map[string]map[string]string{}
+	m, _ := doc.(map[string]interface{})
+	roles, _ := m["RoleDetailList"].([]interface{})
+	for _, r := range roles {
+		r, _ := r.(map[string]interface{})
+		profiles, _ := r["InstanceProfileList"].([]interface{})
+		for _, p := range profiles {
+			p, _ := p.(map[string]interface{})
+			name, _ := p["InstanceProfileName"].(string)
+			list, _ := p["Tags"].([]interface{})
+			for _, t := range list {
+				t, _ := t.(map[string]interface{})
+				k, _ := t["Key"].(string)
+				v, _ := t["Value"].(string)
+				if tags[name] == nil {
+					tags[name] = map[string]string{}
+				}
+				tags[name][k] = v
+			}
+		}
+	}
+	return tags
+}
+
+// decodeAuthorizationDetails decodes a JSON value into a document the way
+// the JSON protocol does, except that policy documents are taken as strings
+// whether they are objects, which is how the AWS CLI prints them, or strings,
+// which the API percent-encodes.  Objects are kept as they are written,
+// members in the same order, only compacted.
+func decodeAuthorizationDetails(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		m := map[string]interface{}{}
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			k := tok.(string)
+			if policyDocumentMembers[k] {
+				m[k], err = decodePolicyDocument(dec)
+			} else {
+				m[k], err = decodeAuthorizationDetails(dec)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+		}
+		_, err = dec.Token()
+		return m, err
+	case json.Delim('['):
+		l := []interface{}{}
+		for dec.More() {
+			v, err := decodeAuthorizationDetails(dec)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		_, err = dec.Token()
+		return l, err
+	}
+	return tok, nil
+}
+
+func decodePolicyDocument(dec *json.Decoder) (interface{}, error) {
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return url.PathUnescape(s)
+	}
+	b := &bytes.Buffer{}
+	if err := json.Compact(b, raw); err != nil {
+		return nil, err
+	}
+	return b.String(), nil
+}
+
+func importTags(tags []iam.Tag) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(tags))
+	for _, t := range tags {
+		m[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return m
+}
+
+func importInlinePolicies(policies []iam.PolicyDetail) map[string]string {
+	if len(policies) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(policies))
+	for _, p := range policies {
+		m[aws.StringValue(p.PolicyName)] = aws.StringValue(p.PolicyDocument)
+	}
+	return m
+}
+
+// importAttachedPolicies turns attached policies into the keys the registry
+// knows them by: the ARN for AWS managed policies, the name otherwise.
+func importAttachedPolicies(policies []iam.AttachedPolicy) []string {
+	var keys []string
+	for _, p := range policies {
+		if arn := aws.StringValue(p.PolicyArn); isAWSManagedPolicyArn(arn) {
+			keys = append(keys, arn)
+		} else {
+			keys = append(keys, aws.StringValue(p.PolicyName))
+		}
+	}
+	return keys
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// authorizationDetailsJSON is GetAccountAuthorizationDetails as the API
+// returns it, with the policy documents percent-encoded, but for the trust
+// policy of the role, which is an object as the AWS CLI prints it.
+const authorizationDetailsJSON = `{
+  "UserDetailList": [
+    {
+      "UserName": "alice", "UserId": "AIDAALICE", "Path": "/",
+      "Arn": "arn:aws:iam::123456789012:user/alice",
+      "CreateDate": "2020-01-02T03:04:05Z",
+      "GroupList": ["devs"],
+      "UserPolicyList": [
+        {"PolicyName": "inline", "PolicyDocument": "%7B%22Version%22%3A%20%222012-10-17%22%7D"},
+        {"PolicyName": "partly", "PolicyDocument": "{\"Resource\": \"arn:aws:s3:::a%20b\"}"}
+      ],
+      "Tags": [{"Key": "team", "Value": "red"}]
+    }
+  ],
+  "GroupDetailList": [
+    {"GroupName": "devs", "GroupId": "AGPADEVS", "Path": "/", "Arn": "arn:aws:iam::123456789012:group/devs", "CreateDate": "2020-01-02T03:04:05Z"}
+  ],
+  "RoleDetailList": [
+    {
+      "RoleName": "web", "RoleId": "AROAWEB", "Path": "/",
+      "Arn": "arn:aws:iam::123456789012:role/web",
+      "CreateDate": "2020-01-02T03:04:05Z",
+      "AssumeRolePolicyDocument": {"Version": "2012-10-17", "Statement": []},
+      "InstanceProfileList": [
+        {
+          "InstanceProfileName": "web", "InstanceProfileId": "AIPAWEB", "Path": "/",
+          "Arn": "arn:aws:iam::123456789012:instance-profile/web",
+          "CreateDate": "2020-01-02T03:04:05Z",
+          "Roles": [{"RoleName": "web", "RoleId": "AROAWEB", "Path": "/", "Arn": "arn:aws:iam::123456789012:role/web", "CreateDate": "2020-01-02T03:04:05Z"}],
+          "Tags": [{"Key": "env", "Value": "prod"}]
+        }
+      ]
+    }
+  ],
+  "Policies": []
+}`
+
+func TestLoadAuthorizationDetails(t *testing.T) {
+	y, err := loadAuthorizationDetails([]byte(authorizationDetailsJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if y.Account.Id != "123456789012" {
+		t.Errorf("got account %s", y.Account.Id)
+	}
+	if len(y.Users) != 1 || len(y.Groups) != 1 || len(y.Roles) != 1 || len(y.InstanceProfiles) != 1 {
+		t.Fatalf("got %+v", y)
+	}
+	u := y.Users[0]
+	for name, want := range map[string]string{
+		"inline": `{"Version": "2012-10-17"}`,
+		// documents are decoded whatever they start with
+		"partly": `{"Resource": "arn:aws:s3:::a b"}`,
+	} {
+		if got := u.InlinePolicies[name]; got != want {
+			t.Errorf("policy %s: got %s, want %s", name, got, want)
+		}
+	}
+	if !reflect.DeepEqual(u.Tags, map[string]string{"team": "red"}) {
+		t.Errorf("got user tags %v", u.Tags)
+	}
+	if got, want := y.Groups[0].Members, []string{"alice"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got members %v, want %v", got, want)
+	}
+	if got, want := y.Roles[0].AssumeRolePolicyDocument, `{"Version":"2012-10-17","Statement":[]}`; got != want {
+		t.Errorf("got trust policy %s, want %s", got, want)
+	}
+	p := y.InstanceProfiles[0]
+	if !reflect.DeepEqual(p.Tags, map[string]string{"env": "prod"}) || !reflect.DeepEqual(p.Roles, []string{"web"}) {
+		t.Errorf("got instance profile %+v", p)
+	}
+	if _, err := buildRegistry(y); err != nil {
+		t.Errorf("buildRegistry: %v", err)
+	}
+}
+
+// What the emulator answers GetAccountAuthorizationDetails with can be
+// imported back.
+func TestLoadAuthorizationDetailsRoundTrip(t *testing.T) {
+	svc, _ := newTestService(t, authorizationDetailsFixture)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{}`))
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", iamServiceShape+".GetAccountAuthorizationDetails")
+	w := httptest.NewRecorder()
+	svc.Handle(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d:\n%s", w.Code, w.Body)
+	}
+	y, err := loadAuthorizationDetails(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, w.Body)
+	}
+	var alice *IAMUser
+	for i := range y.Users {
+		if y.Users[i].Name == "alice" {
+			alice = &y.Users[i]
+		}
+	}
+	if alice == nil || alice.InlinePolicies["inline"] != `{"Version": "2012-10-17"}` {
+		t.Errorf("got %+v:\n%s", alice, w.Body)
+	}
+	if _, err := buildRegistry(y); err != nil {
+		t.Errorf("buildRegistry: %v", err)
+	}
+}
@@ -0,0 +1,842 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml/ast"
+)
+
+// cfnNoValue is what Ref AWS::NoValue resolves to; properties and list
+// elements that resolve to it are dropped.
+var cfnNoValue = &struct{}{}
+
+// cfnNameProperties are the properties that name IAM resources of each
+// type.  Resources whose name is left to CloudFormation are named after
+// their logical IDs.
+var cfnNameProperties = map[string]string{
+	"AWS::IAM::User":            "UserName",
+	"AWS::IAM::Group":           "GroupName",
+	"AWS::IAM::Role":            "RoleName",
+	"AWS::IAM::InstanceProfile": "InstanceProfileName",
+	"AWS::IAM::ManagedPolicy":   "ManagedPolicyName",
+	"AWS::IAM::Policy":          "PolicyName",
+}
+
+type cfnResource struct {
+	Type       string
+	Condition  string
+	Properties map[string]interface{}
+}
+
+// cfnTemplate resolves the intrinsic functions of a CloudFormation
+// template as though it were deployed as a stack named after the file to
+// the default account in us-east-1, with the default parameter values.
+// Ref and Fn::GetAtt of resources other than IAM ones resolve to their
+// logical IDs, so that policy documents still read sensibly.
+type cfnTemplate struct {
+	stackName  string
+	parameters map[string]interface{}
+	mappings   map[string]interface{}
+	conditions map[string]interface{}
+	resources  map[string]*cfnResource
+	names      map[string]string
+	resolving  map[string]bool
+	evaluated  map[string]bool
+}
+
+// isCloudFormationTemplate tells whether b looks like a template rather than
+// anything else that may share its file extension, going by the top-level
+// keys only.  Documents that fail to parse are left to their usual readers,
+// which report the error.
+func isCloudFormationTemplate(b []byte) bool {
+	f, err := parseYAML(b)
+	if err != nil || len(f.Docs) == 0 {
+		return false
+	}
+	var values []*ast.MappingValueNode
+	switch n := f.Docs[0].Body.(type) {
+	case *ast.MappingNode:
+		values = n.Values
+	case *ast.MappingValueNode:
+		values = []*ast.MappingValueNode{n}
+	}
+	for _, mv := range values {
+		switch mv.Key.GetToken().Value {
+		case "AWSTemplateFormatVersion", "Resources":
+			return true
+		}
+	}
+	return false
+}
+
+// decodeCloudFormationTemplate decodes a template written in either JSON or
+// YAML.  The short forms of intrinsic functions (!Ref and the like) of YAML
+// are turned into the long ones.
+func decodeCloudFormationTemplate(b []byte) (map[string]interface{}, error) {
+	f, err := parseYAML(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Docs) == 0 || f.Docs[0].Body == nil {
+		return nil, fmt.Errorf("empty template")
+	}
+	anchors := map[string]interface{}{}
+	v, err := cfnNodeValue(f.Docs[0].Body, anchors)
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("a template must be a mapping")
+	}
+	return doc, nil
+}
+
+func cfnNodeValue(node ast.Node, anchors map[string]interface{}) (interface{}, error) {
+	switch n := node.(type) {
+	case *ast.TagNode:
+		v, err := cfnNodeValue(n.Value, anchors)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(n.Start.Value, "!")
+		switch name {
+		case "Ref", "Condition":
+			return map[string]interface{}{name: v}, nil
+		case "GetAtt":
+			if s, ok := v.(string); ok {
+				parts := strings.SplitN(s, ".", 2)
+				l := make([]interface{}, len(parts))
+				for i, p := range parts {
+					l[i] = p
+				}
+				v = l
+			}
+		}
+		return map[string]interface{}{"Fn::" + name: v}, nil
+	case *ast.MappingNode:
+		m := make(map[string]interface{}, len(n.Values))
+		for _, mv := range n.Values {
+			k, v, err := cfnMappingValue(mv, anchors)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case *ast.MappingValueNode:
+		k, v, err := cfnMappingValue(n, anchors)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{k: v}, nil
+	case *ast.SequenceNode:
+		l := make([]interface{}, len(n.Values))
+		for i, e := range n.Values {
+			v, err := cfnNodeValue(e, anchors)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = v
+		}
+		return l, nil
+	case *ast.AnchorNode:
+		v, err := cfnNodeValue(n.Value, anchors)
+		if err != nil {
+			return nil, err
+		}
+		anchors[n.Name.GetToken().Value] = v
+		return v, nil
+	case *ast.AliasNode:
+		v, ok := anchors[n.Value.GetToken().Value]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown anchor %s", n.GetToken().Position.Line, n.Value.GetToken().Value)
+		}
+		return v, nil
+	case *ast.StringNode:
+		return n.Value, nil
+	case *ast.LiteralNode:
+		return n.Value.Value, nil
+	case *ast.NullNode:
+		return nil, nil
+	case ast.ScalarNode:
+		return n.GetValue(), nil
+	}
+	return nil, fmt.Errorf("line %d: unexpected %s", node.GetToken().Position.Line, node.Type())
+}
+
+func cfnMappingValue(mv *ast.MappingValueNode, anchors map[string]interface{}) (string, interface{}, error) {
+	k, err := cfnNodeValue(mv.Key, anchors)
+	if err != nil {
+		return "", nil, err
+	}
+	v, err := cfnNodeValue(mv.Value, anchors)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprint(k), v, nil
+}
+
+// loadCloudFormationTemplate reads the AWS::IAM::* resources of a
+// CloudFormation template as a fixture.
+func loadCloudFormationTemplate(file string, b []byte) (*fixture, error) {
+	doc, err := decodeCloudFormationTemplate(b)
+	if err != nil {
+		return nil, err
+	}
+	t := &cfnTemplate{
+		stackName:  strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
+		parameters: map[string]interface{}{},
+		mappings:   asMap(doc["Mappings"]),
+		conditions: asMap(doc["Conditions"]),
+		resources:  map[string]*cfnResource{},
+		names:      map[string]string{},
+		resolving:  map[string]bool{},
+		evaluated:  map[string]bool{},
+	}
+	for name, v := range asMap(doc["Parameters"]) {
+		t.parameters[name] = asMap(v)["Default"]
+	}
+	for id, v := range asMap(doc["Resources"]) {
+		m := asMap(v)
+		r := &cfnResource{Properties: asMap(m["Properties"])}
+		r.Type, _ = m["Type"].(string)
+		r.Condition, _ = m["Condition"].(string)
+		t.resources[id] = r
+	}
+
+	// resources are imported in the order of their logical IDs, so that the
+	// result does not depend on how the template is laid out
+	ids := make([]string, 0, len(t.resources))
+	for id := range t.resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	fb := newFixtureBuilder()
+	for _, id := range ids {
+		r := t.resources[id]
+		if !strings.HasPrefix(r.Type, "AWS::IAM::") {
+			continue
+		}
+		if r.Condition != "" {
+			ok, err := t.condition(r.Condition)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", id, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		props, err := t.resolve(r.Properties)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+		name, err := t.name(id)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+		if err := importCloudFormationResource(fb, r.Type, name, cfnProperties(asMap(props))); err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+	}
+	return fb.build()
+}
+
+// name returns the physical name of the resource of the logical ID id.
+func (t *cfnTemplate) name(id string) (string, error) {
+	if n, ok := t.names[id]; ok {
+		return n, nil
+	}
+	r := t.resources[id]
+	n := id
+	if prop, ok := cfnNameProperties[r.Type]; ok {
+		if v, ok := r.Properties[prop]; ok {
+			if t.resolving[id] {
+				return "", fmt.Errorf("the name of %s refers to itself", id)
+			}
+			t.resolving[id] = true
+			resolved, err := t.resolve(v)
+			delete(t.resolving, id)
+			if err != nil {
+				return "", err
+			}
+			s, ok := cfnScalar(resolved)
+			if !ok {
+				return "", fmt.Errorf("%s must be a string", prop)
+			}
+			n = s
+		}
+	}
+	t.names[id] = n
+	return n, nil
+}
+
+func (t *cfnTemplate) path(id string) (string, error) {
+	v, ok := t.resources[id].Properties["Path"]
+	if !ok {
+		return "/", nil
+	}
+	resolved, err := t.resolve(v)
+	if err != nil {
+		return "", err
+	}
+	s, _ := cfnScalar(resolved)
+	return s, nil
+}
+
+func (t *cfnTemplate) ref(name string) (interface{}, error) {
+	switch name {
+	case "AWS::AccountId":
+		return defaultAccountId, nil
+	case "AWS::Region":
+		return "us-east-1", nil
+	case "AWS::Partition":
+		return "aws", nil
+	case "AWS::URLSuffix":
+		return "amazonaws.com", nil
+	case "AWS::StackName":
+		return t.stackName, nil
+	case "AWS::StackId":
+		return fmt.Sprintf("arn:aws:cloudformation:us-east-1:%s:stack/%s/00000000-0000-0000-0000-000000000000", defaultAccountId, t.stackName), nil
+	case "AWS::NoValue":
+		return cfnNoValue, nil
+	}
+	if v, ok := t.parameters[name]; ok {
+		if v == nil {
+			return nil, fmt.Errorf("parameter %s has no default value", name)
+		}
+		return t.resolve(v)
+	}
+	r, ok := t.resources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown parameter or resource %s", name)
+	}
+	if r.Type == "AWS::IAM::ManagedPolicy" {
+		return t.arn(name)
+	}
+	return t.name(name)
+}
+
+// arn returns the ARN of the IAM resource of the logical ID id.
+func (t *cfnTemplate) arn(id string) (string, error) {
+	kind := map[string]string{
+		"AWS::IAM::User":            "user",
+		"AWS::IAM::Group":           "group",
+		"AWS::IAM::Role":            "role",
+		"AWS::IAM::InstanceProfile": "instance-profile",
+		"AWS::IAM::ManagedPolicy":   "policy",
+	}[t.resources[id].Type]
+	if kind == "" {
+		return id, nil
+	}
+	name, err := t.name(id)
+	if err != nil {
+		return "", err
+	}
+	path, err := t.path(id)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("arn:aws:iam::%s:%s%s%s", defaultAccountId, kind, path, name), nil
+}
+
+func (t *cfnTemplate) getAtt(id, attr string) (interface{}, error) {
+	r, ok := t.resources[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource %s", id)
+	}
+	if !strings.HasPrefix(r.Type, "AWS::IAM::") {
+		return id, nil
+	}
+	switch attr {
+	case "Arn", "PolicyArn":
+		return t.arn(id)
+	}
+	return nil, fmt.Errorf("attribute %s of %s is not known until it is created", attr, id)
+}
+
+var cfnSubRegexp = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// resolve returns v with the intrinsic functions in it evaluated.
+func (t *cfnTemplate) resolve(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 1 {
+			for fn, arg := range v {
+				if fn == "Ref" || fn == "Condition" || strings.HasPrefix(fn, "Fn::") {
+					return t.intrinsic(fn, arg)
+				}
+			}
+		}
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			r, err := t.resolve(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			if r != cfnNoValue {
+				m[k] = r
+			}
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			r, err := t.resolve(e)
+			if err != nil {
+				return nil, err
+			}
+			if r != cfnNoValue {
+				l = append(l, r)
+			}
+		}
+		return l, nil
+	}
+	return v, nil
+}
+
+func (t *cfnTemplate) intrinsic(fn string, arg interface{}) (interface{}, error) {
+	switch fn {
+	case "Ref":
+		name, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("Ref takes a name")
+		}
+		return t.ref(name)
+	case "Condition":
+		name, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("Condition takes a name")
+		}
+		return t.condition(name)
+	case "Fn::GetAtt":
+		var id, attr string
+		switch a := arg.(type) {
+		case string:
+			id, attr, _ = strings.Cut(a, ".")
+		case []interface{}:
+			if len(a) == 2 {
+				id, _ = a[0].(string)
+				attr, _ = a[1].(string)
+			}
+		}
+		if id == "" || attr == "" {
+			return nil, fmt.Errorf("Fn::GetAtt takes a logical ID and an attribute")
+		}
+		return t.getAtt(id, attr)
+	case "Fn::Sub":
+		var s string
+		vars := map[string]interface{}{}
+		switch a := arg.(type) {
+		case string:
+			s = a
+		case []interface{}:
+			if len(a) == 2 {
+				s, _ = a[0].(string)
+				vars = asMap(a[1])
+			}
+		}
+		return t.sub(s, vars)
+	case "Fn::Join":
+		a, ok := arg.([]interface{})
+		if !ok || len(a) != 2 {
+			return nil, fmt.Errorf("Fn::Join takes a delimiter and a list")
+		}
+		delim, _ := a[0].(string)
+		l, err := t.resolve(a[1])
+		if err != nil {
+			return nil, err
+		}
+		items, ok := l.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Fn::Join takes a delimiter and a list")
+		}
+		parts := make([]string, len(items))
+		for i, e := range items {
+			if parts[i], ok = cfnScalar(e); !ok {
+				return nil, fmt.Errorf("Fn::Join can only join strings")
+			}
+		}
+		return strings.Join(parts, delim), nil
+	case "Fn::Select":
+		a, ok := arg.([]interface{})
+		if !ok || len(a) != 2 {
+			return nil, fmt.Errorf("Fn::Select takes an index and a list")
+		}
+		r, err := t.resolve(a)
+		if err != nil {
+			return nil, err
+		}
+		a = r.([]interface{})
+		s, _ := cfnScalar(a[0])
+		i, err := strconv.Atoi(s)
+		items, ok := a[1].([]interface{})
+		if err != nil || !ok || i < 0 || i >= len(items) {
+			return nil, fmt.Errorf("Fn::Select takes an index and a list")
+		}
+		return items[i], nil
+	case "Fn::Split":
+		a, ok := arg.([]interface{})
+		if !ok || len(a) != 2 {
+			return nil, fmt.Errorf("Fn::Split takes a delimiter and a string")
+		}
+		r, err := t.resolve(a)
+		if err != nil {
+			return nil, err
+		}
+		a = r.([]interface{})
+		delim, _ := cfnScalar(a[0])
+		s, _ := cfnScalar(a[1])
+		var l []interface{}
+		for _, p := range strings.Split(s, delim) {
+			l = append(l, p)
+		}
+		return l, nil
+	case "Fn::FindInMap":
+		a, ok := arg.([]interface{})
+		if !ok || len(a) != 3 {
+			return nil, fmt.Errorf("Fn::FindInMap takes a map name and two keys")
+		}
+		r, err := t.resolve(a)
+		if err != nil {
+			return nil, err
+		}
+		a = r.([]interface{})
+		var v interface{} = t.mappings
+		for _, k := range a {
+			s, _ := cfnScalar(k)
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Fn::FindInMap: no %s", s)
+			}
+			if v, ok = m[s]; !ok {
+				return nil, fmt.Errorf("Fn::FindInMap: no %s", s)
+			}
+		}
+		return t.resolve(v)
+	case "Fn::If":
+		a, ok := arg.([]interface{})
+		if !ok || len(a) != 3 {
+			return nil, fmt.Errorf("Fn::If takes a condition and two values")
+		}
+		name, _ := a[0].(string)
+		c, err := t.condition(name)
+		if err != nil {
+			return nil, err
+		}
+		if c {
+			return t.resolve(a[1])
+		}
+		return t.resolve(a[2])
+	case "Fn::Equals", "Fn::Not", "Fn::And", "Fn::Or":
+		return t.evaluate(map[string]interface{}{fn: arg})
+	}
+	return nil, fmt.Errorf("%s is not supported", fn)
+}
+
+func (t *cfnTemplate) sub(s string, vars map[string]interface{}) (interface{}, error) {
+	var err error
+	out := cfnSubRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		if strings.HasPrefix(name, "!") {
+			return "${" + name[1:] + "}"
+		}
+		var v interface{}
+		var e error
+		if vv, ok := vars[name]; ok {
+			v, e = t.resolve(vv)
+		} else if id, attr, ok := strings.Cut(name, "."); ok {
+			v, e = t.getAtt(id, attr)
+		} else {
+			v, e = t.ref(name)
+		}
+		if e != nil {
+			if err == nil {
+				err = e
+			}
+			return ref
+		}
+		r, _ := cfnScalar(v)
+		return r
+	})
+	return out, err
+}
+
+func (t *cfnTemplate) condition(name string) (bool, error) {
+	c, ok := t.conditions[name]
+	if !ok {
+		return false, fmt.Errorf("unknown condition %s", name)
+	}
+	if t.evaluated[name] {
+		return false, fmt.Errorf("condition %s refers to itself", name)
+	}
+	t.evaluated[name] = true
+	defer delete(t.evaluated, name)
+	return t.evaluate(c)
+}
+
+func (t *cfnTemplate) evaluate(c interface{}) (bool, error) {
+	m := asMap(c)
+	if len(m) != 1 {
+		return false, fmt.Errorf("a condition must be a single condition function")
+	}
+	for fn, arg := range m {
+		a, _ := arg.([]interface{})
+		switch fn {
+		case "Condition":
+			name, _ := arg.(string)
+			return t.condition(name)
+		case "Fn::Equals":
+			r, err := t.resolve(arg)
+			if err != nil {
+				return false, err
+			}
+			a, _ = r.([]interface{})
+			if len(a) != 2 {
+				return false, fmt.Errorf("Fn::Equals takes two values")
+			}
+			x, _ := cfnScalar(a[0])
+			y, _ := cfnScalar(a[1])
+			return x == y, nil
+		case "Fn::Not":
+			if len(a) != 1 {
+				return false, fmt.Errorf("Fn::Not takes a condition")
+			}
+			v, err := t.evaluate(a[0])
+			return !v, err
+		case "Fn::And", "Fn::Or":
+			result := fn == "Fn::And"
+			for _, e := range a {
+				v, err := t.evaluate(e)
+				if err != nil {
+					return false, err
+				}
+				if fn == "Fn::And" {
+					result = result && v
+				} else {
+					result = result || v
+				}
+			}
+			return result, nil
+		}
+		return false, fmt.Errorf("%s is not a condition function", fn)
+	}
+	return false, nil
+}
+
+// cfnScalar renders a scalar of a template as the string CloudFormation
+// would pass on.
+func cfnScalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+// cfnProperties reads the resolved properties of a resource.
+type cfnProperties map[string]interface{}
+
+func (p cfnProperties) string(name string) string {
+	s, _ := cfnScalar(p[name])
+	return s
+}
+
+func (p cfnProperties) strings(name string) []string {
+	l, _ := p[name].([]interface{})
+	var result []string
+	for _, v := range l {
+		if s, ok := cfnScalar(v); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func (p cfnProperties) document(name string) (string, error) {
+	switch v := p[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		return string(b), nil
+	}
+}
+
+func (p cfnProperties) tags() map[string]string {
+	l, _ := p["Tags"].([]interface{})
+	if len(l) == 0 {
+		return nil
+	}
+	tags := make(map[string]string, len(l))
+	for _, v := range l {
+		t := cfnProperties(asMap(v))
+		tags[t.string("Key")] = t.string("Value")
+	}
+	return tags
+}
+
+// inlinePolicies adds the Policies property of a user, group or role.
+func (p cfnProperties) inlinePolicies(fb *fixtureBuilder, kind, name string) error {
+	l, _ := p["Policies"].([]interface{})
+	for _, v := range l {
+		pp := cfnProperties(asMap(v))
+		doc, err := pp.document("PolicyDocument")
+		if err != nil {
+			return err
+		}
+		fb.putInlinePolicy(kind, name, pp.string("PolicyName"), doc)
+	}
+	for _, arn := range p.strings("ManagedPolicyArns") {
+		fb.attachPolicy(kind, name, arn)
+	}
+	return nil
+}
+
+func importCloudFormationResource(fb *fixtureBuilder, typ, name string, p cfnProperties) error {
+	path := p.string("Path")
+	switch typ {
+	case "AWS::IAM::User":
+		u := &IAMUser{Name: name, Path: path, Tags: p.tags()}
+		if _, ok := p["LoginProfile"]; ok {
+			u.Password = &IAMUserPassword{}
+		}
+		if err := fb.addUser(u); err != nil {
+			return err
+		}
+		for _, g := range p.strings("Groups") {
+			fb.addGroupMember(g, name)
+		}
+		return p.inlinePolicies(fb, "user", name)
+	case "AWS::IAM::Group":
+		if err := fb.addGroup(&IAMGroup{Name: name, Path: path}); err != nil {
+			return err
+		}
+		return p.inlinePolicies(fb, "group", name)
+	case "AWS::IAM::Role":
+		doc, err := p.document("AssumeRolePolicyDocument")
+		if err != nil {
+			return err
+		}
+		var maxSessionDuration int64
+		if s := p.string("MaxSessionDuration"); s != "" {
+			if maxSessionDuration, err = strconv.ParseInt(s, 10, 64); err != nil {
+				return fmt.Errorf("MaxSessionDuration: %w", err)
+			}
+		}
+		err = fb.addRole(&IAMRole{
+			Name:                     name,
+			Path:                     path,
+			Description:              p.string("Description"),
+			AssumeRolePolicyDocument: doc,
+			MaxSessionDuration:       maxSessionDuration,
+			Tags:                     p.tags(),
+		})
+		if err != nil {
+			return err
+		}
+		return p.inlinePolicies(fb, "role", name)
+	case "AWS::IAM::InstanceProfile":
+		return fb.addInstanceProfile(&IAMInstanceProfile{Name: name, Path: path}, p.strings("Roles"))
+	case "AWS::IAM::ManagedPolicy":
+		doc, err := p.document("PolicyDocument")
+		if err != nil {
+			return err
+		}
+		err = fb.addPolicy(&IAMPolicy{
+			Name:        name,
+			Path:        path,
+			Description: p.string("Description"),
+			Versions:    []IAMPolicyVersion{{Document: doc}},
+		})
+		if err != nil {
+			return err
+		}
+		for _, u := range p.strings("Users") {
+			fb.attachPolicy("user", u, name)
+		}
+		for _, g := range p.strings("Groups") {
+			fb.attachPolicy("group", g, name)
+		}
+		for _, r := range p.strings("Roles") {
+			fb.attachPolicy("role", r, name)
+		}
+	case "AWS::IAM::Policy", "AWS::IAM::UserPolicy", "AWS::IAM::GroupPolicy", "AWS::IAM::RolePolicy":
+		doc, err := p.document("PolicyDocument")
+		if err != nil {
+			return err
+		}
+		users, groups, roles := p.strings("Users"), p.strings("Groups"), p.strings("Roles")
+		if u := p.string("UserName"); u != "" {
+			users = append(users, u)
+		}
+		if g := p.string("GroupName"); g != "" {
+			groups = append(groups, g)
+		}
+		if r := p.string("RoleName"); r != "" {
+			roles = append(roles, r)
+		}
+		if typ != "AWS::IAM::Policy" {
+			name = p.string("PolicyName")
+		}
+		for _, u := range users {
+			fb.putInlinePolicy("user", u, name, doc)
+		}
+		for _, g := range groups {
+			fb.putInlinePolicy("group", g, name, doc)
+		}
+		for _, r := range roles {
+			fb.putInlinePolicy("role", r, name, doc)
+		}
+	case "AWS::IAM::UserToGroupAddition":
+		for _, u := range p.strings("Users") {
+			fb.addGroupMember(p.string("GroupName"), u)
+		}
+	case "AWS::IAM::AccessKey":
+		status := p.string("Status")
+		if status == "" {
+			status = "Active"
+		}
+		fb.updateUser(p.string("UserName"), func(u *IAMUser) {
+			u.AccessKeys = append(u.AccessKeys, IAMAccessKey{Status: status})
+		})
+	default:
+		logger.Warn("ignoring a resource the emulator does not model", slog.String("type", typ))
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"time"
+)
+
+// importedCredentials is the credential information of a user read off a
+// credential report, which is applied to the user of the same name once the
+// whole fixture has been read.
+type importedCredentials struct {
+	at   position
+	user IAMUser
+}
+
+// loadCredentialReport reads a CSV credential report, as returned by
+// GetCredentialReport, skipping the row of the root account.
+func loadCredentialReport(file string, b []byte) ([]importedCredentials, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	columns := make(map[string]int, len(records[0]))
+	for i, c := range records[0] {
+		columns[c] = i
+	}
+	for _, c := range credentialReportColumns {
+		if _, ok := columns[c]; !ok {
+			return nil, fmt.Errorf("%s: column %s is missing", file, c)
+		}
+	}
+
+	var result []importedCredentials
+	for i, rec := range records[1:] {
+		at := position{file: file, line: i + 2}
+		var err error
+		field := func(c string) string {
+			return rec[columns[c]]
+		}
+		timeField := func(c string) time.Time {
+			t, e := reportTime(field(c))
+			if e != nil && err == nil {
+				err = fmt.Errorf("%s: %s: %w", at, c, e)
+			}
+			return t
+		}
+		if field("user") == "<root_account>" {
+			continue
+		}
+		u := IAMUser{
+			Name:      field("user"),
+			CreatedAt: timeField("user_creation_time"),
+			MFAActive: field("mfa_active") == "true",
+		}
+		if field("password_enabled") == "true" {
+			u.Password = &IAMUserPassword{
+				LastChanged: timeField("password_last_changed"),
+				LastUsed:    timeField("password_last_used"),
+			}
+		}
+		for n := 1; n <= maxAccessKeysPerUser; n++ {
+			prefix := fmt.Sprintf("access_key_%d_", n)
+			if field(prefix+"last_rotated") == "N/A" {
+				continue
+			}
+			k := IAMAccessKey{
+				Status:    reportStatus(field(prefix + "active")),
+				CreatedAt: timeField(prefix + "last_rotated"),
+			}
+			if date := timeField(prefix + "last_used_date"); !date.IsZero() {
+				k.LastUsed = &IAMAccessKeyLastUsed{
+					Date:    date,
+					Region:  reportString(field(prefix + "last_used_region")),
+					Service: reportString(field(prefix + "last_used_service")),
+				}
+			}
+			u.AccessKeys = append(u.AccessKeys, k)
+		}
+		for n := 1; n <= maxSigningCertificatesPerUser; n++ {
+			prefix := fmt.Sprintf("cert_%d_", n)
+			if field(prefix+"last_rotated") == "N/A" {
+				continue
+			}
+			u.SigningCertificates = append(u.SigningCertificates, IAMSigningCertificate{
+				Status:     reportStatus(field(prefix + "active")),
+				UploadedAt: timeField(prefix + "last_rotated"),
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, importedCredentials{at: at, user: u})
+	}
+	return result, nil
+}
+
+// applyCredentials sets the credential information of the user c is about.
+func (y *fixture) applyCredentials(c importedCredentials) error {
+	for i := range y.Users {
+		u := &y.Users[i]
+		if u.Name != c.user.Name {
+			continue
+		}
+		if u.CreatedAt.IsZero() {
+			u.CreatedAt = c.user.CreatedAt
+		}
+		u.Password = c.user.Password
+		u.MFAActive = c.user.MFAActive
+		u.AccessKeys = c.user.AccessKeys
+		u.SigningCertificates = c.user.SigningCertificates
+		return nil
+	}
+	return fmt.Errorf("%s: unknown user %s", c.at, c.user.Name)
+}
+
+// reportTime parses a timestamp of the credential report, where N/A and the
+// like stand for none.
+func reportTime(v string) (time.Time, error) {
+	switch v {
+	case "", "N/A", "no_information", "not_supported":
+		return time.Time{}, nil
+	}
+	return parseTimestamp(v, "iso8601")
+}
+
+func reportString(v string) string {
+	if v == "N/A" {
+		return ""
+	}
+	return v
+}
+
+func reportStatus(active string) string {
+	if active == "true" {
+		return "Active"
+	}
+	return "Inactive"
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// terraformState is the part of a Terraform state file (format version 4,
+// as of Terraform 0.12) that matters here.
+type terraformState struct {
+	Version   int                 `json:"version"`
+	Resources []terraformResource `json:"resources"`
+}
+
+type terraformResource struct {
+	Module    string `json:"module"`
+	Mode      string `json:"mode"`
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	Instances []struct {
+		IndexKey   interface{}            `json:"index_key"`
+		Attributes map[string]interface{} `json:"attributes"`
+	} `json:"instances"`
+}
+
+func (r *terraformResource) address(indexKey interface{}) string {
+	addr := r.Type + "." + r.Name
+	if r.Module != "" {
+		addr = r.Module + "." + addr
+	}
+	switch k := indexKey.(type) {
+	case string:
+		addr += fmt.Sprintf("[%q]", k)
+	case float64:
+		addr += fmt.Sprintf("[%d]", int(k))
+	}
+	return addr
+}
+
+// terraformAttributes reads the attributes of a resource instance, which
+// Terraform writes as JSON in the types of the provider schema.
+type terraformAttributes map[string]interface{}
+
+func (a terraformAttributes) string(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+func (a terraformAttributes) strings(name string) []string {
+	l, _ := a[name].([]interface{})
+	var result []string
+	for _, v := range l {
+		if s, ok := v.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func (a terraformAttributes) tags() map[string]string {
+	m, _ := a["tags"].(map[string]interface{})
+	if len(m) == 0 {
+		return nil
+	}
+	tags := make(map[string]string, len(m))
+	for k, v := range m {
+		tags[k], _ = v.(string)
+	}
+	return tags
+}
+
+func (a terraformAttributes) time(name string) (time.Time, error) {
+	s := a.string(name)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return parseTimestamp(s, "iso8601")
+}
+
+// loadTerraformState reads the aws_iam_* resources of a Terraform state file
+// as a fixture.  Data sources are left out, as they do not belong to the
+// configuration.
+func loadTerraformState(b []byte) (*fixture, error) {
+	var state terraformState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, err
+	}
+	if state.Version != 4 {
+		return nil, fmt.Errorf("unsupported state format version %d", state.Version)
+	}
+	fb := newFixtureBuilder()
+	for i := range state.Resources {
+		r := &state.Resources[i]
+		if r.Mode != "managed" || !strings.HasPrefix(r.Type, "aws_iam_") {
+			continue
+		}
+		for _, inst := range r.Instances {
+			if err := importTerraformResource(fb, r.Type, terraformAttributes(inst.Attributes)); err != nil {
+				return nil, fmt.Errorf("%s: %w", r.address(inst.IndexKey), err)
+			}
+		}
+	}
+	return fb.build()
+}
+
+func importTerraformResource(fb *fixtureBuilder, typ string, a terraformAttributes) error {
+	if arn := a.string("arn"); arn != "" {
+		if err := fb.accountFromArn(arn); err != nil {
+			return err
+		}
+	}
+	switch typ {
+	case "aws_iam_user":
+		createdAt, err := a.time("create_date")
+		if err != nil {
+			return err
+		}
+		return fb.addUser(&IAMUser{
+			Id:        a.string("unique_id"),
+			Name:      a.string("name"),
+			CreatedAt: createdAt,
+			Path:      a.string("path"),
+			Tags:      a.tags(),
+		})
+	case "aws_iam_group":
+		return fb.addGroup(&IAMGroup{
+			Id:   a.string("unique_id"),
+			Name: a.string("name"),
+			Path: a.string("path"),
+		})
+	case "aws_iam_role":
+		createdAt, err := a.time("create_date")
+		if err != nil {
+			return err
+		}
+		var maxSessionDuration int64
+		if f, ok := a["max_session_duration"].(float64); ok {
+			maxSessionDuration = int64(f)
+		}
+		name := a.string("name")
+		err = fb.addRole(&IAMRole{
+			Id:                       a.string("unique_id"),
+			Name:                     name,
+			CreatedAt:                createdAt,
+			Path:                     a.string("path"),
+			Description:              a.string("description"),
+			AssumeRolePolicyDocument: a.string("assume_role_policy"),
+			MaxSessionDuration:       maxSessionDuration,
+			Tags:                     a.tags(),
+		})
+		if err != nil {
+			return err
+		}
+		// the inline_policy blocks and managed_policy_arns of the role
+		// itself; an inline_policy without a name only marks the role as
+		// having none
+		l, _ := a["inline_policy"].([]interface{})
+		for _, v := range l {
+			p := terraformAttributes(asMap(v))
+			if p.string("name") != "" {
+				fb.putInlinePolicy("role", name, p.string("name"), p.string("policy"))
+			}
+		}
+		for _, arn := range a.strings("managed_policy_arns") {
+			fb.attachPolicy("role", name, arn)
+		}
+		return nil
+	case "aws_iam_instance_profile":
+		createdAt, err := a.time("create_date")
+		if err != nil {
+			return err
+		}
+		var roles []string
+		if r := a.string("role"); r != "" {
+			roles = []string{r}
+		}
+		return fb.addInstanceProfile(&IAMInstanceProfile{
+			Id:        a.string("unique_id"),
+			Name:      a.string("name"),
+			CreatedAt: createdAt,
+			Path:      a.string("path"),
+			Tags:      a.tags(),
+		}, roles)
+	case "aws_iam_policy":
+		return fb.addPolicy(&IAMPolicy{
+			Id:          a.string("policy_id"),
+			Name:        a.string("name"),
+			Path:        a.string("path"),
+			Description: a.string("description"),
+			Versions:    []IAMPolicyVersion{{Document: a.string("policy")}},
+			Tags:        a.tags(),
+		})
+	case "aws_iam_user_policy":
+		fb.putInlinePolicy("user", a.string("user"), a.string("name"), a.string("policy"))
+	case "aws_iam_group_policy":
+		fb.putInlinePolicy("group", a.string("group"), a.string("name"), a.string("policy"))
+	case "aws_iam_role_policy":
+		fb.putInlinePolicy("role", a.string("role"), a.string("name"), a.string("policy"))
+	case "aws_iam_user_policy_attachment":
+		fb.attachPolicy("user", a.string("user"), a.string("policy_arn"))
+	case "aws_iam_group_policy_attachment":
+		fb.attachPolicy("group", a.string("group"), a.string("policy_arn"))
+	case "aws_iam_role_policy_attachment":
+		fb.attachPolicy("role", a.string("role"), a.string("policy_arn"))
+	case "aws_iam_policy_attachment":
+		for _, u := range a.strings("users") {
+			fb.attachPolicy("user", u, a.string("policy_arn"))
+		}
+		for _, g := range a.strings("groups") {
+			fb.attachPolicy("group", g, a.string("policy_arn"))
+		}
+		for _, r := range a.strings("roles") {
+			fb.attachPolicy("role", r, a.string("policy_arn"))
+		}
+	case "aws_iam_group_membership":
+		for _, u := range a.strings("users") {
+			fb.addGroupMember(a.string("group"), u)
+		}
+	case "aws_iam_user_group_membership":
+		for _, g := range a.strings("groups") {
+			fb.addGroupMember(g, a.string("user"))
+		}
+	case "aws_iam_user_login_profile":
+		fb.updateUser(a.string("user"), func(u *IAMUser) {
+			u.Password = &IAMUserPassword{}
+		})
+	case "aws_iam_access_key":
+		createdAt, err := a.time("create_date")
+		if err != nil {
+			return err
+		}
+		status := a.string("status")
+		fb.updateUser(a.string("user"), func(u *IAMUser) {
+			u.AccessKeys = append(u.AccessKeys, IAMAccessKey{
+				Id:        a.string("id"),
+				Status:    status,
+				CreatedAt: createdAt,
+			})
+		})
+	case "aws_iam_account_alias":
+		fb.fixture.Account.Alias = a.string("account_alias")
+	default:
+		logger.Warn("ignoring a resource the emulator does not model", slog.String("type", typ))
+	}
+	return nil
+}
+
+func asMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
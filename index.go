@@ -0,0 +1,76 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"sort"
+)
+
+// orderedIndex holds entities by name and keeps the names sorted, so that
+// enumerations come out in the same order every time and can be resumed
+// from a name, which is what pagination markers designate.
+type orderedIndex[T any] struct {
+	byName map[string]T
+	names  []string
+}
+
+func newOrderedIndex[T any]() *orderedIndex[T] {
+	return &orderedIndex[T]{
+		byName: make(map[string]T),
+	}
+}
+
+func (idx *orderedIndex[T]) get(name string) (T, bool) {
+	v, ok := idx.byName[name]
+	return v, ok
+}
+
+func (idx *orderedIndex[T]) put(name string, v T) {
+	if _, ok := idx.byName[name]; !ok {
+		i := sort.SearchStrings(idx.names, name)
+		idx.names = append(idx.names, "")
+		copy(idx.names[i+1:], idx.names[i:])
+		idx.names[i] = name
+	}
+	idx.byName[name] = v
+}
+
+func (idx *orderedIndex[T]) delete(name string) bool {
+	if _, ok := idx.byName[name]; !ok {
+		return false
+	}
+	delete(idx.byName, name)
+	i := sort.SearchStrings(idx.names, name)
+	idx.names = append(idx.names[:i], idx.names[i+1:]...)
+	return true
+}
+
+func (idx *orderedIndex[T]) len() int {
+	return len(idx.names)
+}
+
+// values returns the entities in ascending order of their names.
+func (idx *orderedIndex[T]) values() []T {
+	result := make([]T, len(idx.names))
+	for i, name := range idx.names {
+		result[i] = idx.byName[name]
+	}
+	return result
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestOrderedIndex(t *testing.T) {
+	idx := newOrderedIndex[int]()
+	for i, name := range []string{"c", "a", "d", "b"} {
+		idx.put(name, i)
+	}
+	idx.put("a", 10)
+	if !idx.delete("d") || idx.delete("d") {
+		t.Error("delete did not report whether the entity was there")
+	}
+	if got := idx.values(); len(got) != 3 || got[0] != 10 || got[1] != 3 || got[2] != 0 {
+		t.Errorf("got %v, want [10 3 0]", got)
+	}
+	if idx.len() != 3 {
+		t.Errorf("got length %d", idx.len())
+	}
+}
+
+func TestListOrder(t *testing.T) {
+	svc, _ := newTestService(t, `
+users: [{name: carol}, {name: alice}, {name: Bob}, {name: dave}]
+groups: [{name: ops}, {name: dev}, {name: admin}]
+roles: [{name: web}, {name: batch}]
+`)
+	for _, c := range []struct {
+		action, element string
+		want            []string
+	}{
+		{"ListUsers", "UserName", []string{"Bob", "alice", "carol", "dave"}},
+		{"ListGroups", "GroupName", []string{"admin", "dev", "ops"}},
+		{"ListRoles", "RoleName", []string{"batch", "web"}},
+	} {
+		re := regexp.MustCompile("<" + c.element + ">([^<]*)<")
+		// the order must not change from call to call, as map iteration does
+		for i := 0; i < 10; i++ {
+			w := query(t, svc, url.Values{"Action": {c.action}})
+			var got []string
+			for _, m := range re.FindAllStringSubmatch(w.Body.String(), -1) {
+				got = append(got, m[1])
+			}
+			if strings.Join(got, ",") != strings.Join(c.want, ",") {
+				t.Fatalf("%s: got %v, want %v", c.action, got, c.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,427 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// maxRolesPerInstanceProfile is the hard quota IAM imposes on the number of
+// roles an instance profile can hold.
+const maxRolesPerInstanceProfile = 1
+
+type IAMInstanceProfile struct {
+	Id        string            `yaml:"id"`
+	Name      string            `yaml:"name"`
+	CreatedAt time.Time         `yaml:"created_at"`
+	Path      string            `yaml:"path"`
+	Tags      map[string]string `yaml:"tags,omitempty"`
+	Roles     []*IAMRole        `yaml:"-"`
+}
+
+func instanceProfileName(p *IAMInstanceProfile) string {
+	return p.Name
+}
+
+func instanceProfilePath(p *IAMInstanceProfile) string {
+	return p.Path
+}
+
+func (p *IAMInstanceProfile) BuildArn(accountId string) string {
+	path := strings.TrimPrefix(strings.TrimRight(p.Path, "/"), "/")
+	var slash string
+	if path != "" {
+		slash = "/"
+	}
+	return fmt.Sprintf("arn:aws:iam::%s:instance-profile/%s%s%s", accountId, path, slash, p.Name)
+}
+
+func (p *IAMInstanceProfile) BuildInstanceProfile(accountId string) iam.InstanceProfile {
+	roles := make([]iam.Role, len(p.Roles))
+	for i, r := range p.Roles {
+		roles[i] = r.BuildRole(accountId)
+	}
+	return iam.InstanceProfile{
+		Arn:                 aws.String(p.BuildArn(accountId)),
+		CreateDate:          aws.Time(p.CreatedAt),
+		InstanceProfileId:   aws.String(p.Id),
+		InstanceProfileName: aws.String(p.Name),
+		Path:                aws.String(p.Path),
+		Roles:               roles,
+	}
+}
+
+// BuildTaggedInstanceProfile is BuildInstanceProfile with the tags included,
+// for the operations whose output carries them.
+func (p *IAMInstanceProfile) BuildTaggedInstanceProfile(accountId string) InstanceProfile {
+	ip := p.BuildInstanceProfile(accountId)
+	result := InstanceProfile{
+		Arn:                 ip.Arn,
+		CreateDate:          ip.CreateDate,
+		InstanceProfileId:   ip.InstanceProfileId,
+		InstanceProfileName: ip.InstanceProfileName,
+		Path:                ip.Path,
+		Roles:               ip.Roles,
+	}
+	if len(p.Tags) > 0 {
+		result.Tags = buildTags(p.Tags)
+	}
+	return result
+}
+
+func noSuchInstanceProfile(name string) error {
+	return &SenderFault{
+		Code_:    "NoSuchEntity",
+		Message_: fmt.Sprintf("Instance Profile %s cannot be found.", name),
+	}
+}
+
+func registerInstanceProfileHandlers(iamAPISet *APISet, reg IAMRegistry) {
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "CreateInstanceProfile",
+			Proto: CreateInstanceProfileInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*CreateInstanceProfileInput)
+				err = validateTags(params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				tags, err := mergeTags(nil, params.Tags, "TagsPerInstanceProfile")
+				if err != nil {
+					return nil, err
+				}
+				p := &IAMInstanceProfile{
+					Name:      *params.InstanceProfileName,
+					CreatedAt: time.Now().UTC().Truncate(time.Second),
+					Path:      aws.StringValue(params.Path),
+					Tags:      tags,
+				}
+				if p.Path == "" {
+					p.Path = "/"
+				}
+				err = reg.CreateInstanceProfile(p)
+				if err != nil {
+					return nil, err
+				}
+
+				ip := p.BuildTaggedInstanceProfile(accountId)
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &CreateInstanceProfileOutput{InstanceProfile: &ip},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "GetInstanceProfile",
+			Proto: iam.GetInstanceProfileInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*iam.GetInstanceProfileInput)
+				p, ok, err := reg.GetInstanceProfileByName(*params.InstanceProfileName)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchInstanceProfile(*params.InstanceProfileName)
+				}
+
+				ip := p.BuildTaggedInstanceProfile(accountId)
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &GetInstanceProfileOutput{InstanceProfile: &ip},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListInstanceProfiles",
+			Proto: iam.ListInstanceProfilesInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*iam.ListInstanceProfilesInput)
+
+				profiles, err := reg.GetInstanceProfiles()
+				if err != nil {
+					return nil, err
+				}
+				profiles = filterByPathPrefix(profiles, instanceProfilePath, params.PathPrefix)
+				profiles, marker, err := paginate(profiles, instanceProfileName, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+
+				out := &iam.ListInstanceProfilesOutput{
+					IsTruncated: aws.Bool(marker != nil),
+					Marker:      marker,
+				}
+
+				out.InstanceProfiles = make([]iam.InstanceProfile, len(profiles))
+				for i, p := range profiles {
+					out.InstanceProfiles[i] = p.BuildInstanceProfile(accountId)
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "DeleteInstanceProfile",
+			Proto: iam.DeleteInstanceProfileInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.DeleteInstanceProfileInput)
+				err := reg.DeleteInstanceProfile(*params.InstanceProfileName)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.DeleteInstanceProfileOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "AddRoleToInstanceProfile",
+			Proto: iam.AddRoleToInstanceProfileInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.AddRoleToInstanceProfileInput)
+				err := reg.AddRoleToInstanceProfile(*params.InstanceProfileName, *params.RoleName)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.AddRoleToInstanceProfileOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "RemoveRoleFromInstanceProfile",
+			Proto: iam.RemoveRoleFromInstanceProfileInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.RemoveRoleFromInstanceProfileInput)
+				err := reg.RemoveRoleFromInstanceProfile(*params.InstanceProfileName, *params.RoleName)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.RemoveRoleFromInstanceProfileOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListInstanceProfilesForRole",
+			Proto: iam.ListInstanceProfilesForRoleInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*iam.ListInstanceProfilesForRoleInput)
+
+				profiles, err := reg.GetInstanceProfilesForRole(*params.RoleName)
+				if err != nil {
+					return nil, err
+				}
+				profiles, marker, err := paginate(profiles, instanceProfileName, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+
+				out := &iam.ListInstanceProfilesForRoleOutput{
+					IsTruncated: aws.Bool(marker != nil),
+					Marker:      marker,
+				}
+
+				out.InstanceProfiles = make([]iam.InstanceProfile, len(profiles))
+				for i, p := range profiles {
+					out.InstanceProfiles[i] = p.BuildInstanceProfile(accountId)
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+}
+
+func (reg *BasicIAMRegistry) GetInstanceProfileByName(name string) (*IAMInstanceProfile, bool, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	p, ok := reg.instanceProfiles.get(name)
+	return p, ok, nil
+}
+
+func (reg *BasicIAMRegistry) GetInstanceProfiles() ([]*IAMInstanceProfile, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.instanceProfiles.values(), nil
+}
+
+func (reg *BasicIAMRegistry) GetInstanceProfilesForRole(roleName string) ([]*IAMInstanceProfile, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	if _, ok := reg.roles.get(roleName); !ok {
+		return nil, noSuchRole(roleName)
+	}
+	profiles := make([]*IAMInstanceProfile, 0)
+	for _, p := range reg.instanceProfiles.values() {
+		for _, r := range p.Roles {
+			if r.Name == roleName {
+				profiles = append(profiles, p)
+				break
+			}
+		}
+	}
+	return profiles, nil
+}
+
+func (reg *BasicIAMRegistry) CreateInstanceProfile(p *IAMInstanceProfile) error {
+	return reg.update(func(tx *registryTx) error {
+		if _, ok := tx.instanceProfile(p.Name); ok {
+			return &SenderFault{
+				Code_:    "EntityAlreadyExists",
+				Message_: fmt.Sprintf("Instance Profile %s already exists.", p.Name),
+			}
+		}
+		if p.Id == "" {
+			p.Id = tx.allocateId(instanceProfileIdPrefix, p.Name)
+		}
+		tx.putInstanceProfile(p)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) DeleteInstanceProfile(name string) error {
+	return reg.update(func(tx *registryTx) error {
+		p, ok := tx.instanceProfile(name)
+		if !ok {
+			return noSuchInstanceProfile(name)
+		}
+		if len(p.Roles) > 0 {
+			return &SenderFault{
+				Code_:    "DeleteConflict",
+				Message_: "Cannot delete entity, must remove roles from instance profile first.",
+			}
+		}
+		tx.deleteInstanceProfile(name)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) AddRoleToInstanceProfile(profileName, roleName string) error {
+	return reg.update(func(tx *registryTx) error {
+		p, ok := tx.instanceProfile(profileName)
+		if !ok {
+			return noSuchInstanceProfile(profileName)
+		}
+		r, ok := tx.role(roleName)
+		if !ok {
+			return noSuchRole(roleName)
+		}
+		for _, pr := range p.Roles {
+			// the profile may still refer to a previous version of the role
+			if pr.Name == r.Name {
+				return &SenderFault{
+					Code_:    "EntityAlreadyExists",
+					Message_: fmt.Sprintf("Role %s already exists in instance profile %s.", roleName, profileName),
+				}
+			}
+		}
+		if len(p.Roles) >= maxRolesPerInstanceProfile {
+			return &SenderFault{
+				Code_:    "LimitExceeded",
+				Message_: fmt.Sprintf("Cannot exceed quota for InstanceSessionsPerInstanceProfile: %d", maxRolesPerInstanceProfile),
+			}
+		}
+		// replace rather than append in place so that readers holding the
+		// previous slice never observe a partial update
+		np := *p
+		np.Roles = make([]*IAMRole, 0, len(p.Roles)+1)
+		np.Roles = append(np.Roles, p.Roles...)
+		np.Roles = append(np.Roles, r)
+		tx.putInstanceProfile(&np)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) RemoveRoleFromInstanceProfile(profileName, roleName string) error {
+	return reg.update(func(tx *registryTx) error {
+		p, ok := tx.instanceProfile(profileName)
+		if !ok {
+			return noSuchInstanceProfile(profileName)
+		}
+		if _, ok := tx.role(roleName); !ok {
+			return noSuchRole(roleName)
+		}
+		roles := make([]*IAMRole, 0, len(p.Roles))
+		for _, r := range p.Roles {
+			if r.Name != roleName {
+				roles = append(roles, r)
+			}
+		}
+		if len(roles) == len(p.Roles) {
+			return &SenderFault{
+				Code_:    "NoSuchEntity",
+				Message_: fmt.Sprintf("The role with name %s is not associated with instance profile %s.", roleName, profileName),
+			}
+		}
+		np := *p
+		np.Roles = roles
+		tx.putInstanceProfile(&np)
+		return nil
+	})
+}
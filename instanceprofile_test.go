@@ -0,0 +1,173 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+const instanceProfileFixture = `
+roles:
+  - name: web
+    instance_profile: {}
+  - name: db
+instance_profiles:
+  - name: spare
+    path: /ops/
+`
+
+func TestInstanceProfileOperations(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		params url.Values
+		status int
+		want   []string
+	}{
+		{
+			name:   "get",
+			params: url.Values{"Action": {"GetInstanceProfile"}, "InstanceProfileName": {"web"}},
+			status: http.StatusOK,
+			want:   []string{"<InstanceProfileName>web</InstanceProfileName>", "<RoleName>web</RoleName>", "arn:aws:iam::000000000000:instance-profile/web"},
+		},
+		{
+			name:   "get unknown",
+			params: url.Values{"Action": {"GetInstanceProfile"}, "InstanceProfileName": {"nope"}},
+			status: http.StatusNotFound,
+			want:   []string{"<Code>NoSuchEntity</Code>", "Instance Profile nope cannot be found."},
+		},
+		{
+			name:   "list by path prefix",
+			params: url.Values{"Action": {"ListInstanceProfiles"}, "PathPrefix": {"/ops/"}},
+			status: http.StatusOK,
+			want:   []string{"<InstanceProfileName>spare</InstanceProfileName>", "arn:aws:iam::000000000000:instance-profile/ops/spare"},
+		},
+		{
+			name:   "list for role",
+			params: url.Values{"Action": {"ListInstanceProfilesForRole"}, "RoleName": {"web"}},
+			status: http.StatusOK,
+			want:   []string{"<InstanceProfileName>web</InstanceProfileName>"},
+		},
+		{
+			name:   "list for unknown role",
+			params: url.Values{"Action": {"ListInstanceProfilesForRole"}, "RoleName": {"nope"}},
+			status: http.StatusNotFound,
+			want:   []string{"<Code>NoSuchEntity</Code>"},
+		},
+		{
+			name:   "create existing",
+			params: url.Values{"Action": {"CreateInstanceProfile"}, "InstanceProfileName": {"web"}},
+			status: http.StatusConflict,
+			want:   []string{"<Code>EntityAlreadyExists</Code>"},
+		},
+		{
+			name:   "add beyond the quota",
+			params: url.Values{"Action": {"AddRoleToInstanceProfile"}, "InstanceProfileName": {"web"}, "RoleName": {"db"}},
+			status: http.StatusConflict,
+			want:   []string{"<Code>LimitExceeded</Code>"},
+		},
+		{
+			name:   "add unknown role",
+			params: url.Values{"Action": {"AddRoleToInstanceProfile"}, "InstanceProfileName": {"spare"}, "RoleName": {"nope"}},
+			status: http.StatusNotFound,
+			want:   []string{"The role with name nope cannot be found."},
+		},
+		{
+			name:   "remove a role not in the profile",
+			params: url.Values{"Action": {"RemoveRoleFromInstanceProfile"}, "InstanceProfileName": {"web"}, "RoleName": {"db"}},
+			status: http.StatusNotFound,
+			want:   []string{"The role with name db is not associated with instance profile web."},
+		},
+		{
+			name:   "delete a profile holding a role",
+			params: url.Values{"Action": {"DeleteInstanceProfile"}, "InstanceProfileName": {"web"}},
+			status: http.StatusConflict,
+			want:   []string{"<Code>DeleteConflict</Code>"},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			svc, _ := newTestService(t, instanceProfileFixture)
+			w := query(t, svc, c.params)
+			if w.Code != c.status {
+				t.Errorf("got status %d, want %d:\n%s", w.Code, c.status, w.Body)
+			}
+			for _, s := range c.want {
+				if !strings.Contains(w.Body.String(), s) {
+					t.Errorf("%q is missing from\n%s", s, w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestInstanceProfileLifecycle(t *testing.T) {
+	svc, reg := newTestService(t, instanceProfileFixture)
+	steps := []url.Values{
+		{"Action": {"CreateInstanceProfile"}, "InstanceProfileName": {"batch"}, "Path": {"/jobs/"}},
+		{"Action": {"AddRoleToInstanceProfile"}, "InstanceProfileName": {"batch"}, "RoleName": {"db"}},
+		{"Action": {"RemoveRoleFromInstanceProfile"}, "InstanceProfileName": {"batch"}, "RoleName": {"db"}},
+		{"Action": {"AddRoleToInstanceProfile"}, "InstanceProfileName": {"batch"}, "RoleName": {"db"}},
+	}
+	for _, params := range steps {
+		if w := query(t, svc, params); w.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d:\n%s", params.Get("Action"), w.Code, w.Body)
+		}
+	}
+	p, ok, _ := reg.GetInstanceProfileByName("batch")
+	if !ok || p.Path != "/jobs/" || !strings.HasPrefix(p.Id, instanceProfileIdPrefix) {
+		t.Fatalf("got %+v", p)
+	}
+	if len(p.Roles) != 1 || p.Roles[0].Name != "db" {
+		t.Fatalf("got roles %v, want db", p.Roles)
+	}
+	profiles, _ := reg.GetInstanceProfilesForRole("db")
+	if len(profiles) != 1 || profiles[0].Name != "batch" {
+		t.Errorf("got %v for role db", profiles)
+	}
+
+	for _, params := range []url.Values{
+		{"Action": {"RemoveRoleFromInstanceProfile"}, "InstanceProfileName": {"batch"}, "RoleName": {"db"}},
+		{"Action": {"DeleteInstanceProfile"}, "InstanceProfileName": {"batch"}},
+	} {
+		if w := query(t, svc, params); w.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d:\n%s", params.Get("Action"), w.Code, w.Body)
+		}
+	}
+	if _, ok, _ := reg.GetInstanceProfileByName("batch"); ok {
+		t.Error("the instance profile was not deleted")
+	}
+}
+
+func TestAddRoleToInstanceProfileAfterRoleUpdate(t *testing.T) {
+	reg := newTestRegistry(t, instanceProfileFixture)
+	// the role is replaced by a new version
+	if err := reg.TagRole("web", []iam.Tag{{Key: aws.String("k"), Value: aws.String("v")}}); err != nil {
+		t.Fatal(err)
+	}
+	err := reg.AddRoleToInstanceProfile("web", "web")
+	if f, ok := err.(Fault); !ok || f.Code() != "EntityAlreadyExists" {
+		t.Errorf("got %v, want EntityAlreadyExists", err)
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fixtureLinter checks fixture files more strictly than loading them does,
+// and reports every problem it finds rather than the first one.
+type fixtureLinter struct {
+	defined     map[string]position
+	ids         map[string]idOwner
+	diagnostics []diagnostic
+}
+
+var yamlErrorRegexp = regexp.MustCompile(`^\[(\d+):\d+\] ([^\n]*)`)
+
+type diagnostic struct {
+	line    int
+	message string
+}
+
+type idOwner struct {
+	at   position
+	what string
+}
+
+func newFixtureLinter() *fixtureLinter {
+	return &fixtureLinter{
+		defined: map[string]position{},
+		ids:     map[string]idOwner{},
+	}
+}
+
+func (l *fixtureLinter) reportf(at position, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, diagnostic{at.line, fmt.Sprintf("%s: %s", at, fmt.Sprintf(format, args...))})
+}
+
+// lintFile checks a YAML fixture file against the schema and the entities
+// it defines against those of the files checked before it.
+func (l *fixtureLinter) lintFile(file string) {
+	// the problems of a file are told in the order of their lines
+	start := len(l.diagnostics)
+	defer func() {
+		found := l.diagnostics[start:]
+		sort.SliceStable(found, func(i, j int) bool { return found[i].line < found[j].line })
+	}()
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		l.diagnostics = append(l.diagnostics, diagnostic{0, err.Error()})
+		return
+	}
+	if isCloudFormationTemplate(b) {
+		return
+	}
+	f, err := parseYAML(b)
+	if err != nil {
+		// the parser quotes the source after a [LINE:COLUMN] prefix, which
+		// is brought in line with the other diagnostics
+		msg := err.Error()
+		if m := yamlErrorRegexp.FindStringSubmatch(msg); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			l.reportf(position{file, line}, "%s", m[2])
+			return
+		}
+		l.diagnostics = append(l.diagnostics, diagnostic{0, fmt.Sprintf("%s: %s", file, msg)})
+		return
+	}
+	if err := expandEnv(file, f); err != nil {
+		l.diagnostics = append(l.diagnostics, diagnostic{0, err.Error()})
+		return
+	}
+	if len(f.Docs) == 0 || f.Docs[0].Body == nil {
+		return
+	}
+	if len(f.Docs) > 1 {
+		l.reportf(position{file, f.Docs[1].GetToken().Position.Line}, "only the first document of a file is read")
+	}
+	c := newYAMLConverter()
+	root := c.convert(f.Docs[0].Body)
+	for _, p := range c.problems {
+		l.reportf(position{file, p.line}, "%s", p.message)
+	}
+	fixtureSchema.check(root, "", func(line int, format string, args ...interface{}) {
+		l.reportf(position{file, line}, format, args...)
+	})
+	if root.kind == "object" {
+		l.lintEntities(file, root)
+	}
+}
+
+func (l *fixtureLinter) lintEntities(file string, root *yamlNode) {
+	// name is the member entities are known by
+	for _, k := range []struct{ field, kind, name string }{
+		{"users", "user", "name"},
+		{"groups", "group", "name"},
+		{"roles", "role", "name"},
+		{"instance_profiles", "instance profile", "name"},
+		{"policies", "policy", "name"},
+		{"open_id_connect_providers", "OpenID Connect provider", "url"},
+		{"saml_providers", "SAML provider", "name"},
+		{"virtual_mfa_devices", "virtual MFA device", "name"},
+		{"server_certificates", "server certificate", "name"},
+	} {
+		list := root.field(k.field)
+		if list == nil || list.kind != "array" {
+			continue
+		}
+		for i, e := range list.items {
+			name := e.field(k.name)
+			if e.kind != "object" || name == nil || name.kind != "string" {
+				// the schema has it covered
+				continue
+			}
+			at := fmt.Sprintf("%s[%d]", k.field, i)
+			key := name.value
+			if k.kind == "OpenID Connect provider" {
+				key = oidcProviderKey(&IAMOpenIDConnectProvider{Url: name.value})
+			}
+			if k.kind == "policy" {
+				p := &IAMPolicy{Name: name.value}
+				if v := e.field("path"); v != nil {
+					p.Path = v.value
+				}
+				if v := e.field("aws_managed"); v != nil {
+					p.AWSManaged = v.value == "true"
+				}
+				key = policyKey(p)
+				if versions := e.field("versions"); versions != nil {
+					for j, v := range versions.items {
+						if v.kind == "object" {
+							l.lintDocument(file, fmt.Sprintf("%s.versions[%d].document", at, j), v.field("document"))
+						}
+					}
+				}
+			}
+			l.define(file, k.kind, key, name.line, e.field("id"))
+			if ip := e.field("instance_profile"); k.kind == "role" && ip != nil && ip.kind == "object" {
+				ipName := key
+				if n := ip.field("name"); n != nil {
+					ipName = n.value
+				}
+				l.define(file, "instance profile", ipName, ip.line, ip.field("id"))
+			}
+			l.lintDocument(file, at+".assume_role_policy_document", e.field("assume_role_policy_document"))
+			if inline := e.field("inline_policies"); inline != nil {
+				for _, f := range inline.fields {
+					l.lintDocument(file, at+".inline_policies."+f.key.value, f.value)
+				}
+			}
+		}
+	}
+}
+
+// define notes the entity of kind named name, written at line, and its ID,
+// which must be unique among the entities of all kinds.
+func (l *fixtureLinter) define(file, kind, name string, line int, id *yamlNode) {
+	at := position{file, line}
+	if prev, ok := l.defined[kind+" "+name]; ok {
+		l.reportf(at, "%s %s is already defined at %s", kind, name, prev)
+		return
+	}
+	l.defined[kind+" "+name] = at
+	if id == nil || id.kind != "string" || id.value == "" {
+		return
+	}
+	what := kind + " " + name
+	if prev, ok := l.ids[id.value]; ok {
+		l.reportf(position{file, id.line}, "ID %s of %s is already taken by %s at %s", id.value, what, prev.what, prev.at)
+		return
+	}
+	l.ids[id.value] = idOwner{position{file, id.line}, what}
+}
+
+// lintDocument checks that a policy document is a JSON object.  Syntax
+// errors in documents written as block scalars are located to their line.
+func (l *fixtureLinter) lintDocument(file, path string, n *yamlNode) {
+	if n == nil || n.kind != "string" || n.value == "" {
+		return
+	}
+	var doc interface{}
+	err := json.Unmarshal([]byte(n.value), &doc)
+	if err != nil {
+		line := n.line
+		if serr, ok := err.(*json.SyntaxError); ok && n.block {
+			offset := int(serr.Offset)
+			if offset > len(n.value) {
+				offset = len(n.value)
+			}
+			// the content of a block scalar starts on the line after the
+			// indicator
+			line += 1 + strings.Count(strings.TrimRight(n.value[:offset], "\n"), "\n")
+		}
+		l.reportf(position{file, line}, "%s is not valid JSON: %v", path, err)
+		return
+	}
+	if _, ok := doc.(map[string]interface{}); !ok {
+		l.reportf(position{file, n.line}, "%s must be a JSON object", path)
+	}
+}
+
+// validateMain is the validate subcommand, which checks fixtures without
+// starting the emulator and exits with a non-zero status if they have any
+// problem, so that it can run in pre-commit hooks and CI.
+func validateMain(args []string) int {
+	// only warnings, such as those of importers skipping resources, are of
+	// interest
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	fs := flag.NewFlagSet(progname+" validate", flag.ExitOnError)
+	var printSchema bool
+	fs.BoolVar(&printSchema, "schema", false, "print the JSON Schema of the fixture format and exit")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s validate [-schema] FIXTURE...\n", progname)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if printSchema {
+		os.Stdout.Write(fixtureSchemaJSON)
+		return 0
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		cmdlineErr("specify a path to the YAML file(s)")
+		return 255
+	}
+	y, files, err := loadFixtures(fs.Args())
+	l := newFixtureLinter()
+	for _, f := range files {
+		switch strings.ToLower(filepath.Ext(f)) {
+		case ".yml", ".yaml":
+			l.lintFile(f)
+		}
+	}
+	// the loader stops at the first problem, which the checks above have
+	// most likely reported already; what is left for it are the references
+	// between entities and whatever the other formats hold
+	if len(l.diagnostics) == 0 {
+		if err == nil {
+			_, err = buildRegistry(y)
+		}
+		if err != nil {
+			l.diagnostics = append(l.diagnostics, diagnostic{0, err.Error()})
+		}
+	}
+	for _, d := range l.diagnostics {
+		fmt.Println(d.message)
+	}
+	if len(l.diagnostics) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// runValidate runs the validate subcommand on files written to a temporary
+// directory and returns its exit status and output, with the directory
+// stripped off the file names.
+func runValidate(t *testing.T, files map[string]string, args ...string) (int, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		writeTestFile(t, filepath.Join(dir, name), content)
+	}
+	for i, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			args[i] = filepath.Join(dir, a)
+		}
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, savedLogger := os.Stdout, logger
+	os.Stdout = w
+	defer func() {
+		os.Stdout, logger = stdout, savedLogger
+	}()
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	status := validateMain(args)
+	w.Close()
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	return status, strings.ReplaceAll(<-out, dir+string(filepath.Separator), "")
+}
+
+func TestValidate(t *testing.T) {
+	for _, c := range []struct {
+		what   string
+		files  map[string]string
+		status int
+		want   string
+	}{
+		{
+			"a valid fixture",
+			map[string]string{"users.yml": "users:\n  - name: alice\n"},
+			0, "",
+		},
+		{
+			"schema violations, in the order of their lines",
+			map[string]string{"users.yml": "users:\n  - name: bob smith\n  - nmae: carol\n    name: carol\n"},
+			1, "users.yml:2: users[0].name: \"bob smith\" does not match ^[\\w+=,.@-]+$\n" +
+				"users.yml:3: unknown field users[1].nmae\n",
+		},
+		{
+			"a policy document in a block scalar, located to its line",
+			map[string]string{"users.yml": "users:\n  - name: alice\n    inline_policies:\n      s3: |\n        {\n          \"Version\": \"2012-10-17\"\n          \"Statement\": []\n        }\n"},
+			1, "users.yml:7: users[0].inline_policies.s3 is not valid JSON: invalid character '\"' after object key:value pair\n",
+		},
+		{
+			"a policy document that is not an object",
+			map[string]string{"roles.yml": "roles:\n  - name: web\n    assume_role_policy_document: '[]'\n"},
+			1, "roles.yml:3: roles[0].assume_role_policy_document must be a JSON object\n",
+		},
+		{
+			"entities defined twice across files",
+			map[string]string{
+				"a.yml": "users:\n  - name: alice\n",
+				"b.yml": "users:\n  - name: alice\n",
+			},
+			1, "b.yml:2: user alice is already defined at a.yml:2\n",
+		},
+		{
+			"an ID taken twice",
+			map[string]string{"a.yml": "users:\n  - name: alice\n    id: AIDA1\n  - name: bob\n    id: AIDA1\n"},
+			1, "a.yml:5: ID AIDA1 of user bob is already taken by user alice at a.yml:3\n",
+		},
+		{
+			"an instance profile declared by a role clashing with one defined on its own",
+			map[string]string{"a.yml": "roles:\n  - name: web\n    instance_profile: {}\ninstance_profiles:\n  - name: web\n"},
+			1, "a.yml:5: instance profile web is already defined at a.yml:3\n",
+		},
+		{
+			"more than one document",
+			map[string]string{"a.yml": "users:\n  - name: alice\n---\nusers:\n  - name: bob\n"},
+			1, "a.yml:4: only the first document of a file is read\n",
+		},
+		{
+			"a reference to an undefined entity, which only the loader finds",
+			map[string]string{"a.yml": "groups:\n  - name: devs\n    members: [mallory]\n"},
+			1, "a.yml:2: unknown user mallory among the members of devs\n",
+		},
+		{
+			"an unset environment variable",
+			map[string]string{"a.yml": "users:\n  - name: ${EMU_UNSET}\n"},
+			1, "a.yml:2: environment variable EMU_UNSET is not set\n",
+		},
+	} {
+		var args []string
+		for name := range c.files {
+			args = append(args, name)
+		}
+		sort.Strings(args)
+		status, out := runValidate(t, c.files, args...)
+		if status != c.status || out != c.want {
+			t.Errorf("%s: got %d:\n%s\nwant %d:\n%s", c.what, status, out, c.status, c.want)
+		}
+	}
+}
+
+func TestValidateDirectory(t *testing.T) {
+	status, out := runValidate(t, map[string]string{
+		"a.yml":     "users:\n  - name: alice\n",
+		"b.yaml":    "groups:\n  - name: devs\n    members: [alice]\n",
+		"notes.txt": "not a fixture",
+	}, ".")
+	if status != 0 || out != "" {
+		t.Errorf("got %d:\n%s", status, out)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	status, out := runValidate(t, nil, "-schema")
+	if status != 0 || out != string(fixtureSchemaJSON) {
+		t.Errorf("got %d:\n%s", status, out)
+	}
+}
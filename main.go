@@ -23,12 +23,12 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/mattn/go-isatty"
 )
@@ -36,7 +36,12 @@ import (
 var rootCtx = context.Background()
 var logger *slog.Logger
 
-func listenAndServe(ctx context.Context, addr string) error {
+type listener struct {
+	addr    string
+	handler http.Handler
+}
+
+func listenAndServe(ctx context.Context, addr string, handler http.Handler) error {
 	l, err := (&net.ListenConfig{}).Listen(ctx, "tcp", addr)
 	if err != nil {
 		return err
@@ -44,15 +49,22 @@ func listenAndServe(ctx context.Context, addr string) error {
 
 	server := &http.Server{
 		Addr:    addr,
-		Handler: http.HandlerFunc(iamService.Handle),
+		Handler: handler,
 	}
 	return server.Serve(l)
 }
 
-func start(addr string) error {
+func start(listeners []listener) error {
 	ctx, cancel := context.WithCancel(rootCtx)
 	defer cancel()
-	return listenAndServe(ctx, "127.0.0.1:9000")
+	errCh := make(chan error, len(listeners))
+	for _, l := range listeners {
+		logger.Info("listening", slog.String("addr", l.addr))
+		go func(l listener) {
+			errCh <- listenAndServe(ctx, l.addr, l.handler)
+		}(l)
+	}
+	return <-errCh
 }
 
 var progname = filepath.Base(os.Args[0])
@@ -71,28 +83,123 @@ func initializerLogger() {
 
 func main() {
 	initializerLogger()
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(exportMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validateMain(os.Args[2:]))
+	}
+	os.Exit(serveMain())
+}
+
+// serveMain runs the server until it fails.  The store is closed on the way
+// out, which os.Exit would skip, so the exit code is returned instead.
+func serveMain() int {
 	var addr string
+	var imdsAddr string
+	var imdsInstanceProfile string
+	var imdsRequireToken bool
+	var containerCredentialsAddr string
+	var containerCredentialsToken string
+	containerCredentialsRoles := roleMappingFlag{}
+	var credentialsLifetime time.Duration
+	var storeSpec string
+	var watch bool
+	var watchInterval time.Duration
 	flag.StringVar(&addr, "bind", "127.0.0.1:9000", "bind to `ADDRESS`")
+	flag.StringVar(&imdsAddr, "imds-bind", "", "serve the EC2 instance metadata credential endpoints on `ADDRESS`")
+	flag.StringVar(&imdsInstanceProfile, "imds-instance-profile", "", "vend credentials for the role of instance profile `NAME` through IMDS")
+	flag.BoolVar(&imdsRequireToken, "imds-require-token", false, "reject IMDSv1 requests that carry no session token")
+	flag.StringVar(&containerCredentialsAddr, "container-credentials-bind", "", "serve the ECS/EKS container credentials endpoint on `ADDRESS`")
+	flag.StringVar(&containerCredentialsToken, "container-credentials-token", os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"), "require `TOKEN` in the Authorization header of container credentials requests")
+	flag.Var(containerCredentialsRoles, "container-credentials-role", "vend credentials for `PATH=ROLE` on the container credentials endpoint (repeatable)")
+	flag.Int64Var(&defaultMaxItems, "default-max-items", defaultMaxItems, "page size of List operations called without MaxItems")
+	flag.StringVar(&idSeed, "seed", "", "derive the IDs of entities that have none in the fixture from `SEED`, making them reproducible")
+	flag.DurationVar(&credentialsLifetime, "credentials-lifetime", defaultCredentialsLifetime, "lifetime of the vended temporary credentials")
+	flag.StringVar(&storeSpec, "store", "memory", "keep the entities in `STORE`: memory, or bolt:PATH for a database file that survives restarts")
+	flag.BoolVar(&watch, "watch", false, "reload the fixture whenever it changes on disk")
+	flag.DurationVar(&watchInterval, "watch-interval", time.Second, "check the fixture for changes every `DURATION`")
 	flag.Parse()
-	if len(flag.Args()) < 1 {
-		flag.PrintDefaults()
-		cmdlineErr("specify a path to the YAML file")
-		os.Exit(255)
+	if defaultMaxItems < 1 || defaultMaxItems > maxMaxItems {
+		cmdlineErr(fmt.Sprintf("-default-max-items must be between 1 and %d", maxMaxItems))
+		return 255
 	}
-	b, err := ioutil.ReadFile(flag.Args()[0])
+	store, err := openStore(storeSpec)
 	if err != nil {
 		cmdlineErr(err.Error())
-		os.Exit(1)
+		return 1
 	}
-	reg, err := buildRegistryFromYAML(b)
+	defer store.Close()
+	var y *fixture
+	if len(flag.Args()) >= 1 {
+		y, _, err = loadFixtures(flag.Args())
+		if err != nil {
+			cmdlineErr(err.Error())
+			return 1
+		}
+	} else if _, ok := store.(*MemoryStore); ok {
+		// a store that survives restarts may have been populated already
+		flag.PrintDefaults()
+		cmdlineErr("specify a path to the YAML file(s)")
+		return 255
+	}
+	if watch {
+		// a persistent store outlives the fixture, which only seeds it
+		if _, ok := store.(*MemoryStore); !ok {
+			cmdlineErr("-watch requires the memory store")
+			return 255
+		}
+		if watchInterval <= 0 {
+			cmdlineErr("-watch-interval must be positive")
+			return 255
+		}
+	}
+	reg, err := openRegistry(store, y)
 	if err != nil {
 		cmdlineErr(err.Error())
-		os.Exit(1)
+		return 1
+	}
+	if watch {
+		go newFixtureWatcher(reg, flag.Args(), watchInterval).Run(rootCtx)
 	}
 	registerAPISet(reg)
-	err = start(addr)
+	iamService.Requests = NewRequestLog(defaultRequestLogSize)
+	admin, err := NewAdminServer(reg, flag.Args(), iamService.Requests)
+	if err != nil {
+		cmdlineErr(err.Error())
+		return 1
+	}
+	listeners := []listener{{addr: addr, handler: newServiceHandler(admin)}}
+	issuer := NewCredentialsIssuer(credentialsLifetime)
+	if imdsAddr != "" {
+		if imdsInstanceProfile == "" {
+			cmdlineErr("-imds-bind requires -imds-instance-profile")
+			return 255
+		}
+		if _, ok, _ := reg.GetInstanceProfileByName(imdsInstanceProfile); !ok {
+			logger.Warn("instance profile does not exist (yet)", slog.String("instanceProfile", imdsInstanceProfile))
+		}
+		imds := &IMDSServer{
+			Registry:            reg,
+			Issuer:              issuer,
+			InstanceProfileName: imdsInstanceProfile,
+			RequireToken:        imdsRequireToken,
+		}
+		listeners = append(listeners, listener{addr: imdsAddr, handler: http.HandlerFunc(imds.Handle)})
+	}
+	if containerCredentialsAddr != "" {
+		ccs := &ContainerCredentialsServer{
+			Registry:           reg,
+			Issuer:             issuer,
+			AuthorizationToken: containerCredentialsToken,
+			Mappings:           containerCredentialsRoles,
+		}
+		listeners = append(listeners, listener{addr: containerCredentialsAddr, handler: http.HandlerFunc(ccs.Handle)})
+	}
+	err = start(listeners)
 	if err != nil {
 		cmdlineErr(err.Error())
-		os.Exit(1)
+		return 1
 	}
+	return 0
 }
@@ -0,0 +1,77 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	os.Exit(m.Run())
+}
+
+// newTestRegistry builds a registry out of a fixture given as YAML.
+func newTestRegistry(t *testing.T, fixtureYAML string) *BasicIAMRegistry {
+	t.Helper()
+	y, err := parseFixture([]byte(fixtureYAML))
+	if err != nil {
+		t.Fatalf("parseFixture: %v", err)
+	}
+	reg, err := buildRegistry(y)
+	if err != nil {
+		t.Fatalf("buildRegistry: %v", err)
+	}
+	return reg
+}
+
+// newTestService returns the IAM service serving the registry of the
+// fixture.  The global iamService is swapped for it for the duration of the
+// test.
+func newTestService(t *testing.T, fixtureYAML string) (*Service, *BasicIAMRegistry) {
+	t.Helper()
+	reg := newTestRegistry(t, fixtureYAML)
+	saved := iamService
+	iamService = &Service{Name: "iam"}
+	t.Cleanup(func() { iamService = saved })
+	registerAPISet(reg)
+	return iamService, reg
+}
+
+// query calls the operation named by the Action parameter over the query
+// protocol.
+func query(t *testing.T, svc *Service, params url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	if params.Get("Version") == "" {
+		params.Set("Version", "2010-05-08")
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(params.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	svc.Handle(w, req)
+	return w
+}
@@ -20,46 +20,309 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/xml"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/private/protocol/xml/xmlutil"
 )
 
-func marshal(e *xml.Encoder, op *aws.Operation, requestId, ns string, result interface{}) error {
-	var err error
-	outerEnvelope := xml.StartElement{Name: xml.Name{Space: ns, Local: op.Name + "Response"}}
-	err = e.EncodeToken(outerEnvelope)
-	if err != nil {
-		return err
-	}
-	innerEnvelope := xml.StartElement{Name: xml.Name{Local: op.Name + "Result"}}
-	err = e.EncodeToken(innerEnvelope)
-	if err != nil {
-		return err
-	}
-	err = xmlutil.BuildXML(result, e)
-	if err != nil {
-		return err
-	}
-	err = e.EncodeToken(innerEnvelope.End())
-	if err != nil {
-		return err
-	}
-	{
-		requestMetadataElem := xml.StartElement{Name: xml.Name{Local: "RequestMetadata"}}
-		err = e.EncodeToken(requestMetadataElem)
+const iso8601TimeFormat = "2006-01-02T15:04:05Z"
+
+// xmlWriter writes XML the way IAM and STS do, which encoding/xml does not:
+// quotes are left as they are in text, and elements without content are
+// self-closing.  Elements are indented by two spaces, those holding text
+// staying on one line.
+type xmlWriter struct {
+	b     *bytes.Buffer
+	depth int
+	// open is whether the start tag written last still awaits its ">"
+	open bool
+}
+
+func newXMLWriter(b *bytes.Buffer) *xmlWriter {
+	return &xmlWriter{b: b}
+}
+
+var xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+var xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "\"", "&quot;")
+
+// escapeText escapes s for the content of an element, replacing the
+// characters XML cannot carry as encoding/xml does.
+func escapeText(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || r >= 0x20 && r <= 0xd7ff || r >= 0xe000 && r <= 0xfffd || r >= 0x10000 && r <= 0x10ffff {
+			return r
+		}
+		return '\ufffd'
+	}, s)
+	return xmlTextEscaper.Replace(s)
+}
+
+func (w *xmlWriter) writeStart(name string, attrs []xml.Attr) {
+	if w.open {
+		w.b.WriteByte('>')
+	}
+	if w.b.Len() > 0 {
+		w.b.WriteByte('\n')
+	}
+	for i := 0; i < w.depth; i++ {
+		w.b.WriteString("  ")
+	}
+	w.b.WriteByte('<')
+	w.b.WriteString(name)
+	for _, a := range attrs {
+		fmt.Fprintf(w.b, ` %s="%s"`, a.Name.Local, xmlAttrEscaper.Replace(a.Value))
+	}
+}
+
+// start opens an element that holds other elements.
+func (w *xmlWriter) start(name string, attrs ...xml.Attr) {
+	w.writeStart(name, attrs)
+	w.open = true
+	w.depth++
+}
+
+// end closes the element start opened last.
+func (w *xmlWriter) end(name string) {
+	w.depth--
+	if w.open {
+		w.b.WriteString("/>")
+		w.open = false
+		return
+	}
+	w.b.WriteByte('\n')
+	for i := 0; i < w.depth; i++ {
+		w.b.WriteString("  ")
+	}
+	fmt.Fprintf(w.b, "</%s>", name)
+}
+
+// element writes an element that holds text.
+func (w *xmlWriter) element(name, text string) {
+	w.writeStart(name, nil)
+	w.open = false
+	if text == "" {
+		w.b.WriteString("/>")
+		return
+	}
+	fmt.Fprintf(w.b, ">%s</%s>", escapeText(text), name)
+}
+
+// marshal writes the response envelope of the query protocol:
+//
+//	<OpResponse xmlns="...">
+//	  <OpResult>...</OpResult>
+//	  <ResponseMetadata>
+//	    <RequestId>...</RequestId>
+//	  </ResponseMetadata>
+//	</OpResponse>
+//
+// The Result element is left out for operations without output members,
+// as the services do.  Members are written in the order the IAM API model
+// declares them, see shapeMembers.
+func marshal(w *xmlWriter, op *aws.Operation, requestId, ns string, result interface{}) error {
+	w.start(op.Name+"Response", xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: ns})
+	v := reflect.Indirect(reflect.ValueOf(result))
+	if v.IsValid() && hasMembers(v) {
+		err := encodeValue(w, v, op.Name+"Result", "")
 		if err != nil {
 			return err
 		}
-		err = e.EncodeElement(requestId, xml.StartElement{Name: xml.Name{Local: "RequestId"}})
+	}
+	w.start("ResponseMetadata")
+	w.element("RequestId", requestId)
+	w.end("ResponseMetadata")
+	w.end(op.Name + "Response")
+	return nil
+}
+
+type shapeMember struct {
+	name  string
+	field string
+	value reflect.Value
+	tag   reflect.StructTag
+}
+
+//go:generate go run memberorder_gen.go
+
+// shapeMembers enumerates the members of a shape that go into the body,
+// skipping the ones that are not set.  The SDK declares the fields of its
+// shapes in alphabetical order, so they come in the order memberOrder gives
+// for the shape instead, and the members it does not list after those in
+// the order of the fields.
+func shapeMembers(v reflect.Value) []shapeMember {
+	t := v.Type()
+	var members []shapeMember
+	for i := 0; i < v.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // ignore unexported fields
+		}
+		if field.Tag.Get("ignore") != "" || field.Tag.Get("location") != "" {
+			continue
+		}
+		fv := v.Field(i)
+		switch fv.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			if fv.IsNil() {
+				continue
+			}
+		}
+		name := field.Tag.Get("locationName")
+		if name == "" {
+			name = field.Name
+		}
+		members = append(members, shapeMember{name: name, field: field.Name, value: fv, tag: field.Tag})
+	}
+	if order, ok := memberOrder[t.Name()]; ok {
+		rank := func(field string) int {
+			for i, f := range order {
+				if f == field {
+					return i
+				}
+			}
+			return len(order)
+		}
+		sort.SliceStable(members, func(i, j int) bool {
+			return rank(members[i].field) < rank(members[j].field)
+		})
+	}
+	return members
+}
+
+func hasMembers(v reflect.Value) bool {
+	return v.Kind() == reflect.Struct && len(shapeMembers(v)) > 0
+}
+
+func encodeValue(w *xmlWriter, v reflect.Value, name string, tag reflect.StructTag) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			w.element(name, formatTimestamp(t, tag.Get("timestampFormat")))
+			return nil
+		}
+		w.start(name)
+		for _, m := range shapeMembers(v) {
+			err := encodeValue(w, m.value, m.name, m.tag)
+			if err != nil {
+				return err
+			}
+		}
+		w.end(name)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			w.element(name, base64.StdEncoding.EncodeToString(v.Bytes()))
+			return nil
+		}
+		memberName := tag.Get("locationNameList")
+		if memberName == "" {
+			memberName = "member"
+		}
+		if tag.Get("flattened") != "" {
+			for i := 0; i < v.Len(); i++ {
+				err := encodeValue(w, v.Index(i), name, "")
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		w.start(name)
+		for i := 0; i < v.Len(); i++ {
+			err := encodeValue(w, v.Index(i), memberName, "")
+			if err != nil {
+				return err
+			}
+		}
+		w.end(name)
+		return nil
+	case reflect.Map:
+		return encodeMap(w, v, name, tag)
+	default:
+		s, err := formatScalar(v)
 		if err != nil {
-			return err
+			return fmt.Errorf("%s: %w", name, err)
 		}
-		err = e.EncodeToken(requestMetadataElem.End())
+		w.element(name, s)
+		return nil
+	}
+}
+
+func encodeMap(w *xmlWriter, v reflect.Value, name string, tag reflect.StructTag) error {
+	kname, vname := "key", "value"
+	if n := tag.Get("locationNameKey"); n != "" {
+		kname = n
+	}
+	if n := tag.Get("locationNameValue"); n != "" {
+		vname = n
+	}
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	flattened := tag.Get("flattened") != ""
+	entry := "entry"
+	if flattened {
+		entry = name
+	} else {
+		w.start(name)
+	}
+	for _, k := range keys {
+		w.start(entry)
+		w.element(kname, k.String())
+		err := encodeValue(w, v.MapIndex(k), vname, "")
 		if err != nil {
 			return err
 		}
+		w.end(entry)
+	}
+	if !flattened {
+		w.end(name)
+	}
+	return nil
+}
+
+func formatTimestamp(t time.Time, format string) string {
+	switch format {
+	case "unixTimestamp":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "rfc822":
+		return t.UTC().Format(http.TimeFormat)
+	default:
+		return t.UTC().Format(iso8601TimeFormat)
+	}
+}
+
+func formatScalar(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
 	}
-	return e.EncodeToken(outerEnvelope.End())
+	return "", fmt.Errorf("unsupported type %s", v.Type())
 }
@@ -0,0 +1,97 @@
+// Code generated by memberorder_gen.go from models/apis/iam/2010-05-08/api-2.json; DO NOT EDIT.
+
+package main
+
+// memberOrder lists the members of the IAM output shapes with more than one
+// member in the order the API model declares them, by the name of the type
+// the SDK generates for the shape.
+var memberOrder = map[string][]string{
+	"AccessDetail":                                    {"ServiceName", "ServiceNamespace", "Region", "EntityPath", "LastAuthenticatedTime", "TotalAuthenticatedEntities"},
+	"AccessKey":                                       {"UserName", "AccessKeyId", "Status", "SecretAccessKey", "CreateDate"},
+	"AccessKeyLastUsed":                               {"LastUsedDate", "ServiceName", "Region"},
+	"AccessKeyMetadata":                               {"UserName", "AccessKeyId", "Status", "CreateDate"},
+	"AttachedPermissionsBoundary":                     {"PermissionsBoundaryType", "PermissionsBoundaryArn"},
+	"AttachedPolicy":                                  {"PolicyName", "PolicyArn"},
+	"DeletionTaskFailureReasonType":                   {"Reason", "RoleUsageList"},
+	"EntityDetails":                                   {"EntityInfo", "LastAuthenticated"},
+	"EntityInfo":                                      {"Arn", "Name", "Type", "Id", "Path"},
+	"ErrorDetails":                                    {"Message", "Code"},
+	"EvaluationResult":                                {"EvalActionName", "EvalResourceName", "EvalDecision", "MatchedStatements", "MissingContextValues", "OrganizationsDecisionDetail", "PermissionsBoundaryDecisionDetail", "EvalDecisionDetails", "ResourceSpecificResults"},
+	"GenerateCredentialReportOutput":                  {"State", "Description"},
+	"GetAccessKeyLastUsedOutput":                      {"UserName", "AccessKeyLastUsed"},
+	"GetAccountAuthorizationDetailsOutput":            {"UserDetailList", "GroupDetailList", "RoleDetailList", "Policies", "IsTruncated", "Marker"},
+	"GetCredentialReportOutput":                       {"Content", "ReportFormat", "GeneratedTime"},
+	"GetGroupOutput":                                  {"Group", "Users", "IsTruncated", "Marker"},
+	"GetGroupPolicyOutput":                            {"GroupName", "PolicyName", "PolicyDocument"},
+	"GetOpenIDConnectProviderOutput":                  {"Url", "ClientIDList", "ThumbprintList", "CreateDate"},
+	"GetOrganizationsAccessReportOutput":              {"JobStatus", "JobCreationDate", "JobCompletionDate", "NumberOfServicesAccessible", "NumberOfServicesNotAccessed", "AccessDetails", "IsTruncated", "Marker", "ErrorDetails"},
+	"GetRolePolicyOutput":                             {"RoleName", "PolicyName", "PolicyDocument"},
+	"GetSAMLProviderOutput":                           {"SAMLMetadataDocument", "CreateDate", "ValidUntil"},
+	"GetServiceLastAccessedDetailsOutput":             {"JobStatus", "JobType", "JobCreationDate", "ServicesLastAccessed", "JobCompletionDate", "IsTruncated", "Marker", "Error"},
+	"GetServiceLastAccessedDetailsWithEntitiesOutput": {"JobStatus", "JobCreationDate", "JobCompletionDate", "EntityDetailsList", "IsTruncated", "Marker", "Error"},
+	"GetServiceLinkedRoleDeletionStatusOutput":        {"Status", "Reason"},
+	"GetUserPolicyOutput":                             {"UserName", "PolicyName", "PolicyDocument"},
+	"Group":                                           {"Path", "GroupName", "GroupId", "Arn", "CreateDate"},
+	"GroupDetail":                                     {"Path", "GroupName", "GroupId", "Arn", "CreateDate", "GroupPolicyList", "AttachedManagedPolicies"},
+	"InstanceProfile":                                 {"Path", "InstanceProfileName", "InstanceProfileId", "Arn", "CreateDate", "Roles"},
+	"ListAccessKeysOutput":                            {"AccessKeyMetadata", "IsTruncated", "Marker"},
+	"ListAccountAliasesOutput":                        {"AccountAliases", "IsTruncated", "Marker"},
+	"ListAttachedGroupPoliciesOutput":                 {"AttachedPolicies", "IsTruncated", "Marker"},
+	"ListAttachedRolePoliciesOutput":                  {"AttachedPolicies", "IsTruncated", "Marker"},
+	"ListAttachedUserPoliciesOutput":                  {"AttachedPolicies", "IsTruncated", "Marker"},
+	"ListEntitiesForPolicyOutput":                     {"PolicyGroups", "PolicyUsers", "PolicyRoles", "IsTruncated", "Marker"},
+	"ListGroupPoliciesOutput":                         {"PolicyNames", "IsTruncated", "Marker"},
+	"ListGroupsForUserOutput":                         {"Groups", "IsTruncated", "Marker"},
+	"ListGroupsOutput":                                {"Groups", "IsTruncated", "Marker"},
+	"ListInstanceProfilesForRoleOutput":               {"InstanceProfiles", "IsTruncated", "Marker"},
+	"ListInstanceProfilesOutput":                      {"InstanceProfiles", "IsTruncated", "Marker"},
+	"ListMFADevicesOutput":                            {"MFADevices", "IsTruncated", "Marker"},
+	"ListPoliciesGrantingServiceAccessEntry":          {"ServiceNamespace", "Policies"},
+	"ListPoliciesGrantingServiceAccessOutput":         {"PoliciesGrantingServiceAccess", "IsTruncated", "Marker"},
+	"ListPoliciesOutput":                              {"Policies", "IsTruncated", "Marker"},
+	"ListPolicyVersionsOutput":                        {"Versions", "IsTruncated", "Marker"},
+	"ListRolePoliciesOutput":                          {"PolicyNames", "IsTruncated", "Marker"},
+	"ListRoleTagsOutput":                              {"Tags", "IsTruncated", "Marker"},
+	"ListRolesOutput":                                 {"Roles", "IsTruncated", "Marker"},
+	"ListSSHPublicKeysOutput":                         {"SSHPublicKeys", "IsTruncated", "Marker"},
+	"ListServerCertificatesOutput":                    {"ServerCertificateMetadataList", "IsTruncated", "Marker"},
+	"ListSigningCertificatesOutput":                   {"Certificates", "IsTruncated", "Marker"},
+	"ListUserPoliciesOutput":                          {"PolicyNames", "IsTruncated", "Marker"},
+	"ListUserTagsOutput":                              {"Tags", "IsTruncated", "Marker"},
+	"ListUsersOutput":                                 {"Users", "IsTruncated", "Marker"},
+	"ListVirtualMFADevicesOutput":                     {"VirtualMFADevices", "IsTruncated", "Marker"},
+	"LoginProfile":                                    {"UserName", "CreateDate", "PasswordResetRequired"},
+	"MFADevice":                                       {"UserName", "SerialNumber", "EnableDate"},
+	"ManagedPolicyDetail":                             {"PolicyName", "PolicyId", "Arn", "Path", "DefaultVersionId", "AttachmentCount", "PermissionsBoundaryUsageCount", "IsAttachable", "Description", "CreateDate", "UpdateDate", "PolicyVersionList"},
+	"PasswordPolicy":                                  {"MinimumPasswordLength", "RequireSymbols", "RequireNumbers", "RequireUppercaseCharacters", "RequireLowercaseCharacters", "AllowUsersToChangePassword", "ExpirePasswords", "MaxPasswordAge", "PasswordReusePrevention", "HardExpiry"},
+	"Policy":                                          {"PolicyName", "PolicyId", "Arn", "Path", "DefaultVersionId", "AttachmentCount", "PermissionsBoundaryUsageCount", "IsAttachable", "Description", "CreateDate", "UpdateDate"},
+	"PolicyDetail":                                    {"PolicyName", "PolicyDocument"},
+	"PolicyGrantingServiceAccess":                     {"PolicyName", "PolicyType", "PolicyArn", "EntityType", "EntityName"},
+	"PolicyGroup":                                     {"GroupName", "GroupId"},
+	"PolicyRole":                                      {"RoleName", "RoleId"},
+	"PolicyUser":                                      {"UserName", "UserId"},
+	"PolicyVersion":                                   {"Document", "VersionId", "IsDefaultVersion", "CreateDate"},
+	"Position":                                        {"Line", "Column"},
+	"ResourceSpecificResult":                          {"EvalResourceName", "EvalResourceDecision", "MatchedStatements", "MissingContextValues", "EvalDecisionDetails", "PermissionsBoundaryDecisionDetail"},
+	"Role":                                            {"Path", "RoleName", "RoleId", "Arn", "CreateDate", "AssumeRolePolicyDocument", "Description", "MaxSessionDuration", "PermissionsBoundary", "Tags", "RoleLastUsed"},
+	"RoleDetail":                                      {"Path", "RoleName", "RoleId", "Arn", "CreateDate", "AssumeRolePolicyDocument", "InstanceProfileList", "RolePolicyList", "AttachedManagedPolicies", "PermissionsBoundary", "Tags", "RoleLastUsed"},
+	"RoleLastUsed":                                    {"LastUsedDate", "Region"},
+	"RoleUsageType":                                   {"Region", "Resources"},
+	"SAMLProviderListEntry":                           {"Arn", "ValidUntil", "CreateDate"},
+	"SSHPublicKey":                                    {"UserName", "SSHPublicKeyId", "Fingerprint", "SSHPublicKeyBody", "Status", "UploadDate"},
+	"SSHPublicKeyMetadata":                            {"UserName", "SSHPublicKeyId", "Status", "UploadDate"},
+	"ServerCertificate":                               {"ServerCertificateMetadata", "CertificateBody", "CertificateChain"},
+	"ServerCertificateMetadata":                       {"Path", "ServerCertificateName", "ServerCertificateId", "Arn", "UploadDate", "Expiration"},
+	"ServiceLastAccessed":                             {"ServiceName", "LastAuthenticated", "ServiceNamespace", "LastAuthenticatedEntity", "LastAuthenticatedRegion", "TotalAuthenticatedEntities", "TrackedActionsLastAccessed"},
+	"ServiceSpecificCredential":                       {"CreateDate", "ServiceName", "ServiceUserName", "ServicePassword", "ServiceSpecificCredentialId", "UserName", "Status"},
+	"ServiceSpecificCredentialMetadata":               {"UserName", "Status", "ServiceUserName", "CreateDate", "ServiceSpecificCredentialId", "ServiceName"},
+	"SigningCertificate":                              {"UserName", "CertificateId", "CertificateBody", "Status", "UploadDate"},
+	"SimulateCustomPolicyOutput":                      {"EvaluationResults", "IsTruncated", "Marker"},
+	"SimulatePrincipalPolicyOutput":                   {"EvaluationResults", "IsTruncated", "Marker"},
+	"Statement":                                       {"SourcePolicyId", "SourcePolicyType", "StartPosition", "EndPosition"},
+	"Tag":                                             {"Key", "Value"},
+	"TrackedActionLastAccessed":                       {"ActionName", "LastAccessedEntity", "LastAccessedTime", "LastAccessedRegion"},
+	"User":                                            {"Path", "UserName", "UserId", "Arn", "CreateDate", "PasswordLastUsed", "PermissionsBoundary", "Tags"},
+	"UserDetail":                                      {"Path", "UserName", "UserId", "Arn", "CreateDate", "UserPolicyList", "GroupList", "AttachedManagedPolicies", "PermissionsBoundary", "Tags"},
+	"VirtualMFADevice":                                {"SerialNumber", "Base32StringSeed", "QRCodePNG", "User", "EnableDate"},
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+
+//go:build ignore
+
+// This program writes memberorder.go from the IAM API model the SDK ships:
+//
+//	go generate
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const modelPath = "models/apis/iam/2010-05-08/api-2.json"
+
+type shapeRef struct {
+	Shape string `json:"shape"`
+}
+
+type shape struct {
+	Type    string    `json:"type"`
+	Member  *shapeRef `json:"member"`
+	Value   *shapeRef `json:"value"`
+	Members members   `json:"members"`
+}
+
+// members keeps the names of the members of a structure in the order the
+// model lists them, which a map would lose.
+type members struct {
+	names []string
+	refs  map[string]shapeRef
+}
+
+func (m *members) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &m.refs); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		m.names = append(m.names, t.(string))
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type model struct {
+	Operations map[string]struct {
+		Output *shapeRef `json:"output"`
+	} `json:"operations"`
+	Shapes map[string]shape `json:"shapes"`
+}
+
+func main() {
+	dir, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "github.com/aws/aws-sdk-go-v2").Output()
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(strings.TrimSpace(string(dir)), modelPath))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var m model
+	if err := json.Unmarshal(b, &m); err != nil {
+		log.Fatal(err)
+	}
+
+	// the SDK names the output shape of an operation after the operation,
+	// and the shapes it refers to after themselves
+	orders := map[string][]string{}
+	var walk func(name string)
+	walk = func(name string) {
+		s := m.Shapes[name]
+		switch s.Type {
+		case "list":
+			walk(s.Member.Shape)
+		case "map":
+			walk(s.Value.Shape)
+		case "structure":
+			if _, ok := orders[name]; ok {
+				return
+			}
+			orders[name] = s.Members.names
+			for _, n := range s.Members.names {
+				walk(s.Members.refs[n].Shape)
+			}
+		}
+	}
+	for name, op := range m.Operations {
+		if op.Output == nil {
+			continue
+		}
+		s := m.Shapes[op.Output.Shape]
+		orders[name+"Output"] = s.Members.names
+		for _, n := range s.Members.names {
+			walk(s.Members.refs[n].Shape)
+		}
+	}
+	names := make([]string, 0, len(orders))
+	for name, order := range orders {
+		if len(order) > 1 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "// Code generated by memberorder_gen.go from %s; DO NOT EDIT.\n\n", modelPath)
+	fmt.Fprintf(out, "package main\n\n")
+	fmt.Fprintf(out, "// memberOrder lists the members of the IAM output shapes with more than one\n")
+	fmt.Fprintf(out, "// member in the order the API model declares them, by the name of the type\n")
+	fmt.Fprintf(out, "// the SDK generates for the shape.\n")
+	fmt.Fprintf(out, "var memberOrder = map[string][]string{\n")
+	for _, name := range names {
+		fmt.Fprintf(out, "\t%q: {", name)
+		for i, n := range orders[name] {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			fmt.Fprintf(out, "%q", n)
+		}
+		out.WriteString("},\n")
+	}
+	out.WriteString("}\n")
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("memberorder.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// IAMVirtualMFADevice is a virtual MFA device, which is assigned to the
+// user named by User, if any.  The seed is handed out once on creation
+// and not kept, as the emulator does not check authentication codes.
+type IAMVirtualMFADevice struct {
+	Name      string            `yaml:"name"`
+	Path      string            `yaml:"path"`
+	User      string            `yaml:"user,omitempty"`
+	EnabledAt *time.Time        `yaml:"enabled_at,omitempty"`
+	Tags      map[string]string `yaml:"tags,omitempty"`
+}
+
+func mfaDeviceName(d *IAMVirtualMFADevice) string {
+	return d.Name
+}
+
+// BuildArn returns the serial number of the device, which is its ARN.
+func (d *IAMVirtualMFADevice) BuildArn(accountId string) string {
+	return fmt.Sprintf("arn:aws:iam::%s:mfa%s%s", accountId, d.Path, d.Name)
+}
+
+func noSuchMFADevice(serial string) error {
+	return &SenderFault{
+		Code_:    "NoSuchEntity",
+		Message_: fmt.Sprintf("VirtualMFADevice with serial number %s doesn't exist.", serial),
+	}
+}
+
+// mfaDeviceNameFromSerial returns the name of the device serial designates
+// in the account.  The path is checked against the device by the caller.
+func mfaDeviceNameFromSerial(serial, accountId string) (string, bool) {
+	prefix := fmt.Sprintf("arn:aws:iam::%s:mfa/", accountId)
+	if !strings.HasPrefix(serial, prefix) {
+		return "", false
+	}
+	return serial[strings.LastIndex(serial, "/")+1:], true
+}
+
+func (d *IAMVirtualMFADevice) BuildVirtualMFADevice(accountId string, user *IAMUser) VirtualMFADevice {
+	result := VirtualMFADevice{
+		SerialNumber: aws.String(d.BuildArn(accountId)),
+		EnableDate:   d.EnabledAt,
+	}
+	if user != nil {
+		result.User = &iam.User{
+			Arn:        aws.String(user.BuildArn(accountId)),
+			CreateDate: aws.Time(user.CreatedAt),
+			Path:       aws.String(user.Path),
+			UserId:     aws.String(user.Id),
+			UserName:   aws.String(user.Name),
+		}
+	}
+	if len(d.Tags) > 0 {
+		result.Tags = buildTags(d.Tags)
+	}
+	return result
+}
+
+func registerMFADeviceHandlers(iamAPISet *APISet, reg IAMRegistry) {
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "CreateVirtualMFADevice",
+			Proto: CreateVirtualMFADeviceInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*CreateVirtualMFADeviceInput)
+				err = validateTags(params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				tags, err := mergeTags(nil, params.Tags, "TagsPerMFADevice")
+				if err != nil {
+					return nil, err
+				}
+				d := &IAMVirtualMFADevice{
+					Name: *params.VirtualMFADeviceName,
+					Path: aws.StringValue(params.Path),
+					Tags: tags,
+				}
+				if d.Path == "" {
+					d.Path = "/"
+				}
+				err = reg.CreateVirtualMFADevice(d)
+				if err != nil {
+					return nil, err
+				}
+				seed := make([]byte, 20)
+				if _, err := rand.Read(seed); err != nil {
+					return nil, err
+				}
+				out := d.BuildVirtualMFADevice(accountId, nil)
+				out.Base32StringSeed = []byte(base32.StdEncoding.EncodeToString(seed))
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &CreateVirtualMFADeviceOutput{VirtualMFADevice: &out},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListVirtualMFADevices",
+			Proto: iam.ListVirtualMFADevicesInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*iam.ListVirtualMFADevicesInput)
+				devices, err := reg.GetVirtualMFADevices()
+				if err != nil {
+					return nil, err
+				}
+				if status := params.AssignmentStatus; status != "" && status != iam.AssignmentStatusTypeAny {
+					filtered := make([]*IAMVirtualMFADevice, 0, len(devices))
+					for _, d := range devices {
+						if (d.User != "") == (status == iam.AssignmentStatusTypeAssigned) {
+							filtered = append(filtered, d)
+						}
+					}
+					devices = filtered
+				}
+				devices, marker, err := paginate(devices, mfaDeviceName, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+				out := &ListVirtualMFADevicesOutput{
+					IsTruncated:       aws.Bool(marker != nil),
+					Marker:            marker,
+					VirtualMFADevices: make([]VirtualMFADevice, len(devices)),
+				}
+				for i, d := range devices {
+					var user *IAMUser
+					if d.User != "" {
+						user, _, err = reg.GetUserByName(d.User)
+						if err != nil {
+							return nil, err
+						}
+					}
+					out.VirtualMFADevices[i] = d.BuildVirtualMFADevice(accountId, user)
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "DeleteVirtualMFADevice",
+			Proto: iam.DeleteVirtualMFADeviceInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.DeleteVirtualMFADeviceInput)
+				err := reg.DeleteVirtualMFADevice(*params.SerialNumber)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.DeleteVirtualMFADeviceOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+}
+
+func (reg *BasicIAMRegistry) GetVirtualMFADevices() ([]*IAMVirtualMFADevice, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.mfaDevices.values(), nil
+}
+
+func (reg *BasicIAMRegistry) GetVirtualMFADevice(serial string) (*IAMVirtualMFADevice, bool, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	accountId := reg.accountId()
+	name, ok := mfaDeviceNameFromSerial(serial, accountId)
+	if !ok {
+		return nil, false, nil
+	}
+	d, ok := reg.mfaDevices.get(name)
+	if !ok || d.BuildArn(accountId) != serial {
+		return nil, false, nil
+	}
+	return d, true, nil
+}
+
+// mfaDeviceBySerial is GetVirtualMFADevice within a transaction.
+func (tx *registryTx) mfaDeviceBySerial(serial string) (*IAMVirtualMFADevice, bool) {
+	accountId := tx.reg.accountId()
+	name, ok := mfaDeviceNameFromSerial(serial, accountId)
+	if !ok {
+		return nil, false
+	}
+	d, ok := tx.mfaDevice(name)
+	if !ok || d.BuildArn(accountId) != serial {
+		return nil, false
+	}
+	return d, true
+}
+
+func (reg *BasicIAMRegistry) CreateVirtualMFADevice(d *IAMVirtualMFADevice) error {
+	return reg.update(func(tx *registryTx) error {
+		if _, ok := tx.mfaDevice(d.Name); ok {
+			return &SenderFault{
+				Code_:    "EntityAlreadyExists",
+				Message_: "MFADevice entity at the same path and name already exists.",
+			}
+		}
+		tx.putMFADevice(d)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) DeleteVirtualMFADevice(serial string) error {
+	return reg.update(func(tx *registryTx) error {
+		d, ok := tx.mfaDeviceBySerial(serial)
+		if !ok {
+			return noSuchMFADevice(serial)
+		}
+		if d.User != "" {
+			return &SenderFault{
+				Code_:    "DeleteConflict",
+				Message_: "MFA Device is in use by a user. Deactivate the device first.",
+			}
+		}
+		tx.deleteMFADevice(d.Name)
+		return nil
+	})
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestVirtualMFADeviceOperations(t *testing.T) {
+	svc, reg := newTestService(t, `
+users:
+  - name: alice
+virtual_mfa_devices:
+  - name: alice
+    user: alice
+    tags: {env: test}
+`)
+	assigned := "arn:aws:iam::000000000000:mfa/alice"
+	created := "arn:aws:iam::000000000000:mfa/devices/spare"
+	for _, c := range []struct {
+		params url.Values
+		status int
+		want   string
+	}{
+		{url.Values{"Action": {"ListVirtualMFADevices"}, "AssignmentStatus": {"Assigned"}}, http.StatusOK, "<UserName>alice</UserName>"},
+		{url.Values{"Action": {"CreateVirtualMFADevice"}, "Path": {"/devices/"}, "VirtualMFADeviceName": {"spare"}, "Tags.member.1.Key": {"team"}, "Tags.member.1.Value": {"red"}}, http.StatusOK, "<SerialNumber>" + created + "</SerialNumber>"},
+		{url.Values{"Action": {"CreateVirtualMFADevice"}, "Path": {"/devices/"}, "VirtualMFADeviceName": {"spare"}}, http.StatusConflict, "<Code>EntityAlreadyExists</Code>"},
+		{url.Values{"Action": {"ListVirtualMFADevices"}, "AssignmentStatus": {"Unassigned"}}, http.StatusOK, "<SerialNumber>" + created + "</SerialNumber>"},
+		{url.Values{"Action": {"TagMFADevice"}, "SerialNumber": {created}, "Tags.member.1.Key": {"env"}, "Tags.member.1.Value": {"prod"}}, http.StatusOK, ""},
+		{url.Values{"Action": {"UntagMFADevice"}, "SerialNumber": {created}, "TagKeys.member.1": {"team"}}, http.StatusOK, ""},
+		{url.Values{"Action": {"ListMFADeviceTags"}, "SerialNumber": {assigned}}, http.StatusOK, "<Value>test</Value>"},
+		{url.Values{"Action": {"ListMFADeviceTags"}, "SerialNumber": {assigned + "x"}}, http.StatusNotFound, "<Code>NoSuchEntity</Code>"},
+		{url.Values{"Action": {"DeleteVirtualMFADevice"}, "SerialNumber": {assigned}}, http.StatusConflict, "<Code>DeleteConflict</Code>"},
+		{url.Values{"Action": {"DeleteVirtualMFADevice"}, "SerialNumber": {created}}, http.StatusOK, ""},
+	} {
+		w := query(t, svc, c.params)
+		if w.Code != c.status || !strings.Contains(w.Body.String(), c.want) {
+			t.Errorf("%v: got %d, want %d and %q:\n%s", c.params, w.Code, c.status, c.want, w.Body)
+		}
+	}
+	w := query(t, svc, url.Values{"Action": {"ListVirtualMFADevices"}, "AssignmentStatus": {"Unassigned"}})
+	if strings.Contains(w.Body.String(), "<member>") {
+		t.Errorf("the deleted device is still listed:\n%s", w.Body)
+	}
+	if devices, _ := reg.GetVirtualMFADevices(); len(devices) != 1 {
+		t.Errorf("got %d devices, want 1", len(devices))
+	}
+}
+
+func TestVirtualMFADeviceOfUnknownUser(t *testing.T) {
+	f, err := parseFixture([]byte(`virtual_mfa_devices: [{name: d, user: nobody}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buildRegistry(f); err == nil {
+		t.Error("a device assigned to an unknown user was accepted")
+	}
+}
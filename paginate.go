@@ -0,0 +1,100 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const maxMaxItems = 1000
+
+// defaultMaxItems is the page size of List operations that are called without
+// MaxItems.  It is the same as IAM's unless overridden on the command line.
+var defaultMaxItems int64 = 100
+
+func invalidMarker() error {
+	return &SenderFault{
+		Code_:    "InvalidInput",
+		Message_: "Invalid Marker.",
+	}
+}
+
+func encodeMarker(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeMarker(marker string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(marker)
+	if err != nil || len(key) == 0 {
+		return "", invalidMarker()
+	}
+	return string(key), nil
+}
+
+// paginate cuts a page out of items, which must be sorted by key.  The
+// marker handed out designates the key of the first item of the next page
+// rather than its position, so that pages stay consistent while entities are
+// added or removed between calls.
+func paginate[T any](items []T, key func(T) string, marker *string, maxItems *int64) ([]T, *string, error) {
+	// validateParams has checked MaxItems against its range already
+	n := defaultMaxItems
+	if maxItems != nil {
+		n = *maxItems
+	}
+	start := 0
+	if marker != nil {
+		k, err := decodeMarker(*marker)
+		if err != nil {
+			return nil, nil, err
+		}
+		start = sort.Search(len(items), func(i int) bool {
+			return key(items[i]) >= k
+		})
+	}
+	items = items[start:]
+	if int64(len(items)) <= n {
+		return items, nil, nil
+	}
+	return items[:n], aws.String(encodeMarker(key(items[n]))), nil
+}
+
+// sortByKey sorts items in place in the order paginate expects.
+func sortByKey[T any](items []T, key func(T) string) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return key(items[i]) < key(items[j])
+	})
+}
+
+func filterByPathPrefix[T any](items []T, path func(T) string, prefix *string) []T {
+	if prefix == nil || *prefix == "" {
+		return items
+	}
+	filtered := make([]T, 0, len(items))
+	for _, item := range items {
+		if strings.HasPrefix(path(item), *prefix) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
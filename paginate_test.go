@@ -0,0 +1,84 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestPaginate(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+	key := func(s string) string { return s }
+	var got []string
+	var marker *string
+	for {
+		page, next, err := paginate(items, key, marker, aws.Int64(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > 2 {
+			t.Fatalf("got a page of %d items", len(page))
+		}
+		got = append(got, page...)
+		if next == nil {
+			break
+		}
+		marker = next
+	}
+	if strings.Join(got, "") != "abcde" {
+		t.Errorf("got %v", got)
+	}
+	// the marker points at the item, not at its position
+	_, next, _ := paginate(items, key, nil, aws.Int64(2))
+	page, _, err := paginate(items[1:], key, next, aws.Int64(2))
+	if err != nil || page[0] != "c" {
+		t.Errorf("got %v, %v after removing an item", page, err)
+	}
+	if _, _, err := paginate(items, key, aws.String("!"), nil); err == nil {
+		t.Error("a bad marker was accepted")
+	}
+}
+
+func TestMaxItemsRange(t *testing.T) {
+	svc, _ := newTestService(t, `users: [{name: alice}]`)
+	for _, c := range []struct {
+		maxItems string
+		status   int
+		want     string
+	}{
+		{"0", http.StatusBadRequest, "Value '0' at 'maxItems' failed to satisfy constraint: Member must have value greater than or equal to 1"},
+		{"1001", http.StatusBadRequest, "Value '1001' at 'maxItems' failed to satisfy constraint: Member must have value less than or equal to 1000"},
+		{"1000", http.StatusOK, "<UserName>alice</UserName>"},
+	} {
+		w := query(t, svc, url.Values{"Action": {"ListUsers"}, "MaxItems": {c.maxItems}})
+		body := w.Body.String()
+		if w.Code != c.status || !strings.Contains(body, c.want) {
+			t.Errorf("MaxItems %s: got %d, want %d and %q:\n%s", c.maxItems, w.Code, c.status, c.want, w.Body)
+		}
+		if strings.Count(body, "validation error") > 1 {
+			t.Errorf("MaxItems %s: reported more than once:\n%s", c.maxItems, w.Body)
+		}
+	}
+}
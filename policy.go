@@ -0,0 +1,351 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// awsManagedPolicyArnPrefix begins the ARNs of the policies AWS manages,
+// which are the same in every account.
+const awsManagedPolicyArnPrefix = "arn:aws:iam::aws:policy/"
+
+// maxPolicyVersions is the number of versions a managed policy can have.
+const maxPolicyVersions = 5
+
+// IAMPolicy is a managed policy.  A policy of the account is known by its
+// name, and one AWS manages by its ARN.
+type IAMPolicy struct {
+	Id             string             `yaml:"id"`
+	Name           string             `yaml:"name"`
+	CreatedAt      time.Time          `yaml:"created_at"`
+	UpdatedAt      time.Time          `yaml:"updated_at"`
+	Path           string             `yaml:"path"`
+	Description    string             `yaml:"description,omitempty"`
+	AWSManaged     bool               `yaml:"aws_managed,omitempty"`
+	DefaultVersion string             `yaml:"default_version"`
+	Versions       []IAMPolicyVersion `yaml:"versions"`
+	Tags           map[string]string  `yaml:"tags,omitempty"`
+}
+
+type IAMPolicyVersion struct {
+	Id        string    `yaml:"id"`
+	Document  string    `yaml:"document"`
+	CreatedAt time.Time `yaml:"created_at"`
+}
+
+func (p *IAMPolicy) BuildArn(accountId string) string {
+	if p.AWSManaged {
+		accountId = "aws"
+	}
+	// the path may not have been defaulted yet when a fixture is read
+	path := p.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("arn:aws:iam::%s:policy%s%s", accountId, path, p.Name)
+}
+
+// policyKey is what the registry and the managed_policies of users, groups
+// and roles know p by.
+func policyKey(p *IAMPolicy) string {
+	if p.AWSManaged {
+		return p.BuildArn("")
+	}
+	return p.Name
+}
+
+// defaultDocument returns the document of the default version of p, which
+// is the one that takes effect.
+func (p *IAMPolicy) defaultDocument() string {
+	for _, v := range p.Versions {
+		if v.Id == p.DefaultVersion {
+			return v.Document
+		}
+	}
+	return ""
+}
+
+// isAWSManagedPolicyArn tells whether key designates a policy AWS manages.
+func isAWSManagedPolicyArn(key string) bool {
+	return strings.HasPrefix(key, awsManagedPolicyArnPrefix)
+}
+
+// policyKeyFromArn turns the ARN of a managed policy into the key the
+// registry knows the policy by.
+func policyKeyFromArn(arn string) string {
+	if isAWSManagedPolicyArn(arn) {
+		return arn
+	}
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// normalizePolicy fills in the defaults of a managed policy given by a
+// fixture and checks it for consistency.
+func normalizePolicy(p *IAMPolicy) error {
+	if p.Path == "" {
+		p.Path = "/"
+	}
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = epoch
+	}
+	if p.UpdatedAt.IsZero() {
+		p.UpdatedAt = p.CreatedAt
+	}
+	if len(p.Versions) == 0 {
+		return fmt.Errorf("policy %s has no version", p.Name)
+	}
+	if len(p.Versions) > maxPolicyVersions {
+		return fmt.Errorf("policy %s cannot have more than %d versions", p.Name, maxPolicyVersions)
+	}
+	seen := make(map[string]bool, len(p.Versions))
+	for i := range p.Versions {
+		v := &p.Versions[i]
+		if v.Id == "" {
+			v.Id = fmt.Sprintf("v%d", i+1)
+		}
+		if seen[v.Id] {
+			return fmt.Errorf("policy %s has more than one version %s", p.Name, v.Id)
+		}
+		seen[v.Id] = true
+		if v.Document == "" {
+			return fmt.Errorf("version %s of policy %s has no document", v.Id, p.Name)
+		}
+		if v.CreatedAt.IsZero() {
+			v.CreatedAt = p.CreatedAt
+		}
+	}
+	if p.DefaultVersion == "" {
+		p.DefaultVersion = p.Versions[len(p.Versions)-1].Id
+	} else if !seen[p.DefaultVersion] {
+		return fmt.Errorf("policy %s has no version %s to make the default", p.Name, p.DefaultVersion)
+	}
+	return nil
+}
+
+// checkPolicyAttachments checks what a user, group or role refers to as its
+// managed policies.  Policies AWS manages need not be defined, as nobody
+// would write out the whole of them.
+func checkPolicyAttachments(reg *BasicIAMRegistry, keys []string) error {
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if seen[k] {
+			return fmt.Errorf("policy %s is attached more than once", k)
+		}
+		seen[k] = true
+		if _, ok := reg.policies.get(k); !ok && !isAWSManagedPolicyArn(k) {
+			return fmt.Errorf("unknown policy %s", k)
+		}
+	}
+	return nil
+}
+
+func noSuchPolicy(arn string) error {
+	return &SenderFault{
+		Code_:    "NoSuchEntity",
+		Message_: fmt.Sprintf("Policy %s does not exist or is not attachable.", arn),
+	}
+}
+
+func registerPolicyHandlers(iamAPISet *APISet, reg IAMRegistry) {
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "GetPolicy",
+			Proto: iam.GetPolicyInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*iam.GetPolicyInput)
+				p, ok, err := reg.GetPolicyByArn(*params.PolicyArn)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchPolicy(*params.PolicyArn)
+				}
+				attachments, err := countPolicyAttachments(reg)
+				if err != nil {
+					return nil, err
+				}
+				detail := p.BuildManagedPolicyDetail(accountId, attachments[policyKey(p)])
+				out := &Policy{
+					Arn:                           detail.Arn,
+					AttachmentCount:               detail.AttachmentCount,
+					CreateDate:                    detail.CreateDate,
+					DefaultVersionId:              detail.DefaultVersionId,
+					Description:                   detail.Description,
+					IsAttachable:                  detail.IsAttachable,
+					Path:                          detail.Path,
+					PermissionsBoundaryUsageCount: detail.PermissionsBoundaryUsageCount,
+					PolicyId:                      detail.PolicyId,
+					PolicyName:                    detail.PolicyName,
+					UpdateDate:                    detail.UpdateDate,
+				}
+				if len(p.Tags) > 0 {
+					out.Tags = buildTags(p.Tags)
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &GetPolicyOutput{Policy: out},
+					},
+				}, nil
+			},
+		},
+	)
+}
+
+func (reg *BasicIAMRegistry) GetPolicies() ([]*IAMPolicy, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.policies.values(), nil
+}
+
+func (reg *BasicIAMRegistry) GetPolicyByArn(arn string) (*IAMPolicy, bool, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	accountId := reg.accountId()
+	p, ok := reg.policies.get(policyKeyFromArn(arn))
+	if !ok || p.BuildArn(accountId) != arn {
+		return nil, false, nil
+	}
+	return p, true, nil
+}
+
+// policyByArn is GetPolicyByArn within a transaction.
+func (tx *registryTx) policyByArn(arn string) (*IAMPolicy, bool) {
+	accountId := tx.reg.accountId()
+	p, ok := tx.policy(policyKeyFromArn(arn))
+	if !ok || p.BuildArn(accountId) != arn {
+		return nil, false
+	}
+	return p, true
+}
+
+// buildAttachedPolicies renders the managed_policies of a user, group or
+// role, looking them up in policies by their keys.
+func buildAttachedPolicies(keys []string, policies map[string]*IAMPolicy, accountId string) []iam.AttachedPolicy {
+	result := make([]iam.AttachedPolicy, 0, len(keys))
+	for _, k := range keys {
+		if p, ok := policies[k]; ok {
+			result = append(result, iam.AttachedPolicy{
+				PolicyArn:  aws.String(p.BuildArn(accountId)),
+				PolicyName: aws.String(p.Name),
+			})
+			continue
+		}
+		// an AWS managed policy that is not defined
+		result = append(result, iam.AttachedPolicy{
+			PolicyArn:  aws.String(k),
+			PolicyName: aws.String(k[strings.LastIndex(k, "/")+1:]),
+		})
+	}
+	return result
+}
+
+// buildInlinePolicies renders the inline policies of a user, group or role
+// in ascending order of their names.
+func buildInlinePolicies(policies map[string]string) []iam.PolicyDetail {
+	names := make([]string, 0, len(policies))
+	for n := range policies {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	result := make([]iam.PolicyDetail, len(names))
+	for i, n := range names {
+		result[i] = iam.PolicyDetail{
+			PolicyName:     aws.String(n),
+			PolicyDocument: aws.String(escapePolicyDocument(policies[n])),
+		}
+	}
+	return result
+}
+
+// BuildManagedPolicyDetail renders p the way GetAccountAuthorizationDetails
+// does, with every version.
+func (p *IAMPolicy) BuildManagedPolicyDetail(accountId string, attachmentCount int64) iam.ManagedPolicyDetail {
+	versions := make([]iam.PolicyVersion, len(p.Versions))
+	for i, v := range p.Versions {
+		versions[i] = iam.PolicyVersion{
+			CreateDate:       aws.Time(v.CreatedAt),
+			Document:         aws.String(escapePolicyDocument(v.Document)),
+			IsDefaultVersion: aws.Bool(v.Id == p.DefaultVersion),
+			VersionId:        aws.String(v.Id),
+		}
+	}
+	detail := iam.ManagedPolicyDetail{
+		Arn:                           aws.String(p.BuildArn(accountId)),
+		AttachmentCount:               aws.Int64(attachmentCount),
+		CreateDate:                    aws.Time(p.CreatedAt),
+		DefaultVersionId:              aws.String(p.DefaultVersion),
+		IsAttachable:                  aws.Bool(true),
+		Path:                          aws.String(p.Path),
+		PermissionsBoundaryUsageCount: aws.Int64(0),
+		PolicyId:                      aws.String(p.Id),
+		PolicyName:                    aws.String(p.Name),
+		PolicyVersionList:             versions,
+		UpdateDate:                    aws.Time(p.UpdatedAt),
+	}
+	if p.Description != "" {
+		detail.Description = aws.String(p.Description)
+	}
+	return detail
+}
+
+// countPolicyAttachments counts the users, groups and roles each managed
+// policy is attached to, by the key of the policy.
+func countPolicyAttachments(reg IAMRegistry) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	users, err := reg.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		for _, k := range u.ManagedPolicies {
+			counts[k]++
+		}
+	}
+	groups, err := reg.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		for _, k := range g.ManagedPolicies {
+			counts[k]++
+		}
+	}
+	roles, err := reg.GetRoles()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range roles {
+		for _, k := range r.ManagedPolicies {
+			counts[k]++
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPolicyTags(t *testing.T) {
+	svc, reg := newTestService(t, `
+policies:
+  - name: p
+    path: /team/
+    tags: {env: test}
+    versions: [{document: '{}'}]
+`)
+	arn := "arn:aws:iam::000000000000:policy/team/p"
+	for _, c := range []struct {
+		params url.Values
+		status int
+		want   string
+	}{
+		{url.Values{"Action": {"GetPolicy"}, "PolicyArn": {arn}}, http.StatusOK, "<Key>env</Key>"},
+		{url.Values{"Action": {"GetPolicy"}, "PolicyArn": {"arn:aws:iam::000000000000:policy/p"}}, http.StatusNotFound, "Policy arn:aws:iam::000000000000:policy/p does not exist or is not attachable."},
+		{url.Values{"Action": {"TagPolicy"}, "PolicyArn": {arn}, "Tags.member.1.Key": {"team"}, "Tags.member.1.Value": {"red"}}, http.StatusOK, ""},
+		{url.Values{"Action": {"TagPolicy"}, "PolicyArn": {arn}, "Tags.member.1.Key": {"aws:team"}, "Tags.member.1.Value": {"red"}}, http.StatusBadRequest, ""},
+		{url.Values{"Action": {"UntagPolicy"}, "PolicyArn": {arn}, "TagKeys.member.1": {"env"}}, http.StatusOK, ""},
+		{url.Values{"Action": {"ListPolicyTags"}, "PolicyArn": {arn}}, http.StatusOK, "<Value>red</Value>"},
+		{url.Values{"Action": {"ListPolicyTags"}, "PolicyArn": {"arn:aws:iam::000000000000:policy/q"}}, http.StatusNotFound, "<Code>NoSuchEntity</Code>"},
+	} {
+		w := query(t, svc, c.params)
+		if w.Code != c.status || !strings.Contains(w.Body.String(), c.want) {
+			t.Errorf("%v: got %d, want %d and %q:\n%s", c.params, w.Code, c.status, c.want, w.Body)
+		}
+	}
+	p, ok, err := reg.GetPolicyByArn(arn)
+	if err != nil || !ok {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+	if len(p.Tags) != 1 || p.Tags["team"] != "red" {
+		t.Errorf("got tags %v", p.Tags)
+	}
+}
@@ -0,0 +1,543 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file evaluates identity-based policy documents the way the IAM
+// policy simulator does, to the extent an emulator can: statements are
+// matched by action and resource, and conditions are checked against the
+// context keys the caller supplies, plus those the emulator derives from
+// the entities involved, such as aws:ResourceTag/KEY.
+
+// policyValues is a member of a statement that may be given as either a
+// single value or an array of values.  Condition values may be booleans or
+// numbers, which are compared as their JSON text.
+type policyValues []string
+
+func (v *policyValues) UnmarshalJSON(b []byte) error {
+	var raw interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		items = []interface{}{raw}
+	}
+	*v = make(policyValues, len(items))
+	for i, item := range items {
+		switch item := item.(type) {
+		case string:
+			(*v)[i] = item
+		case bool:
+			(*v)[i] = strconv.FormatBool(item)
+		case json.Number:
+			(*v)[i] = item.String()
+		default:
+			return fmt.Errorf("unexpected value %s", b)
+		}
+	}
+	return nil
+}
+
+type policyStatement struct {
+	Sid         string                             `json:"Sid"`
+	Effect      string                             `json:"Effect"`
+	Action      policyValues                       `json:"Action"`
+	NotAction   policyValues                       `json:"NotAction"`
+	Resource    policyValues                       `json:"Resource"`
+	NotResource policyValues                       `json:"NotResource"`
+	Condition   map[string]map[string]policyValues `json:"Condition"`
+}
+
+// policyStatements is the Statement of a document, which may be a single
+// statement rather than an array.
+type policyStatements []policyStatement
+
+func (s *policyStatements) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '{' {
+		var st policyStatement
+		if err := json.Unmarshal(b, &st); err != nil {
+			return err
+		}
+		*s = policyStatements{st}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]policyStatement)(s))
+}
+
+type policyDocument struct {
+	Version   string           `json:"Version"`
+	Statement policyStatements `json:"Statement"`
+}
+
+func malformedPolicyDocument(message string) error {
+	return &SenderFault{
+		Code_:    "MalformedPolicyDocument",
+		Message_: message,
+	}
+}
+
+func parsePolicyDocument(doc string) (*policyDocument, error) {
+	var d policyDocument
+	if err := json.Unmarshal([]byte(doc), &d); err != nil {
+		return nil, malformedPolicyDocument("Syntax errors in policy.")
+	}
+	for _, st := range d.Statement {
+		if st.Effect != "Allow" && st.Effect != "Deny" {
+			return nil, malformedPolicyDocument("Invalid effect: " + st.Effect)
+		}
+		if (st.Action == nil) == (st.NotAction == nil) {
+			return nil, malformedPolicyDocument("Policy statement must contain actions.")
+		}
+		if (st.Resource == nil) == (st.NotResource == nil) {
+			return nil, malformedPolicyDocument("Policy statement must contain resources.")
+		}
+	}
+	return &d, nil
+}
+
+// evalContext holds the values of the context keys by their names in
+// lower case, as keys are matched case-insensitively.
+type evalContext struct {
+	values map[string][]string
+	// names keeps the names as given, for MissingContextValues
+	names map[string]string
+}
+
+func newEvalContext() *evalContext {
+	return &evalContext{values: map[string][]string{}, names: map[string]string{}}
+}
+
+// set sets the values of key unless the key has some already, so that the
+// values the caller supplies take precedence over derived ones.
+func (c *evalContext) set(key string, values ...string) {
+	k := strings.ToLower(key)
+	if _, ok := c.values[k]; ok {
+		return
+	}
+	c.values[k] = values
+	c.names[k] = key
+}
+
+func (c *evalContext) get(key string) ([]string, bool) {
+	v, ok := c.values[strings.ToLower(key)]
+	return v, ok
+}
+
+// policyVariableRegexp matches the ${...} of resource ARNs and condition
+// values.
+var policyVariableRegexp = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// expandPolicyVariables substitutes the policy variables in pattern, and
+// tells whether every one of them has a single value to substitute.
+func expandPolicyVariables(pattern string, ctx *evalContext) (string, bool) {
+	ok := true
+	result := policyVariableRegexp.ReplaceAllStringFunc(pattern, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		switch name {
+		case "*", "?", "$":
+			return name
+		}
+		if v, found := ctx.get(name); found && len(v) == 1 {
+			return v[0]
+		}
+		ok = false
+		return ref
+	})
+	return result, ok
+}
+
+// wildcardMatch matches s against pattern, where * matches any sequence of
+// characters and ? any single character, including slashes.
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for pattern = pattern[1:]; len(pattern) > 0 && pattern[0] == '*'; pattern = pattern[1:] {
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if wildcardMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return s == ""
+}
+
+func matchesAction(patterns policyValues, action string) bool {
+	for _, p := range patterns {
+		if wildcardMatch(strings.ToLower(p), strings.ToLower(action)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesResource(patterns policyValues, resource string, ctx *evalContext) bool {
+	for _, p := range patterns {
+		p, ok := expandPolicyVariables(p, ctx)
+		if ok && (p == "*" || wildcardMatch(p, resource)) {
+			return true
+		}
+	}
+	return false
+}
+
+// appliesTo tells whether st covers action on resource, regardless of its
+// conditions.
+func (st *policyStatement) appliesTo(action, resource string, ctx *evalContext) bool {
+	if st.Action != nil {
+		if !matchesAction(st.Action, action) {
+			return false
+		}
+	} else if matchesAction(st.NotAction, action) {
+		return false
+	}
+	if st.Resource != nil {
+		return matchesResource(st.Resource, resource, ctx)
+	}
+	return !matchesResource(st.NotResource, resource, ctx)
+}
+
+// conditionOperator is a condition operator split into its parts, e.g.
+// ForAllValues:StringLikeIfExists.
+type conditionOperator struct {
+	set      string
+	base     string
+	ifExists bool
+}
+
+func parseConditionOperator(op string) conditionOperator {
+	var c conditionOperator
+	if i := strings.Index(op, ":"); i >= 0 {
+		c.set, op = op[:i], op[i+1:]
+	}
+	if op != "Null" && strings.HasSuffix(op, "IfExists") {
+		c.ifExists = true
+		op = strings.TrimSuffix(op, "IfExists")
+	}
+	c.base = op
+	return c
+}
+
+// negated tells whether the operator is the negation of another, which
+// holds for a value that matches none of those of the policy.
+func (c conditionOperator) negated() bool {
+	return strings.Contains(c.base, "Not")
+}
+
+// compareValues is the positive form of the operator applied to a value of
+// the context and one of the policy.
+func (c conditionOperator) compareValues(v, pv string) (bool, error) {
+	switch strings.Replace(c.base, "Not", "", 1) {
+	case "StringEquals", "BinaryEquals":
+		return v == pv, nil
+	case "StringEqualsIgnoreCase":
+		return strings.EqualFold(v, pv), nil
+	case "StringLike":
+		return wildcardMatch(pv, v), nil
+	case "ArnEquals", "ArnLike":
+		return matchArn(pv, v), nil
+	case "Bool":
+		return strings.EqualFold(v, pv), nil
+	case "NumericEquals", "NumericLessThan", "NumericLessThanEquals", "NumericGreaterThan", "NumericGreaterThanEquals":
+		a, err1 := strconv.ParseFloat(v, 64)
+		b, err2 := strconv.ParseFloat(pv, 64)
+		if err1 != nil || err2 != nil {
+			return false, nil
+		}
+		return compareOrdered(c.base, a, b), nil
+	case "DateEquals", "DateLessThan", "DateLessThanEquals", "DateGreaterThan", "DateGreaterThanEquals":
+		a, ok1 := parseConditionDate(v)
+		b, ok2 := parseConditionDate(pv)
+		if !ok1 || !ok2 {
+			return false, nil
+		}
+		return compareOrdered(c.base, float64(a.UnixNano()), float64(b.UnixNano())), nil
+	case "IpAddress":
+		_, n, err := net.ParseCIDR(pv)
+		if err != nil {
+			if ip := net.ParseIP(pv); ip != nil {
+				return ip.Equal(net.ParseIP(v)), nil
+			}
+			return false, nil
+		}
+		ip := net.ParseIP(v)
+		return ip != nil && n.Contains(ip), nil
+	}
+	return false, malformedPolicyDocument(fmt.Sprintf("Invalid Condition type : %s", c.base))
+}
+
+func compareOrdered(op string, a, b float64) bool {
+	switch {
+	case strings.HasSuffix(op, "LessThanEquals"):
+		return a <= b
+	case strings.HasSuffix(op, "LessThan"):
+		return a < b
+	case strings.HasSuffix(op, "GreaterThanEquals"):
+		return a >= b
+	case strings.HasSuffix(op, "GreaterThan"):
+		return a > b
+	}
+	return a == b
+}
+
+func parseConditionDate(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), true
+	}
+	return time.Time{}, false
+}
+
+// matchArn matches the ARNs component by component, as ArnLike does, with
+// wildcards that do not cross colons.
+func matchArn(pattern, arn string) bool {
+	pp := strings.SplitN(pattern, ":", 6)
+	ap := strings.SplitN(arn, ":", 6)
+	if len(pp) != 6 || len(ap) != 6 {
+		return false
+	}
+	for i := range pp {
+		if !wildcardMatch(pp[i], ap[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// valueMatches applies the operator to a single value of the context.
+func (c conditionOperator) valueMatches(v string, pvs []string) (bool, error) {
+	for _, pv := range pvs {
+		ok, err := c.compareValues(v, pv)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return !c.negated(), nil
+		}
+	}
+	return c.negated(), nil
+}
+
+// evaluate tells whether the condition on key holds.  missing is set when
+// the outcome depends on a key the context lacks.
+func (c conditionOperator) evaluate(key string, pvs []string, ctx *evalContext) (result bool, missing bool, err error) {
+	values, ok := ctx.get(key)
+	if c.base == "Null" {
+		if len(pvs) == 0 {
+			return false, false, nil
+		}
+		return strings.EqualFold(pvs[0], "true") != ok, false, nil
+	}
+	for i, pv := range pvs {
+		if expanded, ok := expandPolicyVariables(pv, ctx); ok {
+			pvs[i] = expanded
+		}
+	}
+	if !ok {
+		switch {
+		case c.ifExists, c.set == "ForAllValues":
+			return true, false, nil
+		case c.set == "ForAnyValue":
+			return false, true, nil
+		}
+		return c.negated(), !c.negated(), nil
+	}
+	if c.set == "ForAllValues" {
+		for _, v := range values {
+			ok, err := c.valueMatches(v, pvs)
+			if err != nil || !ok {
+				return false, false, err
+			}
+		}
+		return true, false, nil
+	}
+	// a single valued key, and ForAnyValue
+	if c.negated() && c.set == "" {
+		for _, v := range values {
+			ok, err := c.valueMatches(v, pvs)
+			if err != nil || !ok {
+				return false, false, err
+			}
+		}
+		return true, false, nil
+	}
+	for _, v := range values {
+		ok, err := c.valueMatches(v, pvs)
+		if err != nil {
+			return false, false, err
+		}
+		if ok {
+			return true, false, nil
+		}
+	}
+	return false, false, nil
+}
+
+// conditionsHold checks the Condition block of st, whose operators and keys
+// must all hold.  The keys the context lacks and that would have mattered
+// are added to missing.
+func (st *policyStatement) conditionsHold(ctx *evalContext, missing map[string]bool) (bool, error) {
+	result := true
+	for op, keys := range st.Condition {
+		c := parseConditionOperator(op)
+		for key, pvs := range keys {
+			ok, miss, err := c.evaluate(key, append([]string(nil), pvs...), ctx)
+			if err != nil {
+				return false, err
+			}
+			if miss {
+				missing[key] = true
+			}
+			if !ok {
+				result = false
+			}
+		}
+	}
+	return result, nil
+}
+
+// policySource is a policy document along with where it came from, as
+// MatchedStatements tells.
+type policySource struct {
+	id       string
+	kind     string
+	document *policyDocument
+}
+
+// evalDecision is what the policies make of an action on a resource.
+type evalDecision struct {
+	decision string
+	matched  []policySource
+	missing  []string
+}
+
+const (
+	evalAllowed      = "allowed"
+	evalExplicitDeny = "explicitDeny"
+	evalImplicitDeny = "implicitDeny"
+)
+
+// evaluatePolicies decides on action on resource under the identity-based
+// policies, the permissions boundaries, if any, and the resource-based
+// policies, if any, of the same account.
+func evaluatePolicies(identity, boundaries, resourcePolicies []policySource, action, resource string, ctx *evalContext) (*evalDecision, error) {
+	missing := map[string]bool{}
+	// scan returns whether any statement allows and whether any denies,
+	// along with the sources of the statements that did
+	scan := func(sources []policySource) (allowed, denied []policySource, err error) {
+		for _, src := range sources {
+			var allows, denies bool
+			for i := range src.document.Statement {
+				st := &src.document.Statement[i]
+				if !st.appliesTo(action, resource, ctx) {
+					continue
+				}
+				ok, err := st.conditionsHold(ctx, missing)
+				if err != nil {
+					return nil, nil, err
+				}
+				if !ok {
+					continue
+				}
+				if st.Effect == "Deny" {
+					denies = true
+				} else {
+					allows = true
+				}
+			}
+			if denies {
+				denied = append(denied, src)
+			} else if allows {
+				allowed = append(allowed, src)
+			}
+		}
+		return allowed, denied, nil
+	}
+
+	d := &evalDecision{decision: evalImplicitDeny}
+	allowed, denied, err := scan(identity)
+	if err != nil {
+		return nil, err
+	}
+	resAllowed, resDenied, err := scan(resourcePolicies)
+	if err != nil {
+		return nil, err
+	}
+	denied = append(denied, resDenied...)
+	if len(boundaries) > 0 {
+		boundaryAllowed, boundaryDenied, err := scan(boundaries)
+		if err != nil {
+			return nil, err
+		}
+		denied = append(denied, boundaryDenied...)
+		if len(boundaryAllowed) == 0 {
+			allowed = nil
+		}
+	}
+	allowed = append(allowed, resAllowed...)
+	switch {
+	case len(denied) > 0:
+		d.decision = evalExplicitDeny
+		d.matched = denied
+	case len(allowed) > 0:
+		d.decision = evalAllowed
+		d.matched = allowed
+	}
+	for k := range missing {
+		d.missing = append(d.missing, k)
+	}
+	sort.Strings(d.missing)
+	return d, nil
+}
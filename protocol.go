@@ -0,0 +1,454 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// protocol is a wire protocol the operations are served over.  Requests are
+// decoded into the same input shapes whatever the protocol is, so that a
+// handler serves every one of them.
+type protocol interface {
+	decodeRequest(e *Service, req *http.Request) (*APISet, Handler, interface{}, error)
+	// operation returns the name of the operation the request calls, as far
+	// as decodeRequest got before it failed, if it did
+	operation() string
+	renderResult(w http.ResponseWriter, apiset *APISet, op *aws.Operation, requestId string, result interface{}) error
+	renderFault(w http.ResponseWriter, e *Service, req *http.Request, requestId string, err Fault) error
+}
+
+const (
+	smithyProtocolHeader = "Smithy-Protocol"
+	rpcv2CBORProtocol    = "rpc-v2-cbor"
+	cborContentType      = "application/cbor"
+)
+
+// detectProtocol tells the protocol of a request from its headers.  awsJson
+// requests are told by their Content-Type and Smithy RPCv2 CBOR ones by the
+// Smithy-Protocol header or the Content-Type; anything else is taken for the
+// query protocol.
+func detectProtocol(req *http.Request) protocol {
+	if req.Header.Get(smithyProtocolHeader) == rpcv2CBORProtocol {
+		return &cborProtocol{}
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-amz-json-1.0", "application/x-amz-json-1.1":
+		return &jsonProtocol{contentType: mediaType}
+	case cborContentType:
+		return &cborProtocol{}
+	}
+	return &queryProtocol{}
+}
+
+// maxRequestBodySize is the largest request body read; IAM and STS turn down
+// larger ones as well.
+const maxRequestBodySize = 10 << 20
+
+// readRequestBody reads the body of a request up to maxRequestBodySize.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(nil, req.Body, maxRequestBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, &SenderFault{
+				Code_:    "RequestEntityTooLarge",
+				Message_: fmt.Sprintf("Request body must be no larger than %d bytes.", tooLarge.Limit),
+			}
+		}
+		return nil, err
+	}
+	return body, nil
+}
+
+// queryProtocol keeps the action and API version of the request, which
+// the fault is rendered after.
+type queryProtocol struct {
+	action, version string
+}
+
+func (p *queryProtocol) operation() string {
+	return p.action
+}
+
+func (p *queryProtocol) decodeRequest(e *Service, req *http.Request) (*APISet, Handler, interface{}, error) {
+	form, err := parseQueryForm(req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	p.action = form.Get("Action")
+	p.version = form.Get("Version")
+	action, apiVersion := p.action, p.version
+	if action == "" {
+		return nil, nil, nil, &SenderFault{
+			Code_:    "MissingAction",
+			Message_: "The request must contain the parameter Action.",
+		}
+	}
+
+	logger.Debug("query request", slog.String("action", action), slog.String("apiVersion", apiVersion))
+
+	apiset, handler, err := e.queryHandler(action, apiVersion)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	params, err := handler.UnmarshalParams(req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return apiset, handler, params, nil
+}
+
+func (p *queryProtocol) renderResult(w http.ResponseWriter, apiset *APISet, op *aws.Operation, requestId string, result interface{}) error {
+	// IAM and STS send neither an XML declaration nor a charset in the
+	// Content-Type; the body is indented by two spaces and ends with a
+	// newline.
+	b := &bytes.Buffer{}
+	err := marshal(newXMLWriter(b), op, requestId, apiset.Namespace, result)
+	if err != nil {
+		return err
+	}
+	b.WriteByte('\n')
+	w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(200)
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+func (p *queryProtocol) renderFault(w http.ResponseWriter, e *Service, req *http.Request, requestId string, err Fault) error {
+	if err.Code() == "MethodNotAllowed" {
+		w.Header().Set("Allow", "GET, POST")
+	}
+	return renderFaultResponse(w, requestId, e.faultNamespace(p.version), err)
+}
+
+const formContentType = "application/x-www-form-urlencoded"
+
+// parseQueryForm returns the parameters of a query protocol request, which
+// it sets req.Form to as well for the handler to unmarshal.  The parameters
+// come in the query string of a GET, or in the form-encoded body of a POST
+// as well as in its query string.  Parameters in the body take
+// precedence over those of the same name in the query string, where
+// presigned requests carry their X-Amz-* signing parameters.
+func parseQueryForm(req *http.Request) (url.Values, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		return nil, &SenderFault{
+			Code_:    "MethodNotAllowed",
+			Message_: fmt.Sprintf("The HTTP method %s is not supported; use GET or POST.", req.Method),
+		}
+	}
+	form, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		return nil, malformedQueryString(err)
+	}
+	if req.Method == http.MethodPost {
+		body, err := readRequestBody(req)
+		if err != nil {
+			return nil, err
+		}
+		bodyForm, err := parseFormBody(req.Header.Get("Content-Type"), body)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range bodyForm {
+			form[k] = v
+		}
+	}
+	for k, vs := range form {
+		if !utf8.ValidString(k) {
+			return nil, malformedQueryString(fmt.Errorf("parameter name is not valid UTF-8"))
+		}
+		for _, v := range vs {
+			if !utf8.ValidString(v) {
+				return nil, malformedQueryString(fmt.Errorf("value of %s is not valid UTF-8", k))
+			}
+		}
+	}
+	req.Form = form
+	return form, nil
+}
+
+// parseFormBody parses the body of a POST, which is UTF-8 unless the
+// Content-Type says it is ISO-8859-1.  An empty body may come without a
+// Content-Type or with any.
+func parseFormBody(contentType string, body []byte) (url.Values, error) {
+	if len(body) == 0 {
+		return url.Values{}, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if contentType == "" {
+		mediaType, params, err = formContentType, nil, nil
+	}
+	if err != nil || mediaType != formContentType {
+		return nil, unsupportedMediaType(contentType)
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, malformedQueryString(err)
+	}
+	switch strings.ToLower(params["charset"]) {
+	case "", "utf-8", "utf8", "us-ascii":
+	case "iso-8859-1", "latin1":
+		latin1Form := make(url.Values, len(form))
+		for k, vs := range form {
+			for _, v := range vs {
+				latin1Form.Add(latin1ToUTF8(k), latin1ToUTF8(v))
+			}
+		}
+		form = latin1Form
+	default:
+		return nil, unsupportedMediaType(contentType)
+	}
+	return form, nil
+}
+
+func latin1ToUTF8(s string) string {
+	r := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		r[i] = rune(s[i])
+	}
+	return string(r)
+}
+
+func malformedQueryString(err error) error {
+	return &SenderFault{
+		Code_:    "MalformedQueryString",
+		Message_: err.Error(),
+	}
+}
+
+func unsupportedMediaType(contentType string) error {
+	return &SenderFault{
+		Code_:    "UnsupportedMediaType",
+		Message_: fmt.Sprintf("The Content-Type %q is not supported; use %s.", contentType, formContentType),
+	}
+}
+
+// jsonProtocol is awsJson 1.0 and 1.1, which differ only in the Content-Type.
+type jsonProtocol struct {
+	contentType string
+	op          string
+}
+
+func (p *jsonProtocol) operation() string {
+	return p.op
+}
+
+func (p *jsonProtocol) decodeRequest(e *Service, req *http.Request) (*APISet, Handler, interface{}, error) {
+	target := req.Header.Get("X-Amz-Target")
+	i := strings.LastIndexByte(target, '.')
+	if i < 0 {
+		return nil, nil, nil, unknownOperation(target)
+	}
+	p.op = target[i+1:]
+	apiset, handler, err := e.shapeHandler(target[:i], p.op)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var doc map[string]interface{}
+	if len(bytes.TrimSpace(body)) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		err = dec.Decode(&doc)
+		if err != nil {
+			return nil, nil, nil, serializationFault(err)
+		}
+	}
+	params, err := handler.UnmarshalDocument(doc)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return apiset, handler, params, nil
+}
+
+func (p *jsonProtocol) renderResult(w http.ResponseWriter, apiset *APISet, op *aws.Operation, requestId string, result interface{}) error {
+	b, err := json.Marshal(buildResultDocument(result, epochSeconds))
+	if err != nil {
+		return err
+	}
+	return writeBody(w, p.contentType, http.StatusOK, b)
+}
+
+func (p *jsonProtocol) renderFault(w http.ResponseWriter, e *Service, req *http.Request, requestId string, err Fault) error {
+	b, _err := json.Marshal(buildFaultDocument(err))
+	if _err != nil {
+		return _err
+	}
+	setQueryErrorHeader(w, err)
+	return writeBody(w, p.contentType, faultStatusCode(err), b)
+}
+
+// cborProtocol is Smithy RPCv2 CBOR, whose requests are POSTed to
+// /service/{service shape}/operation/{operation}.
+type cborProtocol struct {
+	op string
+}
+
+var (
+	cborEncMode cbor.EncMode
+	cborDecMode cbor.DecMode
+)
+
+func init() {
+	encOpts := cbor.CoreDetEncOptions()
+	encOpts.Time = cbor.TimeUnixDynamic
+	encOpts.TimeTag = cbor.EncTagRequired
+	var err error
+	cborEncMode, err = encOpts.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	cborDecMode, err = cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (p *cborProtocol) operation() string {
+	return p.op
+}
+
+func (p *cborProtocol) decodeRequest(e *Service, req *http.Request) (*APISet, Handler, interface{}, error) {
+	var service string
+	if i := strings.LastIndex(req.URL.Path, "/service/"); i >= 0 {
+		service, p.op, _ = strings.Cut(req.URL.Path[i+len("/service/"):], "/operation/")
+	}
+	if req.Method != http.MethodPost || service == "" || p.op == "" {
+		return nil, nil, nil, unknownOperation(req.URL.Path)
+	}
+	apiset, handler, err := e.shapeHandler(service, p.op)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var doc map[string]interface{}
+	if len(body) > 0 {
+		err = cborDecMode.Unmarshal(body, &doc)
+		if err != nil {
+			return nil, nil, nil, serializationFault(err)
+		}
+	}
+	params, err := handler.UnmarshalDocument(doc)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return apiset, handler, params, nil
+}
+
+func (p *cborProtocol) renderResult(w http.ResponseWriter, apiset *APISet, op *aws.Operation, requestId string, result interface{}) error {
+	b, err := cborEncMode.Marshal(buildResultDocument(result, func(t time.Time) interface{} {
+		return t.UTC()
+	}))
+	if err != nil {
+		return err
+	}
+	w.Header().Set(smithyProtocolHeader, rpcv2CBORProtocol)
+	return writeBody(w, cborContentType, http.StatusOK, b)
+}
+
+func (p *cborProtocol) renderFault(w http.ResponseWriter, e *Service, req *http.Request, requestId string, err Fault) error {
+	b, _err := cborEncMode.Marshal(buildFaultDocument(err))
+	if _err != nil {
+		return _err
+	}
+	w.Header().Set(smithyProtocolHeader, rpcv2CBORProtocol)
+	setQueryErrorHeader(w, err)
+	return writeBody(w, cborContentType, faultStatusCode(err), b)
+}
+
+func epochSeconds(t time.Time) interface{} {
+	return float64(t.Unix()) + float64(t.Nanosecond())/1e9
+}
+
+// buildResultDocument builds the document of an output shape; operations
+// without output answer an empty object.
+func buildResultDocument(result interface{}, timestamp func(time.Time) interface{}) interface{} {
+	doc := buildDocument(reflect.ValueOf(result), timestamp)
+	if doc == nil {
+		return map[string]interface{}{}
+	}
+	return doc
+}
+
+func buildFaultDocument(err Fault) map[string]interface{} {
+	return map[string]interface{}{
+		"__type":  err.Code(),
+		"message": err.Message(),
+	}
+}
+
+// setQueryErrorHeader sets x-amzn-query-error, from which SDKs of services
+// that are compatible with the query protocol take the error code.
+func setQueryErrorHeader(w http.ResponseWriter, err Fault) {
+	w.Header().Set("x-amzn-query-error", err.Code()+";"+faultType(err))
+}
+
+func writeBody(w http.ResponseWriter, contentType string, status int, b []byte) error {
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, err := w.Write(b)
+	return err
+}
+
+func unknownOperation(target string) error {
+	return &SenderFault{
+		Code_:    "UnknownOperationException",
+		Message_: fmt.Sprintf("Could not find operation %s", target),
+	}
+}
+
+func serializationFault(err error) error {
+	return &SenderFault{
+		Code_:    "SerializationException",
+		Message_: err.Error(),
+	}
+}
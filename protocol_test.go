@@ -0,0 +1,239 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const iamServiceShape = "AWSIdentityManagementV20100508"
+
+func TestDetectProtocol(t *testing.T) {
+	for _, c := range []struct {
+		header map[string]string
+		want   protocol
+	}{
+		{map[string]string{"Content-Type": formContentType}, &queryProtocol{}},
+		{map[string]string{}, &queryProtocol{}},
+		{map[string]string{"Content-Type": "application/x-amz-json-1.0"}, &jsonProtocol{contentType: "application/x-amz-json-1.0"}},
+		{map[string]string{"Content-Type": "application/x-amz-json-1.1; charset=utf-8"}, &jsonProtocol{contentType: "application/x-amz-json-1.1"}},
+		{map[string]string{"Content-Type": cborContentType}, &cborProtocol{}},
+		{map[string]string{"Smithy-Protocol": rpcv2CBORProtocol}, &cborProtocol{}},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		for k, v := range c.header {
+			req.Header.Set(k, v)
+		}
+		if got := detectProtocol(req); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: got %#v, want %#v", c.header, got, c.want)
+		}
+	}
+}
+
+func TestJSONProtocol(t *testing.T) {
+	svc, _ := newTestService(t, `
+users:
+  - name: alice
+    created_at: 2020-01-02T03:04:05Z
+`)
+	for _, c := range []struct {
+		target, body string
+		status       int
+		queryError   string
+		check        func(doc map[string]interface{}) bool
+	}{
+		{
+			iamServiceShape + ".GetUser", `{"UserName": "alice"}`, http.StatusOK, "",
+			func(doc map[string]interface{}) bool {
+				u, _ := doc["User"].(map[string]interface{})
+				// timestamps are epoch seconds
+				return u["UserName"] == "alice" && u["CreateDate"] == float64(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).Unix())
+			},
+		},
+		{
+			iamServiceShape + ".GetUser", `{"UserName": "carol"}`, http.StatusNotFound, "NoSuchEntity;Sender",
+			func(doc map[string]interface{}) bool { return doc["__type"] == "NoSuchEntity" },
+		},
+		{
+			iamServiceShape + ".GetUser", `{"UserName":`, http.StatusBadRequest, "SerializationException;Sender",
+			func(doc map[string]interface{}) bool { return doc["__type"] == "SerializationException" },
+		},
+		{
+			iamServiceShape + ".NoSuchOperation", `{}`, http.StatusBadRequest, "UnknownOperationException;Sender",
+			func(doc map[string]interface{}) bool { return doc["__type"] == "UnknownOperationException" },
+		},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(c.body))
+		req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+		req.Header.Set("X-Amz-Target", c.target)
+		w := httptest.NewRecorder()
+		svc.Handle(w, req)
+		var doc map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("%s: %v:\n%s", c.target, err, w.Body)
+		}
+		if w.Code != c.status || w.Header().Get("x-amzn-query-error") != c.queryError || !c.check(doc) {
+			t.Errorf("%s %s: got %d %q:\n%s", c.target, c.body, w.Code, w.Header().Get("x-amzn-query-error"), w.Body)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/x-amz-json-1.0" {
+			t.Errorf("%s: got Content-Type %s", c.target, ct)
+		}
+	}
+}
+
+func TestCBORProtocol(t *testing.T) {
+	svc, _ := newTestService(t, `
+users:
+  - name: alice
+    created_at: 2020-01-02T03:04:05Z
+`)
+	body, err := cborEncMode.Marshal(map[string]interface{}{"UserName": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path   string
+		body   []byte
+		status int
+		check  func(doc map[string]interface{}) bool
+	}{
+		{
+			"/service/" + iamServiceShape + "/operation/GetUser", body, http.StatusOK,
+			func(doc map[string]interface{}) bool {
+				u, _ := doc["User"].(map[string]interface{})
+				created, _ := u["CreateDate"].(time.Time)
+				return u["UserName"] == "alice" && created.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+			},
+		},
+		{
+			"/service/" + iamServiceShape + "/operation/GetUser", []byte{0xff}, http.StatusBadRequest,
+			func(doc map[string]interface{}) bool { return doc["__type"] == "SerializationException" },
+		},
+		{
+			"/service/" + iamServiceShape + "/operation/NoSuchOperation", nil, http.StatusBadRequest,
+			func(doc map[string]interface{}) bool { return doc["__type"] == "UnknownOperationException" },
+		},
+	} {
+		req := httptest.NewRequest(http.MethodPost, c.path, bytes.NewReader(c.body))
+		req.Header.Set("Content-Type", cborContentType)
+		req.Header.Set("Smithy-Protocol", rpcv2CBORProtocol)
+		w := httptest.NewRecorder()
+		svc.Handle(w, req)
+		var doc map[string]interface{}
+		if err := cborDecMode.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("%s: %v", c.path, err)
+		}
+		if w.Code != c.status || !c.check(doc) {
+			t.Errorf("%s: got %d: %v", c.path, w.Code, doc)
+		}
+		if w.Header().Get("Smithy-Protocol") != rpcv2CBORProtocol {
+			t.Errorf("%s: the Smithy-Protocol header is missing", c.path)
+		}
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	svc, _ := newTestService(t, ``)
+	body := "Action=ListUsers&Version=2010-05-08&Marker=" + strings.Repeat("x", maxRequestBodySize)
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", formContentType)
+	w := httptest.NewRecorder()
+	svc.Handle(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "<Code>RequestEntityTooLarge</Code>") {
+		t.Errorf("got %d:\n%s", w.Code, w.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"UserName": "`+strings.Repeat("x", maxRequestBodySize)+`"}`))
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", iamServiceShape+".GetUser")
+	w = httptest.NewRecorder()
+	svc.Handle(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d:\n%s", w.Code, w.Body)
+	}
+}
+
+// Faults of requests that fail to decode are recorded under the operation
+// the protocol got from the request, which is not in req.Form but for query
+// requests.
+func TestFaultOperation(t *testing.T) {
+	svc, _ := newTestService(t, ``)
+	svc.Requests = NewRequestLog(100)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"UserName":`))
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", iamServiceShape+".GetUser")
+	svc.Handle(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest(http.MethodPost, "/service/"+iamServiceShape+"/operation/GetUser", bytes.NewReader([]byte{0xff}))
+	req.Header.Set("Smithy-Protocol", rpcv2CBORProtocol)
+	svc.Handle(httptest.NewRecorder(), req)
+
+	query(t, svc, url.Values{"Action": {"NoSuchAction"}})
+
+	var got []string
+	for _, rec := range svc.Requests.since(0) {
+		got = append(got, rec.Operation+" "+rec.ErrorCode)
+	}
+	want := []string{"GetUser SerializationException", "GetUser SerializationException", "NoSuchAction InvalidAction"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFaultNamespace(t *testing.T) {
+	svc := &Service{Name: "iam"}
+	svc.AddAPISet(NewAPISet("2010-05-08", "https://iam.amazonaws.com/doc/2010-05-08/"))
+	svc.AddAPISet(NewAPISet("2012-01-01", "https://iam.amazonaws.com/doc/2012-01-01/"))
+	for _, c := range []struct {
+		version string
+		want    string
+	}{
+		{"2010-05-08", "https://iam.amazonaws.com/doc/2010-05-08/"},
+		{"2012-01-01", "https://iam.amazonaws.com/doc/2012-01-01/"},
+		{"", "https://iam.amazonaws.com/doc/2012-01-01/"},
+		{"1999-01-01", "https://iam.amazonaws.com/doc/2012-01-01/"},
+	} {
+		if got := svc.faultNamespace(c.version); got != c.want {
+			t.Errorf("%q: got %s, want %s", c.version, got, c.want)
+		}
+	}
+	if got := (&Service{}).faultNamespace(""); got != awsFaultNamespaceUrl {
+		t.Errorf("got %s without API sets", got)
+	}
+
+	// the namespace follows the Version of the request even on the fault
+	// path, where the handler never sees the form
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("Action=NoSuchAction&Version=2012-01-01"))
+	req.Header.Set("Content-Type", formContentType)
+	svc.Handle(w, req)
+	if !strings.Contains(w.Body.String(), `xmlns="https://iam.amazonaws.com/doc/2012-01-01/"`) {
+		t.Errorf("got:\n%s", w.Body)
+	}
+}
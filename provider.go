@@ -0,0 +1,515 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// maxThumbprintsPerOIDCProvider and maxClientIdsPerOIDCProvider are the hard
+// quotas IAM imposes on an OpenID Connect provider.
+const maxThumbprintsPerOIDCProvider = 5
+const maxClientIdsPerOIDCProvider = 100
+
+// IAMOpenIDConnectProvider is an OpenID Connect identity provider, known by
+// its URL without the scheme, as its ARN has it.
+type IAMOpenIDConnectProvider struct {
+	Url         string            `yaml:"url"`
+	CreatedAt   time.Time         `yaml:"created_at"`
+	ClientIds   []string          `yaml:"client_ids,omitempty"`
+	Thumbprints []string          `yaml:"thumbprints,omitempty"`
+	Tags        map[string]string `yaml:"tags,omitempty"`
+}
+
+func oidcProviderKey(p *IAMOpenIDConnectProvider) string {
+	return strings.TrimPrefix(p.Url, "https://")
+}
+
+func (p *IAMOpenIDConnectProvider) BuildArn(accountId string) string {
+	return fmt.Sprintf("arn:aws:iam::%s:oidc-provider/%s", accountId, oidcProviderKey(p))
+}
+
+func noSuchOIDCProvider(arn string) error {
+	return &SenderFault{
+		Code_:    "NoSuchEntity",
+		Message_: fmt.Sprintf("OpenIDConnect Provider not found for arn %s", arn),
+	}
+}
+
+// IAMSAMLProvider is a SAML identity provider.  ValidUntil is taken from the
+// metadata document unless given.
+type IAMSAMLProvider struct {
+	Name             string            `yaml:"name"`
+	CreatedAt        time.Time         `yaml:"created_at"`
+	ValidUntil       *time.Time        `yaml:"valid_until,omitempty"`
+	MetadataDocument string            `yaml:"metadata_document"`
+	Tags             map[string]string `yaml:"tags,omitempty"`
+}
+
+func (p *IAMSAMLProvider) BuildArn(accountId string) string {
+	return fmt.Sprintf("arn:aws:iam::%s:saml-provider/%s", accountId, p.Name)
+}
+
+func noSuchSAMLProvider(arn string) error {
+	return &SenderFault{
+		Code_:    "NoSuchEntity",
+		Message_: fmt.Sprintf("SAMLProvider not found for arn %s", arn),
+	}
+}
+
+// samlMetadata is the part of a SAML metadata document the emulator reads.
+type samlMetadata struct {
+	XMLName    xml.Name
+	ValidUntil string `xml:"validUntil,attr"`
+}
+
+// parseSAMLMetadata checks that doc is an EntityDescriptor, and returns
+// the validUntil it carries, if any.
+func parseSAMLMetadata(doc string) (*time.Time, error) {
+	var md samlMetadata
+	if err := xml.Unmarshal([]byte(doc), &md); err != nil || md.XMLName.Local != "EntityDescriptor" {
+		return nil, &SenderFault{
+			Code_:    "InvalidInput",
+			Message_: "Could not parse metadata",
+		}
+	}
+	if md.ValidUntil == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, md.ValidUntil)
+	if err != nil {
+		return nil, &SenderFault{
+			Code_:    "InvalidInput",
+			Message_: fmt.Sprintf("Invalid validUntil %s in metadata", md.ValidUntil),
+		}
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+// validateOIDCProvider checks what CreateOpenIDConnectProvider and fixtures
+// give the same way.
+func validateOIDCProvider(p *IAMOpenIDConnectProvider) error {
+	if !strings.HasPrefix(p.Url, "https://") || len(p.Url) == len("https://") {
+		return &SenderFault{
+			Code_:    "InvalidInput",
+			Message_: fmt.Sprintf("Invalid OpenID Connect provider URL %s; it must begin with https://", p.Url),
+		}
+	}
+	if len(p.Thumbprints) > maxThumbprintsPerOIDCProvider {
+		return &SenderFault{
+			Code_:    "LimitExceeded",
+			Message_: fmt.Sprintf("Cannot exceed quota for ThumbprintsPerOpenIdConnectProvider: %d", maxThumbprintsPerOIDCProvider),
+		}
+	}
+	if len(p.ClientIds) > maxClientIdsPerOIDCProvider {
+		return &SenderFault{
+			Code_:    "LimitExceeded",
+			Message_: fmt.Sprintf("Cannot exceed quota for ClientIdsPerOpenIdConnectProvider: %d", maxClientIdsPerOIDCProvider),
+		}
+	}
+	for _, t := range p.Thumbprints {
+		if len(t) != 40 || strings.Trim(strings.ToLower(t), "0123456789abcdef") != "" {
+			return &SenderFault{
+				Code_:    "InvalidInput",
+				Message_: fmt.Sprintf("Thumbprint %s is not a 40 digit hex string", t),
+			}
+		}
+	}
+	return nil
+}
+
+func registerProviderHandlers(iamAPISet *APISet, reg IAMRegistry) {
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "CreateOpenIDConnectProvider",
+			Proto: CreateOpenIDConnectProviderInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*CreateOpenIDConnectProviderInput)
+				err = validateTags(params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				tags, err := mergeTags(nil, params.Tags, "TagsPerOpenIDConnectProvider")
+				if err != nil {
+					return nil, err
+				}
+				p := &IAMOpenIDConnectProvider{
+					Url:         *params.Url,
+					CreatedAt:   time.Now().UTC().Truncate(time.Second),
+					ClientIds:   params.ClientIDList,
+					Thumbprints: params.ThumbprintList,
+					Tags:        tags,
+				}
+				err = validateOIDCProvider(p)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.CreateOpenIDConnectProvider(p)
+				if err != nil {
+					return nil, err
+				}
+				out := &CreateOpenIDConnectProviderOutput{
+					OpenIDConnectProviderArn: aws.String(p.BuildArn(accountId)),
+				}
+				if len(p.Tags) > 0 {
+					out.Tags = buildTags(p.Tags)
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "GetOpenIDConnectProvider",
+			Proto: iam.GetOpenIDConnectProviderInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.GetOpenIDConnectProviderInput)
+				p, ok, err := reg.GetOpenIDConnectProvider(*params.OpenIDConnectProviderArn)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchOIDCProvider(*params.OpenIDConnectProviderArn)
+				}
+				out := &GetOpenIDConnectProviderOutput{
+					ClientIDList:   append([]string{}, p.ClientIds...),
+					CreateDate:     aws.Time(p.CreatedAt),
+					ThumbprintList: append([]string{}, p.Thumbprints...),
+					Url:            aws.String(oidcProviderKey(p)),
+				}
+				if len(p.Tags) > 0 {
+					out.Tags = buildTags(p.Tags)
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListOpenIDConnectProviders",
+			Proto: iam.ListOpenIDConnectProvidersInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				providers, err := reg.GetOpenIDConnectProviders()
+				if err != nil {
+					return nil, err
+				}
+				out := &iam.ListOpenIDConnectProvidersOutput{
+					OpenIDConnectProviderList: make([]iam.OpenIDConnectProviderListEntry, len(providers)),
+				}
+				for i, p := range providers {
+					out.OpenIDConnectProviderList[i] = iam.OpenIDConnectProviderListEntry{
+						Arn: aws.String(p.BuildArn(accountId)),
+					}
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "DeleteOpenIDConnectProvider",
+			Proto: iam.DeleteOpenIDConnectProviderInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.DeleteOpenIDConnectProviderInput)
+				err := reg.DeleteOpenIDConnectProvider(*params.OpenIDConnectProviderArn)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.DeleteOpenIDConnectProviderOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "CreateSAMLProvider",
+			Proto: CreateSAMLProviderInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*CreateSAMLProviderInput)
+				err = validateTags(params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				tags, err := mergeTags(nil, params.Tags, "TagsPerSAMLProvider")
+				if err != nil {
+					return nil, err
+				}
+				validUntil, err := parseSAMLMetadata(*params.SAMLMetadataDocument)
+				if err != nil {
+					return nil, err
+				}
+				p := &IAMSAMLProvider{
+					Name:             *params.Name,
+					CreatedAt:        time.Now().UTC().Truncate(time.Second),
+					ValidUntil:       validUntil,
+					MetadataDocument: *params.SAMLMetadataDocument,
+					Tags:             tags,
+				}
+				err = reg.CreateSAMLProvider(p)
+				if err != nil {
+					return nil, err
+				}
+				out := &CreateSAMLProviderOutput{
+					SAMLProviderArn: aws.String(p.BuildArn(accountId)),
+				}
+				if len(p.Tags) > 0 {
+					out.Tags = buildTags(p.Tags)
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "GetSAMLProvider",
+			Proto: iam.GetSAMLProviderInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.GetSAMLProviderInput)
+				p, ok, err := reg.GetSAMLProvider(*params.SAMLProviderArn)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchSAMLProvider(*params.SAMLProviderArn)
+				}
+				out := &GetSAMLProviderOutput{
+					CreateDate:           aws.Time(p.CreatedAt),
+					SAMLMetadataDocument: aws.String(p.MetadataDocument),
+					ValidUntil:           p.ValidUntil,
+				}
+				if len(p.Tags) > 0 {
+					out.Tags = buildTags(p.Tags)
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListSAMLProviders",
+			Proto: iam.ListSAMLProvidersInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				providers, err := reg.GetSAMLProviders()
+				if err != nil {
+					return nil, err
+				}
+				out := &iam.ListSAMLProvidersOutput{
+					SAMLProviderList: make([]iam.SAMLProviderListEntry, len(providers)),
+				}
+				for i, p := range providers {
+					out.SAMLProviderList[i] = iam.SAMLProviderListEntry{
+						Arn:        aws.String(p.BuildArn(accountId)),
+						CreateDate: aws.Time(p.CreatedAt),
+						ValidUntil: p.ValidUntil,
+					}
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "DeleteSAMLProvider",
+			Proto: iam.DeleteSAMLProviderInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.DeleteSAMLProviderInput)
+				err := reg.DeleteSAMLProvider(*params.SAMLProviderArn)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.DeleteSAMLProviderOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+}
+
+// oidcProviderKeyFromArn returns the key of the OpenID Connect provider arn
+// designates in the account.
+func oidcProviderKeyFromArn(arn, accountId string) (string, bool) {
+	prefix := fmt.Sprintf("arn:aws:iam::%s:oidc-provider/", accountId)
+	if !strings.HasPrefix(arn, prefix) {
+		return "", false
+	}
+	return arn[len(prefix):], true
+}
+
+// samlProviderNameFromArn returns the name of the SAML provider arn
+// designates in the account.
+func samlProviderNameFromArn(arn, accountId string) (string, bool) {
+	prefix := fmt.Sprintf("arn:aws:iam::%s:saml-provider/", accountId)
+	if !strings.HasPrefix(arn, prefix) {
+		return "", false
+	}
+	return arn[len(prefix):], true
+}
+
+func (reg *BasicIAMRegistry) GetOpenIDConnectProviders() ([]*IAMOpenIDConnectProvider, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.oidcProviders.values(), nil
+}
+
+func (reg *BasicIAMRegistry) GetOpenIDConnectProvider(arn string) (*IAMOpenIDConnectProvider, bool, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	key, ok := oidcProviderKeyFromArn(arn, reg.accountId())
+	if !ok {
+		return nil, false, nil
+	}
+	p, ok := reg.oidcProviders.get(key)
+	return p, ok, nil
+}
+
+func (reg *BasicIAMRegistry) CreateOpenIDConnectProvider(p *IAMOpenIDConnectProvider) error {
+	return reg.update(func(tx *registryTx) error {
+		if _, ok := tx.oidcProvider(oidcProviderKey(p)); ok {
+			return &SenderFault{
+				Code_:    "EntityAlreadyExists",
+				Message_: fmt.Sprintf("Provider with url %s already exists.", p.Url),
+			}
+		}
+		tx.putOIDCProvider(p)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) DeleteOpenIDConnectProvider(arn string) error {
+	return reg.update(func(tx *registryTx) error {
+		p, ok := tx.oidcProviderByArn(arn)
+		if !ok {
+			return noSuchOIDCProvider(arn)
+		}
+		tx.deleteOIDCProvider(oidcProviderKey(p))
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) GetSAMLProviders() ([]*IAMSAMLProvider, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.samlProviders.values(), nil
+}
+
+func (reg *BasicIAMRegistry) GetSAMLProvider(arn string) (*IAMSAMLProvider, bool, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	name, ok := samlProviderNameFromArn(arn, reg.accountId())
+	if !ok {
+		return nil, false, nil
+	}
+	p, ok := reg.samlProviders.get(name)
+	return p, ok, nil
+}
+
+func (reg *BasicIAMRegistry) CreateSAMLProvider(p *IAMSAMLProvider) error {
+	return reg.update(func(tx *registryTx) error {
+		if _, ok := tx.samlProvider(p.Name); ok {
+			return &SenderFault{
+				Code_:    "EntityAlreadyExists",
+				Message_: fmt.Sprintf("SAMLProvider %s already exists.", p.Name),
+			}
+		}
+		tx.putSAMLProvider(p)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) DeleteSAMLProvider(arn string) error {
+	return reg.update(func(tx *registryTx) error {
+		p, ok := tx.samlProviderByArn(arn)
+		if !ok {
+			return noSuchSAMLProvider(arn)
+		}
+		tx.deleteSAMLProvider(p.Name)
+		return nil
+	})
+}
+
+// oidcProviderByArn is GetOpenIDConnectProvider within a transaction.
+func (tx *registryTx) oidcProviderByArn(arn string) (*IAMOpenIDConnectProvider, bool) {
+	key, ok := oidcProviderKeyFromArn(arn, tx.reg.accountId())
+	if !ok {
+		return nil, false
+	}
+	return tx.oidcProvider(key)
+}
+
+// samlProviderByArn is GetSAMLProvider within a transaction.
+func (tx *registryTx) samlProviderByArn(arn string) (*IAMSAMLProvider, bool) {
+	name, ok := samlProviderNameFromArn(arn, tx.reg.accountId())
+	if !ok {
+		return nil, false
+	}
+	return tx.samlProvider(name)
+}
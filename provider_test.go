@@ -0,0 +1,101 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// testSAMLMetadata is padded to the least length the API accepts.
+var testSAMLMetadata = `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://idp.example.com" validUntil="2030-01-01T00:00:00Z"><!--` + strings.Repeat(" ", 1000) + `--></EntityDescriptor>`
+
+func TestOpenIDConnectProviderOperations(t *testing.T) {
+	svc, reg := newTestService(t, `
+open_id_connect_providers:
+  - url: https://token.example.com
+    client_ids: [sts.amazonaws.com]
+    thumbprints: [0123456789abcdef0123456789abcdef01234567]
+    tags: {env: test}
+`)
+	arn := "arn:aws:iam::000000000000:oidc-provider/token.example.com"
+	created := "arn:aws:iam::000000000000:oidc-provider/auth.example.com/path"
+	for _, c := range []struct {
+		params url.Values
+		status int
+		want   string
+	}{
+		{url.Values{"Action": {"GetOpenIDConnectProvider"}, "OpenIDConnectProviderArn": {arn}}, http.StatusOK, "<member>sts.amazonaws.com</member>"},
+		{url.Values{"Action": {"ListOpenIDConnectProviderTags"}, "OpenIDConnectProviderArn": {arn}}, http.StatusOK, "<Key>env</Key>"},
+		{url.Values{"Action": {"CreateOpenIDConnectProvider"}, "Url": {"http://auth.example.com"}, "ThumbprintList.member.1": {"0123456789abcdef0123456789abcdef01234567"}}, http.StatusBadRequest, "<Code>InvalidInput</Code>"},
+		{url.Values{"Action": {"CreateOpenIDConnectProvider"}, "Url": {"https://auth.example.com"}, "ThumbprintList.member.1": {"0123456789abcdef0123456789abcdef0123456z"}}, http.StatusBadRequest, "<Code>InvalidInput</Code>"},
+		{url.Values{"Action": {"CreateOpenIDConnectProvider"}, "Url": {"https://auth.example.com/path"}, "ThumbprintList.member.1": {"0123456789abcdef0123456789abcdef01234567"}, "Tags.member.1.Key": {"team"}, "Tags.member.1.Value": {"red"}}, http.StatusOK, "<OpenIDConnectProviderArn>" + created + "</OpenIDConnectProviderArn>"},
+		{url.Values{"Action": {"CreateOpenIDConnectProvider"}, "Url": {"https://auth.example.com/path"}, "ThumbprintList.member.1": {"0123456789abcdef0123456789abcdef01234567"}}, http.StatusConflict, "<Code>EntityAlreadyExists</Code>"},
+		{url.Values{"Action": {"ListOpenIDConnectProviders"}}, http.StatusOK, "<Arn>" + created + "</Arn>"},
+		{url.Values{"Action": {"TagOpenIDConnectProvider"}, "OpenIDConnectProviderArn": {created}, "Tags.member.1.Key": {"env"}, "Tags.member.1.Value": {"prod"}}, http.StatusOK, ""},
+		{url.Values{"Action": {"UntagOpenIDConnectProvider"}, "OpenIDConnectProviderArn": {created}, "TagKeys.member.1": {"team"}}, http.StatusOK, ""},
+		{url.Values{"Action": {"TagOpenIDConnectProvider"}, "OpenIDConnectProviderArn": {created + "x"}, "Tags.member.1.Key": {"env"}, "Tags.member.1.Value": {"prod"}}, http.StatusNotFound, "<Code>NoSuchEntity</Code>"},
+		{url.Values{"Action": {"DeleteOpenIDConnectProvider"}, "OpenIDConnectProviderArn": {arn}}, http.StatusOK, ""},
+		{url.Values{"Action": {"GetOpenIDConnectProvider"}, "OpenIDConnectProviderArn": {arn}}, http.StatusNotFound, "OpenIDConnect Provider not found for arn " + arn},
+	} {
+		w := query(t, svc, c.params)
+		if w.Code != c.status || !strings.Contains(w.Body.String(), c.want) {
+			t.Errorf("%v: got %d, want %d and %q:\n%s", c.params, w.Code, c.status, c.want, w.Body)
+		}
+	}
+	p, ok, err := reg.GetOpenIDConnectProvider(created)
+	if err != nil || !ok {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+	if len(p.Tags) != 1 || p.Tags["env"] != "prod" {
+		t.Errorf("got tags %v", p.Tags)
+	}
+}
+
+func TestSAMLProviderOperations(t *testing.T) {
+	svc, reg := newTestService(t, ``)
+	arn := "arn:aws:iam::000000000000:saml-provider/idp"
+	for _, c := range []struct {
+		params url.Values
+		status int
+		want   string
+	}{
+		{url.Values{"Action": {"CreateSAMLProvider"}, "Name": {"idp"}, "SAMLMetadataDocument": {"<not-metadata>" + strings.Repeat(" ", 1000) + "</not-metadata>"}}, http.StatusBadRequest, "<Code>InvalidInput</Code>"},
+		{url.Values{"Action": {"CreateSAMLProvider"}, "Name": {"idp"}, "SAMLMetadataDocument": {testSAMLMetadata}}, http.StatusOK, "<SAMLProviderArn>" + arn + "</SAMLProviderArn>"},
+		{url.Values{"Action": {"CreateSAMLProvider"}, "Name": {"idp"}, "SAMLMetadataDocument": {testSAMLMetadata}}, http.StatusConflict, "<Code>EntityAlreadyExists</Code>"},
+		{url.Values{"Action": {"GetSAMLProvider"}, "SAMLProviderArn": {arn}}, http.StatusOK, "<ValidUntil>2030-01-01T00:00:00Z</ValidUntil>"},
+		{url.Values{"Action": {"ListSAMLProviders"}}, http.StatusOK, "<Arn>" + arn + "</Arn>"},
+		{url.Values{"Action": {"TagSAMLProvider"}, "SAMLProviderArn": {arn}, "Tags.member.1.Key": {"env"}, "Tags.member.1.Value": {"prod"}}, http.StatusOK, ""},
+		{url.Values{"Action": {"ListSAMLProviderTags"}, "SAMLProviderArn": {arn}}, http.StatusOK, "<Value>prod</Value>"},
+		{url.Values{"Action": {"UntagSAMLProvider"}, "SAMLProviderArn": {arn}, "TagKeys.member.1": {"env"}}, http.StatusOK, ""},
+		{url.Values{"Action": {"DeleteSAMLProvider"}, "SAMLProviderArn": {arn}}, http.StatusOK, ""},
+		{url.Values{"Action": {"DeleteSAMLProvider"}, "SAMLProviderArn": {arn}}, http.StatusNotFound, "SAMLProvider not found for arn " + arn},
+	} {
+		w := query(t, svc, c.params)
+		if w.Code != c.status || !strings.Contains(w.Body.String(), c.want) {
+			t.Errorf("%v: got %d, want %d and %q:\n%s", c.params, w.Code, c.status, c.want, w.Body)
+		}
+	}
+	if providers, _ := reg.GetSAMLProviders(); len(providers) != 0 {
+		t.Errorf("got providers %v after deleting the provider", providers)
+	}
+}
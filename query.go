@@ -22,6 +22,7 @@ package main
 import (
 	"encoding/base64"
 	"fmt"
+	"math"
 	"net/url"
 	"reflect"
 	"sort"
@@ -48,6 +49,37 @@ func elemOf(value reflect.Value) reflect.Value {
 	return value
 }
 
+// hasParam reports whether the parameter name itself or any parameter nested
+// under it is present.
+func hasParam(v url.Values, name string) bool {
+	if _, ok := v[name]; ok {
+		return true
+	}
+	prefix := name + "."
+	for k := range v {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidParameterError reports a parameter whose value does not parse as
+// the type of its member.
+type invalidParameterError struct {
+	name  string
+	value string
+	err   error
+}
+
+func (e *invalidParameterError) Error() string {
+	return fmt.Sprintf("Value (%s) for parameter %s is invalid.", e.value, e.name)
+}
+
+func (e *invalidParameterError) Unwrap() error {
+	return e.err
+}
+
 type queryBuilder struct {
 	isEC2 bool
 }
@@ -56,6 +88,9 @@ func (q *queryBuilder) buildValue(value reflect.Value, prefix string, tag reflec
 	value = elemOf(value)
 
 	t := tag.Get("type")
+	if t == "" && value.Type() == timeType {
+		t = "timestamp"
+	}
 	if t == "" {
 		switch value.Kind() {
 		case reflect.Struct:
@@ -77,6 +112,9 @@ func (q *queryBuilder) buildValue(value reflect.Value, prefix string, tag reflec
 		err = q.buildMap(value, prefix, tag, v)
 	default:
 		err = q.buildScalar(value, prefix, tag, parentCollection, v)
+		if err != nil {
+			err = &invalidParameterError{name: prefix, value: v.Get(prefix), err: err}
+		}
 	}
 
 	return err
@@ -121,10 +159,9 @@ func (q *queryBuilder) buildStruct(value reflect.Value, prefix string, v url.Val
 			name = prefix + "." + name
 		}
 
-		required, _ := strconv.ParseBool(field.Tag.Get("required"))
-
-		_, ok := v[name]
-		if !ok && !required {
+		// absent members are left nil, which validateParams tells apart
+		// from empty ones
+		if !hasParam(v, name) {
 			continue
 		}
 
@@ -135,15 +172,39 @@ func (q *queryBuilder) buildStruct(value reflect.Value, prefix string, v url.Val
 	return nil
 }
 
-func (q *queryBuilder) buildList(value reflect.Value, prefix string, tag reflect.StructTag, v url.Values) error {
-	// If it's empty, generate an empty value
-	if v.Get(prefix) == "" {
-		if value.Type().Kind() == reflect.Slice {
-			value.Set(reflect.MakeSlice(value.Type(), 0, 0))
+// memberIndices returns the indices N of the parameters named prefix.N or
+// nested under it, in ascending order.  Indices are not required to be
+// contiguous; members are taken in the order of their indices.
+func memberIndices(v url.Values, prefix string) []int {
+	if prefix != "" {
+		prefix += "."
+	}
+	seen := map[int]bool{}
+	var indices []int
+	for k := range v {
+		if !strings.HasPrefix(k, prefix) {
+			continue
 		}
-		return nil
+		s, _, _ := strings.Cut(k[len(prefix):], ".")
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 1 || seen[i] {
+			continue
+		}
+		seen[i] = true
+		indices = append(indices, i)
 	}
+	sort.Ints(indices)
+	return indices
+}
 
+func joinParamName(prefix string, i int) string {
+	if prefix == "" {
+		return strconv.Itoa(i)
+	}
+	return prefix + "." + strconv.Itoa(i)
+}
+
+func (q *queryBuilder) buildList(value reflect.Value, prefix string, tag reflect.StructTag, v url.Values) error {
 	t := value.Type()
 	if t.Kind() == reflect.Array && t.Elem().Kind() == reflect.Uint8 {
 		return q.buildScalar(value, prefix, tag, true, v)
@@ -158,89 +219,107 @@ func (q *queryBuilder) buildList(value reflect.Value, prefix string, tag reflect
 		}
 	}
 
-	for i := 0; i < value.Len(); i++ {
-		slicePrefix := prefix
-		if slicePrefix == "" {
-			slicePrefix = strconv.Itoa(i + 1)
-		} else {
-			slicePrefix = slicePrefix + "." + strconv.Itoa(i+1)
-		}
-		if err := q.buildValue(value.Index(i), slicePrefix, "", true, v); err != nil {
+	// an empty list is sent as the bare parameter with an empty value
+	indices := memberIndices(v, prefix)
+	slice := reflect.MakeSlice(t, 0, len(indices))
+	for _, i := range indices {
+		elem := reflect.New(t.Elem())
+		if err := q.buildValue(elem, joinParamName(prefix, i), "", true, v); err != nil {
 			return err
 		}
+		slice = reflect.Append(slice, elem.Elem())
 	}
+	value.Set(slice)
 	return nil
 }
 
 func (q *queryBuilder) buildMap(value reflect.Value, prefix string, tag reflect.StructTag, v url.Values) error {
-	// If it's empty, generate an empty value
-	if v.Get(prefix) == "" {
-		value.Set(reflect.MakeMap(value.Type()))
-		return nil
-	}
-
-	// check for unflattened list member
+	// check for unflattened map entry
 	if !q.isEC2 && tag.Get("flattened") == "" {
 		prefix += ".entry"
 	}
 
-	// sort keys for improved serialization consistency.
-	// this is not strictly necessary for protocol support.
-	mapKeyValues := value.MapKeys()
-	mapKeys := map[string]reflect.Value{}
-	mapKeyNames := make([]string, len(mapKeyValues))
-	for i, mapKey := range mapKeyValues {
-		name := mapKey.String()
-		mapKeys[name] = mapKey
-		mapKeyNames[i] = name
+	kname := tag.Get("locationNameKey")
+	if kname == "" {
+		kname = "key"
+	}
+	vname := tag.Get("locationNameValue")
+	if vname == "" {
+		vname = "value"
 	}
-	sort.Strings(mapKeyNames)
-
-	for i, mapKeyName := range mapKeyNames {
-		mapKey := mapKeys[mapKeyName]
-		mapValue := reflect.New(value.Type().Elem())
-
-		kname := tag.Get("locationNameKey")
-		if kname == "" {
-			kname = "key"
-		}
-		vname := tag.Get("locationNameValue")
-		if vname == "" {
-			vname = "value"
-		}
 
-		// serialize key
-		var keyName string
-		if prefix == "" {
-			keyName = strconv.Itoa(i+1) + "." + kname
-		} else {
-			keyName = prefix + "." + strconv.Itoa(i+1) + "." + kname
-		}
+	t := value.Type()
+	m := reflect.MakeMap(t)
+	for _, i := range memberIndices(v, prefix) {
+		entryPrefix := joinParamName(prefix, i)
 
-		if err := q.buildValue(mapKey, keyName, "", true, v); err != nil {
+		mapKey := reflect.New(t.Key())
+		if err := q.buildValue(mapKey, entryPrefix+"."+kname, "", true, v); err != nil {
 			return err
 		}
 
-		// deserialize value
-		var valueName string
-		if prefix == "" {
-			valueName = strconv.Itoa(i+1) + "." + vname
-		} else {
-			valueName = prefix + "." + strconv.Itoa(i+1) + "." + vname
-		}
-
-		if err := q.buildValue(mapValue, valueName, "", true, v); err != nil {
-			return err
+		mapValue := reflect.New(t.Elem())
+		if hasParam(v, entryPrefix+"."+vname) {
+			if err := q.buildValue(mapValue, entryPrefix+"."+vname, "", true, v); err != nil {
+				return err
+			}
 		}
 
-		value.SetMapIndex(mapKey, mapValue)
+		m.SetMapIndex(mapKey.Elem(), mapValue.Elem())
 	}
-
+	value.Set(m)
 	return nil
 }
 
 var timeType = reflect.TypeOf(time.Time{})
 
+// iso8601TimeLayouts are the layouts accepted for iso8601 timestamps, which
+// may carry fractional seconds and a UTC offset, or no zone at all, in which
+// case they are taken for UTC.
+var iso8601TimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+}
+
+// rfc822TimeLayouts are the layouts accepted for rfc822 timestamps.
+var rfc822TimeLayouts = []string{
+	time.RFC1123,
+	time.RFC1123Z,
+	time.RFC822,
+	time.RFC822Z,
+}
+
+// parseTimestamp parses a timestamp parameter in the format a timestampFormat
+// tag selects; iso8601 is the default of the query protocol.
+func parseTimestamp(value, format string) (time.Time, error) {
+	if format == "" {
+		format = "iso8601"
+	}
+	var layouts []string
+	switch format {
+	case "unixTimestamp":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid unix timestamp %q", value)
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(math.Round(frac*1e9))).UTC(), nil
+	case "rfc822":
+		layouts = rfc822TimeLayouts
+	case "iso8601":
+		layouts = iso8601TimeLayouts
+	default:
+		return time.Time{}, fmt.Errorf("unsupported timestamp format %q", format)
+	}
+	for _, layout := range layouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid %s timestamp %q", format, value)
+}
+
 func (q *queryBuilder) buildScalar(r reflect.Value, name string, tag reflect.StructTag, parentCollection bool, v url.Values) error {
 	value := v.Get(name)
 	t := r.Type()
@@ -306,8 +385,8 @@ func (q *queryBuilder) buildScalar(r reflect.Value, name string, tag reflect.Str
 			return nil
 		}
 	case reflect.Struct:
-		if t.Elem() == timeType {
-			vv, err := time.Parse("2006-01-02T15:04:05Z", value)
+		if t == timeType {
+			vv, err := parseTimestamp(value, tag.Get("timestampFormat"))
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,272 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/private/protocol/query/queryutil"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// fillShape sets every member of a shape, giving lists and maps two
+// elements each, so that encoding it exercises every way a parameter can
+// be named.  seed tells the values of one member from those of another.
+func fillShape(v reflect.Value, seed string) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillShape(v.Elem(), seed)
+	case reflect.Struct:
+		if v.Type() == timeType {
+			v.Set(reflect.ValueOf(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)))
+			return
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" || f.Tag.Get("location") != "" {
+				continue
+			}
+			fillShape(v.Field(i), seed+"."+f.Name)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(seed))
+			return
+		}
+		s := reflect.MakeSlice(v.Type(), 2, 2)
+		for i := 0; i < 2; i++ {
+			fillShape(s.Index(i), seed+"."+string(rune('a'+i)))
+		}
+		v.Set(s)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for i := 0; i < 2; i++ {
+			k := reflect.New(v.Type().Key()).Elem()
+			fillShape(k, seed+".k"+string(rune('a'+i)))
+			e := reflect.New(v.Type().Elem()).Elem()
+			fillShape(e, seed+".v"+string(rune('a'+i)))
+			m.SetMapIndex(k, e)
+		}
+		v.Set(m)
+	case reflect.String:
+		v.SetString(seed)
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int64:
+		v.SetInt(int64(len(seed)))
+	case reflect.Float64:
+		v.SetFloat(float64(len(seed)) + 0.5)
+	}
+}
+
+// iamInputShapes returns the input shapes of every IAM operation, as told
+// by the Request methods of the client.
+func iamInputShapes() map[string]reflect.Type {
+	shapes := map[string]reflect.Type{}
+	t := reflect.TypeOf(&iam.Client{})
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		if !strings.HasSuffix(m.Name, "Request") || m.Type.NumIn() != 2 {
+			continue
+		}
+		in := m.Type.In(1)
+		if in.Kind() == reflect.Ptr && in.Elem().Kind() == reflect.Struct && strings.HasSuffix(in.Elem().Name(), "Input") {
+			shapes[strings.TrimSuffix(m.Name, "Request")] = in.Elem()
+		}
+	}
+	return shapes
+}
+
+// TestUnmarshalParamsFromSDKShapes has the SDK encode a fully populated
+// input of every IAM operation and checks that UnmarshalParams decodes it
+// back as it was.
+func TestUnmarshalParamsFromSDKShapes(t *testing.T) {
+	shapes := iamInputShapes()
+	if len(shapes) < 100 {
+		t.Fatalf("found only %d operations", len(shapes))
+	}
+	for name, shape := range shapes {
+		t.Run(name, func(t *testing.T) {
+			want := reflect.New(shape)
+			fillShape(want.Elem(), name)
+			v := url.Values{}
+			if err := queryutil.Parse(v, want.Interface(), false); err != nil {
+				t.Fatalf("the SDK failed to encode the input: %v", err)
+			}
+			got := reflect.New(shape)
+			if err := UnmarshalParams(got.Interface(), v, false); err != nil {
+				t.Fatalf("UnmarshalParams: %v\n%v", err, v)
+			}
+			if !reflect.DeepEqual(got.Interface(), want.Interface()) {
+				t.Errorf("got %+v\nwant %+v\nfrom %v", got.Elem(), want.Elem(), v)
+			}
+		})
+	}
+}
+
+type queryTestShape struct {
+	_         struct{}            `type:"structure"`
+	Flat      []string            `type:"list" flattened:"true"`
+	Named     []string            `type:"list" locationNameList:"item"`
+	Structs   []queryTestMember   `type:"list"`
+	FlatMap   map[string]string   `type:"map" flattened:"true"`
+	NamedMap  map[string]*string  `type:"map" locationNameKey:"name" locationNameValue:"val"`
+	NestedMap map[string][]string `type:"map"`
+	Renamed   *string             `locationName:"other" queryName:"Ec2Name" type:"string"`
+	Stamp     *time.Time          `type:"timestamp" timestampFormat:"unixTimestamp"`
+}
+
+type queryTestMember struct {
+	_     struct{}          `type:"structure"`
+	Key   *string           `type:"string"`
+	Inner []queryTestMember `type:"list"`
+}
+
+func TestUnmarshalParams(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		form  string
+		isEC2 bool
+		want  queryTestShape
+	}{
+		{
+			name: "flattened list",
+			form: "Flat.1=a&Flat.2=b",
+			want: queryTestShape{Flat: []string{"a", "b"}},
+		},
+		{
+			name: "indices need not be contiguous",
+			form: "Flat.3=c&Flat.1=a&Flat.10=j",
+			want: queryTestShape{Flat: []string{"a", "c", "j"}},
+		},
+		{
+			name: "list with a member name",
+			form: "Named.item.1=a",
+			want: queryTestShape{Named: []string{"a"}},
+		},
+		{
+			name: "empty list",
+			form: "Named=",
+			want: queryTestShape{Named: []string{}},
+		},
+		{
+			name: "nested structures",
+			form: "Structs.member.1.Key=a&Structs.member.1.Inner.member.1.Key=b&Structs.member.2.Key=c",
+			want: queryTestShape{Structs: []queryTestMember{
+				{Key: aws.String("a"), Inner: []queryTestMember{{Key: aws.String("b")}}},
+				{Key: aws.String("c")},
+			}},
+		},
+		{
+			name: "flattened map",
+			form: "FlatMap.1.key=k&FlatMap.1.value=v",
+			want: queryTestShape{FlatMap: map[string]string{"k": "v"}},
+		},
+		{
+			name: "map with key and value names",
+			form: "NamedMap.entry.1.name=k&NamedMap.entry.1.val=v&NamedMap.entry.2.name=absent",
+			want: queryTestShape{NamedMap: map[string]*string{"k": aws.String("v"), "absent": nil}},
+		},
+		{
+			name: "map of lists",
+			form: "NestedMap.entry.1.key=k&NestedMap.entry.1.value.member.1=a&NestedMap.entry.1.value.member.2=b",
+			want: queryTestShape{NestedMap: map[string][]string{"k": {"a", "b"}}},
+		},
+		{
+			name: "location name",
+			form: "other=x&Stamp=1577934245",
+			want: queryTestShape{Renamed: aws.String("x"), Stamp: aws.Time(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))},
+		},
+		{
+			name:  "EC2 style",
+			form:  "Ec2Name=x&Named.1=a&Structs.1.Key=k&NamedMap.1.name=k&NamedMap.1.val=v",
+			isEC2: true,
+			want: queryTestShape{
+				Renamed:  aws.String("x"),
+				Named:    []string{"a"},
+				Structs:  []queryTestMember{{Key: aws.String("k")}},
+				NamedMap: map[string]*string{"k": aws.String("v")},
+			},
+		},
+	} {
+		v, err := url.ParseQuery(c.form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got queryTestShape
+		if err := UnmarshalParams(&got, v, c.isEC2); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got.Stamp != nil {
+			*got.Stamp = got.Stamp.UTC()
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, c := range []struct {
+		value, format string
+		want          time.Time
+	}{
+		{"2020-01-02T03:04:05Z", "", want},
+		{"2020-01-02T03:04:05Z", "iso8601", want},
+		{"2020-01-02T03:04:05.25Z", "iso8601", want.Add(250 * time.Millisecond)},
+		{"2020-01-02T12:04:05+09:00", "iso8601", want},
+		{"2020-01-02T03:04:05", "iso8601", want},
+		{"1577934245", "unixTimestamp", want},
+		{"1577934245.5", "unixTimestamp", want.Add(500 * time.Millisecond)},
+		{"Thu, 02 Jan 2020 03:04:05 GMT", "rfc822", want},
+		{"Thu, 02 Jan 2020 12:04:05 +0900", "rfc822", want},
+		{"02 Jan 20 03:04 UTC", "rfc822", want.Add(-5 * time.Second)},
+	} {
+		got, err := parseTimestamp(c.value, c.format)
+		if err != nil || !got.Equal(c.want) || got.Location() != time.UTC {
+			t.Errorf("%s (%s): got %v, %v, want %v", c.value, c.format, got, err, c.want)
+		}
+	}
+	for _, c := range []struct{ value, format string }{
+		{"2020-01-02", "iso8601"},
+		{"yesterday", "unixTimestamp"},
+		{"2020-01-02T03:04:05Z", "rfc822"},
+		{"1577934245", "epoch"},
+	} {
+		if _, err := parseTimestamp(c.value, c.format); err == nil {
+			t.Errorf("%s (%s) was accepted", c.value, c.format)
+		}
+	}
+	// a timestamp that does not parse is an invalid parameter
+	var shape queryTestShape
+	err := UnmarshalParams(&shape, url.Values{"Stamp": {"soon"}}, false)
+	if err == nil || err.Error() != "Value (soon) for parameter Stamp is invalid." {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,736 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/goccy/go-yaml"
+)
+
+// The buckets of the store, whose records are the YAML of the entities in
+// the form the fixture gives them.
+const (
+	usersBucket            = "users"
+	groupsBucket           = "groups"
+	rolesBucket            = "roles"
+	instanceProfilesBucket = "instance_profiles"
+	policiesBucket         = "policies"
+	oidcProvidersBucket    = "open_id_connect_providers"
+	samlProvidersBucket    = "saml_providers"
+	mfaDevicesBucket       = "virtual_mfa_devices"
+	serverCertsBucket      = "server_certificates"
+	accountBucket          = "account"
+	metaBucket             = "meta"
+
+	accountKey          = "account"
+	credentialReportKey = "credential_report"
+	// formatKey marks a store that has been populated.
+	formatKey     = "format"
+	storeFormatV1 = "1"
+)
+
+type groupRecord struct {
+	IAMGroup `yaml:",inline"`
+	Members  []string `yaml:"members,omitempty"`
+}
+
+type instanceProfileRecord struct {
+	IAMInstanceProfile `yaml:",inline"`
+	Roles              []string `yaml:"roles,omitempty"`
+}
+
+func newBasicIAMRegistry(store Store) *BasicIAMRegistry {
+	return &BasicIAMRegistry{
+		groups:           newOrderedIndex[*IAMGroup](),
+		users:            newOrderedIndex[*IAMUser](),
+		roles:            newOrderedIndex[*IAMRole](),
+		instanceProfiles: newOrderedIndex[*IAMInstanceProfile](),
+		policies:         newOrderedIndex[*IAMPolicy](),
+		oidcProviders:    newOrderedIndex[*IAMOpenIDConnectProvider](),
+		samlProviders:    newOrderedIndex[*IAMSAMLProvider](),
+		mfaDevices:       newOrderedIndex[*IAMVirtualMFADevice](),
+		serverCerts:      newOrderedIndex[*IAMServerCertificate](),
+		ids:              NewIDAllocator(idSeed),
+		store:            store,
+	}
+}
+
+// openRegistry returns the registry kept in store.  A store that has never
+// been populated is seeded from the fixture, if any; the fixture is ignored
+// for a store that has.
+func openRegistry(store Store, fixture *fixture) (*BasicIAMRegistry, error) {
+	var populated bool
+	err := store.View(func(tx StoreTx) error {
+		var err error
+		_, populated, err = tx.Get(metaBucket, formatKey)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if populated {
+		if fixture != nil {
+			logger.Info("the store is already populated; the fixture is ignored")
+		}
+		return loadRegistry(store)
+	}
+	reg := newBasicIAMRegistry(store)
+	if fixture != nil {
+		reg, err = buildRegistry(fixture)
+		if err != nil {
+			return nil, err
+		}
+		reg.store = store
+	}
+	return reg, reg.persistAll()
+}
+
+// persistAll writes every entity of the registry to its store.
+func (reg *BasicIAMRegistry) persistAll() error {
+	return reg.update(func(tx *registryTx) error {
+		tx.putAll(reg)
+		return nil
+	})
+}
+
+// putAll stages every entity of src, along with the mark of a populated
+// store.
+func (tx *registryTx) putAll(src *BasicIAMRegistry) {
+	for _, p := range src.policies.values() {
+		tx.putPolicy(p)
+	}
+	for _, u := range src.users.values() {
+		tx.putUser(u)
+	}
+	for _, g := range src.groups.values() {
+		tx.putGroup(g)
+	}
+	for _, r := range src.roles.values() {
+		tx.putRole(r)
+	}
+	for _, p := range src.instanceProfiles.values() {
+		tx.putInstanceProfile(p)
+	}
+	for _, p := range src.oidcProviders.values() {
+		tx.putOIDCProvider(p)
+	}
+	for _, p := range src.samlProviders.values() {
+		tx.putSAMLProvider(p)
+	}
+	for _, d := range src.mfaDevices.values() {
+		tx.putMFADevice(d)
+	}
+	for _, c := range src.serverCerts.values() {
+		tx.putServerCertificate(c)
+	}
+	tx.putAccount(src.account)
+	if src.credentialReport != nil {
+		tx.putCredentialReport(src.credentialReport)
+	}
+	tx.ops = append(tx.ops, registryOp{
+		persist: func(stx StoreTx) error {
+			return stx.Put(metaBucket, formatKey, []byte(storeFormatV1))
+		},
+	})
+}
+
+// replace swaps the whole state of the registry for that of next, in the
+// store and in memory at once, and returns the state it had before.
+func (reg *BasicIAMRegistry) replace(next *BasicIAMRegistry) (*fixture, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	prev := reg.export()
+	tx := newRegistryTx(next)
+	tx.putAll(next)
+	err := reg.store.Update(func(stx StoreTx) error {
+		for _, bucket := range []string{usersBucket, groupsBucket, rolesBucket, instanceProfilesBucket, policiesBucket, oidcProvidersBucket, samlProvidersBucket, mfaDevicesBucket, serverCertsBucket, accountBucket} {
+			if err := clearBucket(stx, bucket); err != nil {
+				return err
+			}
+		}
+		for _, op := range tx.ops {
+			if err := op.persist(stx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	reg.users = next.users
+	reg.groups = next.groups
+	reg.roles = next.roles
+	reg.instanceProfiles = next.instanceProfiles
+	reg.policies = next.policies
+	reg.oidcProviders = next.oidcProviders
+	reg.samlProviders = next.samlProviders
+	reg.mfaDevices = next.mfaDevices
+	reg.serverCerts = next.serverCerts
+	reg.account = next.account
+	reg.ids = next.ids
+	reg.credentialReport = next.credentialReport
+	return prev, nil
+}
+
+func clearBucket(stx StoreTx, bucket string) error {
+	var keys []string
+	err := stx.ForEach(bucket, func(key string, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := stx.Delete(bucket, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadRegistry builds a registry out of the records of a populated store.
+func loadRegistry(store Store) (*BasicIAMRegistry, error) {
+	reg := newBasicIAMRegistry(store)
+	err := store.View(func(tx StoreTx) error {
+		b, _, err := tx.Get(metaBucket, formatKey)
+		if err != nil {
+			return err
+		}
+		if string(b) != storeFormatV1 {
+			return fmt.Errorf("unsupported store format %s", b)
+		}
+		err = tx.ForEach(policiesBucket, func(key string, value []byte) error {
+			p := &IAMPolicy{}
+			if err := yaml.Unmarshal(value, p); err != nil {
+				return fmt.Errorf("policy %s: %w", key, err)
+			}
+			reg.policies.put(policyKey(p), p)
+			return reg.ids.Reserve(p.Id)
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(usersBucket, func(key string, value []byte) error {
+			u := &IAMUser{}
+			if err := yaml.Unmarshal(value, u); err != nil {
+				return fmt.Errorf("user %s: %w", key, err)
+			}
+			reg.users.put(u.Name, u)
+			return reg.ids.Reserve(u.Id)
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(rolesBucket, func(key string, value []byte) error {
+			r := &IAMRole{}
+			if err := yaml.Unmarshal(value, r); err != nil {
+				return fmt.Errorf("role %s: %w", key, err)
+			}
+			reg.roles.put(r.Name, r)
+			return reg.ids.Reserve(r.Id)
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(groupsBucket, func(key string, value []byte) error {
+			rec := &groupRecord{}
+			if err := yaml.Unmarshal(value, rec); err != nil {
+				return fmt.Errorf("group %s: %w", key, err)
+			}
+			for _, m := range rec.Members {
+				u, ok := reg.users.get(m)
+				if !ok {
+					return fmt.Errorf("unknown user %s among the members of %s", m, rec.Name)
+				}
+				rec.IAMGroup.Members = append(rec.IAMGroup.Members, u)
+			}
+			reg.groups.put(rec.Name, &rec.IAMGroup)
+			return reg.ids.Reserve(rec.Id)
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(instanceProfilesBucket, func(key string, value []byte) error {
+			rec := &instanceProfileRecord{}
+			if err := yaml.Unmarshal(value, rec); err != nil {
+				return fmt.Errorf("instance profile %s: %w", key, err)
+			}
+			for _, n := range rec.Roles {
+				r, ok := reg.roles.get(n)
+				if !ok {
+					return fmt.Errorf("unknown role %s among the roles of %s", n, rec.Name)
+				}
+				rec.IAMInstanceProfile.Roles = append(rec.IAMInstanceProfile.Roles, r)
+			}
+			reg.instanceProfiles.put(rec.Name, &rec.IAMInstanceProfile)
+			return reg.ids.Reserve(rec.Id)
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(oidcProvidersBucket, func(key string, value []byte) error {
+			p := &IAMOpenIDConnectProvider{}
+			if err := yaml.Unmarshal(value, p); err != nil {
+				return fmt.Errorf("OpenID Connect provider %s: %w", key, err)
+			}
+			reg.oidcProviders.put(oidcProviderKey(p), p)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(samlProvidersBucket, func(key string, value []byte) error {
+			p := &IAMSAMLProvider{}
+			if err := yaml.Unmarshal(value, p); err != nil {
+				return fmt.Errorf("SAML provider %s: %w", key, err)
+			}
+			reg.samlProviders.put(p.Name, p)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(mfaDevicesBucket, func(key string, value []byte) error {
+			d := &IAMVirtualMFADevice{}
+			if err := yaml.Unmarshal(value, d); err != nil {
+				return fmt.Errorf("virtual MFA device %s: %w", key, err)
+			}
+			reg.mfaDevices.put(d.Name, d)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(serverCertsBucket, func(key string, value []byte) error {
+			c := &IAMServerCertificate{}
+			if err := yaml.Unmarshal(value, c); err != nil {
+				return fmt.Errorf("server certificate %s: %w", key, err)
+			}
+			reg.serverCerts.put(c.Name, c)
+			return reg.ids.Reserve(c.Id)
+		})
+		if err != nil {
+			return err
+		}
+		if b, ok, err := tx.Get(accountBucket, accountKey); err != nil {
+			return err
+		} else if ok {
+			if err := yaml.Unmarshal(b, &reg.account); err != nil {
+				return fmt.Errorf("account: %w", err)
+			}
+		}
+		if b, ok, err := tx.Get(accountBucket, credentialReportKey); err != nil {
+			return err
+		} else if ok {
+			reg.credentialReport = &IAMCredentialReport{}
+			if err := yaml.Unmarshal(b, reg.credentialReport); err != nil {
+				return fmt.Errorf("credential report: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("loaded the registry from the store", slog.Int("nUsers", reg.users.len()), slog.Int("nGroups", reg.groups.len()), slog.Int("nRoles", reg.roles.len()), slog.Int("nInstanceProfiles", reg.instanceProfiles.len()), slog.Int("nPolicies", reg.policies.len()))
+	return reg, nil
+}
+
+// registryOp is a change staged in a registryTx: persist writes it to the
+// store and apply makes it to the in-memory indexes.
+type registryOp struct {
+	persist func(StoreTx) error
+	apply   func()
+}
+
+// registryTx is a transaction over the registry.  Changes are staged on it
+// and are visible to the lookups of the same transaction, but to nothing
+// else until the transaction commits.
+type registryTx struct {
+	reg              *BasicIAMRegistry
+	users            stagedIndex[*IAMUser]
+	groups           stagedIndex[*IAMGroup]
+	roles            stagedIndex[*IAMRole]
+	instanceProfiles stagedIndex[*IAMInstanceProfile]
+	policies         stagedIndex[*IAMPolicy]
+	oidcProviders    stagedIndex[*IAMOpenIDConnectProvider]
+	samlProviders    stagedIndex[*IAMSAMLProvider]
+	mfaDevices       stagedIndex[*IAMVirtualMFADevice]
+	serverCerts      stagedIndex[*IAMServerCertificate]
+	ops              []registryOp
+	// the users and roles that were replaced, by their previous version,
+	// whose referrers are relinked once the transaction is applied
+	replacedUsers map[*IAMUser]*IAMUser
+	replacedRoles map[*IAMRole]*IAMRole
+	// the IDs allocated in the transaction, which are given back if it fails
+	allocated []string
+}
+
+func newRegistryTx(reg *BasicIAMRegistry) *registryTx {
+	return &registryTx{
+		reg:              reg,
+		users:            stagedIndex[*IAMUser]{index: reg.users},
+		groups:           stagedIndex[*IAMGroup]{index: reg.groups},
+		roles:            stagedIndex[*IAMRole]{index: reg.roles},
+		instanceProfiles: stagedIndex[*IAMInstanceProfile]{index: reg.instanceProfiles},
+		policies:         stagedIndex[*IAMPolicy]{index: reg.policies},
+		oidcProviders:    stagedIndex[*IAMOpenIDConnectProvider]{index: reg.oidcProviders},
+		samlProviders:    stagedIndex[*IAMSAMLProvider]{index: reg.samlProviders},
+		mfaDevices:       stagedIndex[*IAMVirtualMFADevice]{index: reg.mfaDevices},
+		serverCerts:      stagedIndex[*IAMServerCertificate]{index: reg.serverCerts},
+	}
+}
+
+// update runs fn as one transaction: the changes it stages are written to
+// the store all at once, and made to the in-memory indexes only once the
+// store has committed them.  If fn or the store fails, nothing changes.
+func (reg *BasicIAMRegistry) update(fn func(tx *registryTx) error) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	tx := newRegistryTx(reg)
+	err := fn(tx)
+	if err == nil {
+		err = reg.store.Update(func(stx StoreTx) error {
+			for _, op := range tx.ops {
+				if err := op.persist(stx); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		for _, id := range tx.allocated {
+			reg.ids.Release(id)
+		}
+		return err
+	}
+	for _, op := range tx.ops {
+		if op.apply != nil {
+			op.apply()
+		}
+	}
+	tx.relink()
+	return nil
+}
+
+// allocateId allocates an ID that is given back should the transaction fail.
+func (tx *registryTx) allocateId(prefix, name string) string {
+	id := tx.reg.ids.Allocate(prefix, name)
+	tx.allocated = append(tx.allocated, id)
+	return id
+}
+
+// relink points the groups and instance profiles that refer to a user or a
+// role the transaction replaced at the new version.  Like the entities
+// themselves, they are replaced rather than changed in place, as readers
+// may still hold them.
+func (tx *registryTx) relink() {
+	if len(tx.replacedUsers) > 0 {
+		for _, g := range tx.reg.groups.values() {
+			if members, ok := relinked(g.Members, tx.replacedUsers); ok {
+				ng := *g
+				ng.Members = members
+				tx.reg.groups.put(g.Name, &ng)
+			}
+		}
+	}
+	if len(tx.replacedRoles) > 0 {
+		for _, p := range tx.reg.instanceProfiles.values() {
+			if roles, ok := relinked(p.Roles, tx.replacedRoles); ok {
+				np := *p
+				np.Roles = roles
+				tx.reg.instanceProfiles.put(p.Name, &np)
+			}
+		}
+	}
+}
+
+// relinked returns a copy of list with the replaced entities swapped for
+// their latest version, and whether there were any.
+func relinked[E comparable](list []E, replaced map[E]E) ([]E, bool) {
+	var result []E
+	for i, v := range list {
+		nv, ok := replaced[v]
+		if !ok {
+			continue
+		}
+		// an entity may have been replaced more than once
+		for next, ok := replaced[nv]; ok; next, ok = replaced[nv] {
+			nv = next
+		}
+		if result == nil {
+			result = append([]E(nil), list...)
+		}
+		result[i] = nv
+	}
+	return result, result != nil
+}
+
+func (tx *registryTx) user(name string) (*IAMUser, bool) {
+	return tx.users.get(name)
+}
+
+func (tx *registryTx) role(name string) (*IAMRole, bool) {
+	return tx.roles.get(name)
+}
+
+func (tx *registryTx) instanceProfile(name string) (*IAMInstanceProfile, bool) {
+	return tx.instanceProfiles.get(name)
+}
+
+func (tx *registryTx) policy(key string) (*IAMPolicy, bool) {
+	return tx.policies.get(key)
+}
+
+func (tx *registryTx) oidcProvider(key string) (*IAMOpenIDConnectProvider, bool) {
+	return tx.oidcProviders.get(key)
+}
+
+func (tx *registryTx) samlProvider(name string) (*IAMSAMLProvider, bool) {
+	return tx.samlProviders.get(name)
+}
+
+func (tx *registryTx) mfaDevice(name string) (*IAMVirtualMFADevice, bool) {
+	return tx.mfaDevices.get(name)
+}
+
+func (tx *registryTx) serverCertificate(name string) (*IAMServerCertificate, bool) {
+	return tx.serverCerts.get(name)
+}
+
+func (tx *registryTx) putUser(u *IAMUser) {
+	tx.users.stage(u.Name, u)
+	tx.ops = append(tx.ops, registryOp{
+		persist: func(stx StoreTx) error {
+			return putRecord(stx, usersBucket, u.Name, u)
+		},
+		apply: func() {
+			if old, ok := tx.reg.users.get(u.Name); ok && old != u {
+				if tx.replacedUsers == nil {
+					tx.replacedUsers = make(map[*IAMUser]*IAMUser)
+				}
+				tx.replacedUsers[old] = u
+			}
+			tx.reg.users.put(u.Name, u)
+		},
+	})
+}
+
+func (tx *registryTx) putGroup(g *IAMGroup) {
+	tx.groups.stage(g.Name, g)
+	rec := &groupRecord{IAMGroup: *g, Members: make([]string, len(g.Members))}
+	for i, u := range g.Members {
+		rec.Members[i] = u.Name
+	}
+	tx.ops = append(tx.ops, registryOp{
+		persist: func(stx StoreTx) error {
+			return putRecord(stx, groupsBucket, g.Name, rec)
+		},
+		apply: func() {
+			tx.reg.groups.put(g.Name, g)
+		},
+	})
+}
+
+func (tx *registryTx) putRole(r *IAMRole) {
+	tx.roles.stage(r.Name, r)
+	tx.ops = append(tx.ops, registryOp{
+		persist: func(stx StoreTx) error {
+			return putRecord(stx, rolesBucket, r.Name, r)
+		},
+		apply: func() {
+			if old, ok := tx.reg.roles.get(r.Name); ok && old != r {
+				if tx.replacedRoles == nil {
+					tx.replacedRoles = make(map[*IAMRole]*IAMRole)
+				}
+				tx.replacedRoles[old] = r
+			}
+			tx.reg.roles.put(r.Name, r)
+		},
+	})
+}
+
+func (tx *registryTx) putInstanceProfile(p *IAMInstanceProfile) {
+	tx.instanceProfiles.stage(p.Name, p)
+	rec := &instanceProfileRecord{IAMInstanceProfile: *p, Roles: make([]string, len(p.Roles))}
+	for i, r := range p.Roles {
+		rec.Roles[i] = r.Name
+	}
+	tx.ops = append(tx.ops, registryOp{
+		persist: func(stx StoreTx) error {
+			return putRecord(stx, instanceProfilesBucket, p.Name, rec)
+		},
+		apply: func() {
+			tx.reg.instanceProfiles.put(p.Name, p)
+		},
+	})
+}
+
+func (tx *registryTx) putPolicy(p *IAMPolicy) {
+	key := policyKey(p)
+	tx.policies.stage(key, p)
+	tx.ops = append(tx.ops, registryOp{
+		persist: func(stx StoreTx) error {
+			return putRecord(stx, policiesBucket, key, p)
+		},
+		apply: func() {
+			tx.reg.policies.put(key, p)
+		},
+	})
+}
+
+func (tx *registryTx) deleteInstanceProfile(name string) {
+	tx.instanceProfiles.stageDelete(name)
+	tx.ops = append(tx.ops, deleteOp(instanceProfilesBucket, name, tx.reg.instanceProfiles))
+}
+
+func (tx *registryTx) putOIDCProvider(p *IAMOpenIDConnectProvider) {
+	key := oidcProviderKey(p)
+	tx.oidcProviders.stage(key, p)
+	tx.ops = append(tx.ops, putOp(oidcProvidersBucket, key, p, tx.reg.oidcProviders))
+}
+
+func (tx *registryTx) deleteOIDCProvider(key string) {
+	tx.oidcProviders.stageDelete(key)
+	tx.ops = append(tx.ops, deleteOp(oidcProvidersBucket, key, tx.reg.oidcProviders))
+}
+
+func (tx *registryTx) putSAMLProvider(p *IAMSAMLProvider) {
+	tx.samlProviders.stage(p.Name, p)
+	tx.ops = append(tx.ops, putOp(samlProvidersBucket, p.Name, p, tx.reg.samlProviders))
+}
+
+func (tx *registryTx) deleteSAMLProvider(name string) {
+	tx.samlProviders.stageDelete(name)
+	tx.ops = append(tx.ops, deleteOp(samlProvidersBucket, name, tx.reg.samlProviders))
+}
+
+func (tx *registryTx) putMFADevice(d *IAMVirtualMFADevice) {
+	tx.mfaDevices.stage(d.Name, d)
+	tx.ops = append(tx.ops, putOp(mfaDevicesBucket, d.Name, d, tx.reg.mfaDevices))
+}
+
+func (tx *registryTx) deleteMFADevice(name string) {
+	tx.mfaDevices.stageDelete(name)
+	tx.ops = append(tx.ops, deleteOp(mfaDevicesBucket, name, tx.reg.mfaDevices))
+}
+
+func (tx *registryTx) putServerCertificate(c *IAMServerCertificate) {
+	tx.serverCerts.stage(c.Name, c)
+	tx.ops = append(tx.ops, putOp(serverCertsBucket, c.Name, c, tx.reg.serverCerts))
+}
+
+func (tx *registryTx) deleteServerCertificate(name string) {
+	tx.serverCerts.stageDelete(name)
+	tx.ops = append(tx.ops, deleteOp(serverCertsBucket, name, tx.reg.serverCerts))
+}
+
+func (tx *registryTx) putAccount(a IAMAccount) {
+	tx.ops = append(tx.ops, registryOp{
+		persist: func(stx StoreTx) error {
+			return putRecord(stx, accountBucket, accountKey, a)
+		},
+		apply: func() {
+			tx.reg.account = a
+		},
+	})
+}
+
+func (tx *registryTx) putCredentialReport(report *IAMCredentialReport) {
+	tx.ops = append(tx.ops, registryOp{
+		persist: func(stx StoreTx) error {
+			return putRecord(stx, accountBucket, credentialReportKey, report)
+		},
+		apply: func() {
+			tx.reg.credentialReport = report
+		},
+	})
+}
+
+func putRecord(stx StoreTx, bucket, key string, v interface{}) error {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", bucket, key, err)
+	}
+	return stx.Put(bucket, key, b)
+}
+
+// putOp stores v under key, for the entities that nothing refers to by
+// pointer.
+func putOp[T any](bucket, key string, v T, index *orderedIndex[T]) registryOp {
+	return registryOp{
+		persist: func(stx StoreTx) error {
+			return putRecord(stx, bucket, key, v)
+		},
+		apply: func() {
+			index.put(key, v)
+		},
+	}
+}
+
+func deleteOp[T any](bucket, name string, index *orderedIndex[T]) registryOp {
+	return registryOp{
+		persist: func(stx StoreTx) error {
+			return stx.Delete(bucket, name)
+		},
+		apply: func() {
+			index.delete(name)
+		},
+	}
+}
+
+// stagedIndex overlays the changes staged in a transaction on an index.
+type stagedIndex[T any] struct {
+	index   *orderedIndex[T]
+	changes map[string]T
+	deleted map[string]bool
+}
+
+func (s *stagedIndex[T]) get(name string) (T, bool) {
+	if s.deleted[name] {
+		var zero T
+		return zero, false
+	}
+	if v, ok := s.changes[name]; ok {
+		return v, true
+	}
+	return s.index.get(name)
+}
+
+func (s *stagedIndex[T]) stage(name string, v T) {
+	if s.changes == nil {
+		s.changes = make(map[string]T)
+	}
+	delete(s.deleted, name)
+	s.changes[name] = v
+}
+
+func (s *stagedIndex[T]) stageDelete(name string) {
+	if s.deleted == nil {
+		s.deleted = make(map[string]bool)
+	}
+	delete(s.changes, name)
+	s.deleted[name] = true
+}
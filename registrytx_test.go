@@ -0,0 +1,230 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+const registryTxFixture = `
+users:
+  - name: alice
+groups:
+  - name: admins
+    members: [alice]
+roles:
+  - name: web
+    instance_profile: {}
+`
+
+// failingStore is a MemoryStore whose read-write transactions fail.
+type failingStore struct {
+	*MemoryStore
+}
+
+func (s failingStore) Update(fn func(StoreTx) error) error {
+	return errors.New("the store is broken")
+}
+
+func TestUpdateReplacesEntities(t *testing.T) {
+	reg := newTestRegistry(t, registryTxFixture)
+	before, _, _ := reg.GetUserByName("alice")
+	err := reg.TagUser("alice", []iam.Tag{{Key: aws.String("team"), Value: aws.String("a")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before.Tags) != 0 {
+		t.Errorf("the user held by a reader was changed: %v", before.Tags)
+	}
+	after, _, _ := reg.GetUserByName("alice")
+	if after == before || after.Tags["team"] != "a" {
+		t.Errorf("got %p %v, want a new user tagged team=a", after, after.Tags)
+	}
+}
+
+func TestUpdateRelinksReferrers(t *testing.T) {
+	reg := newTestRegistry(t, registryTxFixture)
+	groupBefore, _, _ := reg.GetGroupByName("admins")
+	profileBefore, _, _ := reg.GetInstanceProfileByName("web")
+	if err := reg.TagUser("alice", []iam.Tag{{Key: aws.String("k"), Value: aws.String("v")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.TagRole("web", []iam.Tag{{Key: aws.String("k"), Value: aws.String("v")}}); err != nil {
+		t.Fatal(err)
+	}
+	// a second update of the same entity
+	if err := reg.TagRole("web", []iam.Tag{{Key: aws.String("k2"), Value: aws.String("v")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	u, _, _ := reg.GetUserByName("alice")
+	g, _, _ := reg.GetGroupByName("admins")
+	if g == groupBefore {
+		t.Error("the group was changed in place")
+	}
+	if len(g.Members) != 1 || g.Members[0] != u {
+		t.Errorf("the group does not refer to the latest user")
+	}
+	if groupBefore.Members[0] == u {
+		t.Errorf("the group held by a reader was changed")
+	}
+
+	r, _, _ := reg.GetRoleByName("web")
+	p, _, _ := reg.GetInstanceProfileByName("web")
+	if len(p.Roles) != 1 || p.Roles[0] != r || len(p.Roles[0].Tags) != 2 {
+		t.Errorf("the instance profile does not refer to the latest role")
+	}
+	if len(profileBefore.Roles[0].Tags) != 0 {
+		t.Errorf("the role held by a reader was changed")
+	}
+}
+
+func TestUpdateDoesNotRaceWithReaders(t *testing.T) {
+	reg := newTestRegistry(t, registryTxFixture)
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			u, _, _ := reg.GetUserByName("alice")
+			_ = len(u.Tags)
+			for range u.Tags {
+			}
+			p, _, _ := reg.GetInstanceProfileByName("web")
+			_ = p.Roles[0].Tags["k"]
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		tag := []iam.Tag{{Key: aws.String("k"), Value: aws.String(string(rune('a' + i%26)))}}
+		if err := reg.TagUser("alice", tag); err != nil {
+			t.Fatal(err)
+		}
+		if err := reg.TagRole("web", tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestUpdateRollsBack(t *testing.T) {
+	reg := newTestRegistry(t, registryTxFixture)
+	reg.store = failingStore{NewMemoryStore()}
+	p := &IAMInstanceProfile{Name: "new", Path: "/"}
+	if err := reg.CreateInstanceProfile(p); err == nil {
+		t.Fatal("CreateInstanceProfile succeeded on a broken store")
+	}
+	if _, ok, _ := reg.GetInstanceProfileByName("new"); ok {
+		t.Error("the instance profile was created though the store failed")
+	}
+	if reg.ids.used[p.Id] {
+		t.Errorf("ID %s was not given back", p.Id)
+	}
+	if err := reg.TagUser("alice", []iam.Tag{{Key: aws.String("k"), Value: aws.String("v")}}); err == nil {
+		t.Fatal("TagUser succeeded on a broken store")
+	}
+	if u, _, _ := reg.GetUserByName("alice"); len(u.Tags) != 0 {
+		t.Errorf("the user was tagged though the store failed: %v", u.Tags)
+	}
+}
+
+func TestRegistryPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iam.db")
+	y, err := parseFixture([]byte(registryTxFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := openRegistry(store, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId := reg.Export().Users[0].Id
+	if err := reg.TagUser("alice", []iam.Tag{{Key: aws.String("k"), Value: aws.String("v")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.CreateInstanceProfile(&IAMInstanceProfile{Name: "extra", Path: "/"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	// the fixture is ignored for a populated store
+	reg, err = openRegistry(store, &fixture{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, ok, _ := reg.GetUserByName("alice")
+	if !ok || u.Id != userId || u.Tags["k"] != "v" {
+		t.Errorf("got user %+v, want alice with ID %s tagged k=v", u, userId)
+	}
+	g, _, _ := reg.GetGroupByName("admins")
+	if len(g.Members) != 1 || g.Members[0] != u {
+		t.Errorf("the members of the group were not restored")
+	}
+	if _, ok, _ := reg.GetInstanceProfileByName("extra"); !ok {
+		t.Errorf("the instance profile created was not restored")
+	}
+	p, _, _ := reg.GetInstanceProfileByName("web")
+	if len(p.Roles) != 1 || p.Roles[0].Name != "web" {
+		t.Errorf("the roles of the instance profile were not restored")
+	}
+}
+
+func TestMemoryStoreRollsBack(t *testing.T) {
+	s := NewMemoryStore()
+	err := s.Update(func(tx StoreTx) error {
+		if err := tx.Put("b", "k", []byte("v")); err != nil {
+			return err
+		}
+		if v, ok, _ := tx.Get("b", "k"); !ok || string(v) != "v" {
+			t.Errorf("a write is not visible in its own transaction")
+		}
+		return errors.New("abort")
+	})
+	if err == nil {
+		t.Fatal("the error of the transaction was lost")
+	}
+	s.View(func(tx StoreTx) error {
+		if _, ok, _ := tx.Get("b", "k"); ok {
+			t.Errorf("the write of a failed transaction was committed")
+		}
+		return nil
+	})
+}
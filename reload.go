@@ -0,0 +1,369 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// fixtureWatcher rebuilds the registry whenever a fixture file changes on
+// disk, included files and files added to or removed from fixture directories
+// among them.  Files are polled and compared by content, which works the same
+// on every platform and filesystem, bind mounts included.
+type fixtureWatcher struct {
+	Registry *BasicIAMRegistry
+	Paths    []string
+	Interval time.Duration
+	digests  map[string][]byte
+}
+
+func newFixtureWatcher(reg *BasicIAMRegistry, paths []string, interval time.Duration) *fixtureWatcher {
+	w := &fixtureWatcher{Registry: reg, Paths: paths, Interval: interval}
+	_, files, _ := loadFixtures(paths)
+	w.digests = w.digest(files)
+	return w
+}
+
+// digest hashes the paths the fixture is made of, along with files.  A
+// directory is hashed by the names of the fixture files in it, and a file that
+// cannot be read is recorded without a digest.
+func (w *fixtureWatcher) digest(files []string) map[string][]byte {
+	digests := make(map[string][]byte, len(w.Paths)+len(files))
+	for _, p := range append(append([]string{}, w.Paths...), files...) {
+		var b []byte
+		fi, err := os.Stat(p)
+		if err == nil && fi.IsDir() {
+			var names []string
+			names, err = fixtureFiles(p)
+			b = []byte(strings.Join(names, "\n"))
+		} else if err == nil {
+			b, err = ioutil.ReadFile(p)
+		}
+		if err != nil {
+			digests[p] = nil
+			continue
+		}
+		d := sha256.Sum256(b)
+		digests[p] = d[:]
+	}
+	return digests
+}
+
+func (w *fixtureWatcher) watched() []string {
+	files := make([]string, 0, len(w.digests))
+	for p := range w.digests {
+		files = append(files, p)
+	}
+	return files
+}
+
+func (w *fixtureWatcher) changed() bool {
+	for p, d := range w.digest(w.watched()) {
+		if !bytes.Equal(d, w.digests[p]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *fixtureWatcher) Run(ctx context.Context) {
+	logger.Info("watching the fixture", slog.Any("paths", w.Paths), slog.Duration("interval", w.Interval))
+	t := time.NewTicker(w.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if w.changed() {
+			w.reload()
+		}
+	}
+}
+
+// reload rebuilds the registry out of the fixture.  Should the fixture be
+// broken, the registry keeps its state until the fixture is fixed.
+func (w *fixtureWatcher) reload() {
+	// the files are hashed before reading where possible, so that a write
+	// racing with the read is noticed on the next poll
+	pre := w.digest(w.watched())
+	y, files, err := loadFixtures(w.Paths)
+	w.digests = w.digest(files)
+	for p := range w.digests {
+		if d, ok := pre[p]; ok {
+			w.digests[p] = d
+		}
+	}
+	if err != nil {
+		logger.Error("failed to load the fixture; keeping the current state", slog.Any("error", err))
+		return
+	}
+	cur := w.Registry.Export()
+	inheritIds(y, cur)
+	inheritImportDates(y, cur)
+	next, err := buildRegistry(y)
+	if err != nil {
+		logger.Error("invalid fixture; keeping the current state", slog.Any("error", err))
+		return
+	}
+	prev, err := w.Registry.replace(next)
+	if err != nil {
+		logger.Error("failed to replace the registry; keeping the current state", slog.Any("error", err))
+		return
+	}
+	logger.Info("reloaded the fixture", diffFixtures(prev, next.Export())...)
+}
+
+// inheritIds gives the entities of y that have no ID the one their namesakes
+// in cur have, so that IDs generated at random stay the same across reloads.
+// IDs that y gives to other entities explicitly are not inherited.
+func inheritIds(y *fixture, cur *fixture) {
+	taken := map[string]bool{}
+	for _, u := range y.Users {
+		taken[u.Id] = true
+	}
+	for _, g := range y.Groups {
+		taken[g.Id] = true
+	}
+	for _, r := range y.Roles {
+		taken[r.Id] = true
+		if r.InstanceProfile != nil {
+			taken[r.InstanceProfile.Id] = true
+		}
+	}
+	for _, p := range y.InstanceProfiles {
+		taken[p.Id] = true
+	}
+	for i := range y.Policies {
+		taken[y.Policies[i].Id] = true
+	}
+	for _, c := range y.ServerCertificates {
+		taken[c.Id] = true
+	}
+	inherit := func(id *string, ids map[string]string, name string) {
+		if *id != "" {
+			return
+		}
+		if old, ok := ids[name]; ok && !taken[old] {
+			*id = old
+			taken[old] = true
+		}
+	}
+
+	ids := map[string]string{}
+	for _, u := range cur.Users {
+		ids[u.Name] = u.Id
+	}
+	for i := range y.Users {
+		inherit(&y.Users[i].Id, ids, y.Users[i].Name)
+	}
+	ids = map[string]string{}
+	for _, g := range cur.Groups {
+		ids[g.Name] = g.Id
+	}
+	for i := range y.Groups {
+		inherit(&y.Groups[i].Id, ids, y.Groups[i].Name)
+	}
+	ids = map[string]string{}
+	for _, r := range cur.Roles {
+		ids[r.Name] = r.Id
+	}
+	for i := range y.Roles {
+		inherit(&y.Roles[i].Id, ids, y.Roles[i].Name)
+	}
+	ids = map[string]string{}
+	for _, p := range cur.InstanceProfiles {
+		ids[p.Name] = p.Id
+	}
+	for i := range y.Roles {
+		if p := y.Roles[i].InstanceProfile; p != nil {
+			name := p.Name
+			if name == "" {
+				name = y.Roles[i].Name
+			}
+			inherit(&p.Id, ids, name)
+		}
+	}
+	for i := range y.InstanceProfiles {
+		inherit(&y.InstanceProfiles[i].Id, ids, y.InstanceProfiles[i].Name)
+	}
+	ids = map[string]string{}
+	for i := range cur.Policies {
+		ids[policyKey(&cur.Policies[i])] = cur.Policies[i].Id
+	}
+	for i := range y.Policies {
+		inherit(&y.Policies[i].Id, ids, policyKey(&y.Policies[i]))
+	}
+	ids = map[string]string{}
+	for _, c := range cur.ServerCertificates {
+		ids[c.Name] = c.Id
+	}
+	for i := range y.ServerCertificates {
+		inherit(&y.ServerCertificates[i].Id, ids, y.ServerCertificates[i].Name)
+	}
+}
+
+// inheritImportDates gives the entities of y that date from their import the
+// date their namesakes in cur have, so that reloading does not date them anew.
+func inheritImportDates(y *fixture, cur *fixture) {
+	dates := map[string]time.Time{}
+	cur.creationDates(func(key string, t *time.Time) {
+		dates[key] = *t
+	})
+	y.creationDates(func(key string, t *time.Time) {
+		if d, ok := dates[key]; ok && y.importDated[key] {
+			*t = d
+		}
+	})
+}
+
+// diffFixtures summarizes what differs between two snapshots as log
+// attributes, one group per kind of entity.  Entities are compared in their
+// fixture form, so only differences a fixture can express count.
+func diffFixtures(prev, next *fixture) []any {
+	var attrs []any
+	diff := func(kind string, prev, next map[string][]byte) {
+		var added, removed, changed []string
+		for name, v := range next {
+			pv, ok := prev[name]
+			if !ok {
+				added = append(added, name)
+			} else if !bytes.Equal(pv, v) {
+				changed = append(changed, name)
+			}
+		}
+		for name := range prev {
+			if _, ok := next[name]; !ok {
+				removed = append(removed, name)
+			}
+		}
+		if len(added)+len(removed)+len(changed) == 0 {
+			return
+		}
+		var group []any
+		for _, names := range []struct {
+			key   string
+			names []string
+		}{{"added", added}, {"removed", removed}, {"changed", changed}} {
+			if len(names.names) > 0 {
+				sort.Strings(names.names)
+				group = append(group, slog.Any(names.key, names.names))
+			}
+		}
+		attrs = append(attrs, slog.Group(kind, group...))
+	}
+
+	users := func(f *fixture) map[string][]byte {
+		m := make(map[string][]byte, len(f.Users))
+		for _, u := range f.Users {
+			m[u.Name] = fixtureEntry(u)
+		}
+		return m
+	}
+	groups := func(f *fixture) map[string][]byte {
+		m := make(map[string][]byte, len(f.Groups))
+		for _, g := range f.Groups {
+			m[g.Name] = fixtureEntry(g)
+		}
+		return m
+	}
+	roles := func(f *fixture) map[string][]byte {
+		m := make(map[string][]byte, len(f.Roles))
+		for _, r := range f.Roles {
+			m[r.Name] = fixtureEntry(r)
+		}
+		return m
+	}
+	instanceProfiles := func(f *fixture) map[string][]byte {
+		m := make(map[string][]byte, len(f.InstanceProfiles))
+		for _, p := range f.InstanceProfiles {
+			m[p.Name] = fixtureEntry(p)
+		}
+		return m
+	}
+	policies := func(f *fixture) map[string][]byte {
+		m := make(map[string][]byte, len(f.Policies))
+		for i := range f.Policies {
+			m[policyKey(&f.Policies[i])] = fixtureEntry(f.Policies[i])
+		}
+		return m
+	}
+	diff("users", users(prev), users(next))
+	diff("groups", groups(prev), groups(next))
+	diff("roles", roles(prev), roles(next))
+	diff("instanceProfiles", instanceProfiles(prev), instanceProfiles(next))
+	oidcProviders := func(f *fixture) map[string][]byte {
+		m := make(map[string][]byte, len(f.OpenIDConnectProviders))
+		for i := range f.OpenIDConnectProviders {
+			m[oidcProviderKey(&f.OpenIDConnectProviders[i])] = fixtureEntry(f.OpenIDConnectProviders[i])
+		}
+		return m
+	}
+	samlProviders := func(f *fixture) map[string][]byte {
+		m := make(map[string][]byte, len(f.SAMLProviders))
+		for _, p := range f.SAMLProviders {
+			m[p.Name] = fixtureEntry(p)
+		}
+		return m
+	}
+	mfaDevices := func(f *fixture) map[string][]byte {
+		m := make(map[string][]byte, len(f.VirtualMFADevices))
+		for _, d := range f.VirtualMFADevices {
+			m[d.Name] = fixtureEntry(d)
+		}
+		return m
+	}
+	serverCertificates := func(f *fixture) map[string][]byte {
+		m := make(map[string][]byte, len(f.ServerCertificates))
+		for _, c := range f.ServerCertificates {
+			m[c.Name] = fixtureEntry(c)
+		}
+		return m
+	}
+	diff("policies", policies(prev), policies(next))
+	diff("openIDConnectProviders", oidcProviders(prev), oidcProviders(next))
+	diff("samlProviders", samlProviders(prev), samlProviders(next))
+	diff("virtualMFADevices", mfaDevices(prev), mfaDevices(next))
+	diff("serverCertificates", serverCertificates(prev), serverCertificates(next))
+	if !bytes.Equal(fixtureEntry(prev.Account), fixtureEntry(next.Account)) {
+		attrs = append(attrs, slog.Bool("accountChanged", true))
+	}
+	return attrs
+}
+
+func fixtureEntry(v interface{}) []byte {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		// cannot happen for the types a fixture consists of
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,228 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// userIds maps the names of the users of reg to their IDs.
+func userIds(t *testing.T, reg *BasicIAMRegistry) map[string]string {
+	t.Helper()
+	users, err := reg.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]string, len(users))
+	for _, u := range users {
+		ids[u.Name] = u.Id
+	}
+	return ids
+}
+
+func userNames(ids map[string]string) string {
+	names := make([]string, 0, len(ids))
+	for name := range ids {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestFixtureWatcherReload(t *testing.T) {
+	dir := t.TempDir()
+	main := filepath.Join(dir, "fixture.yml")
+	included := filepath.Join(dir, "included.yml")
+	confDir := filepath.Join(dir, "conf.d")
+	if err := os.Mkdir(confDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, main, "include: [included.yml]\nusers:\n  - name: alice\n")
+	writeTestFile(t, included, "groups:\n  - name: admins\n")
+	paths := []string{main, confDir}
+	y, _, err := loadFixtures(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := buildRegistry(y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceId := userIds(t, reg)["alice"]
+	w := newFixtureWatcher(reg, paths, time.Hour)
+	if w.changed() {
+		t.Fatal("the fixture is reported changed before it was touched")
+	}
+
+	for _, c := range []struct {
+		what  string
+		edit  func()
+		users string
+	}{
+		{"a user added", func() { writeTestFile(t, main, "include: [included.yml]\nusers:\n  - name: alice\n  - name: bob\n") }, "alice,bob"},
+		{"a file added to the directory", func() { writeTestFile(t, filepath.Join(confDir, "carol.yaml"), "users:\n  - name: carol\n") }, "alice,bob,carol"},
+		{"a file of another extension added", func() { writeTestFile(t, filepath.Join(confDir, "notes.txt"), "not a fixture") }, ""},
+		{"the included file changed", func() { writeTestFile(t, included, "users:\n  - name: dave\n") }, "alice,bob,carol,dave"},
+		{"a file removed from the directory", func() { os.Remove(filepath.Join(confDir, "carol.yaml")) }, "alice,bob,dave"},
+		// a broken fixture leaves the state as it is, and is not retried
+		// until it changes again
+		{"a malformed fixture", func() { writeTestFile(t, main, "users: 42\n") }, "alice,bob,dave"},
+		{"an undefined group member", func() {
+			writeTestFile(t, main, "users:\n  - name: alice\ngroups:\n  - name: devs\n    members: [mallory]\n")
+		}, "alice,bob,dave"},
+		{"the fixture fixed", func() { writeTestFile(t, main, "users:\n  - name: alice\n") }, "alice"},
+	} {
+		c.edit()
+		if got := w.changed(); got != (c.users != "") {
+			t.Errorf("%s: changed() = %v", c.what, got)
+			continue
+		}
+		if c.users == "" {
+			continue
+		}
+		w.reload()
+		ids := userIds(t, reg)
+		if got := userNames(ids); got != c.users {
+			t.Errorf("%s: got users %s, want %s", c.what, got, c.users)
+		}
+		if ids["alice"] != aliceId {
+			t.Errorf("%s: the ID of alice changed from %s to %s", c.what, aliceId, ids["alice"])
+		}
+		if w.changed() {
+			t.Errorf("%s: still reported changed after the reload", c.what)
+		}
+	}
+}
+
+func TestFixtureWatcherRun(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "fixture.yml")
+	writeTestFile(t, file, "users:\n  - name: alice\n")
+	y, _, err := loadFixtures([]string{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := buildRegistry(y)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newFixtureWatcher(reg, []string{file}, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	writeTestFile(t, file, "users:\n  - name: alice\n  - name: bob\n")
+	deadline := time.Now().Add(10 * time.Second)
+	for userNames(userIds(t, reg)) != "alice,bob" {
+		if time.Now().After(deadline) {
+			t.Fatalf("the change was not picked up; got users %s", userNames(userIds(t, reg)))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not return after the context was canceled")
+	}
+}
+
+func TestInheritIds(t *testing.T) {
+	cur := &fixture{
+		Users: []IAMUser{
+			{Id: "AIDAALICE", Name: "alice"},
+			{Id: "AIDABOB", Name: "bob"},
+		},
+		Roles: []roleRecord{{IAMRole: IAMRole{Id: "AROAWEB", Name: "web"}}},
+		InstanceProfiles: []instanceProfileRecord{
+			{IAMInstanceProfile: IAMInstanceProfile{Id: "AIPAWEB", Name: "web"}},
+		},
+	}
+	y := &fixture{
+		Users: []IAMUser{
+			{Name: "alice"},
+			{Name: "bob"},
+			// carol takes the ID bob had, which bob must not inherit then
+			{Id: "AIDABOB", Name: "carol"},
+		},
+		Roles: []roleRecord{
+			// the instance profile a role declares is named after the role
+			// unless it is named otherwise
+			{IAMRole: IAMRole{Name: "web"}, InstanceProfile: &IAMInstanceProfile{}},
+		},
+	}
+	inheritIds(y, cur)
+	for _, c := range []struct{ got, want string }{
+		{y.Users[0].Id, "AIDAALICE"},
+		{y.Users[1].Id, ""},
+		{y.Users[2].Id, "AIDABOB"},
+		{y.Roles[0].Id, "AROAWEB"},
+		{y.Roles[0].InstanceProfile.Id, "AIPAWEB"},
+	} {
+		if c.got != c.want {
+			t.Errorf("got %q, want %q", c.got, c.want)
+		}
+	}
+}
+
+func TestDiffFixtures(t *testing.T) {
+	prev := &fixture{
+		Users:  []IAMUser{{Name: "alice"}, {Name: "bob"}},
+		Groups: []groupRecord{{IAMGroup: IAMGroup{Name: "admins"}}},
+	}
+	next := &fixture{
+		Users:   []IAMUser{{Name: "alice", Path: "/staff/"}, {Name: "carol"}},
+		Groups:  []groupRecord{{IAMGroup: IAMGroup{Name: "admins"}}},
+		Account: IAMAccount{Alias: "example"},
+	}
+	var b bytes.Buffer
+	slog.New(slog.NewTextHandler(&b, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey || a.Key == slog.MessageKey) {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})).Info("", diffFixtures(prev, next)...)
+	want := "users.added=[carol] users.removed=[bob] users.changed=[alice] accountChanged=true\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+	if attrs := diffFixtures(next, next); len(attrs) != 0 {
+		t.Errorf("got %v for identical fixtures", attrs)
+	}
+}
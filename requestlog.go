@@ -0,0 +1,119 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// defaultRequestLogSize is how many of the latest requests the admin API
+// can list.
+const defaultRequestLogSize = 1000
+
+// RecordedRequest is what the admin API tells about a request to the AWS
+// APIs.
+type RecordedRequest struct {
+	Seq        uint64      `json:"seq"`
+	Time       time.Time   `json:"time"`
+	RequestId  string      `json:"request_id"`
+	Operation  string      `json:"operation,omitempty"`
+	Parameters interface{} `json:"parameters,omitempty"`
+	Status     int         `json:"status"`
+	ErrorCode  string      `json:"error_code,omitempty"`
+	// Duration is in milliseconds.
+	Duration float64 `json:"duration"`
+}
+
+// redactedValue stands in for the parameters the log must not keep.
+const redactedValue = "(redacted)"
+
+// redactSensitive masks the parameters in doc, which buildDocument made out
+// of params, that the API model marks sensitive, such as private keys.
+func redactSensitive(params interface{}, doc interface{}) {
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return
+	}
+	v := reflect.Indirect(reflect.ValueOf(params))
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	for _, member := range shapeMembers(v) {
+		if member.tag.Get("sensitive") == "true" {
+			m[member.field] = redactedValue
+		}
+	}
+}
+
+// RequestLog keeps the latest Size requests in a ring, numbering them in
+// the order they complete so that clients can ask for those that came
+// after one they saw.
+type RequestLog struct {
+	Size int
+
+	mu       sync.Mutex
+	requests []RecordedRequest
+	next     int
+	seq      uint64
+}
+
+func NewRequestLog(size int) *RequestLog {
+	return &RequestLog{Size: size}
+}
+
+func (l *RequestLog) add(r RecordedRequest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	r.Seq = l.seq
+	if l.Size <= 0 {
+		return
+	}
+	if len(l.requests) < l.Size {
+		l.requests = append(l.requests, r)
+		return
+	}
+	l.requests[l.next] = r
+	l.next = (l.next + 1) % l.Size
+}
+
+// since returns the requests numbered after seq, oldest first.
+func (l *RequestLog) since(seq uint64) []RecordedRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := make([]RecordedRequest, 0, len(l.requests))
+	for i := range l.requests {
+		r := l.requests[(l.next+i)%len(l.requests)]
+		if r.Seq > seq {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// clear forgets the requests recorded so far; numbering goes on.
+func (l *RequestLog) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests = nil
+	l.next = 0
+}
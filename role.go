@@ -0,0 +1,177 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+type IAMRole struct {
+	Id                       string            `yaml:"id"`
+	Name                     string            `yaml:"name"`
+	CreatedAt                time.Time         `yaml:"created_at"`
+	Path                     string            `yaml:"path"`
+	Description              string            `yaml:"description,omitempty"`
+	AssumeRolePolicyDocument string            `yaml:"assume_role_policy_document,omitempty"`
+	MaxSessionDuration       int64             `yaml:"max_session_duration,omitempty"`
+	Tags                     map[string]string `yaml:"tags,omitempty"`
+	InlinePolicies           map[string]string `yaml:"inline_policies,omitempty"`
+	ManagedPolicies          []string          `yaml:"managed_policies,omitempty"`
+}
+
+func roleName(r *IAMRole) string {
+	return r.Name
+}
+
+func rolePath(r *IAMRole) string {
+	return r.Path
+}
+
+func (r *IAMRole) BuildArn(accountId string) string {
+	path := strings.TrimPrefix(strings.TrimRight(r.Path, "/"), "/")
+	var slash string
+	if path != "" {
+		slash = "/"
+	}
+	return fmt.Sprintf("arn:aws:iam::%s:role/%s%s%s", accountId, path, slash, r.Name)
+}
+
+func (r *IAMRole) BuildRole(accountId string) iam.Role {
+	role := iam.Role{
+		Arn:        aws.String(r.BuildArn(accountId)),
+		CreateDate: aws.Time(r.CreatedAt),
+		RoleId:     aws.String(r.Id),
+		RoleName:   aws.String(r.Name),
+		Path:       aws.String(r.Path),
+	}
+	if r.Description != "" {
+		role.Description = aws.String(r.Description)
+	}
+	if r.AssumeRolePolicyDocument != "" {
+		role.AssumeRolePolicyDocument = aws.String(escapePolicyDocument(r.AssumeRolePolicyDocument))
+	}
+	if r.MaxSessionDuration != 0 {
+		role.MaxSessionDuration = aws.Int64(r.MaxSessionDuration)
+	}
+	return role
+}
+
+// escapePolicyDocument percent-encodes a policy document the way IAM does
+// in its responses.
+func escapePolicyDocument(doc string) string {
+	return strings.ReplaceAll(url.QueryEscape(doc), "+", "%20")
+}
+
+func noSuchRole(name string) error {
+	return &SenderFault{
+		Code_:    "NoSuchEntity",
+		Message_: fmt.Sprintf("The role with name %s cannot be found.", name),
+	}
+}
+
+func registerRoleHandlers(iamAPISet *APISet, reg IAMRegistry) {
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "GetRole",
+			Proto: iam.GetRoleInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*iam.GetRoleInput)
+				r, ok, err := reg.GetRoleByName(*params.RoleName)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchRole(*params.RoleName)
+				}
+
+				role := r.BuildRole(accountId)
+				if len(r.Tags) > 0 {
+					role.Tags = buildTags(r.Tags)
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.GetRoleOutput{Role: &role},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListRoles",
+			Proto: iam.ListRolesInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*iam.ListRolesInput)
+
+				roles, err := reg.GetRoles()
+				if err != nil {
+					return nil, err
+				}
+				roles = filterByPathPrefix(roles, rolePath, params.PathPrefix)
+				roles, marker, err := paginate(roles, roleName, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+
+				out := &iam.ListRolesOutput{
+					IsTruncated: aws.Bool(marker != nil),
+					Marker:      marker,
+				}
+
+				out.Roles = make([]iam.Role, len(roles))
+				for i, r := range roles {
+					out.Roles[i] = r.BuildRole(accountId)
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+}
+
+func (reg *BasicIAMRegistry) GetRoleByName(name string) (*IAMRole, bool, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	r, ok := reg.roles.get(name)
+	return r, ok, nil
+}
+
+func (reg *BasicIAMRegistry) GetRoles() ([]*IAMRole, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.roles.values(), nil
+}
@@ -0,0 +1,407 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/goccy/go-yaml/ast"
+)
+
+// fixtureSchemaJSON is the JSON Schema of the fixture format, which editors
+// can use as well.
+//
+//go:embed fixture.schema.json
+var fixtureSchemaJSON []byte
+
+var fixtureSchema = mustCompileSchema(fixtureSchemaJSON)
+
+// jsonSchema is the subset of JSON Schema that fixture.schema.json is
+// written in.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Defs                 map[string]*jsonSchema `json:"$defs"`
+	Description          string                 `json:"description"`
+	Type                 string                 `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties"`
+	PropertyNames        *jsonSchema            `json:"propertyNames"`
+	Required             []string               `json:"required"`
+	MaxProperties        *int                   `json:"maxProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Format               string                 `json:"format"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	AnyOf                []*jsonSchema          `json:"anyOf"`
+	Not                  *jsonSchema            `json:"not"`
+
+	// never is set for the schema false, which nothing satisfies
+	never bool
+	ref   *jsonSchema
+	re    *regexp.Regexp
+}
+
+func (s *jsonSchema) UnmarshalJSON(b []byte) error {
+	var valid bool
+	if err := json.Unmarshal(b, &valid); err == nil {
+		s.never = !valid
+		return nil
+	}
+	type plain jsonSchema
+	return json.Unmarshal(b, (*plain)(s))
+}
+
+func mustCompileSchema(b []byte) *jsonSchema {
+	var s jsonSchema
+	if err := json.Unmarshal(b, &s); err != nil {
+		panic(err)
+	}
+	s.compile(&s)
+	return &s
+}
+
+// compile resolves the references to the definitions of root and compiles
+// the patterns.
+func (s *jsonSchema) compile(root *jsonSchema) {
+	if s == nil {
+		return
+	}
+	if s.Ref != "" {
+		s.ref = root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")]
+		if s.ref == nil {
+			panic("unresolvable reference " + s.Ref)
+		}
+	}
+	if s.Pattern != "" {
+		s.re = regexp.MustCompile(s.Pattern)
+	}
+	for _, d := range s.Defs {
+		d.compile(root)
+	}
+	for _, p := range s.Properties {
+		p.compile(root)
+	}
+	for _, sub := range append([]*jsonSchema{s.AdditionalProperties, s.PropertyNames, s.Items, s.Not}, s.AnyOf...) {
+		sub.compile(root)
+	}
+}
+
+// yamlNode is a YAML value along with the line it is written on, with
+// anchors, aliases and merge keys resolved, which is what the schema is
+// checked against.
+type yamlNode struct {
+	line   int
+	kind   string
+	value  string
+	block  bool
+	items  []*yamlNode
+	fields []yamlField
+}
+
+type yamlField struct {
+	key    *yamlNode
+	value  *yamlNode
+	merged bool
+}
+
+func (n *yamlNode) field(name string) *yamlNode {
+	for _, f := range n.fields {
+		if f.key.value == name {
+			return f.value
+		}
+	}
+	return nil
+}
+
+var yamlKindNames = map[string]string{
+	"object":  "an object",
+	"array":   "an array",
+	"string":  "a string",
+	"integer": "an integer",
+	"number":  "a number",
+	"boolean": "a boolean",
+	"null":    "null",
+}
+
+// yamlProblem is something wrong with a YAML document at a line.
+type yamlProblem struct {
+	line    int
+	message string
+}
+
+// yamlConverter turns the AST of a document into yamlNodes, noting the
+// duplicate keys and unknown aliases on the way.
+type yamlConverter struct {
+	anchors  map[string]*yamlNode
+	problems []yamlProblem
+}
+
+func newYAMLConverter() *yamlConverter {
+	return &yamlConverter{anchors: map[string]*yamlNode{}}
+}
+
+func (c *yamlConverter) problemf(line int, format string, args ...interface{}) {
+	c.problems = append(c.problems, yamlProblem{line, fmt.Sprintf(format, args...)})
+}
+
+func (c *yamlConverter) convert(node ast.Node) *yamlNode {
+	if node == nil {
+		return &yamlNode{kind: "null"}
+	}
+	n := &yamlNode{line: node.GetToken().Position.Line}
+	switch v := node.(type) {
+	case *ast.NullNode:
+		n.kind = "null"
+	case *ast.StringNode:
+		n.kind, n.value = "string", v.Value
+	case *ast.LiteralNode:
+		n.kind, n.value, n.block = "string", v.Value.Value, true
+	case *ast.IntegerNode:
+		n.kind, n.value = "integer", v.GetToken().Value
+	case *ast.FloatNode, *ast.InfinityNode, *ast.NanNode:
+		n.kind, n.value = "number", v.GetToken().Value
+	case *ast.BoolNode:
+		n.kind, n.value = "boolean", strconv.FormatBool(v.Value)
+	case *ast.TagNode:
+		return c.convert(v.Value)
+	case *ast.AnchorNode:
+		value := c.convert(v.Value)
+		c.anchors[v.Name.GetToken().Value] = value
+		return value
+	case *ast.AliasNode:
+		name := v.Value.GetToken().Value
+		if value, ok := c.anchors[name]; ok {
+			return value
+		}
+		c.problemf(n.line, "unknown alias *%s", name)
+		n.kind = "null"
+	case *ast.SequenceNode:
+		n.kind = "array"
+		for _, e := range v.Values {
+			n.items = append(n.items, c.convert(e))
+		}
+	case *ast.MappingNode:
+		n.kind = "object"
+		for _, mv := range v.Values {
+			c.addField(n, mv)
+		}
+	case *ast.MappingValueNode:
+		n.kind = "object"
+		c.addField(n, v)
+	default:
+		c.problemf(n.line, "unexpected %s", node.Type())
+		n.kind = "null"
+	}
+	return n
+}
+
+// addField adds an entry of a mapping.  The entries that a merge key
+// brings in give way to those written out, wherever they are.
+func (c *yamlConverter) addField(n *yamlNode, mv *ast.MappingValueNode) {
+	value := c.convert(mv.Value)
+	if _, ok := mv.Key.(*ast.MergeKeyNode); ok {
+		sources := []*yamlNode{value}
+		if value.kind == "array" {
+			sources = value.items
+		}
+		for _, src := range sources {
+			for _, f := range src.fields {
+				if n.field(f.key.value) == nil {
+					n.fields = append(n.fields, yamlField{f.key, f.value, true})
+				}
+			}
+		}
+		return
+	}
+	// keys are strings, as they would be in JSON
+	k := *c.convert(mv.Key)
+	k.kind = "string"
+	key := &k
+	for i, f := range n.fields {
+		if f.key.value == key.value {
+			if !f.merged {
+				c.problemf(key.line, "duplicate key %s (first given at line %d)", key.value, f.key.line)
+			}
+			n.fields[i] = yamlField{key, value, false}
+			return
+		}
+	}
+	n.fields = append(n.fields, yamlField{key, value, false})
+}
+
+// check reports each way n fails to satisfy s, path being where n is in the
+// document.
+func (s *jsonSchema) check(n *yamlNode, path string, report func(line int, format string, args ...interface{})) {
+	if s.ref != nil {
+		s.ref.check(n, path, report)
+	}
+	if s.never {
+		report(n.line, "%s is not allowed", path)
+		return
+	}
+	if s.Type != "" && n.kind != s.Type && !(s.Type == "number" && n.kind == "integer") {
+		what := path
+		if what == "" {
+			what = "the fixture"
+		}
+		report(n.line, "%s must be %s, not %s", what, yamlKindNames[s.Type], yamlKindNames[n.kind])
+		return
+	}
+	if len(s.Enum) > 0 {
+		var allowed []string
+		found := false
+		for _, e := range s.Enum {
+			found = found || fmt.Sprint(e) == n.value
+			allowed = append(allowed, fmt.Sprint(e))
+		}
+		if !found {
+			report(n.line, "%s must be one of %s, not %q", path, strings.Join(allowed, ", "), n.value)
+		}
+	}
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, sub := range s.AnyOf {
+			failed := false
+			sub.check(n, path, func(int, string, ...interface{}) { failed = true })
+			if !failed {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			what := s.Description
+			if what == "" {
+				what = "any of the allowed forms"
+			}
+			report(n.line, "%s: %q is not %s", path, n.value, what)
+		}
+	}
+	if s.Not != nil {
+		failed := false
+		s.Not.check(n, path, func(int, string, ...interface{}) { failed = true })
+		if !failed && s.Not.Pattern != "" {
+			report(n.line, "%s: %q must not match %s", path, n.value, s.Not.Pattern)
+		} else if !failed {
+			report(n.line, "%s: %q is not allowed", path, n.value)
+		}
+	}
+	switch n.kind {
+	case "object":
+		s.checkObject(n, path, report)
+	case "array":
+		if s.MinItems != nil && len(n.items) < *s.MinItems {
+			report(n.line, "%s must have at least %d item(s)", path, *s.MinItems)
+		}
+		if s.MaxItems != nil && len(n.items) > *s.MaxItems {
+			report(n.line, "%s must have at most %d item(s), not %d", path, *s.MaxItems, len(n.items))
+		}
+		if s.Items != nil {
+			for i, item := range n.items {
+				s.Items.check(item, fmt.Sprintf("%s[%d]", path, i), report)
+			}
+		}
+	case "string":
+		l := utf8.RuneCountInString(n.value)
+		if s.MinLength != nil && l < *s.MinLength {
+			if l == 0 {
+				report(n.line, "%s must not be empty", path)
+			} else {
+				report(n.line, "%s: %q is shorter than %d characters", path, n.value, *s.MinLength)
+			}
+		}
+		if s.MaxLength != nil && l > *s.MaxLength {
+			report(n.line, "%s is longer than %d characters", path, *s.MaxLength)
+		}
+		if s.re != nil && !s.re.MatchString(n.value) {
+			report(n.line, "%s: %q does not match %s", path, n.value, s.Pattern)
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, n.value); err != nil {
+				report(n.line, "%s: %q is not an RFC 3339 timestamp", path, n.value)
+			}
+		}
+	case "integer", "number":
+		v, err := strconv.ParseFloat(n.value, 64)
+		if err != nil {
+			break
+		}
+		if s.Minimum != nil && v < *s.Minimum {
+			report(n.line, "%s must be at least %v, not %s", path, *s.Minimum, n.value)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			report(n.line, "%s must be at most %v, not %s", path, *s.Maximum, n.value)
+		}
+	}
+}
+
+func (s *jsonSchema) checkObject(n *yamlNode, path string, report func(line int, format string, args ...interface{})) {
+	prefix := path + "."
+	if path == "" {
+		prefix = ""
+	}
+	if s.MaxProperties != nil && len(n.fields) > *s.MaxProperties {
+		report(n.line, "%s must have at most %d entries, not %d", path, *s.MaxProperties, len(n.fields))
+	}
+	var missing []string
+	for _, r := range s.Required {
+		if n.field(r) == nil {
+			missing = append(missing, r)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		what := path
+		if what == "" {
+			what = "the fixture"
+		}
+		report(n.line, "%s is missing %s", what, strings.Join(missing, ", "))
+	}
+	for _, f := range n.fields {
+		if s.PropertyNames != nil {
+			s.PropertyNames.check(f.key, prefix+f.key.value, report)
+		}
+		if p, ok := s.Properties[f.key.value]; ok {
+			p.check(f.value, prefix+f.key.value, report)
+			continue
+		}
+		if s.AdditionalProperties == nil {
+			continue
+		}
+		if s.AdditionalProperties.never {
+			report(f.key.line, "unknown field %s%s", prefix, f.key.value)
+			continue
+		}
+		s.AdditionalProperties.check(f.value, prefix+f.key.value, report)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestSchemaCoversFixture checks that the schema and the types fixtures are
+// decoded into know the same fields, so that validate neither turns down
+// what the emulator reads nor lets through what it would ignore.
+func TestSchemaCoversFixture(t *testing.T) {
+	var walk func(typ reflect.Type, s *jsonSchema, path string)
+	walk = func(typ reflect.Type, s *jsonSchema, path string) {
+		for s.ref != nil {
+			s = s.ref
+		}
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ == timeType || len(s.AnyOf) > 0 {
+			return
+		}
+		switch typ.Kind() {
+		case reflect.Slice:
+			if s.Items == nil {
+				t.Errorf("%s: the schema has no items", path)
+				return
+			}
+			walk(typ.Elem(), s.Items, path+"[]")
+		case reflect.Map:
+			if s.AdditionalProperties != nil && !s.AdditionalProperties.never {
+				walk(typ.Elem(), s.AdditionalProperties, path+".*")
+			}
+		case reflect.Struct:
+			known := map[string]bool{}
+			var fields func(typ reflect.Type)
+			fields = func(typ reflect.Type) {
+				for i := 0; i < typ.NumField(); i++ {
+					f := typ.Field(i)
+					name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+					if opts == "inline" {
+						fields(f.Type)
+						continue
+					}
+					if f.PkgPath != "" || name == "-" || name == "" {
+						continue
+					}
+					known[name] = true
+					p, ok := s.Properties[name]
+					if !ok {
+						t.Errorf("%s.%s is read but not in the schema", path, name)
+						continue
+					}
+					walk(f.Type, p, path+"."+name)
+				}
+			}
+			fields(typ)
+			var extra []string
+			for name := range s.Properties {
+				if !known[name] {
+					extra = append(extra, name)
+				}
+			}
+			sort.Strings(extra)
+			for _, name := range extra {
+				t.Errorf("%s.%s is in the schema but not read", path, name)
+			}
+		}
+	}
+	walk(reflect.TypeOf(fixture{}), fixtureSchema, "$")
+}
+
+func TestSchemaCheck(t *testing.T) {
+	for _, c := range []struct {
+		fixture string
+		want    []string
+	}{
+		{"users:\n  - name: alice\n", nil},
+		{"", nil},
+		{"- alice\n", []string{"1: the fixture must be an object, not an array"}},
+		{"users:\n  - nmae: alice\n", []string{
+			"2: users[0] is missing name",
+			"2: unknown field users[0].nmae",
+		}},
+		{"users:\n  - name: bob smith\n    path: staff\n", []string{
+			`2: users[0].name: "bob smith" does not match ^[\w+=,.@-]+$`,
+			`3: users[0].path: "staff" does not match ^(/|/[\x21-\x7E]+/)$`,
+		}},
+		{"users:\n  - name: alice\n    created_at: yesterday\n", []string{
+			`3: users[0].created_at: "yesterday" is not an RFC 3339 timestamp`,
+		}},
+		{"users:\n  - name: alice\n    access_keys:\n      - status: Enabled\n", []string{
+			`4: users[0].access_keys[0].status must be one of Active, Inactive, not "Enabled"`,
+		}},
+		{"users:\n  - name: alice\n    access_keys: [{}, {}, {}]\n", []string{
+			"3: users[0].access_keys must have at most 2 item(s), not 3",
+		}},
+		{"roles:\n  - name: web\n    max_session_duration: 60\n", []string{
+			"3: roles[0].max_session_duration must be at least 3600, not 60",
+		}},
+		// account IDs must be quoted
+		{"account:\n  id: 123456789012\n", []string{"2: account.id must be a string, not an integer"}},
+		{"account:\n  id: \"123456789012\"\n", nil},
+		// anchors, aliases and merge keys are resolved, and fields written
+		// out win over merged ones
+		{"users:\n  - &base\n    name: alice\n    path: /staff/\n  - <<: *base\n    name: bob\n", nil},
+		{"users:\n  - <<: *nowhere\n    name: bob\n", []string{"2: unknown alias *nowhere"}},
+		{"users:\n  - name: alice\n    name: bob\n", []string{"3: duplicate key name (first given at line 2)"}},
+		{"credential_report:\n  content: user\n", []string{"2: credential_report is missing generated_at"}},
+	} {
+		f, err := parseYAML([]byte(c.fixture))
+		if err != nil {
+			t.Fatalf("%q: %v", c.fixture, err)
+		}
+		var got []string
+		if len(f.Docs) > 0 && f.Docs[0].Body != nil {
+			conv := newYAMLConverter()
+			root := conv.convert(f.Docs[0].Body)
+			for _, p := range conv.problems {
+				got = append(got, fmt.Sprintf("%d: %s", p.line, p.message))
+			}
+			fixtureSchema.check(root, "", func(line int, format string, args ...interface{}) {
+				got = append(got, fmt.Sprintf("%d: %s", line, fmt.Sprintf(format, args...)))
+			})
+		}
+		want := append([]string{}, c.want...)
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%q:\ngot  %q\nwant %q", c.fixture, got, want)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// IAMServerCertificate is a server certificate.  Its private key is not
+// kept, as IAM never gives it back.
+type IAMServerCertificate struct {
+	Id         string            `yaml:"id"`
+	Name       string            `yaml:"name"`
+	UploadedAt time.Time         `yaml:"uploaded_at"`
+	Path       string            `yaml:"path"`
+	Body       string            `yaml:"body"`
+	Chain      string            `yaml:"chain,omitempty"`
+	Tags       map[string]string `yaml:"tags,omitempty"`
+}
+
+func serverCertificateName(c *IAMServerCertificate) string {
+	return c.Name
+}
+
+func serverCertificatePath(c *IAMServerCertificate) string {
+	return c.Path
+}
+
+func (c *IAMServerCertificate) BuildArn(accountId string) string {
+	return fmt.Sprintf("arn:aws:iam::%s:server-certificate%s%s", accountId, c.Path, c.Name)
+}
+
+func (c *IAMServerCertificate) BuildMetadata(accountId string) iam.ServerCertificateMetadata {
+	md := iam.ServerCertificateMetadata{
+		Arn:                   aws.String(c.BuildArn(accountId)),
+		Path:                  aws.String(c.Path),
+		ServerCertificateId:   aws.String(c.Id),
+		ServerCertificateName: aws.String(c.Name),
+		UploadDate:            aws.Time(c.UploadedAt),
+	}
+	// the body was checked when the certificate was uploaded or loaded
+	if cert, err := parseCertificatePEM(c.Body); err == nil {
+		md.Expiration = aws.Time(cert.NotAfter.UTC())
+	}
+	return md
+}
+
+func noSuchServerCertificate(name string) error {
+	return &SenderFault{
+		Code_:    "NoSuchEntity",
+		Message_: fmt.Sprintf("The Server Certificate with name %s cannot be found.", name),
+	}
+}
+
+func malformedCertificate(message string) error {
+	return &SenderFault{
+		Code_:    "MalformedCertificate",
+		Message_: message,
+	}
+}
+
+// parseCertificatePEM parses the single PEM encoded certificate of body.
+func parseCertificatePEM(body string) (*x509.Certificate, error) {
+	block, rest := pem.Decode([]byte(body))
+	if block == nil || block.Type != "CERTIFICATE" || strings.TrimSpace(string(rest)) != "" {
+		return nil, malformedCertificate("Unable to parse certificate. Please ensure the certificate is in PEM format.")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, malformedCertificate("Unable to parse certificate. Please ensure the certificate is in PEM format.")
+	}
+	return cert, nil
+}
+
+// validateServerCertificate checks the body and chain of c, and that key,
+// if given, is the private key of the body.
+func validateServerCertificate(c *IAMServerCertificate, key string) error {
+	if _, err := parseCertificatePEM(c.Body); err != nil {
+		return err
+	}
+	rest := []byte(c.Chain)
+	for len(strings.TrimSpace(string(rest))) > 0 {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return malformedCertificate("Unable to parse certificate chain. Please ensure the certificate chain is in PEM format.")
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return malformedCertificate("Unable to parse certificate chain. Please ensure the certificate chain is in PEM format.")
+		}
+	}
+	if key == "" {
+		return nil
+	}
+	if block, _ := pem.Decode([]byte(key)); block == nil {
+		return malformedCertificate("Unable to parse private key. Please ensure the private key is in PEM format.")
+	}
+	if _, err := tls.X509KeyPair([]byte(c.Body), []byte(key)); err != nil {
+		return &SenderFault{
+			Code_:    "KeyPairMismatch",
+			Message_: "The public key in the certificate does not match the private key.",
+		}
+	}
+	return nil
+}
+
+func registerServerCertificateHandlers(iamAPISet *APISet, reg IAMRegistry) {
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "UploadServerCertificate",
+			Proto: UploadServerCertificateInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*UploadServerCertificateInput)
+				err = validateTags(params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				tags, err := mergeTags(nil, params.Tags, "TagsPerServerCertificate")
+				if err != nil {
+					return nil, err
+				}
+				c := &IAMServerCertificate{
+					Name:       *params.ServerCertificateName,
+					UploadedAt: time.Now().UTC().Truncate(time.Second),
+					Path:       aws.StringValue(params.Path),
+					Body:       *params.CertificateBody,
+					Chain:      aws.StringValue(params.CertificateChain),
+					Tags:       tags,
+				}
+				if c.Path == "" {
+					c.Path = "/"
+				}
+				err = validateServerCertificate(c, *params.PrivateKey)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.UploadServerCertificate(c)
+				if err != nil {
+					return nil, err
+				}
+				md := c.BuildMetadata(accountId)
+				out := &UploadServerCertificateOutput{ServerCertificateMetadata: &md}
+				if len(c.Tags) > 0 {
+					out.Tags = buildTags(c.Tags)
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "GetServerCertificate",
+			Proto: iam.GetServerCertificateInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*iam.GetServerCertificateInput)
+				c, ok, err := reg.GetServerCertificateByName(*params.ServerCertificateName)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchServerCertificate(*params.ServerCertificateName)
+				}
+				md := c.BuildMetadata(accountId)
+				sc := &ServerCertificate{
+					CertificateBody:           aws.String(c.Body),
+					ServerCertificateMetadata: &md,
+				}
+				if c.Chain != "" {
+					sc.CertificateChain = aws.String(c.Chain)
+				}
+				if len(c.Tags) > 0 {
+					sc.Tags = buildTags(c.Tags)
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &GetServerCertificateOutput{ServerCertificate: sc},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListServerCertificates",
+			Proto: iam.ListServerCertificatesInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*iam.ListServerCertificatesInput)
+				certs, err := reg.GetServerCertificates()
+				if err != nil {
+					return nil, err
+				}
+				certs = filterByPathPrefix(certs, serverCertificatePath, params.PathPrefix)
+				certs, marker, err := paginate(certs, serverCertificateName, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+				out := &iam.ListServerCertificatesOutput{
+					IsTruncated:                   aws.Bool(marker != nil),
+					Marker:                        marker,
+					ServerCertificateMetadataList: make([]iam.ServerCertificateMetadata, len(certs)),
+				}
+				for i, c := range certs {
+					out.ServerCertificateMetadataList[i] = c.BuildMetadata(accountId)
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: out,
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "DeleteServerCertificate",
+			Proto: iam.DeleteServerCertificateInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.DeleteServerCertificateInput)
+				err := reg.DeleteServerCertificate(*params.ServerCertificateName)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.DeleteServerCertificateOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+}
+
+func (reg *BasicIAMRegistry) GetServerCertificates() ([]*IAMServerCertificate, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.serverCerts.values(), nil
+}
+
+func (reg *BasicIAMRegistry) GetServerCertificateByName(name string) (*IAMServerCertificate, bool, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	c, ok := reg.serverCerts.get(name)
+	return c, ok, nil
+}
+
+func (reg *BasicIAMRegistry) UploadServerCertificate(c *IAMServerCertificate) error {
+	return reg.update(func(tx *registryTx) error {
+		if _, ok := tx.serverCertificate(c.Name); ok {
+			return &SenderFault{
+				Code_:    "EntityAlreadyExists",
+				Message_: fmt.Sprintf("The Server Certificate with name %s already exists.", c.Name),
+			}
+		}
+		if c.Id == "" {
+			c.Id = tx.allocateId(serverCertificateIdPrefix, c.Name)
+		}
+		tx.putServerCertificate(c)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) DeleteServerCertificate(name string) error {
+	return reg.update(func(tx *registryTx) error {
+		if _, ok := tx.serverCertificate(name); !ok {
+			return noSuchServerCertificate(name)
+		}
+		tx.deleteServerCertificate(name)
+		return nil
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCertificate returns a self-signed certificate and its private key
+// in PEM.
+func newTestCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}))
+}
+
+func TestServerCertificateOperations(t *testing.T) {
+	svc, reg := newTestService(t, ``)
+	svc.Requests = NewRequestLog(100)
+	body, key := newTestCertificate(t)
+	_, otherKey := newTestCertificate(t)
+	arn := "arn:aws:iam::000000000000:server-certificate/cloudfront/web"
+	for _, c := range []struct {
+		params url.Values
+		status int
+		want   string
+	}{
+		{url.Values{"Action": {"UploadServerCertificate"}, "ServerCertificateName": {"web"}, "CertificateBody": {"garbage"}, "PrivateKey": {key}}, http.StatusBadRequest, "<Code>MalformedCertificate</Code>"},
+		{url.Values{"Action": {"UploadServerCertificate"}, "ServerCertificateName": {"web"}, "CertificateBody": {body}, "PrivateKey": {otherKey}}, http.StatusBadRequest, "<Code>KeyPairMismatch</Code>"},
+		{url.Values{"Action": {"UploadServerCertificate"}, "ServerCertificateName": {"web"}, "Path": {"/cloudfront/"}, "CertificateBody": {body}, "PrivateKey": {key}, "Tags.member.1.Key": {"env"}, "Tags.member.1.Value": {"test"}}, http.StatusOK, "<Arn>" + arn + "</Arn>"},
+		{url.Values{"Action": {"UploadServerCertificate"}, "ServerCertificateName": {"web"}, "CertificateBody": {body}, "PrivateKey": {key}}, http.StatusConflict, "<Code>EntityAlreadyExists</Code>"},
+		{url.Values{"Action": {"GetServerCertificate"}, "ServerCertificateName": {"web"}}, http.StatusOK, "<Expiration>2030-01-01T00:00:00Z</Expiration>"},
+		{url.Values{"Action": {"ListServerCertificates"}, "PathPrefix": {"/cloudfront/"}}, http.StatusOK, "<ServerCertificateName>web</ServerCertificateName>"},
+		{url.Values{"Action": {"TagServerCertificate"}, "ServerCertificateName": {"web"}, "Tags.member.1.Key": {"team"}, "Tags.member.1.Value": {"red"}}, http.StatusOK, ""},
+		{url.Values{"Action": {"UntagServerCertificate"}, "ServerCertificateName": {"web"}, "TagKeys.member.1": {"env"}}, http.StatusOK, ""},
+		{url.Values{"Action": {"ListServerCertificateTags"}, "ServerCertificateName": {"web"}}, http.StatusOK, "<Key>team</Key>"},
+		{url.Values{"Action": {"DeleteServerCertificate"}, "ServerCertificateName": {"web"}}, http.StatusOK, ""},
+		{url.Values{"Action": {"GetServerCertificate"}, "ServerCertificateName": {"web"}}, http.StatusNotFound, "The Server Certificate with name web cannot be found."},
+	} {
+		w := query(t, svc, c.params)
+		if w.Code != c.status || !strings.Contains(w.Body.String(), c.want) {
+			t.Errorf("%v: got %d, want %d and %q:\n%s", c.params, w.Code, c.status, c.want, w.Body)
+		}
+	}
+	if certs, _ := reg.GetServerCertificates(); len(certs) != 0 {
+		t.Errorf("got certificates %v after deleting the certificate", certs)
+	}
+	// the private key must not make it to the request log
+	b, err := json.Marshal(svc.Requests.since(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "PRIVATE KEY") {
+		t.Errorf("the private key was logged: %s", b)
+	}
+	if !strings.Contains(string(b), redactedValue) {
+		t.Errorf("the private key was not redacted: %s", b)
+	}
+}
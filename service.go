@@ -20,20 +20,21 @@
 package main
 
 import (
-	"bytes"
-	"encoding/xml"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"strconv"
+	"reflect"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/google/uuid"
-	"github.com/rs/zerolog/log"
 )
 
 type Service struct {
-	Name    string
-	apisets []*APISet
+	Name string
+	// Requests records the requests served, if set.
+	Requests *RequestLog
+	apisets  []*APISet
 }
 
 func (e *Service) queryHandler(op string, version string) (*APISet, Handler, error) {
@@ -61,23 +62,31 @@ func (e *Service) buildMetadata(apiset *APISet) aws.Metadata {
 	}
 }
 
-func (e *Service) renderResponse(w http.ResponseWriter, req *http.Request, requestId string) error {
-	err := req.ParseForm()
-	if err != nil {
-		return err
+// shapeHandler looks up the handler of an operation by the name of the
+// service shape, as JSON and CBOR requests address operations.
+func (e *Service) shapeHandler(service, op string) (*APISet, Handler, error) {
+	for _, apiset := range e.apisets {
+		if apiset.ServiceShape != "" && apiset.ServiceShape == service {
+			handler, ok := apiset.QueryHandler(op)
+			if ok {
+				return apiset, handler, nil
+			}
+		}
 	}
+	return nil, nil, unknownOperation(service + "." + op)
+}
 
-	action := req.Form.Get("Action")
-	apiVersion := req.Form.Get("Version")
-
-	log.Debug().Str("action", action).Str("apiVersion", apiVersion)
-
-	apiset, handler, err := e.queryHandler(action, apiVersion)
+func (e *Service) renderResponse(w http.ResponseWriter, req *http.Request, p protocol, requestId string, rec *RecordedRequest) error {
+	apiset, handler, params, err := p.decodeRequest(e, req)
 	if err != nil {
 		return err
 	}
-
-	params, err := handler.UnmarshalParams(req)
+	rec.Operation = handler.Name()
+	rec.Parameters = buildDocument(reflect.ValueOf(params), func(t time.Time) interface{} {
+		return t.UTC().Format(time.RFC3339)
+	})
+	redactSensitive(params, rec.Parameters)
+	err = validateParams(params)
 	if err != nil {
 		return err
 	}
@@ -87,7 +96,7 @@ func (e *Service) renderResponse(w http.ResponseWriter, req *http.Request, reque
 		Params:      params,
 		Metadata:    e.buildMetadata(apiset),
 		Operation: &aws.Operation{
-			Name:       action,
+			Name:       handler.Name(),
 			HTTPMethod: req.Method,
 			HTTPPath:   req.URL.Path,
 		},
@@ -98,24 +107,7 @@ func (e *Service) renderResponse(w http.ResponseWriter, req *http.Request, reque
 		return err
 	}
 
-	b := &bytes.Buffer{}
-	// _, _ = b.WriteString(xml.Header)
-	enc := xml.NewEncoder(b)
-	err = marshal(enc, awsReq.Operation, requestId, apiset.Namespace, awsResp.Request.Data)
-	if err != nil {
-		return err
-	}
-	err = enc.Flush()
-	if err != nil {
-		return err
-	}
-	{
-		w.Header().Set("Content-Length", strconv.Itoa(b.Len()))
-		w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
-		w.WriteHeader(200)
-		w.Write(b.Bytes())
-	}
-	return nil
+	return p.renderResult(w, apiset, awsReq.Operation, requestId, awsResp.Request.Data)
 }
 
 func (e *Service) handleInner(w http.ResponseWriter, req *http.Request) error {
@@ -126,14 +118,33 @@ func (e *Service) handleInner(w http.ResponseWriter, req *http.Request) error {
 	requestIdStr := requestId.String()
 	w.Header().Set("x-amzn-RequestId", requestIdStr)
 
-	err = e.renderResponse(w, req, requestIdStr)
+	rec := &RecordedRequest{Time: time.Now().UTC(), RequestId: requestIdStr, Status: http.StatusOK}
+	if e.Requests != nil {
+		defer func() {
+			rec.Duration = float64(time.Since(rec.Time).Microseconds()) / 1000
+			e.Requests.add(*rec)
+		}()
+	}
+
+	p := detectProtocol(req)
+	err = e.renderResponse(w, req, p, requestIdStr, rec)
 	if err != nil {
-		if _err, ok := err.(Fault); ok {
-			err := renderFaultResponse(w, requestIdStr, _err)
-			if err != nil {
-				return err
+		_err, ok := err.(Fault)
+		if !ok {
+			logger.Error("internal error", slog.String("error", err.Error()), slog.String("url", req.URL.String()), slog.String("requestId", requestIdStr))
+			_err = &ReceiverFault{
+				Code_:    "ServiceFailure",
+				Message_: "The request processing has failed because of an unknown error, exception or failure.",
 			}
-		} else {
+		}
+		if rec.Operation == "" {
+			// the operation is unknown, or the request could not be decoded
+			rec.Operation = p.operation()
+		}
+		rec.Status = faultStatusCode(_err)
+		rec.ErrorCode = _err.Code()
+		err := p.renderFault(w, e, req, requestIdStr, _err)
+		if err != nil {
 			return err
 		}
 	}
@@ -143,15 +154,31 @@ func (e *Service) handleInner(w http.ResponseWriter, req *http.Request) error {
 func (e *Service) Handle(w http.ResponseWriter, req *http.Request) {
 	err := e.handleInner(w, req)
 	if err != nil {
-		log.Error().Err(err).Str("url", req.URL.String())
+		logger.Error("internal error", slog.String("error", err.Error()), slog.String("url", req.URL.String()))
 		http.Error(w, fmt.Sprintf("Internal server error: %s", err.Error()), 500)
 	}
 }
 
+// faultNamespace returns the namespace of the error envelope, which is that of
+// the API version the request asks for, or that of the latest one.
+func (e *Service) faultNamespace(version string) string {
+	var latest *APISet
+	for _, apiset := range e.apisets {
+		if apiset.Version == version {
+			return apiset.Namespace
+		}
+		if latest == nil || apiset.Version > latest.Version {
+			latest = apiset
+		}
+	}
+	if latest == nil {
+		return awsFaultNamespaceUrl
+	}
+	return latest.Namespace
+}
+
 func (e *Service) AddAPISet(apiset *APISet) {
 	e.apisets = append(e.apisets, apiset)
 }
 
-func getAccountId(req *aws.Request) string {
-	return "000000000000" //TODO
-}
+const defaultAccountId = "000000000000"
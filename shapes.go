@@ -0,0 +1,518 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+// This file carries the request and response shapes of operations that the
+// bundled aws-sdk-go-v2 does not know about yet, following the conventions of
+// the generated ones so that UnmarshalParams and marshal treat them alike.
+// Members are declared in the order the current IAM model lists them, which
+// marshal follows for the shapes memberorder.go does not cover.
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+type InstanceProfile struct {
+	_ struct{} `type:"structure"`
+
+	Arn *string `min:"20" type:"string" required:"true"`
+
+	CreateDate *time.Time `type:"timestamp" required:"true"`
+
+	InstanceProfileId *string `min:"16" type:"string" required:"true"`
+
+	InstanceProfileName *string `min:"1" type:"string" required:"true"`
+
+	Path *string `min:"1" type:"string" required:"true"`
+
+	Roles []iam.Role `type:"list" required:"true"`
+
+	Tags []iam.Tag `type:"list"`
+}
+
+type CreateInstanceProfileInput struct {
+	_ struct{} `type:"structure"`
+
+	InstanceProfileName *string `min:"1" type:"string" required:"true"`
+
+	Path *string `min:"1" type:"string"`
+
+	Tags []iam.Tag `type:"list"`
+}
+
+type CreateInstanceProfileOutput struct {
+	_ struct{} `type:"structure"`
+
+	InstanceProfile *InstanceProfile `type:"structure" required:"true"`
+}
+
+type GetInstanceProfileOutput struct {
+	_ struct{} `type:"structure"`
+
+	InstanceProfile *InstanceProfile `type:"structure" required:"true"`
+}
+
+type TagInstanceProfileInput struct {
+	_ struct{} `type:"structure"`
+
+	InstanceProfileName *string `min:"1" type:"string" required:"true"`
+
+	Tags []iam.Tag `type:"list" required:"true"`
+}
+
+type TagInstanceProfileOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+type UntagInstanceProfileInput struct {
+	_ struct{} `type:"structure"`
+
+	InstanceProfileName *string `min:"1" type:"string" required:"true"`
+
+	TagKeys []string `type:"list" required:"true"`
+}
+
+type UntagInstanceProfileOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+type ListInstanceProfileTagsInput struct {
+	_ struct{} `type:"structure"`
+
+	InstanceProfileName *string `min:"1" type:"string" required:"true"`
+
+	Marker *string `min:"1" type:"string"`
+
+	MaxItems *int64 `min:"1" type:"integer"`
+}
+
+type ListInstanceProfileTagsOutput struct {
+	_ struct{} `type:"structure"`
+
+	Tags []iam.Tag `type:"list" required:"true"`
+
+	IsTruncated *bool `type:"boolean"`
+
+	Marker *string `type:"string"`
+}
+
+type Policy struct {
+	_ struct{} `type:"structure"`
+
+	Arn *string `min:"20" type:"string"`
+
+	AttachmentCount *int64 `type:"integer"`
+
+	CreateDate *time.Time `type:"timestamp"`
+
+	DefaultVersionId *string `type:"string"`
+
+	Description *string `type:"string"`
+
+	IsAttachable *bool `type:"boolean"`
+
+	Path *string `min:"1" type:"string"`
+
+	PermissionsBoundaryUsageCount *int64 `type:"integer"`
+
+	PolicyId *string `min:"16" type:"string"`
+
+	PolicyName *string `min:"1" type:"string"`
+
+	Tags []iam.Tag `type:"list"`
+
+	UpdateDate *time.Time `type:"timestamp"`
+}
+
+type GetPolicyOutput struct {
+	_ struct{} `type:"structure"`
+
+	Policy *Policy `type:"structure"`
+}
+
+type TagPolicyInput struct {
+	_ struct{} `type:"structure"`
+
+	PolicyArn *string `min:"20" type:"string" required:"true"`
+
+	Tags []iam.Tag `type:"list" required:"true"`
+}
+
+type TagPolicyOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+type UntagPolicyInput struct {
+	_ struct{} `type:"structure"`
+
+	PolicyArn *string `min:"20" type:"string" required:"true"`
+
+	TagKeys []string `type:"list" required:"true"`
+}
+
+type UntagPolicyOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+type ListPolicyTagsInput struct {
+	_ struct{} `type:"structure"`
+
+	Marker *string `min:"1" type:"string"`
+
+	MaxItems *int64 `min:"1" type:"integer"`
+
+	PolicyArn *string `min:"20" type:"string" required:"true"`
+}
+
+type ListPolicyTagsOutput struct {
+	_ struct{} `type:"structure"`
+
+	Tags []iam.Tag `type:"list" required:"true"`
+
+	IsTruncated *bool `type:"boolean"`
+
+	Marker *string `type:"string"`
+}
+
+type CreateOpenIDConnectProviderInput struct {
+	_ struct{} `type:"structure"`
+
+	ClientIDList []string `type:"list"`
+
+	Tags []iam.Tag `type:"list"`
+
+	ThumbprintList []string `type:"list" required:"true"`
+
+	Url *string `min:"1" type:"string" required:"true"`
+}
+
+type CreateOpenIDConnectProviderOutput struct {
+	_ struct{} `type:"structure"`
+
+	OpenIDConnectProviderArn *string `min:"20" type:"string"`
+
+	Tags []iam.Tag `type:"list"`
+}
+
+type GetOpenIDConnectProviderOutput struct {
+	_ struct{} `type:"structure"`
+
+	ClientIDList []string `type:"list"`
+
+	CreateDate *time.Time `type:"timestamp"`
+
+	Tags []iam.Tag `type:"list"`
+
+	ThumbprintList []string `type:"list"`
+
+	Url *string `min:"1" type:"string"`
+}
+
+type TagOpenIDConnectProviderInput struct {
+	_ struct{} `type:"structure"`
+
+	OpenIDConnectProviderArn *string `min:"20" type:"string" required:"true"`
+
+	Tags []iam.Tag `type:"list" required:"true"`
+}
+
+type TagOpenIDConnectProviderOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+type UntagOpenIDConnectProviderInput struct {
+	_ struct{} `type:"structure"`
+
+	OpenIDConnectProviderArn *string `min:"20" type:"string" required:"true"`
+
+	TagKeys []string `type:"list" required:"true"`
+}
+
+type UntagOpenIDConnectProviderOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+type ListOpenIDConnectProviderTagsInput struct {
+	_ struct{} `type:"structure"`
+
+	Marker *string `min:"1" type:"string"`
+
+	MaxItems *int64 `min:"1" type:"integer"`
+
+	OpenIDConnectProviderArn *string `min:"20" type:"string" required:"true"`
+}
+
+type ListOpenIDConnectProviderTagsOutput struct {
+	_ struct{} `type:"structure"`
+
+	Tags []iam.Tag `type:"list" required:"true"`
+
+	IsTruncated *bool `type:"boolean"`
+
+	Marker *string `type:"string"`
+}
+
+type CreateSAMLProviderInput struct {
+	_ struct{} `type:"structure"`
+
+	Name *string `min:"1" type:"string" required:"true"`
+
+	SAMLMetadataDocument *string `min:"1000" type:"string" required:"true"`
+
+	Tags []iam.Tag `type:"list"`
+}
+
+type CreateSAMLProviderOutput struct {
+	_ struct{} `type:"structure"`
+
+	SAMLProviderArn *string `min:"20" type:"string"`
+
+	Tags []iam.Tag `type:"list"`
+}
+
+type GetSAMLProviderOutput struct {
+	_ struct{} `type:"structure"`
+
+	CreateDate *time.Time `type:"timestamp"`
+
+	SAMLMetadataDocument *string `min:"1000" type:"string"`
+
+	Tags []iam.Tag `type:"list"`
+
+	ValidUntil *time.Time `type:"timestamp"`
+}
+
+type TagSAMLProviderInput struct {
+	_ struct{} `type:"structure"`
+
+	SAMLProviderArn *string `min:"20" type:"string" required:"true"`
+
+	Tags []iam.Tag `type:"list" required:"true"`
+}
+
+type TagSAMLProviderOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+type UntagSAMLProviderInput struct {
+	_ struct{} `type:"structure"`
+
+	SAMLProviderArn *string `min:"20" type:"string" required:"true"`
+
+	TagKeys []string `type:"list" required:"true"`
+}
+
+type UntagSAMLProviderOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+type ListSAMLProviderTagsInput struct {
+	_ struct{} `type:"structure"`
+
+	Marker *string `min:"1" type:"string"`
+
+	MaxItems *int64 `min:"1" type:"integer"`
+
+	SAMLProviderArn *string `min:"20" type:"string" required:"true"`
+}
+
+type ListSAMLProviderTagsOutput struct {
+	_ struct{} `type:"structure"`
+
+	Tags []iam.Tag `type:"list" required:"true"`
+
+	IsTruncated *bool `type:"boolean"`
+
+	Marker *string `type:"string"`
+}
+
+type VirtualMFADevice struct {
+	_ struct{} `type:"structure"`
+
+	Base32StringSeed []byte `type:"blob" sensitive:"true"`
+
+	EnableDate *time.Time `type:"timestamp"`
+
+	QRCodePNG []byte `type:"blob" sensitive:"true"`
+
+	SerialNumber *string `min:"9" type:"string" required:"true"`
+
+	Tags []iam.Tag `type:"list"`
+
+	User *iam.User `type:"structure"`
+}
+
+type CreateVirtualMFADeviceInput struct {
+	_ struct{} `type:"structure"`
+
+	Path *string `min:"1" type:"string"`
+
+	Tags []iam.Tag `type:"list"`
+
+	VirtualMFADeviceName *string `min:"1" type:"string" required:"true"`
+}
+
+type CreateVirtualMFADeviceOutput struct {
+	_ struct{} `type:"structure"`
+
+	VirtualMFADevice *VirtualMFADevice `type:"structure" required:"true"`
+}
+
+type ListVirtualMFADevicesOutput struct {
+	_ struct{} `type:"structure"`
+
+	IsTruncated *bool `type:"boolean"`
+
+	Marker *string `type:"string"`
+
+	VirtualMFADevices []VirtualMFADevice `type:"list" required:"true"`
+}
+
+type TagMFADeviceInput struct {
+	_ struct{} `type:"structure"`
+
+	SerialNumber *string `min:"9" type:"string" required:"true"`
+
+	Tags []iam.Tag `type:"list" required:"true"`
+}
+
+type TagMFADeviceOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+type UntagMFADeviceInput struct {
+	_ struct{} `type:"structure"`
+
+	SerialNumber *string `min:"9" type:"string" required:"true"`
+
+	TagKeys []string `type:"list" required:"true"`
+}
+
+type UntagMFADeviceOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+type ListMFADeviceTagsInput struct {
+	_ struct{} `type:"structure"`
+
+	Marker *string `min:"1" type:"string"`
+
+	MaxItems *int64 `min:"1" type:"integer"`
+
+	SerialNumber *string `min:"9" type:"string" required:"true"`
+}
+
+type ListMFADeviceTagsOutput struct {
+	_ struct{} `type:"structure"`
+
+	Tags []iam.Tag `type:"list" required:"true"`
+
+	IsTruncated *bool `type:"boolean"`
+
+	Marker *string `type:"string"`
+}
+
+type ServerCertificate struct {
+	_ struct{} `type:"structure"`
+
+	CertificateBody *string `min:"1" type:"string" required:"true"`
+
+	CertificateChain *string `min:"1" type:"string"`
+
+	ServerCertificateMetadata *iam.ServerCertificateMetadata `type:"structure" required:"true"`
+
+	Tags []iam.Tag `type:"list"`
+}
+
+type UploadServerCertificateInput struct {
+	_ struct{} `type:"structure"`
+
+	CertificateBody *string `min:"1" type:"string" required:"true"`
+
+	CertificateChain *string `min:"1" type:"string"`
+
+	Path *string `min:"1" type:"string"`
+
+	PrivateKey *string `min:"1" type:"string" required:"true" sensitive:"true"`
+
+	ServerCertificateName *string `min:"1" type:"string" required:"true"`
+
+	Tags []iam.Tag `type:"list"`
+}
+
+type UploadServerCertificateOutput struct {
+	_ struct{} `type:"structure"`
+
+	ServerCertificateMetadata *iam.ServerCertificateMetadata `type:"structure"`
+
+	Tags []iam.Tag `type:"list"`
+}
+
+type GetServerCertificateOutput struct {
+	_ struct{} `type:"structure"`
+
+	ServerCertificate *ServerCertificate `type:"structure" required:"true"`
+}
+
+type TagServerCertificateInput struct {
+	_ struct{} `type:"structure"`
+
+	ServerCertificateName *string `min:"1" type:"string" required:"true"`
+
+	Tags []iam.Tag `type:"list" required:"true"`
+}
+
+type TagServerCertificateOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+type UntagServerCertificateInput struct {
+	_ struct{} `type:"structure"`
+
+	ServerCertificateName *string `min:"1" type:"string" required:"true"`
+
+	TagKeys []string `type:"list" required:"true"`
+}
+
+type UntagServerCertificateOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+type ListServerCertificateTagsInput struct {
+	_ struct{} `type:"structure"`
+
+	Marker *string `min:"1" type:"string"`
+
+	MaxItems *int64 `min:"1" type:"integer"`
+
+	ServerCertificateName *string `min:"1" type:"string" required:"true"`
+}
+
+type ListServerCertificateTagsOutput struct {
+	_ struct{} `type:"structure"`
+
+	Tags []iam.Tag `type:"list" required:"true"`
+
+	IsTruncated *bool `type:"boolean"`
+
+	Marker *string `type:"string"`
+}
@@ -0,0 +1,409 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// simulationResult is an EvaluationResult with the key that keeps the
+// results in the order they were made across pages.
+type simulationResult struct {
+	key    string
+	result iam.EvaluationResult
+}
+
+func simulationResultKey(r simulationResult) string {
+	return r.key
+}
+
+// resourceTags returns the tags of the IAM entity arn designates in the
+// account, which go into the context as aws:ResourceTag/KEY.  ARNs of
+// other services, and those of entities that do not exist, have none.
+func resourceTags(reg IAMRegistry, arn, accountId string) (map[string]string, error) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[2] != "iam" {
+		return nil, nil
+	}
+	kind, name := parts[5], parts[5]
+	if i := strings.Index(kind, "/"); i >= 0 {
+		kind, name = kind[:i], kind[strings.LastIndex(kind, "/")+1:]
+	}
+	switch kind {
+	case "user":
+		u, ok, err := reg.GetUserByName(name)
+		if err != nil || !ok || u.BuildArn(accountId) != arn {
+			return nil, err
+		}
+		return u.Tags, nil
+	case "group":
+		g, ok, err := reg.GetGroupByName(name)
+		if err != nil || !ok || g.BuildArn(accountId) != arn {
+			return nil, err
+		}
+		return g.Tags, nil
+	case "role":
+		r, ok, err := reg.GetRoleByName(name)
+		if err != nil || !ok || r.BuildArn(accountId) != arn {
+			return nil, err
+		}
+		return r.Tags, nil
+	case "instance-profile":
+		p, ok, err := reg.GetInstanceProfileByName(name)
+		if err != nil || !ok || p.BuildArn(accountId) != arn {
+			return nil, err
+		}
+		return p.Tags, nil
+	case "policy":
+		p, ok, err := reg.GetPolicyByArn(arn)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return p.Tags, nil
+	case "oidc-provider":
+		p, ok, err := reg.GetOpenIDConnectProvider(arn)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return p.Tags, nil
+	case "saml-provider":
+		p, ok, err := reg.GetSAMLProvider(arn)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return p.Tags, nil
+	case "mfa":
+		d, ok, err := reg.GetVirtualMFADevice(arn)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return d.Tags, nil
+	case "server-certificate":
+		c, ok, err := reg.GetServerCertificateByName(name)
+		if err != nil || !ok || c.BuildArn(accountId) != arn {
+			return nil, err
+		}
+		return c.Tags, nil
+	}
+	return nil, nil
+}
+
+// parsePolicySources parses the documents given in a request, which
+// MatchedStatements knows by their position in the list.
+func parsePolicySources(docs []string, list string, kind string) ([]policySource, error) {
+	sources := make([]policySource, len(docs))
+	for i, doc := range docs {
+		d, err := parsePolicyDocument(doc)
+		if err != nil {
+			return nil, err
+		}
+		sources[i] = policySource{id: fmt.Sprintf("%s.%d", list, i+1), kind: kind, document: d}
+	}
+	return sources, nil
+}
+
+// principalPolicies collects the policies of the user, group or role arn
+// designates: its inline and managed policies, and for a user, those of
+// the groups it belongs to.  Policies AWS manages that the fixture does not
+// define cannot be evaluated and are left out.
+func principalPolicies(reg IAMRegistry, arn, accountId string, ctx *evalContext) ([]policySource, error) {
+	policies, err := reg.GetPolicies()
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]*IAMPolicy, len(policies))
+	for _, p := range policies {
+		byKey[policyKey(p)] = p
+	}
+	var sources []policySource
+	add := func(kind string, inline map[string]string, managed []string) error {
+		for _, n := range sortedTagKeys(inline) {
+			d, err := parsePolicyDocument(inline[n])
+			if err != nil {
+				return err
+			}
+			sources = append(sources, policySource{id: n, kind: kind, document: d})
+		}
+		for _, k := range managed {
+			p, ok := byKey[k]
+			if !ok {
+				continue
+			}
+			d, err := parsePolicyDocument(p.defaultDocument())
+			if err != nil {
+				return err
+			}
+			kind := string(iam.PolicySourceTypeUserManaged)
+			if p.AWSManaged {
+				kind = string(iam.PolicySourceTypeAwsManaged)
+			}
+			sources = append(sources, policySource{id: p.Name, kind: kind, document: d})
+		}
+		return nil
+	}
+	tags := func(tags map[string]string) {
+		for k, v := range tags {
+			ctx.set("aws:PrincipalTag/"+k, v)
+		}
+	}
+
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[2] != "iam" || !strings.Contains(parts[5], "/") {
+		return nil, invalidPolicySourceArn(arn)
+	}
+	kind, name := parts[5][:strings.Index(parts[5], "/")], parts[5][strings.LastIndex(parts[5], "/")+1:]
+	switch kind {
+	case "user":
+		u, ok, err := reg.GetUserByName(name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || u.BuildArn(accountId) != arn {
+			return nil, noSuchUser(name)
+		}
+		ctx.set("aws:username", u.Name)
+		ctx.set("aws:userid", u.Id)
+		ctx.set("aws:PrincipalArn", arn)
+		ctx.set("aws:PrincipalType", "User")
+		tags(u.Tags)
+		if err := add(string(iam.PolicySourceTypeUser), u.InlinePolicies, u.ManagedPolicies); err != nil {
+			return nil, err
+		}
+		groups, err := reg.GetGroups()
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			for _, m := range g.Members {
+				if m.Name == u.Name {
+					if err := add(string(iam.PolicySourceTypeGroup), g.InlinePolicies, g.ManagedPolicies); err != nil {
+						return nil, err
+					}
+					break
+				}
+			}
+		}
+	case "group":
+		g, ok, err := reg.GetGroupByName(name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || g.BuildArn(accountId) != arn {
+			return nil, &SenderFault{
+				Code_:    "NoSuchEntity",
+				Message_: fmt.Sprintf("The group with name %s cannot be found.", name),
+			}
+		}
+		if err := add(string(iam.PolicySourceTypeGroup), g.InlinePolicies, g.ManagedPolicies); err != nil {
+			return nil, err
+		}
+	case "role":
+		r, ok, err := reg.GetRoleByName(name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || r.BuildArn(accountId) != arn {
+			return nil, noSuchRole(name)
+		}
+		ctx.set("aws:PrincipalArn", arn)
+		tags(r.Tags)
+		if err := add(string(iam.PolicySourceTypeRole), r.InlinePolicies, r.ManagedPolicies); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, invalidPolicySourceArn(arn)
+	}
+	return sources, nil
+}
+
+func invalidPolicySourceArn(arn string) error {
+	return &SenderFault{
+		Code_:    "InvalidInput",
+		Message_: fmt.Sprintf("Invalid ARN %s; the policy source must be a user, group or role.", arn),
+	}
+}
+
+// simulation is what SimulateCustomPolicy and SimulatePrincipalPolicy
+// have in common.
+type simulation struct {
+	identity         []policySource
+	boundaries       []policySource
+	resourcePolicies []policySource
+	// principal is the context the principal gives, if any
+	principal *evalContext
+}
+
+func (s *simulation) run(reg IAMRegistry, accountId string, actions, resources []string, entries []iam.ContextEntry) ([]simulationResult, error) {
+	if len(resources) == 0 {
+		resources = []string{"*"}
+	}
+	contexts := make([]*evalContext, len(resources))
+	for i, resource := range resources {
+		ctx := newEvalContext()
+		for _, e := range entries {
+			if e.ContextKeyName != nil {
+				ctx.set(*e.ContextKeyName, e.ContextKeyValues...)
+			}
+		}
+		if s.principal != nil {
+			for k, v := range s.principal.values {
+				ctx.set(s.principal.names[k], v...)
+			}
+		}
+		tags, err := resourceTags(reg, resource, accountId)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range tags {
+			ctx.set("aws:ResourceTag/"+k, v)
+			ctx.set("iam:ResourceTag/"+k, v)
+		}
+		contexts[i] = ctx
+	}
+
+	var results []simulationResult
+	for _, action := range actions {
+		for i, resource := range resources {
+			d, err := evaluatePolicies(s.identity, s.boundaries, s.resourcePolicies, action, resource, contexts[i])
+			if err != nil {
+				return nil, err
+			}
+			r := iam.EvaluationResult{
+				EvalActionName:       aws.String(action),
+				EvalDecision:         iam.PolicyEvaluationDecisionType(d.decision),
+				EvalResourceName:     aws.String(resource),
+				MatchedStatements:    make([]iam.Statement, len(d.matched)),
+				MissingContextValues: make([]string, len(d.missing)),
+			}
+			for j, src := range d.matched {
+				r.MatchedStatements[j] = iam.Statement{
+					SourcePolicyId:   aws.String(src.id),
+					SourcePolicyType: iam.PolicySourceType(src.kind),
+				}
+			}
+			for j, k := range d.missing {
+				r.MissingContextValues[j] = k
+			}
+			results = append(results, simulationResult{
+				key:    fmt.Sprintf("%08d", len(results)),
+				result: r,
+			})
+		}
+	}
+	return results, nil
+}
+
+func simulationResponse(results []simulationResult, marker *string, maxItems *int64) (*aws.Response, error) {
+	page, next, err := paginate(results, simulationResultKey, marker, maxItems)
+	if err != nil {
+		return nil, err
+	}
+	out := &iam.SimulatePrincipalPolicyOutput{
+		EvaluationResults: make([]iam.EvaluationResult, len(page)),
+		IsTruncated:       aws.Bool(next != nil),
+		Marker:            next,
+	}
+	for i, r := range page {
+		out.EvaluationResults[i] = r.result
+	}
+	return &aws.Response{
+		Request: &aws.Request{
+			Data: out,
+		},
+	}, nil
+}
+
+func registerSimulationHandlers(iamAPISet *APISet, reg IAMRegistry) {
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "SimulateCustomPolicy",
+			Proto: iam.SimulateCustomPolicyInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*iam.SimulateCustomPolicyInput)
+				s := &simulation{}
+				s.identity, err = parsePolicySources(params.PolicyInputList, "PolicyInputList", "")
+				if err != nil {
+					return nil, err
+				}
+				s.boundaries, err = parsePolicySources(params.PermissionsBoundaryPolicyInputList, "PermissionsBoundaryPolicyInputList", "")
+				if err != nil {
+					return nil, err
+				}
+				if params.ResourcePolicy != nil {
+					s.resourcePolicies, err = parsePolicySources([]string{*params.ResourcePolicy}, "ResourcePolicy", string(iam.PolicySourceTypeResource))
+					if err != nil {
+						return nil, err
+					}
+				}
+				results, err := s.run(reg, accountId, params.ActionNames, params.ResourceArns, params.ContextEntries)
+				if err != nil {
+					return nil, err
+				}
+				return simulationResponse(results, params.Marker, params.MaxItems)
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "SimulatePrincipalPolicy",
+			Proto: iam.SimulatePrincipalPolicyInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				accountId, err := reg.GetAccountId()
+				if err != nil {
+					return nil, err
+				}
+				params := req.Params.(*iam.SimulatePrincipalPolicyInput)
+				s := &simulation{principal: newEvalContext()}
+				s.identity, err = principalPolicies(reg, *params.PolicySourceArn, accountId, s.principal)
+				if err != nil {
+					return nil, err
+				}
+				extra, err := parsePolicySources(params.PolicyInputList, "PolicyInputList", string(iam.PolicySourceTypeNone))
+				if err != nil {
+					return nil, err
+				}
+				s.identity = append(s.identity, extra...)
+				s.boundaries, err = parsePolicySources(params.PermissionsBoundaryPolicyInputList, "PermissionsBoundaryPolicyInputList", string(iam.PolicySourceTypeNone))
+				if err != nil {
+					return nil, err
+				}
+				if params.ResourcePolicy != nil {
+					s.resourcePolicies, err = parsePolicySources([]string{*params.ResourcePolicy}, "ResourcePolicy", string(iam.PolicySourceTypeResource))
+					if err != nil {
+						return nil, err
+					}
+				}
+				results, err := s.run(reg, accountId, params.ActionNames, params.ResourceArns, params.ContextEntries)
+				if err != nil {
+					return nil, err
+				}
+				return simulationResponse(results, params.Marker, params.MaxItems)
+			},
+		},
+	)
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const simulationFixture = `
+users:
+  - name: alice
+    tags: {team: red}
+    inline_policies:
+      own: |
+        {"Version": "2012-10-17", "Statement": [{
+          "Effect": "Allow",
+          "Action": "iam:GetRole",
+          "Resource": "*",
+          "Condition": {"StringEquals": {"aws:ResourceTag/team": "${aws:PrincipalTag/team}"}}
+        }]}
+    managed_policies: [deny-delete]
+  - name: bob
+groups:
+  - name: g
+    members: [alice]
+    inline_policies:
+      list: '{"Statement": {"Effect": "Allow", "Action": "iam:List*", "Resource": "*"}}'
+roles:
+  - name: red
+    tags: {team: red}
+  - name: blue
+    tags: {team: blue}
+policies:
+  - name: deny-delete
+    versions:
+      - document: '{"Statement": [{"Effect": "Deny", "Action": "iam:Delete*", "Resource": "*"}]}'
+`
+
+// compactXML drops the indentation of a response so that the elements of
+// a member can be matched as a whole.
+func compactXML(s string) string {
+	return regexp.MustCompile(`>\s+<`).ReplaceAllString(s, "><")
+}
+
+func TestSimulatePrincipalPolicy(t *testing.T) {
+	svc, _ := newTestService(t, simulationFixture)
+	for _, c := range []struct {
+		params url.Values
+		status int
+		want   []string
+	}{
+		{
+			url.Values{
+				"PolicySourceArn":       {"arn:aws:iam::000000000000:user/alice"},
+				"ActionNames.member.1":  {"iam:GetRole"},
+				"ResourceArns.member.1": {"arn:aws:iam::000000000000:role/red"},
+				"ResourceArns.member.2": {"arn:aws:iam::000000000000:role/blue"},
+			},
+			http.StatusOK,
+			[]string{
+				"<EvalResourceName>arn:aws:iam::000000000000:role/red</EvalResourceName><EvalDecision>allowed</EvalDecision><MatchedStatements><member><SourcePolicyId>own</SourcePolicyId><SourcePolicyType>user</SourcePolicyType>",
+				"<EvalResourceName>arn:aws:iam::000000000000:role/blue</EvalResourceName><EvalDecision>implicitDeny</EvalDecision>",
+			},
+		},
+		{
+			url.Values{
+				"PolicySourceArn":      {"arn:aws:iam::000000000000:user/alice"},
+				"ActionNames.member.1": {"iam:ListRoles"},
+				"ActionNames.member.2": {"iam:DeleteRole"},
+			},
+			http.StatusOK,
+			[]string{
+				"<EvalActionName>iam:ListRoles</EvalActionName><EvalResourceName>*</EvalResourceName><EvalDecision>allowed</EvalDecision><MatchedStatements><member><SourcePolicyId>list</SourcePolicyId><SourcePolicyType>group</SourcePolicyType>",
+				"<EvalActionName>iam:DeleteRole</EvalActionName><EvalResourceName>*</EvalResourceName><EvalDecision>explicitDeny</EvalDecision><MatchedStatements><member><SourcePolicyId>deny-delete</SourcePolicyId><SourcePolicyType>user-managed</SourcePolicyType>",
+			},
+		},
+		{
+			url.Values{
+				"PolicySourceArn":      {"arn:aws:iam::000000000000:user/bob"},
+				"ActionNames.member.1": {"iam:ListRoles"},
+			},
+			http.StatusOK,
+			[]string{"<EvalDecision>implicitDeny</EvalDecision>"},
+		},
+		{
+			url.Values{
+				"PolicySourceArn":      {"arn:aws:iam::000000000000:user/carol"},
+				"ActionNames.member.1": {"iam:ListRoles"},
+			},
+			http.StatusNotFound,
+			[]string{"<Code>NoSuchEntity</Code>"},
+		},
+	} {
+		c.params.Set("Action", "SimulatePrincipalPolicy")
+		w := query(t, svc, c.params)
+		if w.Code != c.status {
+			t.Errorf("%v: got %d, want %d:\n%s", c.params, w.Code, c.status, w.Body)
+			continue
+		}
+		for _, want := range c.want {
+			if !strings.Contains(compactXML(w.Body.String()), want) {
+				t.Errorf("%v: want %q in:\n%s", c.params, want, w.Body)
+			}
+		}
+	}
+}
+
+func TestSimulateCustomPolicy(t *testing.T) {
+	svc, _ := newTestService(t, simulationFixture)
+	policy := `{"Statement": [{"Effect": "Allow", "Action": "iam:TagRole", "Resource": "*", "Condition": {"StringEquals": {"iam:ResourceTag/team": "blue", "aws:RequestTag/team": "blue"}}}]}`
+	for _, c := range []struct {
+		params url.Values
+		status int
+		want   []string
+	}{
+		{
+			url.Values{
+				"ResourceArns.member.1":                             {"arn:aws:iam::000000000000:role/blue"},
+				"ContextEntries.member.1.ContextKeyName":            {"aws:RequestTag/team"},
+				"ContextEntries.member.1.ContextKeyType":            {"string"},
+				"ContextEntries.member.1.ContextKeyValues.member.1": {"blue"},
+			},
+			http.StatusOK,
+			[]string{"<EvalResourceName>arn:aws:iam::000000000000:role/blue</EvalResourceName><EvalDecision>allowed</EvalDecision><MatchedStatements><member><SourcePolicyId>PolicyInputList.1</SourcePolicyId>"},
+		},
+		{
+			url.Values{"ResourceArns.member.1": {"arn:aws:iam::000000000000:role/blue"}},
+			http.StatusOK,
+			[]string{"<EvalDecision>implicitDeny</EvalDecision>", "<MissingContextValues><member>aws:RequestTag/team</member></MissingContextValues>"},
+		},
+		{
+			url.Values{
+				"ResourceArns.member.1":                             {"arn:aws:iam::000000000000:role/red"},
+				"ContextEntries.member.1.ContextKeyName":            {"aws:RequestTag/team"},
+				"ContextEntries.member.1.ContextKeyType":            {"string"},
+				"ContextEntries.member.1.ContextKeyValues.member.1": {"blue"},
+			},
+			http.StatusOK,
+			[]string{"<EvalDecision>implicitDeny</EvalDecision>"},
+		},
+		{
+			url.Values{"PolicyInputList.member.1": {"{"}},
+			http.StatusBadRequest,
+			[]string{"<Code>MalformedPolicyDocument</Code>"},
+		},
+	} {
+		c.params.Set("Action", "SimulateCustomPolicy")
+		c.params.Set("ActionNames.member.1", "iam:TagRole")
+		if c.params.Get("PolicyInputList.member.1") == "" {
+			c.params.Set("PolicyInputList.member.1", policy)
+		}
+		w := query(t, svc, c.params)
+		if w.Code != c.status {
+			t.Errorf("%v: got %d, want %d:\n%s", c.params, w.Code, c.status, w.Body)
+			continue
+		}
+		for _, want := range c.want {
+			if !strings.Contains(compactXML(w.Body.String()), want) {
+				t.Errorf("%v: want %q in:\n%s", c.params, want, w.Body)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store is where a BasicIAMRegistry keeps its entities.  Records are opaque
+// byte strings filed under a key in a bucket, and the keys of a bucket are
+// enumerated in ascending byte order.
+type Store interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(StoreTx) error) error
+	// Update runs fn in a read-write transaction, which is committed when
+	// fn succeeds and rolled back as a whole when it fails.
+	Update(fn func(StoreTx) error) error
+	Close() error
+}
+
+type StoreTx interface {
+	Get(bucket, key string) ([]byte, bool, error)
+	Put(bucket, key string, value []byte) error
+	Delete(bucket, key string) error
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+}
+
+// openStore opens the store a -store flag designates: "memory", or
+// "bolt:PATH" for a bolt database file at PATH.
+func openStore(spec string) (Store, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "bolt":
+		if arg == "" {
+			return nil, fmt.Errorf("bolt store requires a path, as in bolt:PATH")
+		}
+		return OpenBoltStore(arg)
+	}
+	return nil, fmt.Errorf("unknown store %s", kind)
+}
+
+// MemoryStore is a Store that keeps everything in memory and is lost when
+// the process exits.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]map[string][]byte),
+	}
+}
+
+func (s *MemoryStore) View(fn func(StoreTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(&memoryStoreTx{store: s})
+}
+
+func (s *MemoryStore) Update(fn func(StoreTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := &memoryStoreTx{store: s, writes: make(map[string]map[string][]byte)}
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	for name, writes := range tx.writes {
+		bucket, ok := s.buckets[name]
+		if !ok {
+			bucket = make(map[string][]byte)
+			s.buckets[name] = bucket
+		}
+		for k, v := range writes {
+			if v == nil {
+				delete(bucket, k)
+			} else {
+				bucket[k] = v
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// memoryStoreTx stages the writes of a transaction, a nil value standing for
+// a deletion, until the transaction commits.
+type memoryStoreTx struct {
+	store  *MemoryStore
+	writes map[string]map[string][]byte
+}
+
+func (tx *memoryStoreTx) Get(bucket, key string) ([]byte, bool, error) {
+	if v, ok := tx.writes[bucket][key]; ok {
+		return v, v != nil, nil
+	}
+	v, ok := tx.store.buckets[bucket][key]
+	return v, ok, nil
+}
+
+func (tx *memoryStoreTx) Put(bucket, key string, value []byte) error {
+	if tx.writes == nil {
+		return fmt.Errorf("cannot write in a read-only transaction")
+	}
+	if tx.writes[bucket] == nil {
+		tx.writes[bucket] = make(map[string][]byte)
+	}
+	tx.writes[bucket][key] = append([]byte{}, value...)
+	return nil
+}
+
+func (tx *memoryStoreTx) Delete(bucket, key string) error {
+	if tx.writes == nil {
+		return fmt.Errorf("cannot write in a read-only transaction")
+	}
+	if tx.writes[bucket] == nil {
+		tx.writes[bucket] = make(map[string][]byte)
+	}
+	tx.writes[bucket][key] = nil
+	return nil
+}
+
+func (tx *memoryStoreTx) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	keys := make([]string, 0, len(tx.store.buckets[bucket]))
+	for k := range tx.store.buckets[bucket] {
+		if _, ok := tx.writes[bucket][k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	for k, v := range tx.writes[bucket] {
+		if v != nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, _, _ := tx.Get(bucket, k)
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,1025 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+const maxTagsPerEntity = 50
+const maxTagKeyLength = 128
+const maxTagValueLength = 256
+
+const tagCharacterClass = `[\p{L}\p{Z}\p{N}_.:/=+\-@]`
+
+var tagKeyRegexp = regexp.MustCompile(`^` + tagCharacterClass + `+$`)
+var tagValueRegexp = regexp.MustCompile(`^` + tagCharacterClass + `*$`)
+
+func validateTagKey(key string, location string) error {
+	if n := utf8.RuneCountInString(key); n < 1 || n > maxTagKeyLength {
+		return &SenderFault{
+			Code_:    "ValidationError",
+			Message_: fmt.Sprintf("1 validation error detected: Value '%s' at '%s' failed to satisfy constraint: Member must have length between 1 and %d", key, location, maxTagKeyLength),
+		}
+	}
+	if !tagKeyRegexp.MatchString(key) {
+		return &SenderFault{
+			Code_:    "ValidationError",
+			Message_: fmt.Sprintf("1 validation error detected: Value '%s' at '%s' failed to satisfy constraint: Member must satisfy regular expression pattern: %s+", key, location, tagCharacterClass),
+		}
+	}
+	return nil
+}
+
+func validateTag(key, value string, i int) error {
+	err := validateTagKey(key, fmt.Sprintf("tags.%d.member.key", i+1))
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(strings.ToLower(key), "aws:") {
+		return &SenderFault{
+			Code_:    "InvalidInput",
+			Message_: "User-defined tag keys can't start with 'aws:'. This prefix is reserved for AWS use.",
+		}
+	}
+	if utf8.RuneCountInString(value) > maxTagValueLength {
+		return &SenderFault{
+			Code_:    "ValidationError",
+			Message_: fmt.Sprintf("1 validation error detected: Value '%s' at 'tags.%d.member.value' failed to satisfy constraint: Member must have length less than or equal to %d", value, i+1, maxTagValueLength),
+		}
+	}
+	if !tagValueRegexp.MatchString(value) {
+		return &SenderFault{
+			Code_:    "ValidationError",
+			Message_: fmt.Sprintf("1 validation error detected: Value '%s' at 'tags.%d.member.value' failed to satisfy constraint: Member must satisfy regular expression pattern: %s*", value, i+1, tagCharacterClass),
+		}
+	}
+	return nil
+}
+
+func validateTags(tags []iam.Tag) error {
+	if len(tags) > maxTagsPerEntity {
+		return &SenderFault{
+			Code_:    "ValidationError",
+			Message_: fmt.Sprintf("1 validation error detected: Value at 'tags' failed to satisfy constraint: Member must have length less than or equal to %d", maxTagsPerEntity),
+		}
+	}
+	for i, t := range tags {
+		if t.Key == nil {
+			return &SenderFault{
+				Code_:    "MissingParameter",
+				Message_: fmt.Sprintf("Tags.member.%d.Key", i+1),
+			}
+		}
+		err := validateTag(*t.Key, aws.StringValue(t.Value), i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateTagKeys(keys []string) error {
+	if len(keys) > maxTagsPerEntity {
+		return &SenderFault{
+			Code_:    "ValidationError",
+			Message_: fmt.Sprintf("1 validation error detected: Value at 'tagKeys' failed to satisfy constraint: Member must have length less than or equal to %d", maxTagsPerEntity),
+		}
+	}
+	for i, k := range keys {
+		err := validateTagKey(k, fmt.Sprintf("tagKeys.%d.member", i+1))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTagMap checks the tags given in a fixture.
+func validateTagMap(tags map[string]string) error {
+	if len(tags) > maxTagsPerEntity {
+		return fmt.Errorf("cannot have more than %d tags", maxTagsPerEntity)
+	}
+	for i, k := range sortedTagKeys(tags) {
+		if err := validateTag(k, tags[k], i); err != nil {
+			return fmt.Errorf("invalid tag %s: %s", k, err.(Fault).Message())
+		}
+	}
+	return nil
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// buildTags renders tags in key order.
+func buildTags(tags map[string]string) []iam.Tag {
+	result := make([]iam.Tag, 0, len(tags))
+	for _, k := range sortedTagKeys(tags) {
+		result = append(result, iam.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(tags[k]),
+		})
+	}
+	return result
+}
+
+func tagKey(t iam.Tag) string {
+	return *t.Key
+}
+
+// mergeTags returns a copy of current with tags applied.  Tag keys are
+// matched case-insensitively, as IAM does, so a tag replaces any existing
+// one whose key differs only in case.
+func mergeTags(current map[string]string, tags []iam.Tag, quotaName string) (map[string]string, error) {
+	merged := make(map[string]string, len(current)+len(tags))
+	for k, v := range current {
+		merged[k] = v
+	}
+	for _, t := range tags {
+		for k := range merged {
+			if strings.EqualFold(k, *t.Key) {
+				delete(merged, k)
+			}
+		}
+		merged[*t.Key] = aws.StringValue(t.Value)
+	}
+	if len(merged) > maxTagsPerEntity {
+		return nil, &SenderFault{
+			Code_:    "LimitExceeded",
+			Message_: fmt.Sprintf("Cannot exceed quota for %s: %d", quotaName, maxTagsPerEntity),
+		}
+	}
+	return merged, nil
+}
+
+func removeTags(current map[string]string, keys []string) map[string]string {
+	remaining := make(map[string]string, len(current))
+	for k, v := range current {
+		remaining[k] = v
+	}
+	for _, key := range keys {
+		for k := range remaining {
+			if strings.EqualFold(k, key) {
+				delete(remaining, k)
+			}
+		}
+	}
+	return remaining
+}
+
+func registerTagHandlers(iamAPISet *APISet, reg IAMRegistry) {
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "TagUser",
+			Proto: iam.TagUserInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.TagUserInput)
+				err := validateTags(params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.TagUser(*params.UserName, params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.TagUserOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "UntagUser",
+			Proto: iam.UntagUserInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.UntagUserInput)
+				err := validateTagKeys(params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.UntagUser(*params.UserName, params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.UntagUserOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListUserTags",
+			Proto: iam.ListUserTagsInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.ListUserTagsInput)
+				u, ok, err := reg.GetUserByName(*params.UserName)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchUser(*params.UserName)
+				}
+				tags, marker, err := paginate(buildTags(u.Tags), tagKey, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.ListUserTagsOutput{
+							IsTruncated: aws.Bool(marker != nil),
+							Marker:      marker,
+							Tags:        tags,
+						},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "TagRole",
+			Proto: iam.TagRoleInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.TagRoleInput)
+				err := validateTags(params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.TagRole(*params.RoleName, params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.TagRoleOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "UntagRole",
+			Proto: iam.UntagRoleInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.UntagRoleInput)
+				err := validateTagKeys(params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.UntagRole(*params.RoleName, params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.UntagRoleOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListRoleTags",
+			Proto: iam.ListRoleTagsInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*iam.ListRoleTagsInput)
+				r, ok, err := reg.GetRoleByName(*params.RoleName)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchRole(*params.RoleName)
+				}
+				tags, marker, err := paginate(buildTags(r.Tags), tagKey, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &iam.ListRoleTagsOutput{
+							IsTruncated: aws.Bool(marker != nil),
+							Marker:      marker,
+							Tags:        tags,
+						},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "TagInstanceProfile",
+			Proto: TagInstanceProfileInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*TagInstanceProfileInput)
+				err := validateTags(params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.TagInstanceProfile(*params.InstanceProfileName, params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &TagInstanceProfileOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "UntagInstanceProfile",
+			Proto: UntagInstanceProfileInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*UntagInstanceProfileInput)
+				err := validateTagKeys(params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.UntagInstanceProfile(*params.InstanceProfileName, params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &UntagInstanceProfileOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListInstanceProfileTags",
+			Proto: ListInstanceProfileTagsInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*ListInstanceProfileTagsInput)
+				p, ok, err := reg.GetInstanceProfileByName(*params.InstanceProfileName)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchInstanceProfile(*params.InstanceProfileName)
+				}
+				tags, marker, err := paginate(buildTags(p.Tags), tagKey, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &ListInstanceProfileTagsOutput{
+							IsTruncated: aws.Bool(marker != nil),
+							Marker:      marker,
+							Tags:        tags,
+						},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "TagPolicy",
+			Proto: TagPolicyInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*TagPolicyInput)
+				err := validateTags(params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.TagPolicy(*params.PolicyArn, params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &TagPolicyOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "UntagPolicy",
+			Proto: UntagPolicyInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*UntagPolicyInput)
+				err := validateTagKeys(params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.UntagPolicy(*params.PolicyArn, params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &UntagPolicyOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListPolicyTags",
+			Proto: ListPolicyTagsInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*ListPolicyTagsInput)
+				p, ok, err := reg.GetPolicyByArn(*params.PolicyArn)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchPolicy(*params.PolicyArn)
+				}
+				tags, marker, err := paginate(buildTags(p.Tags), tagKey, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &ListPolicyTagsOutput{
+							IsTruncated: aws.Bool(marker != nil),
+							Marker:      marker,
+							Tags:        tags,
+						},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "TagOpenIDConnectProvider",
+			Proto: TagOpenIDConnectProviderInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*TagOpenIDConnectProviderInput)
+				err := validateTags(params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.TagOpenIDConnectProvider(*params.OpenIDConnectProviderArn, params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &TagOpenIDConnectProviderOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "UntagOpenIDConnectProvider",
+			Proto: UntagOpenIDConnectProviderInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*UntagOpenIDConnectProviderInput)
+				err := validateTagKeys(params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.UntagOpenIDConnectProvider(*params.OpenIDConnectProviderArn, params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &UntagOpenIDConnectProviderOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListOpenIDConnectProviderTags",
+			Proto: ListOpenIDConnectProviderTagsInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*ListOpenIDConnectProviderTagsInput)
+				p, ok, err := reg.GetOpenIDConnectProvider(*params.OpenIDConnectProviderArn)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchOIDCProvider(*params.OpenIDConnectProviderArn)
+				}
+				tags, marker, err := paginate(buildTags(p.Tags), tagKey, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &ListOpenIDConnectProviderTagsOutput{
+							IsTruncated: aws.Bool(marker != nil),
+							Marker:      marker,
+							Tags:        tags,
+						},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "TagSAMLProvider",
+			Proto: TagSAMLProviderInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*TagSAMLProviderInput)
+				err := validateTags(params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.TagSAMLProvider(*params.SAMLProviderArn, params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &TagSAMLProviderOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "UntagSAMLProvider",
+			Proto: UntagSAMLProviderInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*UntagSAMLProviderInput)
+				err := validateTagKeys(params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.UntagSAMLProvider(*params.SAMLProviderArn, params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &UntagSAMLProviderOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListSAMLProviderTags",
+			Proto: ListSAMLProviderTagsInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*ListSAMLProviderTagsInput)
+				p, ok, err := reg.GetSAMLProvider(*params.SAMLProviderArn)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchSAMLProvider(*params.SAMLProviderArn)
+				}
+				tags, marker, err := paginate(buildTags(p.Tags), tagKey, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &ListSAMLProviderTagsOutput{
+							IsTruncated: aws.Bool(marker != nil),
+							Marker:      marker,
+							Tags:        tags,
+						},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "TagMFADevice",
+			Proto: TagMFADeviceInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*TagMFADeviceInput)
+				err := validateTags(params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.TagMFADevice(*params.SerialNumber, params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &TagMFADeviceOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "UntagMFADevice",
+			Proto: UntagMFADeviceInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*UntagMFADeviceInput)
+				err := validateTagKeys(params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.UntagMFADevice(*params.SerialNumber, params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &UntagMFADeviceOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListMFADeviceTags",
+			Proto: ListMFADeviceTagsInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*ListMFADeviceTagsInput)
+				d, ok, err := reg.GetVirtualMFADevice(*params.SerialNumber)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchMFADevice(*params.SerialNumber)
+				}
+				tags, marker, err := paginate(buildTags(d.Tags), tagKey, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &ListMFADeviceTagsOutput{
+							IsTruncated: aws.Bool(marker != nil),
+							Marker:      marker,
+							Tags:        tags,
+						},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "TagServerCertificate",
+			Proto: TagServerCertificateInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*TagServerCertificateInput)
+				err := validateTags(params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.TagServerCertificate(*params.ServerCertificateName, params.Tags)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &TagServerCertificateOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "UntagServerCertificate",
+			Proto: UntagServerCertificateInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*UntagServerCertificateInput)
+				err := validateTagKeys(params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				err = reg.UntagServerCertificate(*params.ServerCertificateName, params.TagKeys)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &UntagServerCertificateOutput{},
+					},
+				}, nil
+			},
+		},
+	)
+	iamAPISet.RegisterHandler(
+		&QueryOperationHandler{
+			Name_: "ListServerCertificateTags",
+			Proto: ListServerCertificateTagsInput{},
+			Handler: func(req *aws.Request) (*aws.Response, error) {
+				params := req.Params.(*ListServerCertificateTagsInput)
+				c, ok, err := reg.GetServerCertificateByName(*params.ServerCertificateName)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					return nil, noSuchServerCertificate(*params.ServerCertificateName)
+				}
+				tags, marker, err := paginate(buildTags(c.Tags), tagKey, params.Marker, params.MaxItems)
+				if err != nil {
+					return nil, err
+				}
+				return &aws.Response{
+					Request: &aws.Request{
+						Data: &ListServerCertificateTagsOutput{
+							IsTruncated: aws.Bool(marker != nil),
+							Marker:      marker,
+							Tags:        tags,
+						},
+					},
+				}, nil
+			},
+		},
+	)
+}
+
+func (reg *BasicIAMRegistry) TagUser(name string, tags []iam.Tag) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.user(name)
+		if !ok {
+			return noSuchUser(name)
+		}
+		merged, err := mergeTags(v.Tags, tags, "TagsPerUser")
+		if err != nil {
+			return err
+		}
+		nv := *v
+		nv.Tags = merged
+		tx.putUser(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) UntagUser(name string, keys []string) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.user(name)
+		if !ok {
+			return noSuchUser(name)
+		}
+		nv := *v
+		nv.Tags = removeTags(v.Tags, keys)
+		tx.putUser(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) TagRole(name string, tags []iam.Tag) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.role(name)
+		if !ok {
+			return noSuchRole(name)
+		}
+		merged, err := mergeTags(v.Tags, tags, "TagsPerRole")
+		if err != nil {
+			return err
+		}
+		nv := *v
+		nv.Tags = merged
+		tx.putRole(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) UntagRole(name string, keys []string) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.role(name)
+		if !ok {
+			return noSuchRole(name)
+		}
+		nv := *v
+		nv.Tags = removeTags(v.Tags, keys)
+		tx.putRole(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) TagInstanceProfile(name string, tags []iam.Tag) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.instanceProfile(name)
+		if !ok {
+			return noSuchInstanceProfile(name)
+		}
+		merged, err := mergeTags(v.Tags, tags, "TagsPerInstanceProfile")
+		if err != nil {
+			return err
+		}
+		nv := *v
+		nv.Tags = merged
+		tx.putInstanceProfile(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) UntagInstanceProfile(name string, keys []string) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.instanceProfile(name)
+		if !ok {
+			return noSuchInstanceProfile(name)
+		}
+		nv := *v
+		nv.Tags = removeTags(v.Tags, keys)
+		tx.putInstanceProfile(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) TagPolicy(arn string, tags []iam.Tag) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.policyByArn(arn)
+		if !ok {
+			return noSuchPolicy(arn)
+		}
+		merged, err := mergeTags(v.Tags, tags, "TagsPerPolicy")
+		if err != nil {
+			return err
+		}
+		nv := *v
+		nv.Tags = merged
+		tx.putPolicy(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) UntagPolicy(arn string, keys []string) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.policyByArn(arn)
+		if !ok {
+			return noSuchPolicy(arn)
+		}
+		nv := *v
+		nv.Tags = removeTags(v.Tags, keys)
+		tx.putPolicy(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) TagOpenIDConnectProvider(arn string, tags []iam.Tag) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.oidcProviderByArn(arn)
+		if !ok {
+			return noSuchOIDCProvider(arn)
+		}
+		merged, err := mergeTags(v.Tags, tags, "TagsPerOpenIDConnectProvider")
+		if err != nil {
+			return err
+		}
+		nv := *v
+		nv.Tags = merged
+		tx.putOIDCProvider(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) UntagOpenIDConnectProvider(arn string, keys []string) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.oidcProviderByArn(arn)
+		if !ok {
+			return noSuchOIDCProvider(arn)
+		}
+		nv := *v
+		nv.Tags = removeTags(v.Tags, keys)
+		tx.putOIDCProvider(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) TagSAMLProvider(arn string, tags []iam.Tag) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.samlProviderByArn(arn)
+		if !ok {
+			return noSuchSAMLProvider(arn)
+		}
+		merged, err := mergeTags(v.Tags, tags, "TagsPerSAMLProvider")
+		if err != nil {
+			return err
+		}
+		nv := *v
+		nv.Tags = merged
+		tx.putSAMLProvider(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) UntagSAMLProvider(arn string, keys []string) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.samlProviderByArn(arn)
+		if !ok {
+			return noSuchSAMLProvider(arn)
+		}
+		nv := *v
+		nv.Tags = removeTags(v.Tags, keys)
+		tx.putSAMLProvider(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) TagMFADevice(serial string, tags []iam.Tag) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.mfaDeviceBySerial(serial)
+		if !ok {
+			return noSuchMFADevice(serial)
+		}
+		merged, err := mergeTags(v.Tags, tags, "TagsPerMFADevice")
+		if err != nil {
+			return err
+		}
+		nv := *v
+		nv.Tags = merged
+		tx.putMFADevice(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) UntagMFADevice(serial string, keys []string) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.mfaDeviceBySerial(serial)
+		if !ok {
+			return noSuchMFADevice(serial)
+		}
+		nv := *v
+		nv.Tags = removeTags(v.Tags, keys)
+		tx.putMFADevice(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) TagServerCertificate(name string, tags []iam.Tag) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.serverCertificate(name)
+		if !ok {
+			return noSuchServerCertificate(name)
+		}
+		merged, err := mergeTags(v.Tags, tags, "TagsPerServerCertificate")
+		if err != nil {
+			return err
+		}
+		nv := *v
+		nv.Tags = merged
+		tx.putServerCertificate(&nv)
+		return nil
+	})
+}
+
+func (reg *BasicIAMRegistry) UntagServerCertificate(name string, keys []string) error {
+	return reg.update(func(tx *registryTx) error {
+		v, ok := tx.serverCertificate(name)
+		if !ok {
+			return noSuchServerCertificate(name)
+		}
+		nv := *v
+		nv.Tags = removeTags(v.Tags, keys)
+		tx.putServerCertificate(&nv)
+		return nil
+	})
+}
@@ -0,0 +1,256 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// memberConstraint carries the constraints of the IAM API model that the
+// bundled SDK does not put in the struct tags of its shapes; the minima and
+// required members are taken from the tags.
+type memberConstraint struct {
+	// Max is the maximum length of strings and lists, or the maximum value
+	// of numbers; zero means unbounded.
+	Max int64
+	// Pattern is the regular expression of the model, which is what
+	// messages quote.
+	Pattern string
+	// Regexp is an RE2 equivalent of Pattern where the latter is not a valid
+	// RE2 expression.
+	Regexp string
+	// Member constrains the members of a list.
+	Member *memberConstraint
+
+	re *regexp.Regexp
+}
+
+const (
+	entityNamePattern = `[\w+=,.@-]+`
+	pathPattern       = `(\u002F)|(\u002F[\u0021-\u007E]+\u002F)`
+	arnMax            = 2048
+)
+
+var tagKeyConstraint = &memberConstraint{Max: maxTagKeyLength, Pattern: tagCharacterClass + `+`}
+
+// memberConstraints is looked up by "Shape.Member" first and then by the
+// member name alone, as members of the same name mostly share a type across
+// the operations.
+var memberConstraints = map[string]*memberConstraint{
+	"UserName":                     {Max: 128, Pattern: entityNamePattern},
+	"CreateUserInput.UserName":     {Max: 64, Pattern: entityNamePattern},
+	"NewUserName":                  {Max: 64, Pattern: entityNamePattern},
+	"GroupName":                    {Max: 128, Pattern: entityNamePattern},
+	"NewGroupName":                 {Max: 128, Pattern: entityNamePattern},
+	"RoleName":                     {Max: 64, Pattern: entityNamePattern},
+	"InstanceProfileName":          {Max: 128, Pattern: entityNamePattern},
+	"Path":                         {Max: 512, Pattern: pathPattern},
+	"NewPath":                      {Max: 512, Pattern: pathPattern},
+	"PathPrefix":                   {Max: 512, Pattern: `\u002F[\u0021-\u007F]*`},
+	"Marker":                       {Max: 320, Pattern: `[\u0020-\u00FF]+`},
+	"MaxItems":                     {Max: maxMaxItems},
+	"Description":                  {Max: 1000, Pattern: `[\u0009\u000A\u000D\u0020-\u007E\u00A1-\u00FF]*`},
+	"AssumeRolePolicyDocument":     {Max: 131072, Pattern: `[\u0009\u000A\u000D\u0020-\u00FF]+`},
+	"PolicyDocument":               {Max: 131072, Pattern: `[\u0009\u000A\u000D\u0020-\u00FF]+`},
+	"MaxSessionDuration":           {Max: 43200},
+	"PermissionsBoundary":          {Max: arnMax},
+	"PolicyArn":                    {Max: arnMax},
+	"PolicySourceArn":              {Max: arnMax},
+	"OpenIDConnectProviderArn":     {Max: arnMax},
+	"SAMLProviderArn":              {Max: arnMax},
+	"Url":                          {Max: 255},
+	"ClientIDList":                 {Member: &memberConstraint{Max: 255}},
+	"ThumbprintList":               {Member: &memberConstraint{Max: 40}},
+	"CreateSAMLProviderInput.Name": {Max: 128, Pattern: `[\w._-]+`},
+	"SAMLMetadataDocument":         {Max: 10000000},
+	"VirtualMFADeviceName":         {Max: 226, Pattern: entityNamePattern},
+	"SerialNumber":                 {Max: 256, Pattern: `[\w+=/:,.@-]+`},
+	"ServerCertificateName":        {Max: 128, Pattern: entityNamePattern},
+	"CertificateBody":              {Max: 16384, Pattern: `[\u0009\u000A\u000D\u0020-\u00FF]+`},
+	"CertificateChain":             {Max: 2097152, Pattern: `[\u0009\u000A\u000D\u0020-\u00FF]+`},
+	"PrivateKey":                   {Max: 16384, Pattern: `[\u0009\u000A\u000D\u0020-\u00FF]+`},
+	"AccountAlias": {
+		Max:     63,
+		Pattern: `^[a-z0-9]([a-z0-9]|-(?!-)){1,61}[a-z0-9]$`,
+		Regexp:  `^[a-z0-9](-?[a-z0-9])*$`,
+	},
+	"Tags":      {Max: maxTagsPerEntity},
+	"Tag.Key":   tagKeyConstraint,
+	"Tag.Value": {Max: maxTagValueLength, Pattern: tagCharacterClass + `*`},
+	"TagKeys":   {Max: maxTagsPerEntity, Member: tagKeyConstraint},
+}
+
+// modelCodePointRegexp matches \uXXXX, which is how the model spells code
+// points and RE2 does not understand.
+var modelCodePointRegexp = regexp.MustCompile(`\\u([0-9A-Fa-f]{4})`)
+
+func init() {
+	for _, c := range memberConstraints {
+		c.compile()
+	}
+}
+
+func (c *memberConstraint) compile() {
+	if c.re == nil && c.Pattern != "" {
+		re := c.Regexp
+		if re == "" {
+			re = c.Pattern
+		}
+		re = modelCodePointRegexp.ReplaceAllString(re, `\x{$1}`)
+		c.re = regexp.MustCompile(`^(?:` + re + `)$`)
+	}
+	if c.Member != nil {
+		c.Member.compile()
+	}
+}
+
+func lookupMemberConstraint(shape reflect.Type, member string) *memberConstraint {
+	if c, ok := memberConstraints[shape.Name()+"."+member]; ok {
+		return c
+	}
+	return memberConstraints[member]
+}
+
+// validateParams checks the input of an operation against the constraints
+// of its shape, and reports every violation at once in a ValidationError
+// worded the way AWS words it.
+func validateParams(params interface{}) error {
+	var violations []string
+	validateShape(reflect.ValueOf(params), "", &violations)
+	if len(violations) == 0 {
+		return nil
+	}
+	noun := "error"
+	if len(violations) > 1 {
+		noun = "errors"
+	}
+	return &SenderFault{
+		Code_:    "ValidationError",
+		Message_: fmt.Sprintf("%d validation %s detected: %s", len(violations), noun, strings.Join(violations, "; ")),
+	}
+}
+
+func memberPath(prefix, name string) string {
+	name = strings.ToLower(name[:1]) + name[1:]
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func validateShape(v reflect.Value, path string, violations *[]string) {
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct || v.Type() == timeType {
+		return
+	}
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // ignore unexported fields
+		}
+		fv := v.Field(i)
+		p := memberPath(path, field.Name)
+		if isNil(fv) {
+			if required, _ := strconv.ParseBool(field.Tag.Get("required")); required {
+				*violations = append(*violations, fmt.Sprintf("Value null at '%s' failed to satisfy constraint: Member must not be null", p))
+			}
+			continue
+		}
+		var min int64
+		if s := field.Tag.Get("min"); s != "" {
+			min, _ = strconv.ParseInt(s, 10, 64)
+		}
+		validateMember(fv, p, min, lookupMemberConstraint(t, field.Name), violations)
+	}
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+func validateMember(v reflect.Value, path string, min int64, c *memberConstraint, violations *[]string) {
+	violate := func(value, constraint string) {
+		*violations = append(*violations, fmt.Sprintf("Value %sat '%s' failed to satisfy constraint: Member must %s", value, path, constraint))
+	}
+	v = reflect.Indirect(v)
+	switch v.Kind() {
+	case reflect.String:
+		s := v.String()
+		quoted := "'" + s + "' "
+		n := int64(utf8.RuneCountInString(s))
+		if n < min {
+			violate(quoted, fmt.Sprintf("have length greater than or equal to %d", min))
+		}
+		if c == nil {
+			return
+		}
+		if c.Max > 0 && n > c.Max {
+			violate(quoted, fmt.Sprintf("have length less than or equal to %d", c.Max))
+		}
+		if c.re != nil && !c.re.MatchString(s) {
+			violate(quoted, "satisfy regular expression pattern: "+c.Pattern)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := v.Int()
+		quoted := fmt.Sprintf("'%d' ", n)
+		if n < min {
+			violate(quoted, fmt.Sprintf("have value greater than or equal to %d", min))
+		}
+		if c != nil && c.Max > 0 && n > c.Max {
+			violate(quoted, fmt.Sprintf("have value less than or equal to %d", c.Max))
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return
+		}
+		n := int64(v.Len())
+		if n < min {
+			violate("", fmt.Sprintf("have length greater than or equal to %d", min))
+		}
+		if c != nil && c.Max > 0 && n > c.Max {
+			violate("", fmt.Sprintf("have length less than or equal to %d", c.Max))
+		}
+		var mc *memberConstraint
+		if c != nil {
+			mc = c.Member
+		}
+		for i := 0; i < v.Len(); i++ {
+			elemPath := path + "." + strconv.Itoa(i+1) + ".member"
+			elem := v.Index(i)
+			if reflect.Indirect(elem).Kind() == reflect.Struct {
+				validateShape(elem, elemPath, violations)
+			} else {
+				validateMember(elem, elemPath, 0, mc, violations)
+			}
+		}
+	case reflect.Struct:
+		validateShape(v, path, violations)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2020 Moriyoshi Koizumi
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER
+// DEALINGS IN THE SOFTWARE.
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+func TestValidateParams(t *testing.T) {
+	long := strings.Repeat("a", 65)
+	for _, c := range []struct {
+		name   string
+		params interface{}
+		want   string
+	}{
+		{"valid", &iam.GetUserInput{UserName: aws.String("alice")}, ""},
+		{"absent optional member", &iam.GetUserInput{}, ""},
+		{
+			"required",
+			&iam.CreateUserInput{},
+			"1 validation error detected: Value null at 'userName' failed to satisfy constraint: Member must not be null",
+		},
+		{
+			"pattern",
+			&iam.GetUserInput{UserName: aws.String("a b")},
+			`1 validation error detected: Value 'a b' at 'userName' failed to satisfy constraint: Member must satisfy regular expression pattern: [\w+=,.@-]+`,
+		},
+		{
+			"min length",
+			&iam.GetUserInput{UserName: aws.String("")},
+			"2 validation errors detected: Value '' at 'userName' failed to satisfy constraint: Member must have length greater than or equal to 1; Value '' at 'userName' failed to satisfy constraint: Member must satisfy regular expression pattern",
+		},
+		{
+			// UserName is longer for GetUser than for CreateUser
+			"constraint of the shape",
+			&iam.CreateUserInput{UserName: aws.String(long)},
+			"1 validation error detected: Value '" + long + "' at 'userName' failed to satisfy constraint: Member must have length less than or equal to 64",
+		},
+		{"constraint of the member", &iam.GetUserInput{UserName: aws.String(long)}, ""},
+		{
+			"every violation",
+			&iam.CreateUserInput{UserName: aws.String("a b"), Path: aws.String("nopath")},
+			"2 validation errors detected: ",
+		},
+		{
+			"list members",
+			&iam.TagUserInput{UserName: aws.String("alice"), Tags: []iam.Tag{{Key: aws.String("k"), Value: aws.String("v")}, {Key: aws.String(""), Value: aws.String("v")}}},
+			"at 'tags.2.member.key' failed to satisfy constraint: Member must have length greater than or equal to 1",
+		},
+		{
+			"list length",
+			&iam.UntagUserInput{UserName: aws.String("alice"), TagKeys: make([]string, 51)},
+			"at 'tagKeys' failed to satisfy constraint: Member must have length less than or equal to 50",
+		},
+		{
+			"integer range",
+			&iam.ListUsersInput{MaxItems: aws.Int64(0)},
+			"1 validation error detected: Value '0' at 'maxItems' failed to satisfy constraint: Member must have value greater than or equal to 1",
+		},
+	} {
+		err := validateParams(c.params)
+		if c.want == "" {
+			if err != nil {
+				t.Errorf("%s: got %v", c.name, err)
+			}
+			continue
+		}
+		f, ok := err.(*SenderFault)
+		if !ok || f.Code_ != "ValidationError" || !strings.Contains(f.Message_, c.want) {
+			t.Errorf("%s: got %v, want %q", c.name, err, c.want)
+		}
+	}
+}